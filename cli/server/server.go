@@ -1,12 +1,19 @@
 package main
 
 import (
+	"compress/gzip"
+	"context"
 	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/src-d/code-annotation/server"
 	"github.com/src-d/code-annotation/server/dbutil"
 	"github.com/src-d/code-annotation/server/handler"
+	"github.com/src-d/code-annotation/server/repository"
 	"github.com/src-d/code-annotation/server/service"
 
 	"github.com/kelseyhightower/envconfig"
@@ -16,6 +23,16 @@ import (
 // See https://github.com/src-d/ci/blob/v1/Makefile.main#L56
 var version = "dev"
 
+// gitCommit and buildTime are set at build time with -ldflags, see Makefile
+var (
+	gitCommit = "unknown"
+	buildTime = "unknown"
+)
+
+// sseEventsBuffer is the number of answer events kept for each subscriber
+// that is not reading them fast enough
+const sseEventsBuffer = 100
+
 type appConfig struct {
 	Env          string `envconfig:"ENV" default:"production"`
 	Host         string `envconfig:"HOST" default:"0.0.0.0"`
@@ -24,6 +41,24 @@ type appConfig struct {
 	DBConn       string `envconfig:"DB_CONNECTION" default:"sqlite:///var/code-annotation/internal.db"`
 	ExportsPath  string `envconfig:"EXPORTS_PATH" default:"./exports"`
 	GaTrackingID string `envconfig:"GA_TRACKING_ID" required:"false"`
+	ServeStatic  bool   `envconfig:"SERVE_STATIC" default:"true"`
+	StaticDir    string `envconfig:"STATIC_DIR"`
+
+	OutlierThreshold        time.Duration `envconfig:"OUTLIER_THRESHOLD" default:"10m"`
+	MaxDuration             time.Duration `envconfig:"MAX_DURATION" default:"2h"`
+	IdempotencyTTL          time.Duration `envconfig:"IDEMPOTENCY_TTL" default:"24h"`
+	UploadMaxFailureDetails int           `envconfig:"UPLOAD_MAX_FAILURE_DETAILS" default:"100"`
+	MaxBodySize             int64         `envconfig:"MAX_BODY_SIZE" default:"1048576"`
+	MaxUploadSize           int64         `envconfig:"MAX_UPLOAD_SIZE" default:"104857600"`
+	CompressMinSize         int           `envconfig:"COMPRESS_MIN_SIZE" default:"1024"`
+	CompressLevel           int           `envconfig:"COMPRESS_LEVEL" default:"-1"`
+	MaxCommentLength        int           `envconfig:"MAX_COMMENT_LENGTH" default:"1000"`
+	DiffCacheSize           int           `envconfig:"DIFF_CACHE_SIZE" default:"67108864"`
+	DiffMaxBlobSize         int           `envconfig:"DIFF_MAX_BLOB_SIZE" default:"1048576"`
+	WSMaxSubscribers        int           `envconfig:"WS_MAX_SUBSCRIBERS" default:"100"`
+	SSEMaxSubscribers       int           `envconfig:"SSE_MAX_SUBSCRIBERS" default:"100"`
+	MetricsPort             int           `envconfig:"METRICS_PORT"`
+	ShutdownTimeout         time.Duration `envconfig:"SHUTDOWN_TIMEOUT" default:"30s"`
 }
 
 func main() {
@@ -35,15 +70,32 @@ func main() {
 	}
 
 	// loger
-	logger := service.NewLogger(conf.Env)
+	var loggerConfig service.LoggerConfig
+	envconfig.MustProcess("CAT_LOG", &loggerConfig)
+	logger, err := service.NewLogger(conf.Env, loggerConfig)
+	if err != nil {
+		panic(fmt.Sprintf("error configuring the logger: %s", err))
+	}
+
+	if conf.CompressLevel < gzip.HuffmanOnly || conf.CompressLevel > gzip.BestCompression {
+		logger.Fatalf("invalid compression level %d, it must be between %d and %d",
+			conf.CompressLevel, gzip.HuffmanOnly, gzip.BestCompression)
+	}
+
+	// metrics
+	metrics := service.NewMetrics()
 
 	// database
-	db, err := dbutil.Open(conf.DBConn, false)
+	db, err := dbutil.OpenWithObserver(conf.DBConn, false, metrics)
 	if err != nil {
 		logger.Fatalf("error opening the database: %s", err)
 	}
 	defer db.Close()
 
+	var poolConfig dbutil.PoolConfig
+	envconfig.MustProcess("CAT_DB", &poolConfig)
+	db.SetPool(poolConfig)
+
 	if err := dbutil.Bootstrap(db); err != nil {
 		logger.Fatalf("error bootstrapping the database: %s", err)
 	}
@@ -62,15 +114,138 @@ func main() {
 
 	var jwtConfig service.JWTConfig
 	envconfig.MustProcess("CAT_JWT", &jwtConfig)
-	jwt := service.NewJWT(jwtConfig.SigningKey)
+	jwt, err := service.NewJWTFromConfig(jwtConfig)
+	if err != nil {
+		logger.Fatalf("error configuring JWT: %s", err)
+	}
+
+	// the revoked tokens are shared by all the servers using the DB
+	jwt.SetDenylist(repository.NewRevokedTokens(db.DB))
+
+	var rateLimitConfig service.RateLimitConfig
+	envconfig.MustProcess("CAT_RATE_LIMIT", &rateLimitConfig)
+	authRateLimit := service.NewMemoryRateLimitStore(
+		rateLimitConfig.PerMinute, rateLimitConfig.Burst)
+	idempotencyKeys := service.NewIdempotencyStore(conf.IdempotencyTTL)
+
+	var corsConfig service.CORSConfig
+	envconfig.MustProcess("CAT_CORS", &corsConfig)
+
+	progressHub := service.NewProgressHub(conf.WSMaxSubscribers)
+	eventHub := service.NewEventHub(conf.SSEMaxSubscribers, sseEventsBuffer)
+	metrics.AddGauge("cat_websocket_subscribers",
+		"Number of WebSocket subscribers to the experiments progress.",
+		func() float64 { return float64(progressHub.Subscribers()) })
+	metrics.AddGauge("cat_sse_subscribers",
+		"Number of Server-Sent Events subscribers to the experiments answers.",
+		func() float64 { return float64(eventHub.Subscribers()) })
 
-	diffService := service.NewDiff()
+	diffService := service.NewDiff(conf.DiffMaxBlobSize)
+	diffCache := service.NewDiffCache(conf.DiffCacheSize)
+	metrics.AddCounter("cat_diff_cache_hits_total",
+		"Number of file pair diffs taken from the cache.",
+		func() float64 { return float64(diffCache.Hits()) })
+	metrics.AddCounter("cat_diff_cache_misses_total",
+		"Number of file pair diffs not found in the cache.",
+		func() float64 { return float64(diffCache.Misses()) })
 
-	static := handler.NewStatic("build", conf.ServerURL, conf.GaTrackingID)
+	// the frontend is embedded unless a directory is set, or not served at all
+	// when the server is used only for the API
+	var static *handler.Static
+	switch {
+	case !conf.ServeStatic:
+	case conf.StaticDir != "":
+		static = handler.NewStaticFromDir(conf.StaticDir, conf.ServerURL, conf.GaTrackingID)
+	default:
+		static = handler.NewStatic("build", conf.ServerURL, conf.GaTrackingID)
+	}
 
 	// start the router
-	router := server.Router(logger, jwt, oauth, diffService, static, &db, conf.ExportsPath, version)
+	buildInfo := handler.BuildInfo{Version: version, GitCommit: gitCommit, BuildTime: buildTime}
+	router := server.Router(server.Config{
+		Logger:          logger,
+		JWT:             jwt,
+		OAuth:           oauth,
+		AuthRateLimit:   authRateLimit,
+		IdempotencyKeys: idempotencyKeys,
+		CORS:            corsConfig,
+		ProgressHub:     progressHub,
+		EventHub:        eventHub,
+		Metrics:         metrics,
+		Diff:            diffService,
+		DiffCache:       diffCache,
+		Static:          static,
+		DB:              &db,
+
+		ExportsPath:       conf.ExportsPath,
+		OutlierThreshold:  conf.OutlierThreshold,
+		MaxDuration:       conf.MaxDuration,
+		MaxFailureDetails: conf.UploadMaxFailureDetails,
+		MaxCommentLength:  conf.MaxCommentLength,
+		MaxBodySize:       conf.MaxBodySize,
+		MaxUploadSize:     conf.MaxUploadSize,
+		CompressMinSize:   conf.CompressMinSize,
+		CompressLevel:     conf.CompressLevel,
+		BuildInfo:         buildInfo,
+	})
+
+	// the metrics are served without authentication, in their own port if set
+	mux := http.NewServeMux()
+	mux.Handle("/", router)
+	servers := []*http.Server{{Addr: fmt.Sprintf("%s:%d", conf.Host, conf.Port), Handler: mux}}
+	if conf.MetricsPort == 0 {
+		mux.Handle("/metrics", handler.Metrics(metrics))
+	} else {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", handler.Metrics(metrics))
+		servers = append(servers, &http.Server{
+			Addr:    fmt.Sprintf("%s:%d", conf.Host, conf.MetricsPort),
+			Handler: metricsMux,
+		})
+	}
+
+	// the subscribers are not tracked as in-flight requests, they are told to
+	// go away as soon as the shutdown starts
+	servers[0].RegisterOnShutdown(func() {
+		progressHub.Close()
+		eventHub.Close()
+	})
+
+	errs := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+				errs <- err
+			}
+		}(srv)
+	}
+
 	logger.Info("running...")
-	err = http.ListenAndServe(fmt.Sprintf("%s:%d", conf.Host, conf.Port), router)
-	logger.Fatal(err)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+
+	var serveErr error
+	select {
+	case serveErr = <-errs:
+	case sig := <-stop:
+		logger.Infof("received %s, shutting down...", sig)
+	}
+
+	// new connections are refused, and the in-flight requests are given up
+	// to conf.ShutdownTimeout to finish before the database is closed
+	ctx, cancel := context.WithTimeout(context.Background(), conf.ShutdownTimeout)
+	defer cancel()
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			logger.Errorf("error shutting down the server: %s", err)
+		}
+	}
+
+	if serveErr != nil {
+		db.Close()
+		logger.Fatalf("error serving: %s", serveErr)
+	}
+
+	logger.Info("stopped")
 }
@@ -0,0 +1,150 @@
+package dbutil
+
+import (
+	"crypto/sha1"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// csvColumns are the columns required in the header of the CSV files
+var csvColumns = []string{"leftPath", "rightPath", "leftContent", "rightContent", "score"}
+
+// ImportCSV imports pairs of files from a CSV file to the destination DB. The
+// first row must be a header naming the csvColumns, in any order. The optional
+// basePath and baseContent columns hold the base file of three-way pairs; the
+// rows where both are empty are imported as two-way pairs. The rows
+// that can not be imported are skipped and reported to opts.OnFailure, with
+// their record number in the file, so the header is row 1
+func ImportCSV(r io.Reader, destDB DB, opts Options, experimentID int) (success, failures int64, e error) {
+	logger := opts.getLogger()
+
+	rows, err := destDB.Query(selectExperiment, experimentID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if !rows.Next() {
+		rows.Close()
+		return 0, 0, fmt.Errorf("Experiment with id %d doesn't exist", experimentID)
+	}
+	rows.Close()
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return 0, 0, fmt.Errorf("can't read CSV header: %s", err)
+	}
+
+	columns, err := csvColumnIndexes(header)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	tx, err := destDB.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	insert, err := tx.Prepare(insertFilePairsWithBase)
+	if err != nil {
+		tx.Rollback()
+		return 0, 0, err
+	}
+
+	fail := func(row int, reason string) {
+		logger.Printf("Failed to import CSV row %d\nerror: %s\n", row, reason)
+		opts.fail(row, reason)
+		failures++
+	}
+
+	for row := 2; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); !ok {
+				tx.Rollback()
+				return 0, 0, err
+			}
+
+			fail(row, err.Error())
+			continue
+		}
+
+		if len(record) != len(header) {
+			fail(row, fmt.Sprintf("expected %d fields, got %d", len(header), len(record)))
+			continue
+		}
+
+		score, err := strconv.ParseFloat(strings.TrimSpace(record[columns["score"]]), 64)
+		if err != nil {
+			fail(row, fmt.Sprintf("invalid score %q", record[columns["score"]]))
+			continue
+		}
+
+		pathA, contentA := record[columns["leftPath"]], record[columns["leftContent"]]
+		pathB, contentB := record[columns["rightPath"]], record[columns["rightContent"]]
+
+		var blobIDBase, pathBase, contentBase interface{}
+		if _, ok := columns["basePath"]; ok {
+			path, content := record[columns["basePath"]], record[columns["baseContent"]]
+			if path != "" || content != "" {
+				blobIDBase, pathBase, contentBase = blobHash(content), path, content
+			}
+		}
+
+		_, err = insert.Exec(
+			blobHash(contentA), "", "", pathA, contentA, md5hash(contentA), nil,
+			blobHash(contentB), "", "", pathB, contentB, md5hash(contentB), nil,
+			score,
+			experimentID,
+			blobIDBase, pathBase, contentBase)
+
+		if err != nil {
+			fail(row, err.Error())
+			continue
+		}
+
+		success++
+	}
+
+	if err := opts.commit(tx); err != nil {
+		return 0, failures, err
+	}
+
+	return success, failures, nil
+}
+
+// csvColumnIndexes returns the position of every csvColumns in the header
+func csvColumnIndexes(header []string) (map[string]int, error) {
+	indexes := make(map[string]int, len(header))
+	for i, name := range header {
+		indexes[strings.TrimSpace(name)] = i
+	}
+
+	for _, name := range csvColumns {
+		if _, ok := indexes[name]; !ok {
+			return nil, fmt.Errorf("missing CSV column %q", name)
+		}
+	}
+
+	_, hasPath := indexes["basePath"]
+	_, hasContent := indexes["baseContent"]
+	if hasPath != hasContent {
+		return nil, fmt.Errorf(`CSV columns "basePath" and "baseContent" must be used together`)
+	}
+
+	return indexes, nil
+}
+
+// blobHash returns the git blob ID of the content
+func blobHash(content string) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(fmt.Sprintf("blob %d\x00%s", len(content), content))))
+}
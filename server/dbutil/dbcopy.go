@@ -18,7 +18,7 @@ const (
 	alterSequenceSQL = `ALTER SEQUENCE <TABLE>_id_seq RESTART WITH $1`
 )
 
-var tables = []string{"users", "experiments", "file_pairs", "assignments"}
+var tables = []string{"users", "experiments", "file_pairs", "assignments", "experiment_tags", "answer_history"}
 
 // Copy dumps the contents of the origin DB into the destination DB. The
 // destination DB should be bootstrapped, but empty
@@ -152,3 +152,15 @@ func fixSequences(db DB, logger logrus.FieldLogger) {
 		}
 	}
 }
+
+const anonymizeUsersSQL = `UPDATE users SET login = 'annotator-' || id, username = '', avatar_url = ''`
+
+// AnonymizeUsers replaces the login of every user with a pseudonym built from
+// its ID, and removes the name and avatar. The real data can not be recovered
+func AnonymizeUsers(db DB) error {
+	if _, err := db.Exec(anonymizeUsersSQL); err != nil {
+		return fmt.Errorf("can't anonymize the users: %v", err)
+	}
+
+	return nil
+}
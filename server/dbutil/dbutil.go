@@ -7,6 +7,7 @@ import (
 	"os"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
@@ -69,18 +70,108 @@ const (
 			FOREIGN KEY (user_id) REFERENCES users(id),
 			FOREIGN KEY (pair_id) REFERENCES file_pairs(id),
 			FOREIGN KEY (experiment_id) REFERENCES experiments(id))`
+	createExperimentTags = `CREATE TABLE IF NOT EXISTS experiment_tags (
+		experiment_id INTEGER, tag TEXT,
+		PRIMARY KEY (experiment_id, tag),
+		FOREIGN KEY (experiment_id) REFERENCES experiments(id))`
 	createFeatures = `CREATE TABLE IF NOT EXISTS features (
 		blob_id TEXT,
 		name TEXT, weight REAL,
 		PRIMARY KEY (blob_id, name))`
+	// the answer history only grows, a row is added on every answer change
+	createAnswerHistory = `CREATE TABLE IF NOT EXISTS answer_history (
+		id <INCREMENT_TYPE>,
+		assignment_id INTEGER, user_id INTEGER,
+		answer TEXT, duration INTEGER, changed_at TIMESTAMP,
+		PRIMARY KEY (id),
+		FOREIGN KEY (assignment_id) REFERENCES assignments(id),
+		FOREIGN KEY (user_id) REFERENCES users(id))`
+	// the API keys are not copied with the rest of tables, as they are
+	// credentials of the DB they were created in
+	createAPIKeys = `CREATE TABLE IF NOT EXISTS api_keys (
+		id <INCREMENT_TYPE>,
+		user_id INTEGER, experiment_id INTEGER,
+		name TEXT, key_hash TEXT UNIQUE,
+		created_at TIMESTAMP, revoked_at TIMESTAMP,
+		PRIMARY KEY (id),
+		FOREIGN KEY (user_id) REFERENCES users(id),
+		FOREIGN KEY (experiment_id) REFERENCES experiments(id))`
+	// the pseudonym keys are not copied either, the anonymized exports could
+	// be reversed with them
+	createPseudonymKeys = `CREATE TABLE IF NOT EXISTS pseudonym_keys (
+		experiment_id INTEGER, secret TEXT,
+		PRIMARY KEY (experiment_id),
+		FOREIGN KEY (experiment_id) REFERENCES experiments(id))`
+	// the revoked tokens are not copied either, they were signed by the
+	// server of the DB they were revoked in
+	createRevokedTokens = `CREATE TABLE IF NOT EXISTS revoked_tokens (
+		token_id TEXT, expires_at TIMESTAMP,
+		PRIMARY KEY (token_id))`
+)
+
+// column is a column added to a table after its creation
+type column struct {
+	table      string
+	name       string
+	definition string
+}
+
+// migrations lists the columns added to the tables, in order. New columns must
+// be appended at the end so all DBs share the same columns order
+var migrations = []column{
+	{"experiments", "deleted_at", "TIMESTAMP"},
+	{"assignments", "created_at", "TIMESTAMP"},
+	{"assignments", "updated_at", "TIMESTAMP"},
+	{"experiments", "outlier_threshold", "INTEGER"},
+	{"assignments", "outlier", "BOOLEAN"},
+	{"experiments", "status", "TEXT"},
+	{"experiments", "assignment_strategy", "TEXT"},
+	{"experiments", "assignment_seed", "BIGINT"},
+	{"experiments", "version", "INTEGER"},
+	{"assignments", "confidence", "INTEGER"},
+	{"assignments", "comment", "TEXT"},
+	{"file_pairs", "gold_answer", "TEXT"},
+	{"experiments", "created_at", "TIMESTAMP"},
+	{"experiments", "updated_at", "TIMESTAMP"},
+	{"experiments", "deadline", "TIMESTAMP"},
+	{"file_pairs", "blob_id_base", "TEXT"},
+	{"file_pairs", "path_base", "TEXT"},
+	{"file_pairs", "content_base", "TEXT"},
+	{"assignments", "flagged", "BOOLEAN"},
+	{"assignments", "session_id", "TEXT"},
+	{"assignments", "pending_duration", "INTEGER"},
+	{"experiments", "answers", "TEXT"},
+	{"file_pairs", "purged", "BOOLEAN"},
+	{"file_pairs", "loc_a", "INTEGER"},
+	{"file_pairs", "loc_b", "INTEGER"},
+	{"experiments", "assignments_managed", "BOOLEAN"},
+}
+
+// backfills fill the migrated columns of the rows created before them. They are
+// run after the migrations, so they must only update rows with missing values
+var backfills = []string{
+	// the creation time of old assignments is unknown, the epoch is used instead
+	`UPDATE assignments SET created_at = '1970-01-01 00:00:00' WHERE created_at IS NULL`,
+	`UPDATE experiments SET status = 'active' WHERE status IS NULL`,
+	`UPDATE experiments SET assignment_strategy = 'sequential', assignment_seed = 0
+		WHERE assignment_strategy IS NULL`,
+	`UPDATE experiments SET version = 0 WHERE version IS NULL`,
+	// as with the assignments, the epoch is used for the old experiments
+	`UPDATE experiments SET created_at = '1970-01-01 00:00:00' WHERE created_at IS NULL`,
+	`UPDATE experiments SET updated_at = created_at WHERE updated_at IS NULL`,
+}
+
+const (
+	selectColumnSQL = `SELECT %s FROM %s LIMIT 1`
+	addColumnSQL    = `ALTER TABLE %s ADD COLUMN %s %s`
 )
 
 const (
 	defaultExperimentID = 1
 
 	insertExperiments = `INSERT INTO experiments
-		(id, name, description)
-		VALUES ($1, 'default', 'Default experiment')`
+		(id, name, description, status, assignment_strategy, assignment_seed, version, created_at, updated_at)
+		VALUES ($1, 'default', 'Default experiment', 'active', 'sequential', 0, 0, $2, $2)`
 
 	alterExperimentsSequence = `ALTER SEQUENCE experiments_id_seq RESTART WITH 2`
 )
@@ -98,6 +189,13 @@ const insertFilePairs = `INSERT INTO file_pairs (
 		score, experiment_id ) VALUES
 		($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)`
 
+// insertFilePairsWithBase also sets the base file of three-way pairs
+const insertFilePairsWithBase = `INSERT INTO file_pairs (
+		blob_id_a, repository_id_a, commit_hash_a, path_a, content_a, hash_a, uast_a,
+		blob_id_b, repository_id_b, commit_hash_b, path_b, content_b, hash_b, uast_b,
+		score, experiment_id, blob_id_base, path_base, content_base ) VALUES
+		($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)`
+
 var (
 	sqliteReg = regexp.MustCompile(`^sqlite://(.+)$`)
 	psReg     = regexp.MustCompile(`^postgres(ql)?:.+$`)
@@ -122,6 +220,12 @@ func OpenSQLite(filepath string, checkExisting bool) (DB, error) {
 // Open returns a DB from the connection string.
 // With checkExisting it will fail if the DB does not exist
 func Open(connection string, checkExisting bool) (DB, error) {
+	return OpenWithObserver(connection, checkExisting, nil)
+}
+
+// OpenWithObserver returns a DB from the connection string, as Open does,
+// that notifies the duration of every query to the observer, if not nil
+func OpenWithObserver(connection string, checkExisting bool, observer QueryObserver) (DB, error) {
 	if conn := sqliteReg.FindStringSubmatch(connection); conn != nil {
 		if checkExisting {
 			if _, err := os.Stat(conn[1]); os.IsNotExist(err) {
@@ -129,12 +233,12 @@ func Open(connection string, checkExisting bool) (DB, error) {
 			}
 		}
 
-		db, err := sql.Open("sqlite3", conn[1])
+		db, err := openDB("sqlite3", conn[1], observer)
 		return DB{db, Sqlite}, err
 	}
 
 	if psReg.MatchString(connection) {
-		db, err := sql.Open("postgres", connection)
+		db, err := openDB("postgres", connection, observer)
 		return DB{db, Postgres}, err
 	}
 
@@ -143,11 +247,44 @@ sqlite:///path/to/db.db
 postgresql://[user[:password]@][netloc][:port][,...][/dbname]`, connection)
 }
 
-// Bootstrap creates the necessary tables for the output DB. It is safe to call on a
-// DB that is already bootstrapped.
+// PoolConfig defines enviroment variables for the connection pool of the DB.
+// A max of open connections of 0 means no limit, and a lifetime of 0 means
+// the connections are reused forever
+type PoolConfig struct {
+	MaxOpenConns    int           `envconfig:"MAX_OPEN_CONNS" default:"20"`
+	MaxIdleConns    int           `envconfig:"MAX_IDLE_CONNS" default:"5"`
+	ConnMaxLifetime time.Duration `envconfig:"CONN_MAX_LIFETIME" default:"30m"`
+}
+
+// SetPool applies the connection pool settings to the DB
+func (db *DB) SetPool(conf PoolConfig) {
+	db.SetMaxOpenConns(conf.MaxOpenConns)
+	db.SetMaxIdleConns(conf.MaxIdleConns)
+	db.SetConnMaxLifetime(conf.ConnMaxLifetime)
+}
+
+// openDB returns a sql.DB for the driver, wrapped to notify the duration of
+// the queries if there is an observer
+func openDB(driverName, dsn string, observer QueryObserver) (*sql.DB, error) {
+	db, err := sql.Open(driverName, dsn)
+	if err != nil || observer == nil {
+		return db, err
+	}
+
+	// sql.Open does not connect, the DB is only needed to get the driver
+	d := db.Driver()
+	db.Close()
+
+	return sql.OpenDB(&observedConnector{d, dsn, observer}), nil
+}
+
+// Bootstrap creates the necessary tables for the output DB, and adds the
+// missing columns to the existing ones. It is safe to call on a DB that is
+// already bootstrapped.
 func Bootstrap(db DB) error {
 	tables := []string{createUsers, createExperiments,
-		createFilePairs, createAssignments, createFeatures}
+		createFilePairs, createAssignments, createFeatures, createExperimentTags, createAPIKeys,
+		createAnswerHistory, createPseudonymKeys, createRevokedTokens}
 
 	var colType string
 	var blobType string
@@ -172,13 +309,92 @@ func Bootstrap(db DB) error {
 		}
 	}
 
+	if err := migrate(db); err != nil {
+		return err
+	}
+
+	if db.Driver == Sqlite {
+		return createCommentsIndex(db)
+	}
+
+	return nil
+}
+
+// The comments of the assignments are indexed in a SQLite FTS4 table, using
+// the assignment ID as docid. It is kept up to date by triggers, so it does
+// not need to be copied
+const (
+	createAssignmentComments = `CREATE VIRTUAL TABLE IF NOT EXISTS assignment_comments
+		USING fts4(comment, tokenize=unicode61)`
+	createAssignmentCommentsTriggers = `
+		CREATE TRIGGER IF NOT EXISTS assignment_comments_insert AFTER INSERT ON assignments
+		WHEN NEW.comment IS NOT NULL BEGIN
+			INSERT INTO assignment_comments (docid, comment) VALUES (NEW.id, NEW.comment);
+		END;
+		CREATE TRIGGER IF NOT EXISTS assignment_comments_update AFTER UPDATE OF comment ON assignments
+		BEGIN
+			DELETE FROM assignment_comments WHERE docid = OLD.id;
+			INSERT INTO assignment_comments (docid, comment)
+				SELECT NEW.id, NEW.comment WHERE NEW.comment IS NOT NULL;
+		END;
+		CREATE TRIGGER IF NOT EXISTS assignment_comments_delete AFTER DELETE ON assignments
+		BEGIN
+			DELETE FROM assignment_comments WHERE docid = OLD.id;
+		END`
+	// indexes the comments made before the index was created
+	fillAssignmentComments = `INSERT INTO assignment_comments (docid, comment)
+		SELECT id, comment FROM assignments
+		WHERE comment IS NOT NULL AND id NOT IN (SELECT docid FROM assignment_comments)`
+)
+
+// createCommentsIndex creates the full-text index of the assignment comments.
+// If the SQLite library was built without FTS4 nothing is created, and the
+// comments are searched without the index
+func createCommentsIndex(db DB) error {
+	if _, err := db.Exec(createAssignmentComments); err != nil {
+		if strings.Contains(err.Error(), "no such module") {
+			return nil
+		}
+
+		return fmt.Errorf("can't create the comments index: %s", err)
+	}
+
+	for _, cmd := range []string{createAssignmentCommentsTriggers, fillAssignmentComments} {
+		if _, err := db.Exec(cmd); err != nil {
+			return fmt.Errorf("can't create the comments index: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// migrate adds to the tables the columns that are missing, and fills them for
+// the existing rows
+func migrate(db DB) error {
+	for _, c := range migrations {
+		if _, err := db.Exec(fmt.Sprintf(selectColumnSQL, c.name, c.table)); err == nil {
+			continue
+		}
+
+		cmd := fmt.Sprintf(addColumnSQL, c.table, c.name, c.definition)
+		if _, err := db.Exec(cmd); err != nil {
+			return fmt.Errorf("can't add column %s.%s: %s", c.table, c.name, err)
+		}
+	}
+
+	for _, cmd := range backfills {
+		if _, err := db.Exec(cmd); err != nil {
+			return fmt.Errorf("can't backfill migrated columns: %s", err)
+		}
+	}
+
 	return nil
 }
 
 // Initialize populates the DB with default values. It is safe to call on a
 // DB that is already initialized
 func Initialize(db DB) error {
-	_, err := db.Exec(insertExperiments, defaultExperimentID)
+	_, err := db.Exec(insertExperiments, defaultExperimentID, time.Now().UTC())
 	if db.Driver == Postgres && err == nil {
 		db.Exec(alterExperimentsSequence)
 	}
@@ -187,10 +403,21 @@ func Initialize(db DB) error {
 	return nil
 }
 
-// Options for the ImportFiles and Copy methods.
+// Options for the ImportFiles, ImportCSV and Copy methods.
 // Logger is optional, if it is not provided the default stderr will be used.
+// OnFailure is optional, it is called for every row that can not be imported.
+// DryRun makes ImportFiles and ImportCSV validate and count the rows without
+// keeping them in the destination DB.
 type Options struct {
-	Logger logrus.FieldLogger
+	Logger    logrus.FieldLogger
+	OnFailure func(ImportFailure)
+	DryRun    bool
+}
+
+// ImportFailure describes why a row could not be imported
+type ImportFailure struct {
+	Row    int
+	Reason string
 }
 
 func (opts *Options) getLogger() logrus.FieldLogger {
@@ -201,8 +428,25 @@ func (opts *Options) getLogger() logrus.FieldLogger {
 	return logrus.StandardLogger()
 }
 
+// commit commits the import transaction, or rolls it back for a dry run
+func (opts *Options) commit(tx *sql.Tx) error {
+	if opts.DryRun {
+		return tx.Rollback()
+	}
+
+	return tx.Commit()
+}
+
+func (opts *Options) fail(row int, reason string) {
+	if opts.OnFailure != nil {
+		opts.OnFailure(ImportFailure{Row: row, Reason: reason})
+	}
+}
+
 // ImportFiles imports pairs of files from the origin to the destination DB.
-// It copies the contents and processes the needed data (md5 hash)
+// It copies the contents and processes the needed data (md5 hash). The rows
+// that can not be imported are skipped and reported to opts.OnFailure, with
+// their 1-based position in the origin DB
 func ImportFiles(originDB DB, destDB DB, opts Options, experimentID int) (success, failures int64, e error) {
 
 	logger := opts.getLogger()
@@ -234,7 +478,7 @@ func ImportFiles(originDB DB, destDB DB, opts Options, experimentID int) (succes
 		return 0, 0, err
 	}
 
-	for rows.Next() {
+	for row := 1; rows.Next(); row++ {
 		var blobIDA, repositoryIDA, commitHashA, pathA, contentA,
 			blobIDB, repositoryIDB, commitHashB, pathB, contentB string
 		var uastA, uastB []byte
@@ -247,6 +491,7 @@ func ImportFiles(originDB DB, destDB DB, opts Options, experimentID int) (succes
 
 		if err != nil {
 			logger.Printf("Failed to read row from origin DB\nerror: %v\n", err)
+			opts.fail(row, err.Error())
 			failures++
 			continue
 		}
@@ -259,6 +504,7 @@ func ImportFiles(originDB DB, destDB DB, opts Options, experimentID int) (succes
 
 		if err != nil {
 			logger.Printf("Failed to insert row\nerror: %v\n", err)
+			opts.fail(row, err.Error())
 			failures++
 			continue
 		}
@@ -267,7 +513,7 @@ func ImportFiles(originDB DB, destDB DB, opts Options, experimentID int) (succes
 		success += rowsAffected
 	}
 
-	if err := tx.Commit(); err != nil {
+	if err := opts.commit(tx); err != nil {
 		return 0, success + failures, err
 	}
 
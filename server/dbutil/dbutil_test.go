@@ -2,8 +2,10 @@ package dbutil
 
 import (
 	"database/sql"
+	"fmt"
 	"io/ioutil"
 	"testing"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
@@ -25,6 +27,54 @@ func (suite *DBUtilSuite) TestMD5() {
 	}
 }
 
+func (suite *DBUtilSuite) TestSetPool() {
+	db, err := Open("sqlite://"+suite.T().TempDir()+"/pool.db", false)
+	if err != nil {
+		suite.T().Fatalf("can't open the db for test %s", err)
+	}
+	defer db.Close()
+
+	db.SetPool(PoolConfig{MaxOpenConns: 3, MaxIdleConns: 1, ConnMaxLifetime: time.Minute})
+	assert.Equal(suite.T(), 3, db.Stats().MaxOpenConnections)
+}
+
+type operationsObserver []string
+
+func (o *operationsObserver) ObserveQuery(operation string, d time.Duration) {
+	*o = append(*o, operation)
+}
+
+func (suite *DBUtilSuite) TestOpenWithObserver() {
+	assert := assert.New(suite.T())
+
+	var observed operationsObserver
+	db, err := OpenWithObserver("sqlite://"+suite.T().TempDir()+"/observed.db", false, &observed)
+	if err != nil {
+		suite.T().Fatalf("can't open the db for test %s", err)
+	}
+	defer db.Close()
+
+	assert.Nil(Bootstrap(db))
+	observed = nil
+
+	_, err = db.Exec(`INSERT INTO users (login) VALUES ($1)`, "alice")
+	assert.Nil(err)
+
+	tx, err := db.Begin()
+	assert.Nil(err)
+	stmt, err := tx.Prepare(`UPDATE users SET role=$1 WHERE login=$2`)
+	assert.Nil(err)
+	_, err = stmt.Exec("worker", "alice")
+	assert.Nil(err)
+	assert.Nil(tx.Commit())
+
+	var role string
+	assert.Nil(db.QueryRow(`SELECT role FROM users WHERE login=$1`, "alice").Scan(&role))
+	assert.Equal("worker", role)
+
+	assert.Equal(operationsObserver{"insert", "update", "select"}, observed)
+}
+
 func (suite *DBUtilSuite) TestImportFiles() {
 	assert := assert.New(suite.T())
 
@@ -66,6 +116,44 @@ func (suite *DBUtilSuite) TestImportFiles() {
 	assert.Equal(int64(0), failures)
 }
 
+func (suite *DBUtilSuite) TestBootstrapMigratesColumns() {
+	assert := assert.New(suite.T())
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		suite.T().Fatalf("can't create db for test %s", err)
+	}
+	dbWrapper := DB{
+		DB:     db,
+		Driver: Sqlite,
+	}
+
+	// tables as created before any migration
+	_, err = db.Exec(`CREATE TABLE experiments (
+		id INTEGER, name TEXT UNIQUE, description TEXT, PRIMARY KEY (id))`)
+	assert.NoError(err)
+	_, err = db.Exec(`CREATE TABLE assignments (
+		id INTEGER, user_id INTEGER, pair_id INTEGER, experiment_id INTEGER,
+		answer TEXT, duration INTEGER, PRIMARY KEY (id))`)
+	assert.NoError(err)
+	_, err = db.Exec(`INSERT INTO assignments VALUES (1, 1, 1, 1, 'yes', 10)`)
+	assert.NoError(err)
+
+	assert.NoError(Bootstrap(dbWrapper))
+	// migrations are not applied twice
+	assert.NoError(Bootstrap(dbWrapper))
+
+	for _, c := range migrations {
+		_, err := db.Exec(fmt.Sprintf(selectColumnSQL, c.name, c.table))
+		assert.NoError(err)
+	}
+
+	var createdAt time.Time
+	err = db.QueryRow(`SELECT created_at FROM assignments WHERE id=1`).Scan(&createdAt)
+	assert.NoError(err)
+	assert.Equal(time.Unix(0, 0).UTC(), createdAt)
+}
+
 func TestDBUtil(t *testing.T) {
 	suite.Run(t, new(DBUtilSuite))
 }
@@ -0,0 +1,202 @@
+package dbutil
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"time"
+)
+
+// QueryObserver is notified of the duration of every query sent to a DB
+// opened with OpenWithObserver. The operation is the lowercase SQL command of
+// the query: select, insert, update or delete, or other for the rest
+type QueryObserver interface {
+	ObserveQuery(operation string, d time.Duration)
+}
+
+// queryOperations are the SQL commands used as operation by QueryObserver
+var queryOperations = map[string]bool{
+	"select": true,
+	"insert": true,
+	"update": true,
+	"delete": true,
+}
+
+func queryOperation(query string) string {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return "other"
+	}
+
+	op := strings.ToLower(fields[0])
+	if !queryOperations[op] {
+		return "other"
+	}
+
+	return op
+}
+
+// observedConnector opens connections of the driver that time their queries,
+// including the ones made in transactions and with prepared statements.
+// The time reading the rows of a query is not included
+type observedConnector struct {
+	driver   driver.Driver
+	dsn      string
+	observer QueryObserver
+}
+
+func (c *observedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return &observedConn{conn, c.observer}, nil
+}
+
+func (c *observedConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+type observedConn struct {
+	driver.Conn
+	observer QueryObserver
+}
+
+func (c *observedConn) observe(query string, start time.Time) {
+	c.observer.ObserveQuery(queryOperation(query), time.Since(start))
+}
+
+func (c *observedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	var err error
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = p.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &observedStmt{stmt, query, c}, nil
+}
+
+func (c *observedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+
+	if opts.Isolation != 0 || opts.ReadOnly {
+		return nil, errors.New("the driver does not support transaction options")
+	}
+
+	return c.Conn.Begin()
+}
+
+func (c *observedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	start := time.Now()
+
+	var rows driver.Rows
+	var err error
+	if q, ok := c.Conn.(driver.QueryerContext); ok {
+		rows, err = q.QueryContext(ctx, query, args)
+	} else if q, ok := c.Conn.(driver.Queryer); ok {
+		var values []driver.Value
+		if values, err = namedValues(args); err == nil {
+			rows, err = q.Query(query, values)
+		}
+	} else {
+		return nil, driver.ErrSkip
+	}
+
+	if err != driver.ErrSkip {
+		c.observe(query, start)
+	}
+
+	return rows, err
+}
+
+func (c *observedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	start := time.Now()
+
+	var res driver.Result
+	var err error
+	if e, ok := c.Conn.(driver.ExecerContext); ok {
+		res, err = e.ExecContext(ctx, query, args)
+	} else if e, ok := c.Conn.(driver.Execer); ok {
+		var values []driver.Value
+		if values, err = namedValues(args); err == nil {
+			res, err = e.Exec(query, values)
+		}
+	} else {
+		return nil, driver.ErrSkip
+	}
+
+	if err != driver.ErrSkip {
+		c.observe(query, start)
+	}
+
+	return res, err
+}
+
+func (c *observedConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+
+	return nil
+}
+
+type observedStmt struct {
+	driver.Stmt
+	query string
+	conn  *observedConn
+}
+
+func (s *observedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	defer s.conn.observe(s.query, time.Now())
+
+	if q, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		return q.QueryContext(ctx, args)
+	}
+
+	values, err := namedValues(args)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Stmt.Query(values)
+}
+
+func (s *observedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	defer s.conn.observe(s.query, time.Now())
+
+	if e, ok := s.Stmt.(driver.StmtExecContext); ok {
+		return e.ExecContext(ctx, args)
+	}
+
+	values, err := namedValues(args)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Stmt.Exec(values)
+}
+
+// namedValues returns the values of the arguments for the drivers that do
+// not support named arguments
+func namedValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, errors.New("the driver does not support named arguments")
+		}
+
+		values[i] = arg.Value
+	}
+
+	return values, nil
+}
@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/src-d/code-annotation/server/model"
+	"github.com/src-d/code-annotation/server/repository"
+	"github.com/src-d/code-annotation/server/serializer"
+	"github.com/src-d/code-annotation/server/service"
+)
+
+// RequestProcessMiddleware is a function that wraps a RequestProcessFunc
+type RequestProcessMiddleware func(RequestProcessFunc) RequestProcessFunc
+
+// RequireRole returns a RequestProcessMiddleware that only calls the wrapped
+// RequestProcessFunc if the logged user has the given role. Otherwise it
+// returns a serializer.HTTPError with http.StatusForbidden. The requests made
+// with an API key are only allowed for the Worker role, even if the user of
+// the key was promoted after the key was created
+func RequireRole(usersRepo *repository.Users, role model.Role) RequestProcessMiddleware {
+	return func(next RequestProcessFunc) RequestProcessFunc {
+		return func(r *http.Request) (*serializer.Response, error) {
+			userID, err := service.GetUserID(r.Context())
+			if err != nil {
+				return nil, serializer.NewHTTPError(http.StatusUnauthorized, err.Error())
+			}
+
+			user, err := usersRepo.GetByID(r.Context(), userID)
+			if err != nil {
+				return nil, err
+			}
+
+			if user == nil || user.Role != role {
+				return nil, serializer.NewHTTPError(http.StatusForbidden,
+					"the logged user is not a "+role.String())
+			}
+
+			if role != model.Worker && service.IsAPIKeyAuth(r.Context()) {
+				return nil, serializer.NewHTTPError(http.StatusForbidden,
+					"API keys can not be used as a "+role.String())
+			}
+
+			return next(r)
+		}
+	}
+}
+
+// RequireRoleHandler returns a middleware for the routes that are not served
+// by a RequestProcessFunc, such as the streamed exports, that makes the same
+// check as RequireRole. The error is written as in the rest of the API
+func RequireRoleHandler(usersRepo *repository.Users, role model.Role) func(http.Handler) http.Handler {
+	check := RequireRole(usersRepo, role)(func(r *http.Request) (*serializer.Response, error) {
+		return nil, nil
+	})
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, err := check(r); err != nil {
+				write(w, r, nil, err)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RateLimit returns a RequestProcessMiddleware that only calls the wrapped
+// RequestProcessFunc if the passed service.RateLimitStore allows a new request
+// from the client IP. Otherwise it returns a serializer.HTTPError with
+// http.StatusTooManyRequests
+func RateLimit(store service.RateLimitStore) RequestProcessMiddleware {
+	return func(next RequestProcessFunc) RequestProcessFunc {
+		return func(r *http.Request) (*serializer.Response, error) {
+			allowed, err := store.Allow(clientIP(r))
+			if err != nil {
+				return nil, err
+			}
+
+			if !allowed {
+				return nil, serializer.NewHTTPError(http.StatusTooManyRequests,
+					"too many requests, try again later")
+			}
+
+			return next(r)
+		}
+	}
+}
+
+// clientIP returns the IP address of the client that made the request
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
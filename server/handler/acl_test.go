@@ -0,0 +1,100 @@
+package handler_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/src-d/code-annotation/server/handler"
+	"github.com/src-d/code-annotation/server/model"
+	"github.com/src-d/code-annotation/server/repository"
+	"github.com/src-d/code-annotation/server/serializer"
+	"github.com/src-d/code-annotation/server/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequireRole(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDB()
+	usersRepo := repository.NewUsers(db.DB)
+	assert.Nil(usersRepo.Create(context.Background(), &model.User{Login: "requester", Role: model.Requester}))
+	assert.Nil(usersRepo.Create(context.Background(), &model.User{Login: "worker", Role: model.Worker}))
+
+	next := func(r *http.Request) (*serializer.Response, error) {
+		return serializer.NewCountResponse(1), nil
+	}
+	h := handler.RequireRole(usersRepo, model.Requester)(next)
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	res, err := h(reqWithUser(req, 1))
+	assert.Nil(err)
+	assert.Equal(serializer.NewCountResponse(1), res)
+
+	res, err = h(reqWithUser(req, 2))
+	assert.Nil(res)
+	assert.Equal(http.StatusForbidden, err.(serializer.HTTPError).StatusCode())
+
+	res, err = h(req)
+	assert.Nil(res)
+	assert.Equal(http.StatusUnauthorized, err.(serializer.HTTPError).StatusCode())
+}
+
+func TestRequireRoleHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDB()
+	usersRepo := repository.NewUsers(db.DB)
+	assert.Nil(usersRepo.Create(context.Background(), &model.User{Login: "requester", Role: model.Requester}))
+	assert.Nil(usersRepo.Create(context.Background(), &model.User{Login: "worker", Role: model.Worker}))
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("data"))
+	})
+	logger, err := service.NewLogger("production", service.LoggerConfig{})
+	assert.Nil(err)
+	logger.Out = ioutil.Discard
+	h := handler.RequestLogger(logger)(handler.RequireRoleHandler(usersRepo, model.Requester)(next))
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, reqWithUser(req, 1))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("data", w.Body.String())
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, reqWithUser(req, 2))
+	assert.Equal(http.StatusForbidden, w.Code)
+	assert.Contains(w.Body.String(), "the logged user is not a requester")
+}
+
+func TestRateLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	next := func(r *http.Request) (*serializer.Response, error) {
+		return serializer.NewCountResponse(1), nil
+	}
+	h := handler.RateLimit(service.NewMemoryRateLimitStore(1, 1))(next)
+
+	request := func(remoteAddr string) (*serializer.Response, error) {
+		req, _ := http.NewRequest("GET", "/api/auth", nil)
+		req.RemoteAddr = remoteAddr
+		return h(req)
+	}
+
+	res, err := request("10.0.0.1:1234")
+	assert.Nil(err)
+	assert.Equal(serializer.NewCountResponse(1), res)
+
+	// the port does not identify the client
+	res, err = request("10.0.0.1:4321")
+	assert.Nil(res)
+	assert.Equal(serializer.NewHTTPError(http.StatusTooManyRequests,
+		"too many requests, try again later"), err)
+
+	res, err = request("10.0.0.2:1234")
+	assert.Nil(err)
+	assert.Equal(serializer.NewCountResponse(1), res)
+}
@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/src-d/code-annotation/server/model"
+	"github.com/src-d/code-annotation/server/repository"
+	"github.com/src-d/code-annotation/server/serializer"
+	"github.com/src-d/code-annotation/server/service"
+)
+
+type createAPIKeyRequest struct {
+	Name string `json:"name"`
+	// UserID is the service user the requests made with the key act as
+	UserID int `json:"userId"`
+}
+
+// CreateAPIKey returns a function that creates an API key for the passed
+// experiment, acting as the worker passed in the body request. The response
+// includes the key, that can not be retrieved later
+func CreateAPIKey(
+	experimentsRepo *repository.Experiments,
+	usersRepo *repository.Users,
+	repo *repository.APIKeys,
+) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, bodyError(err)
+		}
+
+		var req createAPIKeyRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		name := strings.TrimSpace(req.Name)
+		if name == "" {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest, "the API key name can not be empty")
+		}
+
+		experiment, err := experimentsRepo.GetByID(r.Context(), experimentID, false)
+		if err != nil {
+			return nil, err
+		}
+
+		if experiment == nil {
+			return nil, serializer.NewHTTPError(http.StatusNotFound, "no experiment found")
+		}
+
+		user, err := usersRepo.GetByID(r.Context(), req.UserID)
+		if err != nil {
+			return nil, err
+		}
+
+		if user == nil {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest, "no user found")
+		}
+
+		if user.Role != model.Worker {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest, "API keys can only act as a worker")
+		}
+
+		key, hash, err := service.NewAPIKey()
+		if err != nil {
+			return nil, err
+		}
+
+		apiKey := &model.APIKey{UserID: user.ID, ExperimentID: experiment.ID, Name: name}
+		if err := repo.Create(r.Context(), apiKey, hash); err != nil {
+			return nil, err
+		}
+
+		return serializer.NewAPIKeyResponse(apiKey, key), nil
+	}
+}
+
+// GetAPIKeys returns a function that returns a *serializer.Response with the
+// API keys of the passed experiment, including the revoked ones, but not the
+// keys themselves
+func GetAPIKeys(repo *repository.APIKeys) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
+		apiKeys, err := repo.GetAll(r.Context(), experimentID)
+		if err != nil {
+			return nil, err
+		}
+
+		return serializer.NewAPIKeysResponse(apiKeys), nil
+	}
+}
+
+// RevokeAPIKey returns a function that revokes the requested API key of the
+// passed experiment, so it can not be used anymore
+func RevokeAPIKey(repo *repository.APIKeys) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
+		apiKeyID, err := urlParamInt(r, "apiKeyId")
+		if err != nil {
+			return nil, err
+		}
+
+		ok, err := repo.Revoke(r.Context(), experimentID, apiKeyID)
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			return nil, serializer.NewHTTPError(http.StatusNotFound, "no active API key found")
+		}
+
+		return serializer.NewEmptyResponse(), nil
+	}
+}
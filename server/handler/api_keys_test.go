@@ -0,0 +1,159 @@
+package handler_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/src-d/code-annotation/server/handler"
+	"github.com/src-d/code-annotation/server/model"
+	"github.com/src-d/code-annotation/server/repository"
+	"github.com/src-d/code-annotation/server/serializer"
+	"github.com/src-d/code-annotation/server/service"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t,
+		&model.User{Login: "pipeline", Role: model.Worker},
+		&model.User{Login: "requester", Role: model.Requester},
+	)
+	repo := repository.NewAPIKeys(db.DB)
+	create := handler.CreateAPIKey(repository.NewExperiments(db.DB), repository.NewUsers(db.DB), repo)
+
+	createRequest := func(body string) *http.Request {
+		req, _ := http.NewRequest("POST", "/experiments/1/api-keys", strings.NewReader(body))
+		return chiRequest(req, map[string]string{"experimentId": "1"})
+	}
+
+	res, err := create(createRequest(`{"name": " pipeline ", "userId": 1}`))
+	assert.Nil(err)
+
+	created := withoutTimestamps(res)["data"].(map[string]interface{})
+	key := created["key"].(string)
+	assert.True(strings.HasPrefix(key, service.APIKeyPrefix))
+	assert.Equal("pipeline", created["name"])
+	assert.Equal(float64(1), created["userId"])
+	assert.Equal(float64(1), created["experimentId"])
+
+	// only the hash of the key is stored
+	var stored string
+	assert.Nil(db.QueryRow(`SELECT key_hash FROM api_keys WHERE id=1`).Scan(&stored))
+	assert.Equal(service.HashAPIKey(key), stored)
+
+	_, err = create(createRequest(`{"name": "", "userId": 1}`))
+	assert.Equal(http.StatusBadRequest, err.(serializer.HTTPError).StatusCode())
+	_, err = create(createRequest(`{"name": "other", "userId": 3}`))
+	assert.Equal(http.StatusBadRequest, err.(serializer.HTTPError).StatusCode())
+	_, err = create(createRequest(`{"name": "other", "userId": 2}`))
+	assert.Equal(serializer.NewHTTPError(http.StatusBadRequest, "API keys can only act as a worker"), err)
+
+	req, _ := http.NewRequest("GET", "/experiments/1/api-keys", nil)
+	res, err = handler.GetAPIKeys(repo)(chiRequest(req, map[string]string{"experimentId": "1"}))
+	assert.Nil(err)
+
+	listed := withoutTimestamps(res)["data"].([]interface{})
+	assert.Len(listed, 1)
+	assert.NotContains(listed[0], "key")
+
+	revokeRequest := func(id string) *http.Request {
+		req, _ := http.NewRequest("DELETE", "/experiments/1/api-keys/"+id, nil)
+		return chiRequest(req, map[string]string{"experimentId": "1", "apiKeyId": id})
+	}
+
+	res, err = handler.RevokeAPIKey(repo)(revokeRequest("1"))
+	assert.Nil(err)
+	assert.Equal(serializer.NewEmptyResponse(), res)
+
+	_, err = handler.RevokeAPIKey(repo)(revokeRequest("1"))
+	assert.Equal(serializer.NewHTTPError(http.StatusNotFound, "no active API key found"), err)
+}
+
+func TestAPIKeyAuthMiddleware(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t, &model.User{Login: "pipeline", Role: model.Worker})
+	repo := repository.NewAPIKeys(db.DB)
+
+	key, hash, err := service.NewAPIKey()
+	assert.Nil(err)
+	assert.Nil(repo.Create(context.Background(), &model.APIKey{UserID: 1, ExperimentID: 1, Name: "pipeline"}, hash))
+
+	fallback := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		})
+	}
+
+	h := service.NewAPIKeyAuth(repo).Middleware(fallback)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, err := service.GetUserID(r.Context())
+			assert.Nil(err)
+			fmt.Fprint(w, userID)
+		}))
+
+	request := func(path, token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request("/api/experiments/1/assignments", key)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("1", w.Body.String())
+
+	// the JWT tokens are left to the fallback
+	assert.Equal(http.StatusTeapot, request("/api/experiments/1/assignments", "eyJhbGciOi").Code)
+
+	assert.Equal(http.StatusUnauthorized, request("/api/experiments/1", service.APIKeyPrefix+"wrong").Code)
+	assert.Equal(http.StatusForbidden, request("/api/experiments/2", key).Code)
+	assert.Equal(http.StatusForbidden, request("/api/experiments/10", key).Code)
+	assert.Equal(http.StatusForbidden, request("/api/experiments", key).Code)
+	assert.Equal(http.StatusForbidden, request("/api/experiments/1/api-keys", key).Code)
+
+	ok, err := repo.Revoke(context.Background(), 1, 1)
+	assert.Nil(err)
+	assert.True(ok)
+	assert.Equal(http.StatusUnauthorized, request("/api/experiments/1/assignments", key).Code)
+}
+
+func TestAPIKeyRequireRole(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t, &model.User{Login: "pipeline", Role: model.Worker})
+	repo := repository.NewAPIKeys(db.DB)
+	usersRepo := repository.NewUsers(db.DB)
+
+	key, hash, err := service.NewAPIKey()
+	assert.Nil(err)
+	assert.Nil(repo.Create(context.Background(), &model.APIKey{UserID: 1, ExperimentID: 1, Name: "pipeline"}, hash))
+
+	// the user of the key is promoted after the key was created
+	ok, err := usersRepo.UpdateRole(context.Background(), 1, model.Requester)
+	assert.Nil(err)
+	assert.True(ok)
+
+	next := func(r *http.Request) (*serializer.Response, error) {
+		return serializer.NewCountResponse(1), nil
+	}
+
+	var res *serializer.Response
+	h := service.NewAPIKeyAuth(repo).Middleware(func(next http.Handler) http.Handler { return next })(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			res, err = handler.RequireRole(usersRepo, model.Requester)(next)(r)
+		}))
+
+	req, _ := http.NewRequest("DELETE", "/api/experiments/1", nil)
+	req.Header.Set("Authorization", "Bearer "+key)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Nil(res)
+	assert.Equal(serializer.NewHTTPError(http.StatusForbidden, "API keys can not be used as a requester"), err)
+}
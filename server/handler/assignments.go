@@ -1,10 +1,21 @@
 package handler
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"math"
+	"math/rand"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
+	"github.com/src-d/code-annotation/server/dbutil"
+	"github.com/src-d/code-annotation/server/model"
 	"github.com/src-d/code-annotation/server/repository"
 	"github.com/src-d/code-annotation/server/serializer"
 	"github.com/src-d/code-annotation/server/service"
@@ -12,7 +23,9 @@ import (
 
 // GetAssignmentsForUserExperiment returns a function that returns a *serializer.Response
 // with the assignments for the logged user and a passed experiment
-// if these assignments do not already exist, they are created in advance
+// if these assignments do not already exist, they are created in advance.
+// Once the assignments of the experiment are managed, by assigning, moving or
+// deleting them explicitly, only the existing ones are returned
 func GetAssignmentsForUserExperiment(repo *repository.Assignments) RequestProcessFunc {
 	return func(r *http.Request) (*serializer.Response, error) {
 		experimentID, err := urlParamInt(r, "experimentId")
@@ -25,18 +38,18 @@ func GetAssignmentsForUserExperiment(repo *repository.Assignments) RequestProces
 			return nil, err
 		}
 
-		initialized, err := repo.IsInitialized(userID, experimentID)
+		initialized, err := repo.IsInitialized(r.Context(), userID, experimentID)
 		if err != nil {
 			return nil, err
 		}
 
 		if !initialized {
-			if _, err = repo.Initialize(userID, experimentID); err != nil {
+			if _, err = repo.Initialize(r.Context(), userID, experimentID); err != nil {
 				return nil, err
 			}
 		}
 
-		assignments, err := repo.GetAll(userID, experimentID)
+		assignments, err := repo.GetAll(r.Context(), userID, experimentID)
 		if err != nil {
 			return nil, err
 		}
@@ -45,60 +58,1174 @@ func GetAssignmentsForUserExperiment(repo *repository.Assignments) RequestProces
 	}
 }
 
+// GetUserAssignments returns a function that returns a *serializer.Response
+// with the existing assignments of the logged user for the passed experiment,
+// ordered by ID. Unlike GetAssignmentsForUserExperiment, no assignment is created
+func GetUserAssignments(repo *repository.Assignments) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
+		userID, err := service.GetUserID(r.Context())
+		if err != nil {
+			return nil, err
+		}
+
+		assignments, err := repo.GetByUserAndExperiment(r.Context(), userID, experimentID)
+		if err != nil {
+			return nil, err
+		}
+
+		return serializer.NewAssignmentsResponse(assignments), nil
+	}
+}
+
+// GetRemainingCount returns a function that returns a *serializer.Response
+// with the number of unanswered assignments of the logged user for the passed
+// experiment, and the total of them
+func GetRemainingCount(repo *repository.Assignments) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
+		userID, err := service.GetUserID(r.Context())
+		if err != nil {
+			return nil, err
+		}
+
+		total, complete, err := repo.CountUserAssignments(r.Context(), experimentID, userID)
+		if err != nil {
+			return nil, fmt.Errorf("Error count of assigments from the DB: %v", err)
+		}
+
+		return serializer.NewRemainingCountResponse(total-complete, total), nil
+	}
+}
+
+// GetNextUnansweredAssignment returns a function that returns a *serializer.Response
+// with the first unanswered assignment of the logged user for the passed
+// experiment. If the "scoreTarget" query parameter is set, the assignment is
+// instead picked at random among the unanswered ones, preferring the file pairs
+// with a score close to it, see service.PickByScore. If all of them are
+// answered, the response has no content.
+//
+// Without a target the unanswered assignments are not picked at random, but
+// deliberately served in the order they were created, the same one followed by
+// GetPreviousAssignment to go back. That order is set by the assignment
+// strategy of the experiment: with model.AssignmentRandom it is already a
+// uniformly random order for each user, reproducible from the experiment seed
+func GetNextUnansweredAssignment(repo *repository.Assignments) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
+		userID, err := service.GetUserID(r.Context())
+		if err != nil {
+			return nil, err
+		}
+
+		target, err := scoreTarget(r)
+		if err != nil {
+			return nil, err
+		}
+
+		var assignment *model.Assignment
+		if target == nil {
+			// the order of the assignments is kept, see the function doc
+			assignment, err = repo.GetNextUnanswered(r.Context(), userID, experimentID)
+		} else {
+			assignment, err = nextAssignmentByScore(r.Context(), repo, userID, experimentID, *target)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if assignment == nil {
+			return serializer.NewNoContentResponse(), nil
+		}
+
+		return serializer.NewAssignmentResponse(assignment), nil
+	}
+}
+
+// GetPreviousAssignment returns a function that returns a *serializer.Response
+// with the assignment of the logged user that comes before the requested one,
+// in the same order followed by GetNextUnansweredAssignment. If the requested
+// assignment is the first one, the response has no content
+func GetPreviousAssignment(repo *repository.Assignments) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
+		current, err := ownAssignment(r, repo)
+		if err != nil {
+			return nil, err
+		}
+
+		assignment, err := repo.GetPrevious(r.Context(), current.UserID, experimentID, current.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		if assignment == nil {
+			return serializer.NewNoContentResponse(), nil
+		}
+
+		return serializer.NewAssignmentResponse(assignment), nil
+	}
+}
+
+// scoreTarget returns the value of the "scoreTarget" query parameter, or nil if
+// it is not set. It returns a serializer.HTTPError if it is not a number
+func scoreTarget(r *http.Request) (*float64, error) {
+	value := r.URL.Query().Get("scoreTarget")
+	if value == "" {
+		return nil, nil
+	}
+
+	target, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(target) || math.IsInf(target, 0) {
+		return nil, serializer.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("invalid scoreTarget %q, it must be a number", value))
+	}
+
+	return &target, nil
+}
+
+// nextAssignmentByScore returns one of the unanswered assignments of the user
+// for the experiment, picked at random with a probability weighted by how
+// close the score of its file pair is to target. If all of them are answered,
+// it returns nil, nil
+func nextAssignmentByScore(
+	ctx context.Context,
+	repo *repository.Assignments,
+	userID, experimentID int,
+	target float64,
+) (*model.Assignment, error) {
+	ids, scores, err := repo.GetUnansweredScores(ctx, userID, experimentID)
+	if err != nil {
+		return nil, err
+	}
+
+	i := service.PickByScore(scores, target, rand.Float64())
+	if i < 0 {
+		return nil, nil
+	}
+
+	return repo.GetByID(ctx, ids[i])
+}
+
+// GetAssignment returns a function that returns a *serializer.Response with
+// the requested assignment of the passed experiment. Only its owner, or a
+// requester, can get it
+func GetAssignment(usersRepo *repository.Users, repo *repository.Assignments) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
+		assignmentID, err := urlParamInt(r, "assignmentId")
+		if err != nil {
+			return nil, err
+		}
+
+		assignment, err := repo.GetByID(r.Context(), assignmentID)
+		if err != nil {
+			return nil, err
+		}
+
+		if assignment == nil || assignment.ExperimentID != experimentID {
+			return nil, serializer.NewHTTPError(http.StatusNotFound, "assignment not found")
+		}
+
+		if err := ownerOrRequester(r, usersRepo, assignment); err != nil {
+			return nil, err
+		}
+
+		return serializer.NewAssignmentResponse(assignment), nil
+	}
+}
+
+// GetAnswerHistory returns a function that returns a *serializer.Response
+// with every answer given to the requested assignment, from the first one to
+// the current one. Only its owner and the requesters can read it
+func GetAnswerHistory(usersRepo *repository.Users, repo *repository.Assignments) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
+		assignmentID, err := urlParamInt(r, "assignmentId")
+		if err != nil {
+			return nil, err
+		}
+
+		assignment, err := repo.GetByID(r.Context(), assignmentID)
+		if err != nil {
+			return nil, err
+		}
+
+		if assignment == nil || assignment.ExperimentID != experimentID {
+			return nil, serializer.NewHTTPError(http.StatusNotFound, "assignment not found")
+		}
+
+		if err := ownerOrRequester(r, usersRepo, assignment); err != nil {
+			return nil, err
+		}
+
+		history, err := repo.GetAnswerHistory(r.Context(), assignment.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		return serializer.NewAnswerHistoryResponse(assignment.ID, history), nil
+	}
+}
+
+// ownerOrRequester returns a 403 error unless the logged user is the owner of
+// the assignment or a requester
+func ownerOrRequester(r *http.Request, usersRepo *repository.Users, assignment *model.Assignment) error {
+	userID, err := service.GetUserID(r.Context())
+	if err != nil {
+		return err
+	}
+
+	if userID == assignment.UserID {
+		return nil
+	}
+
+	user, err := usersRepo.GetByID(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	if user == nil || user.Role != model.Requester {
+		return serializer.NewHTTPError(http.StatusForbidden,
+			"logged in user is not the assignment's owner")
+	}
+
+	return nil
+}
+
 type assignmentRequest struct {
 	Answer   string `json:"answer"`
 	Duration int    `json:"duration"`
+	// Confidence is optional, from model.MinConfidence to model.MaxConfidence
+	Confidence *int `json:"confidence"`
+	// Comment is optional, an empty one is not saved
+	Comment *string `json:"comment"`
+}
+
+// validateAnswer returns a serializer.HTTPError if the passed answer is not
+// accepted by the answer scheme of the experiment
+func validateAnswer(experiment *model.Experiment, answer string) error {
+	if !experiment.IsValidAnswer(answer) {
+		return serializer.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("Wrong answer provided: %q", answer))
+	}
+
+	return nil
+}
+
+// validateConfidence returns a serializer.HTTPError if the passed confidence
+// is not nil and it is out of the accepted range
+func validateConfidence(confidence *int) error {
+	if !model.IsValidConfidence(confidence) {
+		return serializer.NewHTTPError(http.StatusBadRequest, fmt.Sprintf(
+			"confidence must be between %d and %d", model.MinConfidence, model.MaxConfidence))
+	}
+
+	return nil
+}
+
+// answerComment returns the passed comment trimmed, or nil if it is nil or
+// empty. It returns a serializer.HTTPError if it has more than maxLength
+// characters
+func answerComment(comment *string, maxLength int) (*string, error) {
+	if comment == nil {
+		return nil, nil
+	}
+
+	trimmed := strings.TrimSpace(*comment)
+	if trimmed == "" {
+		return nil, nil
+	}
+
+	if utf8.RuneCountInString(trimmed) > maxLength {
+		return nil, serializer.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("comment can not be longer than %d characters", maxLength))
+	}
+
+	return &trimmed, nil
+}
+
+// SessionIDHeader is the request header used by the clients to identify the
+// annotation session in which an answer is given
+const SessionIDHeader = "X-Session-Id"
+
+// maxSessionIDLength is the max number of characters of a session ID
+const maxSessionIDLength = 128
+
+// answerSessionID returns the session ID sent in the SessionIDHeader of the
+// request trimmed, or nil if there is none. It returns a serializer.HTTPError
+// if it is too long
+func answerSessionID(r *http.Request) (*string, error) {
+	sessionID := strings.TrimSpace(r.Header.Get(SessionIDHeader))
+	if sessionID == "" {
+		return nil, nil
+	}
+
+	if utf8.RuneCountInString(sessionID) > maxSessionIDLength {
+		return nil, serializer.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("session ID can not be longer than %d characters", maxSessionIDLength))
+	}
+
+	return &sessionID, nil
+}
+
+// answerDuration returns the duration to be saved with an answer: the one sent
+// by the client, or the pending one recorded with HeartbeatAssignment if it is
+// longer, as the client may have lost its count. It is capped to maxDuration
+func answerDuration(duration int, pending *int, maxDuration time.Duration) int {
+	if pending != nil && *pending > duration {
+		duration = *pending
+	}
+
+	return capDuration(duration, maxDuration)
+}
+
+// capDuration returns the given duration, in milliseconds, or maxDuration if
+// it is longer
+func capDuration(duration int, maxDuration time.Duration) int {
+	if max := int(maxDuration / time.Millisecond); duration > max {
+		return max
+	}
+
+	return duration
+}
+
+// SaveAssignment returns a function that replaces the answer and duration of
+// an assignment of the logged user with the ones passed in the body request,
+// and its session with the one sent in the SessionIDHeader, if any. The
+// answers of frozen or closed experiments are rejected, as well as the
+// comments longer than maxCommentLength. The durations are capped to
+// maxDuration, see answerDuration. Durations above the experiment outlier
+// threshold, or defaultOutlierThreshold if it has none, are flagged as
+// outliers. The new experiment progress is published to progress, and the
+// answer to events
+func SaveAssignment(
+	experimentsRepo *repository.Experiments,
+	repo *repository.Assignments,
+	defaultOutlierThreshold time.Duration,
+	maxDuration time.Duration,
+	maxCommentLength int,
+	progress *service.Hub,
+	events *service.Hub,
+) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		assignment, err := ownAssignment(r, repo)
+		if err != nil {
+			return nil, err
+		}
+
+		experiment, err := answerableExperiment(r.Context(), experimentsRepo, assignment)
+		if err != nil {
+			return nil, err
+		}
+
+		var assignmentRequest assignmentRequest
+		body, err := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+		if err == nil {
+			err = json.Unmarshal(body, &assignmentRequest)
+		}
+
+		if err != nil {
+			return nil, bodyError(err)
+		}
+
+		if err := validateAnswer(experiment, assignmentRequest.Answer); err != nil {
+			return nil, err
+		}
+
+		if err := validateConfidence(assignmentRequest.Confidence); err != nil {
+			return nil, err
+		}
+
+		comment, err := answerComment(assignmentRequest.Comment, maxCommentLength)
+		if err != nil {
+			return nil, err
+		}
+
+		sessionID, err := answerSessionID(r)
+		if err != nil {
+			return nil, err
+		}
+
+		duration := answerDuration(assignmentRequest.Duration, assignment.PendingDuration, maxDuration)
+		outlier := experiment.IsOutlier(duration, int(defaultOutlierThreshold/time.Millisecond))
+
+		err = repo.UpdateAnswer(r.Context(), assignment.ID, assignmentRequest.Answer,
+			duration, assignmentRequest.Confidence, comment, outlier, sessionID)
+		if err != nil {
+			return nil, err
+		}
+
+		publishProgress(r, progress, repo, assignment.ExperimentID)
+		publishAnswer(events, assignment, assignmentRequest.Answer)
+
+		return serializer.NewCountResponse(1), nil
+	}
+}
+
+// DeleteAssignment returns a function that deletes the requested assignment.
+// If it is already answered, the "force" query parameter must be true
+func DeleteAssignment(repo *repository.Assignments) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
+		assignmentID, err := urlParamInt(r, "assignmentId")
+		if err != nil {
+			return nil, err
+		}
+
+		assignment, err := repo.GetByID(r.Context(), assignmentID)
+		if err != nil {
+			return nil, err
+		}
+
+		if assignment == nil || assignment.ExperimentID != experimentID {
+			return nil, serializer.NewHTTPError(http.StatusNotFound, "assignment not found")
+		}
+
+		if assignment.Answer.Valid && r.URL.Query().Get("force") != "true" {
+			return nil, serializer.NewHTTPError(http.StatusConflict,
+				"the assignment is already answered, use force=true to delete it")
+		}
+
+		if err := repo.Delete(r.Context(), assignment.ID); err != nil {
+			return nil, err
+		}
+
+		return serializer.NewEmptyResponse(), nil
+	}
+}
+
+type heartbeatRequest struct {
+	Duration int `json:"duration"`
+}
+
+// HeartbeatAssignment returns a function that records the time spent so far,
+// in milliseconds, on an assignment of the logged user before answering it,
+// as passed in the body request. It is capped to maxDuration, and it is kept
+// as the answer duration if the client sends a shorter one, see answerDuration
+func HeartbeatAssignment(
+	experimentsRepo *repository.Experiments,
+	repo *repository.Assignments,
+	maxDuration time.Duration,
+) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		assignment, err := ownAssignment(r, repo)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := answerableExperiment(r.Context(), experimentsRepo, assignment); err != nil {
+			return nil, err
+		}
+
+		var heartbeatRequest heartbeatRequest
+		body, err := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+		if err == nil {
+			err = json.Unmarshal(body, &heartbeatRequest)
+		}
+
+		if err != nil {
+			return nil, bodyError(err)
+		}
+
+		if heartbeatRequest.Duration < 0 {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest, "duration can not be negative")
+		}
+
+		ok, err := repo.SetPendingDuration(r.Context(), assignment.ID,
+			capDuration(heartbeatRequest.Duration, maxDuration))
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			return nil, serializer.NewHTTPError(http.StatusNotFound, "assignment not found")
+		}
+
+		return serializer.NewEmptyResponse(), nil
+	}
+}
+
+// FlagAssignment returns a function that flags, or unflags, an assignment of
+// the logged user for review by a supervisor. The answer of the assignment is
+// kept, and it can be flagged whether it is answered or not
+func FlagAssignment(repo *repository.Assignments, flagged bool) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		assignment, err := ownAssignment(r, repo)
+		if err != nil {
+			return nil, err
+		}
+
+		ok, err := repo.SetFlagged(r.Context(), assignment.ID, flagged)
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			return nil, serializer.NewHTTPError(http.StatusNotFound, "assignment not found")
+		}
+
+		assignment.Flagged = flagged
+		return serializer.NewAssignmentResponse(assignment), nil
+	}
+}
+
+// GetFlaggedAssignments returns a function that returns a *serializer.Response
+// with the assignments of the passed experiment flagged for review, of all the
+// users, ordered by ID
+func GetFlaggedAssignments(repo *repository.Assignments) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
+		assignments, err := repo.GetFlagged(r.Context(), experimentID)
+		if err != nil {
+			return nil, err
+		}
+
+		return serializer.NewAssignmentsResponse(assignments), nil
+	}
+}
+
+// answerableExperiment returns the experiment of the passed assignment. It
+// returns a serializer.HTTPError if the experiment is frozen, or if its
+// deadline has passed
+func answerableExperiment(
+	ctx context.Context,
+	experimentsRepo *repository.Experiments,
+	assignment *model.Assignment,
+) (*model.Experiment, error) {
+	experiment, err := experimentsRepo.GetByID(ctx, assignment.ExperimentID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if experiment == nil {
+		return nil, fmt.Errorf("no experiment found with ID %d", assignment.ExperimentID)
+	}
+
+	if experiment.IsFrozen() {
+		return nil, serializer.NewHTTPError(http.StatusConflict,
+			"the experiment is frozen, its assignments can not be answered")
+	}
+
+	if experiment.IsClosed() {
+		return nil, serializer.NewHTTPError(http.StatusForbidden,
+			"the deadline of the experiment has passed, its assignments can not be answered")
+	}
+
+	return experiment, nil
+}
+
+// ownAssignment returns the assignment requested in the URL. It returns a
+// serializer.HTTPError if it does not exist in the experiment of the URL, or
+// if it does not belong to the logged user
+func ownAssignment(r *http.Request, repo *repository.Assignments) (*model.Assignment, error) {
+	experimentID, err := urlParamInt(r, "experimentId")
+	if err != nil {
+		return nil, err
+	}
+
+	assignmentID, err := urlParamInt(r, "assignmentId")
+	if err != nil {
+		return nil, err
+	}
+
+	assignment, err := repo.GetByID(r.Context(), assignmentID)
+	if err != nil {
+		return nil, err
+	}
+
+	if assignment == nil || assignment.ExperimentID != experimentID {
+		return nil, serializer.NewHTTPError(http.StatusNotFound, "assignment not found")
+	}
+
+	userID, err := service.GetUserID(r.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	if userID != assignment.UserID {
+		return nil, serializer.NewHTTPError(http.StatusForbidden,
+			"logged in user is not the assignment's owner")
+	}
+
+	return assignment, nil
+}
+
+type assignFilePairsRequest struct {
+	UserIDs []int `json:"userIds"`
+	PairIDs []int `json:"pairIds"`
+	// Overlap is the number of users each file pair is assigned to; every
+	// user gets every file pair if it is not set
+	Overlap *int `json:"overlap"`
+}
+
+// AssignFilePairs returns a function that creates the assignments of the
+// experiment for every combination of the users and file pairs passed in the
+// body request. If no file pairs are passed, all the experiment ones are used.
+// If an overlap is passed, each file pair is only assigned to that many users,
+// or to all of them if there are less. In that case the response also includes
+// the overlap achieved
+func AssignFilePairs(
+	usersRepo *repository.Users,
+	filePairsRepo *repository.FilePairs,
+	repo *repository.Assignments,
+) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
+		var req assignFilePairsRequest
+		body, err := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+		if err == nil {
+			err = json.Unmarshal(body, &req)
+		}
+
+		if err != nil {
+			return nil, bodyError(err)
+		}
+
+		if len(req.UserIDs) == 0 {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest, "no users to assign")
+		}
+
+		if req.Overlap != nil && *req.Overlap <= 0 {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest, "overlap must be greater than 0")
+		}
+
+		for _, userID := range req.UserIDs {
+			user, err := usersRepo.GetByID(r.Context(), userID)
+			if err != nil {
+				return nil, err
+			}
+
+			if user == nil {
+				return nil, serializer.NewHTTPError(http.StatusBadRequest,
+					fmt.Sprintf("user %d not found", userID))
+			}
+		}
+
+		pairIDs, err := filePairsRepo.GetIDs(r.Context(), experimentID)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(req.PairIDs) > 0 {
+			experimentPairs := make(map[int]bool, len(pairIDs))
+			for _, id := range pairIDs {
+				experimentPairs[id] = true
+			}
+
+			for _, id := range req.PairIDs {
+				if !experimentPairs[id] {
+					return nil, serializer.NewHTTPError(http.StatusBadRequest,
+						fmt.Sprintf("file pair %d not found in the experiment", id))
+				}
+			}
+
+			pairIDs = req.PairIDs
+		}
+
+		if req.Overlap == nil {
+			created, err := repo.CreateBatch(r.Context(), experimentID, req.UserIDs, pairIDs)
+			if err != nil {
+				return nil, err
+			}
+
+			return serializer.NewCountResponse(created), nil
+		}
+
+		pairsByUser, overlap := distributePairs(req.UserIDs, pairIDs, *req.Overlap)
+		created, err := repo.CreateBatchByUser(r.Context(), experimentID, req.UserIDs, pairsByUser)
+		if err != nil {
+			return nil, err
+		}
+
+		return serializer.NewOverlapCountResponse(created, overlap), nil
+	}
+}
+
+// distributePairs assigns each one of the pair IDs to overlap distinct users,
+// going round-robin through the user IDs so every user gets a similar number
+// of pairs. If there are less users than overlap, each pair is assigned to all
+// of them. It returns the pair IDs for each user, and the overlap achieved
+func distributePairs(userIDs, pairIDs []int, overlap int) (map[int][]int, int) {
+	if overlap > len(userIDs) {
+		overlap = len(userIDs)
+	}
+
+	pairsByUser := make(map[int][]int, len(userIDs))
+	next := 0
+	for _, pairID := range pairIDs {
+		for i := 0; i < overlap; i++ {
+			userID := userIDs[next]
+			pairsByUser[userID] = append(pairsByUser[userID], pairID)
+			next = (next + 1) % len(userIDs)
+		}
+	}
+
+	return pairsByUser, overlap
+}
+
+type reassignAssignmentsRequest struct {
+	FromUserID int `json:"fromUserId"`
+	ToUserID   int `json:"toUserId"`
 }
 
-// SaveAssignment returns a function that saves the user answers as passed in the body request
-func SaveAssignment(repo *repository.Assignments) RequestProcessFunc {
+// ReassignAssignments returns a function that moves the unanswered
+// assignments of the experiment from one user to another, as passed in the
+// body request. The answered assignments are never moved. It returns the
+// number of moved assignments
+func ReassignAssignments(usersRepo *repository.Users, repo *repository.Assignments) RequestProcessFunc {
 	return func(r *http.Request) (*serializer.Response, error) {
-		assignmentID, err := urlParamInt(r, "assignmentId")
+		experimentID, err := urlParamInt(r, "experimentId")
 		if err != nil {
 			return nil, err
 		}
 
-		assignment, err := repo.GetByID(assignmentID)
+		var req reassignAssignmentsRequest
+		body, err := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+		if err == nil {
+			err = json.Unmarshal(body, &req)
+		}
+
 		if err != nil {
-			return nil, err
+			return nil, bodyError(err)
 		}
 
-		if assignment == nil {
-			return nil, serializer.NewHTTPError(http.StatusNotFound, "assignment not found")
+		if req.FromUserID == req.ToUserID {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest,
+				"the assignments must be moved to a different user")
 		}
 
-		userID, err := service.GetUserID(r.Context())
+		for _, userID := range []int{req.FromUserID, req.ToUserID} {
+			user, err := usersRepo.GetByID(r.Context(), userID)
+			if err != nil {
+				return nil, err
+			}
+
+			if user == nil {
+				return nil, serializer.NewHTTPError(http.StatusBadRequest,
+					fmt.Sprintf("user %d not found", userID))
+			}
+		}
+
+		moved, err := repo.Reassign(r.Context(), experimentID, req.FromUserID, req.ToUserID)
 		if err != nil {
 			return nil, err
 		}
 
-		if userID != assignment.UserID {
-			return nil, serializer.NewHTTPError(http.StatusForbidden,
-				"logged in user is not the assignment's owner")
+		return serializer.NewCountResponse(moved), nil
+	}
+}
+
+type resetUserAssignmentsRequest struct {
+	UserID int `json:"userId"`
+}
+
+// ResetUserAssignments returns a function that clears the answers of the
+// user passed in the body request, in the given experiment, along with their
+// sessions, pending durations and flags. The assignments are kept, so they can
+// be answered again. It returns the number of reset assignments
+func ResetUserAssignments(
+	usersRepo *repository.Users,
+	repo *repository.Assignments,
+	progress *service.Hub,
+) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
 		}
 
-		var assignmentRequest assignmentRequest
+		var req resetUserAssignmentsRequest
 		body, err := ioutil.ReadAll(r.Body)
 		defer r.Body.Close()
 		if err == nil {
-			err = json.Unmarshal(body, &assignmentRequest)
+			err = json.Unmarshal(body, &req)
 		}
 
+		if err != nil {
+			return nil, bodyError(err)
+		}
+
+		user, err := usersRepo.GetByID(r.Context(), req.UserID)
 		if err != nil {
 			return nil, err
 		}
 
-		err = repo.Update(assignmentID, assignmentRequest.Answer, assignmentRequest.Duration)
+		if user == nil {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("user %d not found", req.UserID))
+		}
+
+		reset, err := repo.ResetUser(r.Context(), experimentID, req.UserID)
 		if err != nil {
 			return nil, err
 		}
 
-		return serializer.NewCountResponse(1), nil
+		if reset > 0 {
+			publishProgress(r, progress, repo, experimentID)
+		}
+
+		return serializer.NewCountResponse(reset), nil
+	}
+}
+
+type importedAnnotation struct {
+	UserID   int    `json:"userId"`
+	PairID   int    `json:"pairId"`
+	Answer   string `json:"answer"`
+	Duration int    `json:"duration"`
+}
+
+// ImportAnnotations returns a function that saves the answers passed in the
+// body request, as a JSON array, to the assignments of the passed experiment,
+// creating the ones that do not exist. The answers of frozen experiments are
+// rejected. The invalid annotations, or the ones for unknown users or pairs of
+// another experiment, are skipped. The valid ones are saved in a single
+// transaction, and the response includes the details of up to
+// maxFailureDetails skipped annotations, with their 1-based position in the
+// array. Durations above the experiment outlier threshold, or
+// defaultOutlierThreshold if it has none, are flagged as outliers
+func ImportAnnotations(
+	experimentsRepo *repository.Experiments,
+	usersRepo *repository.Users,
+	filePairsRepo *repository.FilePairs,
+	repo *repository.Assignments,
+	defaultOutlierThreshold time.Duration,
+	maxFailureDetails int,
+) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
+		var annotations []importedAnnotation
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, bodyError(err)
+		}
+
+		if err := json.Unmarshal(body, &annotations); err != nil {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		experiment, err := experimentsRepo.GetByID(r.Context(), experimentID, false)
+		if err != nil {
+			return nil, err
+		}
+
+		if experiment == nil {
+			return nil, serializer.NewHTTPError(http.StatusNotFound, "no experiment found")
+		}
+
+		if experiment.IsFrozen() {
+			return nil, serializer.NewHTTPError(http.StatusConflict,
+				"the experiment is frozen, its assignments can not be answered")
+		}
+
+		pairIDs, err := filePairsRepo.GetIDs(r.Context(), experimentID)
+		if err != nil {
+			return nil, err
+		}
+
+		experimentPairs := make(map[int]bool, len(pairIDs))
+		for _, id := range pairIDs {
+			experimentPairs[id] = true
+		}
+
+		users := make(map[int]bool)
+		details := &failureDetails{max: maxFailureDetails}
+		var failures int64
+		fail := func(row int, reason string) {
+			details.add(dbutil.ImportFailure{Row: row, Reason: reason})
+			failures++
+		}
+
+		threshold := int(defaultOutlierThreshold / time.Millisecond)
+		var valid []*model.Assignment
+		for i, a := range annotations {
+			row := i + 1
+
+			if err := validateAnswer(experiment, a.Answer); err != nil {
+				fail(row, err.Error())
+				continue
+			}
+
+			if a.Duration < 0 {
+				fail(row, "the duration can not be negative")
+				continue
+			}
+
+			if !experimentPairs[a.PairID] {
+				fail(row, fmt.Sprintf("no file pair %d in the experiment", a.PairID))
+				continue
+			}
+
+			exists, ok := users[a.UserID]
+			if !ok {
+				user, err := usersRepo.GetByID(r.Context(), a.UserID)
+				if err != nil {
+					return nil, err
+				}
+
+				exists = user != nil
+				users[a.UserID] = exists
+			}
+
+			if !exists {
+				fail(row, fmt.Sprintf("no user %d found", a.UserID))
+				continue
+			}
+
+			valid = append(valid, &model.Assignment{
+				UserID:   a.UserID,
+				PairID:   a.PairID,
+				Answer:   sql.NullString{String: a.Answer, Valid: true},
+				Duration: a.Duration,
+				Outlier:  experiment.IsOutlier(a.Duration, threshold),
+			})
+		}
+
+		success, err := repo.ImportAnswers(r.Context(), experimentID, valid)
+		if err != nil {
+			return nil, err
+		}
+
+		return serializer.NewFilePairsUploadResponse(int64(success), failures, details.list...), nil
+	}
+}
+
+// GetUserQualityScore returns a function that returns a *serializer.Response
+// with the accuracy of the answers of the requested user to the gold standard
+// file pairs of the experiment, see repository.Assignments.GetQualityScore
+func GetUserQualityScore(usersRepo *repository.Users, repo *repository.Assignments) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
+		userID, err := urlParamInt(r, "userId")
+		if err != nil {
+			return nil, err
+		}
+
+		user, err := usersRepo.GetByID(r.Context(), userID)
+		if err != nil {
+			return nil, err
+		}
+
+		if user == nil {
+			return nil, serializer.NewHTTPError(http.StatusNotFound, "user not found")
+		}
+
+		score, err := repo.GetQualityScore(r.Context(), experimentID, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		return serializer.NewQualityScoreResponse(userID, score), nil
+	}
+}
+
+// GetConfusionMatrix returns a function that returns a *serializer.Response
+// with the confusion matrix of the answers of the requested user against the
+// majority answer of every file pair of the experiment, see confusionMatrix.
+// Only the file pairs with at least as many votes as the "minVotes" query
+// parameter, 1 by default, are compared
+func GetConfusionMatrix(usersRepo *repository.Users, repo *repository.Assignments) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
+		userID, err := urlParamInt(r, "userId")
+		if err != nil {
+			return nil, err
+		}
+
+		minVotes, err := urlQueryInt(r, "minVotes", 1)
+		if err != nil {
+			return nil, err
+		}
+
+		user, err := usersRepo.GetByID(r.Context(), userID)
+		if err != nil {
+			return nil, err
+		}
+
+		if user == nil {
+			return nil, serializer.NewHTTPError(http.StatusNotFound, "user not found")
+		}
+
+		consensus, err := repo.GetPairConsensus(r.Context(), experimentID)
+		if err != nil {
+			return nil, err
+		}
+
+		assignments, err := repo.GetByUserAndExperiment(r.Context(), userID, experimentID)
+		if err != nil {
+			return nil, err
+		}
+
+		matrix, pairs := confusionMatrix(consensus, assignments, minVotes)
+		return serializer.NewConfusionMatrixResponse(userID, pairs, matrix), nil
+	}
+}
+
+// confusionMatrix counts, for every majority answer of the file pairs with at
+// least minVotes votes, how many times the user of the given assignments
+// answered each answer. The file pairs with a tie, and the skipped or missing
+// answers of the user, are not counted. The user answers are also votes of
+// the consensus. It returns the matrix and the number of compared file pairs
+func confusionMatrix(
+	consensus []*model.PairConsensus,
+	assignments []*model.Assignment,
+	minVotes int,
+) (map[string]map[string]int, int) {
+	expected := make(map[int]string, len(consensus))
+	for _, c := range consensus {
+		if c.Votes < minVotes {
+			continue
+		}
+
+		if majority, tie := c.Majority(); !tie {
+			expected[c.PairID] = majority
+		}
+	}
+
+	matrix := make(map[string]map[string]int)
+	pairs := 0
+	for _, a := range assignments {
+		majority, ok := expected[a.PairID]
+		if !ok || !a.Answer.Valid || a.Answer.String == "skip" {
+			continue
+		}
+
+		if matrix[majority] == nil {
+			matrix[majority] = make(map[string]int)
+		}
+
+		matrix[majority][a.Answer.String]++
+		pairs++
+	}
+
+	return matrix, pairs
+}
+
+// GetUserSessions returns a function that returns a *serializer.Response with
+// the annotation sessions in which the requested user answered the experiment,
+// see repository.Assignments.GetUserSessions
+func GetUserSessions(usersRepo *repository.Users, repo *repository.Assignments) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
+		userID, err := urlParamInt(r, "userId")
+		if err != nil {
+			return nil, err
+		}
+
+		user, err := usersRepo.GetByID(r.Context(), userID)
+		if err != nil {
+			return nil, err
+		}
+
+		if user == nil {
+			return nil, serializer.NewHTTPError(http.StatusNotFound, "user not found")
+		}
+
+		sessions, err := repo.GetUserSessions(r.Context(), experimentID, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		return serializer.NewUserSessionsResponse(userID, sessions), nil
+	}
+}
+
+// commentSnippetRadius is the max number of characters around the searched
+// term in the snippets of the comments
+const commentSnippetRadius = 40
+
+// SearchComments returns a function that returns a *serializer.Response with
+// the assignments of the experiment whose comment contains the "q" query
+// parameter, see repository.Assignments.SearchComments. Each one comes with a
+// snippet of its comment with the term highlighted, see service.Snippet
+func SearchComments(repo *repository.Assignments) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
+		term := strings.TrimSpace(r.URL.Query().Get("q"))
+		if term == "" {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest, "the q parameter is required")
+		}
+
+		assignments, err := repo.SearchComments(r.Context(), experimentID, term)
+		if err != nil {
+			return nil, err
+		}
+
+		snippets := make([]string, len(assignments))
+		for i, a := range assignments {
+			snippets[i] = service.Snippet(*a.Comment, term, commentSnippetRadius)
+		}
+
+		return serializer.NewCommentMatchesResponse(assignments, snippets), nil
 	}
 }
 
 // GetFilePairAnnotations returns a function that returns a *serializer.Response
-// with the Annotation results for the given File Pair and Experiment IDs
+// with the Annotation results for the given File Pair and Experiment IDs.
+// The maybeAs query parameter, "positive", "negative" or "separate" (default),
+// tells if the "maybe" answers are counted as "yes", as "no" or on their own
 func GetFilePairAnnotations(repo *repository.Assignments) RequestProcessFunc {
 	return func(r *http.Request) (*serializer.Response, error) {
 		experimentID, err := urlParamInt(r, "experimentId")
@@ -106,12 +1233,22 @@ func GetFilePairAnnotations(repo *repository.Assignments) RequestProcessFunc {
 			return nil, err
 		}
 
+		maybeAs := serializer.MaybeSeparate
+		if param := r.URL.Query().Get("maybeAs"); param != "" {
+			maybeAs = serializer.MaybeAs(param)
+		}
+
+		if !maybeAs.IsValid() {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("invalid maybeAs %q, it must be positive, negative or separate", maybeAs))
+		}
+
 		pairID, err := urlParamInt(r, "pairId")
 		if err != nil {
 			return nil, err
 		}
 
-		assignments, err := repo.GetByExperimentPair(experimentID, pairID)
+		assignments, err := repo.GetByExperimentPair(r.Context(), experimentID, pairID)
 		if err != nil {
 			return nil, err
 		}
@@ -119,22 +1256,43 @@ func GetFilePairAnnotations(repo *repository.Assignments) RequestProcessFunc {
 		var responseData serializer.ExpAnnotationResponse
 
 		for _, a := range assignments {
-			switch a.AnswerStr() {
-			case "yes":
-				responseData.Yes++
-			case "maybe":
-				responseData.Maybe++
-			case "no":
-				responseData.No++
-			case "skip":
-				responseData.Skip++
-			case "":
-				responseData.Unanswered++
+			responseData.Add(a.AnswerStr(), 1)
+		}
+
+		return serializer.NewExpAnnotationsResponse(responseData, maybeAs), nil
+	}
+}
+
+// defaultMinSkipRate is the fraction of skipped answers above which a file
+// pair is returned by GetHighSkipPairs, if the "minRate" query parameter is
+// not set
+const defaultMinSkipRate = 0.5
+
+// GetHighSkipPairs returns a function that returns a *serializer.Response with
+// the file pairs of the experiment whose fraction of skipped answers is above
+// the "minRate" query parameter, from 0 to 1, sorted from the most skipped
+// one. They are probably broken or ambiguous
+func GetHighSkipPairs(repo *repository.Assignments) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
+		minRate := defaultMinSkipRate
+		if param := r.URL.Query().Get("minRate"); param != "" {
+			minRate, err = strconv.ParseFloat(param, 64)
+			if err != nil || minRate < 0 || minRate > 1 {
+				return nil, serializer.NewHTTPError(http.StatusBadRequest,
+					fmt.Sprintf("invalid minRate %q, it must be a number from 0 to 1", param))
 			}
 		}
 
-		responseData.Total = len(assignments)
+		rates, err := repo.GetHighSkipPairs(r.Context(), experimentID, minRate)
+		if err != nil {
+			return nil, err
+		}
 
-		return serializer.NewExpAnnotationsResponse(responseData), nil
+		return serializer.NewPairSkipRatesResponse(rates), nil
 	}
 }
@@ -0,0 +1,1173 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/src-d/code-annotation/server/handler"
+	"github.com/src-d/code-annotation/server/model"
+	"github.com/src-d/code-annotation/server/repository"
+	"github.com/src-d/code-annotation/server/serializer"
+	"github.com/stretchr/testify/assert"
+)
+
+func answerRequest(assignmentID string, userID int, body string) *http.Request {
+	req, _ := http.NewRequest("PUT", "/assignments/"+assignmentID+"/answer", strings.NewReader(body))
+	req = chiRequest(req, map[string]string{"experimentId": "1", "assignmentId": assignmentID})
+	return reqWithUser(req, userID)
+}
+
+func TestSaveAssignment(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t,
+		&model.User{Login: "alice", Role: model.Worker},
+		&model.User{Login: "bob", Role: model.Worker},
+	)
+	repo := repository.NewAssignments(db.DB)
+	handler := handler.SaveAssignment(repository.NewExperiments(db.DB), repo, time.Minute, time.Hour, 1000, nil, nil)
+
+	res, err := handler(answerRequest("1", 1, `{"answer": "yes", "duration": 10}`))
+	assert.Nil(err)
+	assert.Equal(serializer.NewCountResponse(1), res)
+
+	first, err := repo.GetByID(context.Background(), 1)
+	assert.Nil(err)
+	assert.NotNil(first.CreatedAt)
+	assert.NotNil(first.UpdatedAt)
+
+	res, err = handler(answerRequest("1", 1, `{"answer": "no", "duration": 20}`))
+	assert.Nil(err)
+
+	second, err := repo.GetByID(context.Background(), 1)
+	assert.Nil(err)
+	assert.Equal("no", second.AnswerStr())
+	assert.Equal(20, second.Duration)
+	assert.Equal(first.CreatedAt, second.CreatedAt)
+	assert.False(second.UpdatedAt.Before(*first.UpdatedAt))
+
+	res, err = handler(answerRequest("1", 1, `{"answer": "perhaps"}`))
+	assert.Nil(res)
+	assert.Equal(http.StatusBadRequest, err.(serializer.HTTPError).StatusCode())
+
+	res, err = handler(answerRequest("1", 2, `{"answer": "yes"}`))
+	assert.Nil(res)
+	assert.Equal(http.StatusForbidden, err.(serializer.HTTPError).StatusCode())
+
+	// the assignment must belong to the experiment of the URL
+	req, _ := http.NewRequest("PUT", "/assignments/1/answer", strings.NewReader(`{"answer": "yes"}`))
+	req = chiRequest(req, map[string]string{"experimentId": "2", "assignmentId": "1"})
+	res, err = handler(reqWithUser(req, 1))
+	assert.Nil(res)
+	assert.Equal(serializer.NewHTTPError(http.StatusNotFound, "assignment not found"), err)
+}
+
+func TestSaveAssignmentConfidence(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t, &model.User{Login: "alice", Role: model.Worker})
+	repo := repository.NewAssignments(db.DB)
+	handler := handler.SaveAssignment(repository.NewExperiments(db.DB), repo, time.Minute, time.Hour, 1000, nil, nil)
+
+	_, err := handler(answerRequest("1", 1, `{"answer": "yes", "duration": 10, "confidence": 5}`))
+	assert.Nil(err)
+
+	assignment, err := repo.GetByID(context.Background(), 1)
+	assert.Nil(err)
+	assert.Equal(5, *assignment.Confidence)
+
+	// a new answer without confidence removes the previous one
+	_, err = handler(answerRequest("1", 1, `{"answer": "no", "duration": 10, "confidence": null}`))
+	assert.Nil(err)
+
+	assignment, err = repo.GetByID(context.Background(), 1)
+	assert.Nil(err)
+	assert.Nil(assignment.Confidence)
+
+	for _, confidence := range []string{"0", "6", "-1"} {
+		res, err := handler(answerRequest("1", 1, `{"answer": "yes", "duration": 10, "confidence": `+confidence+`}`))
+		assert.Nil(res)
+		assert.Equal(serializer.NewHTTPError(http.StatusBadRequest, "confidence must be between 1 and 5"), err)
+	}
+}
+
+func TestSaveAssignmentComment(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t, &model.User{Login: "alice", Role: model.Worker})
+	repo := repository.NewAssignments(db.DB)
+	handler := handler.SaveAssignment(repository.NewExperiments(db.DB), repo, time.Minute, time.Hour, 10, nil, nil)
+
+	_, err := handler(answerRequest("1", 1, `{"answer": "maybe", "duration": 10, "comment": " same logic "}`))
+	assert.Nil(err)
+
+	assignment, err := repo.GetByID(context.Background(), 1)
+	assert.Nil(err)
+	assert.Equal("same logic", *assignment.Comment)
+
+	body, err := json.Marshal(serializer.NewAssignmentResponse(assignment))
+	assert.Nil(err)
+	assert.Contains(string(body), `"comment":"same logic"`)
+
+	// the max length counts characters, not bytes
+	_, err = handler(answerRequest("1", 1, `{"answer": "maybe", "duration": 10, "comment": "ñññññññññññ"}`))
+	assert.Equal(serializer.NewHTTPError(http.StatusBadRequest, "comment can not be longer than 10 characters"), err)
+
+	_, err = handler(answerRequest("1", 1, `{"answer": "maybe", "duration": 10, "comment": "ññññññññññ"}`))
+	assert.Nil(err)
+
+	// an empty comment is not saved
+	_, err = handler(answerRequest("1", 1, `{"answer": "yes", "duration": 10, "comment": "  "}`))
+	assert.Nil(err)
+
+	assignment, err = repo.GetByID(context.Background(), 1)
+	assert.Nil(err)
+	assert.Nil(assignment.Comment)
+}
+
+func TestSaveAssignmentOutlier(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t, &model.User{Login: "alice", Role: model.Worker})
+	experimentsRepo := repository.NewExperiments(db.DB)
+	repo := repository.NewAssignments(db.DB)
+	handler := handler.SaveAssignment(experimentsRepo, repo, time.Minute, time.Hour, 1000, nil, nil)
+
+	_, err := handler(answerRequest("1", 1, `{"answer": "yes", "duration": 60000}`))
+	assert.Nil(err)
+	_, err = handler(answerRequest("2", 1, `{"answer": "yes", "duration": 60001}`))
+	assert.Nil(err)
+
+	first, _ := repo.GetByID(context.Background(), 1)
+	assert.False(first.Outlier)
+	second, _ := repo.GetByID(context.Background(), 2)
+	assert.True(second.Outlier)
+
+	durations, err := repo.GetCompleteDurations(context.Background(), 1)
+	assert.Nil(err)
+	assert.Equal([]int{60000}, durations)
+
+	// the experiment threshold replaces the default one
+	experiment, _ := experimentsRepo.GetByID(context.Background(), 1, false)
+	threshold := 1000
+	experiment.OutlierThreshold = &threshold
+	updated, err := experimentsRepo.Update(context.Background(), experiment)
+	assert.Nil(err)
+	assert.True(updated)
+
+	_, err = handler(answerRequest("1", 1, `{"answer": "no", "duration": 2000}`))
+	assert.Nil(err)
+
+	first, _ = repo.GetByID(context.Background(), 1)
+	assert.True(first.Outlier)
+}
+
+func TestAssignFilePairs(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t)
+	usersRepo := repository.NewUsers(db.DB)
+	repo := repository.NewAssignments(db.DB)
+	handler := handler.AssignFilePairs(usersRepo, repository.NewFilePairs(db.DB), repo)
+
+	for _, login := range []string{"alice", "bob"} {
+		assert.Nil(usersRepo.Create(context.Background(), &model.User{Login: login, Role: model.Worker}))
+	}
+
+	assign := func(body string) (*serializer.Response, error) {
+		req, _ := http.NewRequest("POST", "/experiments/1/assignments", strings.NewReader(body))
+		req = chiRequest(req, map[string]string{"experimentId": "1"})
+		return handler(req)
+	}
+
+	res, err := assign(`{"userIds": [1], "pairIds": [2]}`)
+	assert.Nil(err)
+	assert.Equal(serializer.NewCountResponse(1), res)
+
+	// the already existing assignment is skipped
+	res, err = assign(`{"userIds": [1, 2]}`)
+	assert.Nil(err)
+	assert.Equal(serializer.NewCountResponse(3), res)
+
+	count, err := repo.CountUserAssignment(context.Background(), 1, 2)
+	assert.Nil(err)
+	assert.Equal(2, count)
+
+	res, err = assign(`{"userIds": [3]}`)
+	assert.Nil(res)
+	assert.Equal(http.StatusBadRequest, err.(serializer.HTTPError).StatusCode())
+
+	res, err = assign(`{"userIds": [1], "pairIds": [5]}`)
+	assert.Nil(res)
+	assert.Equal(http.StatusBadRequest, err.(serializer.HTTPError).StatusCode())
+}
+
+func TestAssignFilePairsOverlap(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t)
+	usersRepo := repository.NewUsers(db.DB)
+	repo := repository.NewAssignments(db.DB)
+	handler := handler.AssignFilePairs(usersRepo, repository.NewFilePairs(db.DB), repo)
+
+	for _, login := range []string{"alice", "bob", "carol"} {
+		assert.Nil(usersRepo.Create(context.Background(), &model.User{Login: login, Role: model.Worker}))
+	}
+
+	assign := func(body string) (*serializer.Response, error) {
+		req, _ := http.NewRequest("POST", "/experiments/1/assignments", strings.NewReader(body))
+		req = chiRequest(req, map[string]string{"experimentId": "1"})
+		return handler(req)
+	}
+
+	res, err := assign(`{"userIds": [1, 2, 3], "overlap": 2}`)
+	assert.Nil(err)
+	assert.Equal(serializer.NewOverlapCountResponse(4, 2), res)
+
+	for userID, expected := range map[int][]int{1: {1, 2}, 2: {1}, 3: {2}} {
+		assignments, err := repo.GetAll(context.Background(), userID, 1)
+		assert.Nil(err)
+
+		var pairIDs []int
+		for _, a := range assignments {
+			pairIDs = append(pairIDs, a.PairID)
+		}
+		assert.Equal(expected, pairIDs)
+	}
+
+	// there are less users than the requested overlap
+	res, err = assign(`{"userIds": [2, 3], "overlap": 5}`)
+	assert.Nil(err)
+	assert.Equal(serializer.NewOverlapCountResponse(2, 2), res)
+
+	res, err = assign(`{"userIds": [1], "overlap": 0}`)
+	assert.Nil(res)
+	assert.Equal(serializer.NewHTTPError(http.StatusBadRequest, "overlap must be greater than 0"), err)
+}
+
+func TestGetAssignmentsAfterOverlap(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t)
+	usersRepo := repository.NewUsers(db.DB)
+	repo := repository.NewAssignments(db.DB)
+	assign := handler.AssignFilePairs(usersRepo, repository.NewFilePairs(db.DB), repo)
+	get := handler.GetAssignmentsForUserExperiment(repo)
+
+	for _, login := range []string{"alice", "bob", "carol"} {
+		assert.Nil(usersRepo.Create(context.Background(), &model.User{Login: login, Role: model.Worker}))
+	}
+
+	req, _ := http.NewRequest("POST", "/experiments/1/assignments", strings.NewReader(`{"userIds": [1, 2], "overlap": 1}`))
+	_, err := assign(chiRequest(req, map[string]string{"experimentId": "1"}))
+	assert.Nil(err)
+
+	pairIDs := func(userID int) []int {
+		req, _ := http.NewRequest("GET", "/experiments/1/assignments", nil)
+		res, err := get(reqWithUser(chiRequest(req, map[string]string{"experimentId": "1"}), userID))
+		assert.Nil(err)
+
+		ids := []int{}
+		for _, a := range withoutTimestamps(res)["data"].([]interface{}) {
+			ids = append(ids, int(a.(map[string]interface{})["pairId"].(float64)))
+		}
+		return ids
+	}
+
+	// the users keep the pairs distributed to them, and carol gets none
+	assert.Equal([]int{1}, pairIDs(1))
+	assert.Equal([]int{2}, pairIDs(2))
+	assert.Equal([]int{}, pairIDs(3))
+
+	// the deleted assignments are not created again
+	assignments, err := repo.GetAll(context.Background(), 2, 1)
+	assert.Nil(err)
+	assert.Nil(repo.Delete(context.Background(), assignments[0].ID))
+	assert.Equal([]int{}, pairIDs(2))
+}
+
+func TestAssignFilePairsRandomOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t)
+	usersRepo := repository.NewUsers(db.DB)
+	repo := repository.NewAssignments(db.DB)
+	handler := handler.AssignFilePairs(usersRepo, repository.NewFilePairs(db.DB), repo)
+
+	for _, login := range []string{"alice", "bob", "carol"} {
+		assert.Nil(usersRepo.Create(context.Background(), &model.User{Login: login, Role: model.Worker}))
+	}
+
+	exp := &model.Experiment{ID: 1, AssignmentStrategy: model.AssignmentRandom, AssignmentSeed: 42}
+	_, err := db.DB.Exec(`UPDATE experiments SET assignment_strategy='random', assignment_seed=42 WHERE id=1`)
+	assert.Nil(err)
+
+	req, _ := http.NewRequest("POST", "/experiments/1/assignments", strings.NewReader(`{"userIds": [1, 2, 3]}`))
+	_, err = handler(chiRequest(req, map[string]string{"experimentId": "1"}))
+	assert.Nil(err)
+
+	for userID := 1; userID <= 3; userID++ {
+		assignments, err := repo.GetByUserAndExperiment(context.Background(), userID, 1)
+		assert.Nil(err)
+
+		var pairIDs []int
+		for _, a := range assignments {
+			pairIDs = append(pairIDs, a.PairID)
+		}
+		assert.Equal(exp.OrderPairs(userID, []int{1, 2}), pairIDs)
+	}
+}
+
+func TestReassignAssignments(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t,
+		&model.User{Login: "alice", Role: model.Worker},
+		&model.User{Login: "bob", Role: model.Worker},
+		&model.User{Login: "carol", Role: model.Worker},
+	)
+	repo := repository.NewAssignments(db.DB)
+	handler := handler.ReassignAssignments(repository.NewUsers(db.DB), repo)
+
+	reassign := func(body string) (*serializer.Response, error) {
+		req, _ := http.NewRequest("POST", "/experiments/1/assignments/reassign", strings.NewReader(body))
+		req = chiRequest(req, map[string]string{"experimentId": "1"})
+		return handler(req)
+	}
+
+	// alice answered the pair 1, and carol already has the pair 2
+	assert.Nil(repo.Update(context.Background(), 1, "yes", 10))
+	assert.Nil(repo.Delete(context.Background(), 5))
+
+	res, err := reassign(`{"fromUserId": 1, "toUserId": 3}`)
+	assert.Nil(err)
+	assert.Equal(serializer.NewCountResponse(0), res)
+
+	res, err = reassign(`{"fromUserId": 2, "toUserId": 3}`)
+	assert.Nil(err)
+	assert.Equal(serializer.NewCountResponse(1), res)
+
+	for userID, expected := range map[int]int{1: 2, 2: 1, 3: 2} {
+		count, err := repo.CountUserAssignment(context.Background(), 1, userID)
+		assert.Nil(err)
+		assert.Equal(expected, count, "user %d", userID)
+	}
+
+	for _, body := range []string{
+		`{"fromUserId": 1, "toUserId": 1}`,
+		`{"fromUserId": 1, "toUserId": 4}`,
+		`{"fromUserId": "alice"}`,
+	} {
+		res, err = reassign(body)
+		assert.Nil(res)
+		assert.Equal(http.StatusBadRequest, err.(serializer.HTTPError).StatusCode(), body)
+	}
+}
+
+func TestResetUserAssignments(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t,
+		&model.User{Login: "alice", Role: model.Worker},
+		&model.User{Login: "bob", Role: model.Worker},
+	)
+	repo := repository.NewAssignments(db.DB)
+	handler := handler.ResetUserAssignments(repository.NewUsers(db.DB), repo, nil)
+
+	reset := func(body string) (*serializer.Response, error) {
+		req, _ := http.NewRequest("POST", "/experiments/1/assignments/reset", strings.NewReader(body))
+		req = chiRequest(req, map[string]string{"experimentId": "1"})
+		return handler(req)
+	}
+
+	comment := "almost the same"
+	session := "s1"
+	for _, id := range []int{1, 2, 3} {
+		assert.Nil(repo.UpdateAnswer(context.Background(), id, "yes", 10, nil, &comment, false, &session))
+	}
+
+	_, err := repo.SetFlagged(context.Background(), 1, true)
+	assert.Nil(err)
+
+	res, err := reset(`{"userId": 1}`)
+	assert.Nil(err)
+	assert.Equal(serializer.NewCountResponse(2), res)
+
+	for _, id := range []int{1, 2} {
+		as, err := repo.GetByID(context.Background(), id)
+		assert.Nil(err)
+		assert.False(as.Answer.Valid)
+		assert.Equal(0, as.Duration)
+		assert.Nil(as.Comment)
+		assert.Nil(as.SessionID)
+		assert.False(as.Flagged)
+
+		// the reset is the last change in the history
+		history, err := repo.GetAnswerHistory(context.Background(), id)
+		assert.Nil(err)
+		assert.Len(history, 2)
+		assert.Equal("", history[1].Answer)
+	}
+
+	// bob keeps his answer
+	as, err := repo.GetByID(context.Background(), 3)
+	assert.Nil(err)
+	assert.Equal("yes", as.Answer.String)
+	assert.Equal(comment, *as.Comment)
+
+	count, err := repo.CountUserAssignment(context.Background(), 1, 1)
+	assert.Nil(err)
+	assert.Equal(2, count)
+
+	res, err = reset(`{"userId": 1}`)
+	assert.Nil(err)
+	assert.Equal(serializer.NewCountResponse(0), res)
+
+	// the unanswered assignments with a pending duration are reset too
+	_, err = repo.SetPendingDuration(context.Background(), 4, 500)
+	assert.Nil(err)
+
+	res, err = reset(`{"userId": 2}`)
+	assert.Nil(err)
+	assert.Equal(serializer.NewCountResponse(2), res)
+
+	as, err = repo.GetByID(context.Background(), 4)
+	assert.Nil(err)
+	assert.Nil(as.PendingDuration)
+
+	for _, body := range []string{`{"userId": 3}`, `{"userId": "alice"}`} {
+		res, err = reset(body)
+		assert.Nil(res)
+		assert.Equal(http.StatusBadRequest, err.(serializer.HTTPError).StatusCode(), body)
+	}
+}
+
+func TestImportAnnotations(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t, &model.User{Login: "alice", Role: model.Worker})
+	usersRepo := repository.NewUsers(db.DB)
+	repo := repository.NewAssignments(db.DB)
+	h := handler.ImportAnnotations(repository.NewExperiments(db.DB), usersRepo,
+		repository.NewFilePairs(db.DB), repo, time.Minute, 10)
+
+	// bob has no assignments yet
+	assert.Nil(usersRepo.Create(context.Background(), &model.User{Login: "bob", Role: model.Worker}))
+
+	body := `[
+		{"userId": 1, "pairId": 1, "answer": "yes", "duration": 10},
+		{"userId": 1, "pairId": 2, "answer": "perhaps", "duration": 10},
+		{"userId": 2, "pairId": 2, "answer": "no", "duration": 120000},
+		{"userId": 2, "pairId": 99, "answer": "no", "duration": 10},
+		{"userId": 3, "pairId": 1, "answer": "no", "duration": 10}
+	]`
+	req, _ := http.NewRequest("POST", "/experiments/1/annotations", strings.NewReader(body))
+	res, err := h(chiRequest(req, map[string]string{"experimentId": "1"}))
+	assert.Nil(err)
+	assert.Equal(serializer.NewFilePairsUploadResponse(2, 3,
+		serializer.UploadFailure{Row: 2, Reason: `Wrong answer provided: "perhaps"`},
+		serializer.UploadFailure{Row: 4, Reason: "no file pair 99 in the experiment"},
+		serializer.UploadFailure{Row: 5, Reason: "no user 3 found"},
+	), res)
+
+	updated, err := repo.GetByID(context.Background(), 1)
+	assert.Nil(err)
+	assert.Equal("yes", updated.AnswerStr())
+	assert.False(updated.Outlier)
+
+	created, err := repo.GetByExperimentPair(context.Background(), 1, 2)
+	assert.Nil(err)
+	assert.Len(created, 2)
+	assert.Equal(2, created[1].UserID)
+	assert.Equal("no", created[1].AnswerStr())
+	assert.True(created[1].Outlier)
+
+	req, _ = http.NewRequest("POST", "/experiments/1/annotations", strings.NewReader(`{"userId": 1}`))
+	_, err = h(chiRequest(req, map[string]string{"experimentId": "1"}))
+	assert.Equal(http.StatusBadRequest, err.(serializer.HTTPError).StatusCode())
+}
+
+func TestHeartbeatAssignment(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t,
+		&model.User{Login: "alice", Role: model.Worker},
+		&model.User{Login: "bob", Role: model.Worker},
+	)
+	experimentsRepo := repository.NewExperiments(db.DB)
+	repo := repository.NewAssignments(db.DB)
+	heartbeat := handler.HeartbeatAssignment(experimentsRepo, repo, time.Hour)
+	update := handler.SaveAssignment(experimentsRepo, repo, time.Minute, time.Hour, 1000, nil, nil)
+
+	res, err := heartbeat(answerRequest("1", 1, `{"duration": 5000}`))
+	assert.Nil(err)
+	assert.Equal(serializer.NewEmptyResponse(), res)
+
+	pending, err := repo.GetByID(context.Background(), 1)
+	assert.Nil(err)
+	assert.False(pending.Answer.Valid)
+	assert.Equal(5000, *pending.PendingDuration)
+
+	// the client lost its count, so the pending duration is kept
+	_, err = update(answerRequest("1", 1, `{"answer": "yes", "duration": 1000}`))
+	assert.Nil(err)
+
+	answered, err := repo.GetByID(context.Background(), 1)
+	assert.Nil(err)
+	assert.Equal(5000, answered.Duration)
+	assert.Nil(answered.PendingDuration)
+
+	// the durations are capped
+	_, err = heartbeat(answerRequest("2", 1, `{"duration": 36000000}`))
+	assert.Nil(err)
+
+	pending, err = repo.GetByID(context.Background(), 2)
+	assert.Nil(err)
+	assert.Equal(3600000, *pending.PendingDuration)
+
+	_, err = update(answerRequest("1", 1, `{"answer": "no", "duration": 36000000}`))
+	assert.Nil(err)
+
+	answered, err = repo.GetByID(context.Background(), 1)
+	assert.Nil(err)
+	assert.Equal(3600000, answered.Duration)
+	assert.True(answered.Outlier)
+
+	_, err = heartbeat(answerRequest("1", 1, `{"duration": -1}`))
+	assert.Equal(http.StatusBadRequest, err.(serializer.HTTPError).StatusCode())
+
+	_, err = heartbeat(answerRequest("3", 1, `{"duration": 10}`))
+	assert.Equal(http.StatusForbidden, err.(serializer.HTTPError).StatusCode())
+}
+
+func TestGetConfusionMatrix(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t,
+		&model.User{Login: "alice", Role: model.Worker},
+		&model.User{Login: "bob", Role: model.Worker},
+		&model.User{Login: "carol", Role: model.Worker},
+	)
+	repo := repository.NewAssignments(db.DB)
+	h := handler.GetConfusionMatrix(repository.NewUsers(db.DB), repo)
+
+	confusion := func(userID, query string) (*serializer.Response, error) {
+		req, _ := http.NewRequest("GET", "/experiments/1/users/"+userID+"/confusion"+query, nil)
+		req = chiRequest(req, map[string]string{"experimentId": "1", "userId": userID})
+		return h(req)
+	}
+
+	// the pair 1 has a majority of yes, and the pair 2 is a tie
+	for id, answer := range map[int]string{1: "yes", 2: "no", 3: "yes", 4: "yes", 5: "no", 6: "skip"} {
+		assert.Nil(repo.Update(context.Background(), id, answer, 10))
+	}
+
+	res, err := confusion("3", "")
+	assert.Nil(err)
+	assert.Equal(serializer.NewConfusionMatrixResponse(3, 1, map[string]map[string]int{
+		"yes": {"no": 1},
+	}), res)
+
+	res, err = confusion("1", "?minVotes=4")
+	assert.Nil(err)
+	assert.Equal(serializer.NewConfusionMatrixResponse(1, 0, map[string]map[string]int{}), res)
+
+	res, err = confusion("4", "")
+	assert.Nil(res)
+	assert.Equal(http.StatusNotFound, err.(serializer.HTTPError).StatusCode())
+}
+
+func TestGetHighSkipPairs(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t,
+		&model.User{Login: "alice", Role: model.Worker},
+		&model.User{Login: "bob", Role: model.Worker},
+		&model.User{Login: "carol", Role: model.Worker},
+	)
+	repo := repository.NewAssignments(db.DB)
+	h := handler.GetHighSkipPairs(repo)
+
+	skipped := func(query string) (*serializer.Response, error) {
+		req, _ := http.NewRequest("GET", "/experiments/1/file-pairs/skipped"+query, nil)
+		return h(chiRequest(req, map[string]string{"experimentId": "1"}))
+	}
+
+	// the pair 1 is skipped by 2 of 3 annotators, and the pair 2 by 1 of 2
+	for id, answer := range map[int]string{1: "skip", 3: "skip", 5: "yes", 2: "skip", 4: "no"} {
+		assert.Nil(repo.Update(context.Background(), id, answer, 10))
+	}
+
+	res, err := skipped("")
+	assert.Nil(err)
+	assert.Equal(serializer.NewPairSkipRatesResponse([]*model.PairSkipRate{
+		{PairID: 1, Skips: 2, Answers: 3},
+	}), res)
+
+	res, err = skipped("?minRate=0.4")
+	assert.Nil(err)
+	assert.Equal(serializer.NewPairSkipRatesResponse([]*model.PairSkipRate{
+		{PairID: 1, Skips: 2, Answers: 3},
+		{PairID: 2, Skips: 1, Answers: 2},
+	}), res)
+
+	res, err = skipped("?minRate=1")
+	assert.Nil(err)
+	assert.Equal(serializer.NewPairSkipRatesResponse(nil), res)
+
+	res, err = skipped("?minRate=2")
+	assert.Nil(res)
+	assert.Equal(http.StatusBadRequest, err.(serializer.HTTPError).StatusCode())
+}
+
+func TestGetUserSessions(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t,
+		&model.User{Login: "alice", Role: model.Worker},
+		&model.User{Login: "bob", Role: model.Worker},
+	)
+	repo := repository.NewAssignments(db.DB)
+	update := handler.SaveAssignment(repository.NewExperiments(db.DB), repo, time.Minute, time.Hour, 1000, nil, nil)
+	get := handler.GetUserSessions(repository.NewUsers(db.DB), repo)
+
+	answer := func(assignmentID string, sessionID string) {
+		req := answerRequest(assignmentID, 1, `{"answer": "yes", "duration": 10}`)
+		req.Header.Set(handler.SessionIDHeader, sessionID)
+		_, err := update(req)
+		assert.Nil(err)
+	}
+
+	sessions := func(userID string) (*serializer.Response, error) {
+		req, _ := http.NewRequest("GET", "/experiments/1/users/"+userID+"/sessions", nil)
+		req = chiRequest(req, map[string]string{"experimentId": "1", "userId": userID})
+		return get(req)
+	}
+
+	answer("1", " morning ")
+	answer("2", "afternoon")
+
+	first, err := repo.GetByID(context.Background(), 1)
+	assert.Nil(err)
+	assert.Equal("morning", *first.SessionID)
+
+	res, err := sessions("1")
+	assert.Nil(err)
+	assert.Equal(serializer.NewUserSessionsResponse(1, []*model.Session{
+		{ID: "morning", Answers: 1, Duration: 10},
+		{ID: "afternoon", Answers: 1, Duration: 10},
+	}), res)
+
+	// the answers replaced in a later session move to it
+	answer("1", "afternoon")
+	res, err = sessions("1")
+	assert.Nil(err)
+	assert.Equal(serializer.NewUserSessionsResponse(1, []*model.Session{
+		{ID: "afternoon", Answers: 2, Duration: 20},
+	}), res)
+
+	// the answers without a session are not counted
+	assert.Nil(repo.Update(context.Background(), 3, "no", 10))
+	res, err = sessions("2")
+	assert.Nil(err)
+	assert.Equal(serializer.NewUserSessionsResponse(2, nil), res)
+
+	req := answerRequest("1", 1, `{"answer": "yes", "duration": 10}`)
+	req.Header.Set(handler.SessionIDHeader, strings.Repeat("a", 129))
+	_, err = update(req)
+	assert.Equal(http.StatusBadRequest, err.(serializer.HTTPError).StatusCode())
+
+	res, err = sessions("3")
+	assert.Nil(res)
+	assert.Equal(http.StatusNotFound, err.(serializer.HTTPError).StatusCode())
+}
+
+func TestGetUserQualityScore(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t,
+		&model.User{Login: "alice", Role: model.Worker},
+		&model.User{Login: "bob", Role: model.Worker},
+	)
+	repo := repository.NewAssignments(db.DB)
+	filePairsRepo := repository.NewFilePairs(db.DB)
+	handler := handler.GetUserQualityScore(repository.NewUsers(db.DB), repo)
+
+	quality := func(userID string) (*serializer.Response, error) {
+		req, _ := http.NewRequest("GET", "/experiments/1/users/"+userID+"/quality", nil)
+		req = chiRequest(req, map[string]string{"experimentId": "1", "userId": userID})
+		return handler(req)
+	}
+
+	yes, no := "yes", "no"
+	for pairID, answer := range map[int]*string{1: &yes, 2: &no} {
+		updated, err := filePairsRepo.SetGoldAnswer(context.Background(), 1, pairID, answer)
+		assert.Nil(err)
+		assert.True(updated)
+	}
+
+	// alice fails the pair 2, and bob skips the pair 1
+	assert.Nil(repo.Update(context.Background(), 1, "yes", 10))
+	assert.Nil(repo.Update(context.Background(), 2, "yes", 10))
+	assert.Nil(repo.Update(context.Background(), 3, "skip", 10))
+
+	res, err := quality("1")
+	assert.Nil(err)
+	assert.Equal(serializer.NewQualityScoreResponse(1, model.QualityScore{Evaluated: 2, Correct: 1}), res)
+
+	res, err = quality("2")
+	assert.Nil(err)
+	assert.Equal(serializer.NewQualityScoreResponse(2, model.QualityScore{}), res)
+
+	// the pair 2 is not gold anymore
+	updated, err := filePairsRepo.SetGoldAnswer(context.Background(), 1, 2, nil)
+	assert.Nil(err)
+	assert.True(updated)
+
+	res, err = quality("1")
+	assert.Nil(err)
+	assert.Equal(serializer.NewQualityScoreResponse(1, model.QualityScore{Evaluated: 1, Correct: 1}), res)
+
+	res, err = quality("3")
+	assert.Nil(res)
+	assert.Equal(http.StatusNotFound, err.(serializer.HTTPError).StatusCode())
+}
+
+func TestGetUserAssignments(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t,
+		&model.User{Login: "alice", Role: model.Worker},
+		&model.User{Login: "bob", Role: model.Worker},
+	)
+	repo := repository.NewAssignments(db.DB)
+	handler := handler.GetUserAssignments(repo)
+
+	assert.Nil(repo.Update(context.Background(), 4, "no", 10))
+
+	req, _ := http.NewRequest("GET", "/experiments/1/assignments/mine", nil)
+	req = chiRequest(req, map[string]string{"experimentId": "1"})
+	res, err := handler(reqWithUser(req, 2))
+	assert.Nil(err)
+
+	third, _ := repo.GetByID(context.Background(), 3)
+	fourth, _ := repo.GetByID(context.Background(), 4)
+	assert.False(third.Answer.Valid)
+	assert.Equal(serializer.NewAssignmentsResponse([]*model.Assignment{third, fourth}), res)
+}
+
+func TestGetNextUnansweredAssignment(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t, &model.User{Login: "alice", Role: model.Worker})
+	repo := repository.NewAssignments(db.DB)
+	h := handler.GetNextUnansweredAssignment(repo)
+
+	req, _ := http.NewRequest("GET", "/experiments/1/assignments/next", nil)
+	req = reqWithUser(chiRequest(req, map[string]string{"experimentId": "1"}), 1)
+
+	assert.Nil(repo.Update(context.Background(), 1, "yes", 10))
+
+	res, err := h(req)
+	assert.Nil(err)
+	second, _ := repo.GetByID(context.Background(), 2)
+	assert.Equal(serializer.NewAssignmentResponse(second), res)
+
+	assert.Nil(repo.Update(context.Background(), 2, "no", 10))
+
+	w := httptest.NewRecorder()
+	handler.APIHandlerFunc(h)(w, req)
+	assert.Equal(http.StatusNoContent, w.Code)
+	assert.Empty(w.Body.String())
+}
+
+func TestGetPreviousAssignment(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t,
+		&model.User{Login: "alice", Role: model.Worker},
+		&model.User{Login: "bob", Role: model.Worker},
+	)
+	repo := repository.NewAssignments(db.DB)
+	h := handler.GetPreviousAssignment(repo)
+
+	previous := func(assignmentID string, userID int) (*serializer.Response, error) {
+		req, _ := http.NewRequest("GET", "/experiments/1/assignments/"+assignmentID+"/previous", nil)
+		req = chiRequest(req, map[string]string{"experimentId": "1", "assignmentId": assignmentID})
+		return h(reqWithUser(req, userID))
+	}
+
+	res, err := previous("2", 1)
+	assert.Nil(err)
+	first, _ := repo.GetByID(context.Background(), 1)
+	assert.Equal(serializer.NewAssignmentResponse(first), res)
+
+	// the assignments of other users are skipped
+	res, err = previous("3", 2)
+	assert.Nil(err)
+	assert.Equal(serializer.NewNoContentResponse(), res)
+
+	res, err = previous("1", 1)
+	assert.Nil(err)
+	assert.Equal(serializer.NewNoContentResponse(), res)
+
+	res, err = previous("2", 2)
+	assert.Nil(res)
+	assert.Equal(http.StatusForbidden, err.(serializer.HTTPError).StatusCode())
+}
+
+func TestGetNextUnansweredAssignmentByScore(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t, &model.User{Login: "alice", Role: model.Worker})
+	repo := repository.NewAssignments(db.DB)
+	h := handler.GetNextUnansweredAssignment(repo)
+
+	// the pair 2 is so far from the target that it is never picked first
+	_, err := db.Exec(`UPDATE file_pairs SET score = CASE id WHEN 1 THEN 0.5 ELSE 20 END`)
+	assert.Nil(err)
+
+	next := func(target string) (*serializer.Response, error) {
+		req, _ := http.NewRequest("GET", "/experiments/1/assignments/next?scoreTarget="+target, nil)
+		return h(reqWithUser(chiRequest(req, map[string]string{"experimentId": "1"}), 1))
+	}
+
+	res, err := next("0.4")
+	assert.Nil(err)
+	first, _ := repo.GetByID(context.Background(), 1)
+	assert.Equal(serializer.NewAssignmentResponse(first), res)
+
+	assert.Nil(repo.Update(context.Background(), 1, "yes", 10))
+
+	res, err = next("0.4")
+	assert.Nil(err)
+	second, _ := repo.GetByID(context.Background(), 2)
+	assert.Equal(serializer.NewAssignmentResponse(second), res)
+
+	assert.Nil(repo.Update(context.Background(), 2, "no", 10))
+
+	res, err = next("0.4")
+	assert.Nil(err)
+	assert.Equal(serializer.NewNoContentResponse(), res)
+
+	res, err = next("high")
+	assert.Nil(res)
+	assert.Equal(http.StatusBadRequest, err.(serializer.HTTPError).StatusCode())
+}
+
+func TestGetRemainingCount(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t,
+		&model.User{Login: "alice", Role: model.Worker},
+		&model.User{Login: "bob", Role: model.Worker},
+	)
+	repo := repository.NewAssignments(db.DB)
+	h := handler.GetRemainingCount(repo)
+
+	assert.Nil(repo.Update(context.Background(), 1, "yes", 10))
+
+	for userID, expected := range map[int]*serializer.Response{
+		1: serializer.NewRemainingCountResponse(1, 2),
+		2: serializer.NewRemainingCountResponse(2, 2),
+		3: serializer.NewRemainingCountResponse(0, 0),
+	} {
+		req, _ := http.NewRequest("GET", "/experiments/1/assignments/remaining", nil)
+		req = reqWithUser(chiRequest(req, map[string]string{"experimentId": "1"}), userID)
+
+		res, err := h(req)
+		assert.Nil(err)
+		assert.Equal(expected, res, "user %d", userID)
+	}
+}
+
+func TestGetFilePairAnnotations(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t,
+		&model.User{Login: "alice", Role: model.Worker},
+		&model.User{Login: "bob", Role: model.Worker},
+		&model.User{Login: "carol", Role: model.Worker},
+	)
+	repo := repository.NewAssignments(db.DB)
+	h := handler.GetFilePairAnnotations(repo)
+
+	// the assignments of the pair 1
+	assert.Nil(repo.Update(context.Background(), 1, "yes", 10))
+	assert.Nil(repo.Update(context.Background(), 3, "maybe", 10))
+	assert.Nil(repo.Update(context.Background(), 5, "no", 10))
+
+	for query, expected := range map[string]serializer.ExpAnnotationResponse{
+		"": {Yes: 1, Maybe: 1, No: 1, Total: 3,
+			Answers: map[string]int{"yes": 1, "maybe": 1, "no": 1}},
+		"?maybeAs=separate": {Yes: 1, Maybe: 1, No: 1, Total: 3,
+			Answers: map[string]int{"yes": 1, "maybe": 1, "no": 1}},
+		"?maybeAs=positive": {Yes: 2, No: 1, Total: 3,
+			Answers: map[string]int{"yes": 2, "no": 1}},
+		"?maybeAs=negative": {Yes: 1, No: 2, Total: 3,
+			Answers: map[string]int{"yes": 1, "no": 2}},
+	} {
+		req, _ := http.NewRequest("GET", "/experiments/1/file-pairs/1/annotations"+query, nil)
+		req = chiRequest(req, map[string]string{"experimentId": "1", "pairId": "1"})
+
+		res, err := h(req)
+		assert.Nil(err)
+		assert.Equal(serializer.NewExpAnnotationsResponse(expected, serializer.MaybeSeparate), res, query)
+	}
+
+	req, _ := http.NewRequest("GET", "/experiments/1/file-pairs/1/annotations?maybeAs=yes", nil)
+	req = chiRequest(req, map[string]string{"experimentId": "1", "pairId": "1"})
+	res, err := h(req)
+	assert.Nil(res)
+	assert.Equal(http.StatusBadRequest, err.(serializer.HTTPError).StatusCode())
+}
+
+func TestGetAssignment(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t,
+		&model.User{Login: "alice", Role: model.Worker},
+		&model.User{Login: "bob", Role: model.Worker},
+		&model.User{Login: "carol", Role: model.Requester},
+	)
+	repo := repository.NewAssignments(db.DB)
+	h := handler.GetAssignment(repository.NewUsers(db.DB), repo)
+
+	getRequest := func(experimentID, assignmentID string, userID int) *http.Request {
+		req, _ := http.NewRequest("GET", "/experiments/"+experimentID+"/assignments/"+assignmentID, nil)
+		req = chiRequest(req, map[string]string{"experimentId": experimentID, "assignmentId": assignmentID})
+		return reqWithUser(req, userID)
+	}
+
+	assignment, err := repo.GetByID(context.Background(), 1)
+	assert.Nil(err)
+
+	res, err := h(getRequest("1", "1", 1))
+	assert.Nil(err)
+	assert.Equal(serializer.NewAssignmentResponse(assignment), res)
+
+	// the requesters can get the assignments of any user
+	res, err = h(getRequest("1", "1", 3))
+	assert.Nil(err)
+	assert.Equal(serializer.NewAssignmentResponse(assignment), res)
+
+	res, err = h(getRequest("1", "1", 2))
+	assert.Nil(res)
+	assert.Equal(http.StatusForbidden, err.(serializer.HTTPError).StatusCode())
+
+	for _, ids := range [][]string{{"1", "99"}, {"2", "1"}} {
+		res, err = h(getRequest(ids[0], ids[1], 1))
+		assert.Nil(res)
+		assert.Equal(serializer.NewHTTPError(http.StatusNotFound, "assignment not found"), err)
+	}
+}
+
+func TestGetAnswerHistory(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t,
+		&model.User{Login: "alice", Role: model.Worker},
+		&model.User{Login: "bob", Role: model.Worker},
+		&model.User{Login: "carol", Role: model.Requester},
+	)
+	repo := repository.NewAssignments(db.DB)
+	h := handler.GetAnswerHistory(repository.NewUsers(db.DB), repo)
+
+	getRequest := func(experimentID, assignmentID string, userID int) *http.Request {
+		req, _ := http.NewRequest("GET", "/experiments/"+experimentID+"/assignments/"+assignmentID+"/history", nil)
+		req = chiRequest(req, map[string]string{"experimentId": experimentID, "assignmentId": assignmentID})
+		return reqWithUser(req, userID)
+	}
+
+	changes := func(res *serializer.Response) []interface{} {
+		return withoutTimestamps(res)["data"].(map[string]interface{})["changes"].([]interface{})
+	}
+
+	res, err := h(getRequest("1", "1", 1))
+	assert.Nil(err)
+	assert.Len(changes(res), 0)
+
+	assert.Nil(repo.Update(context.Background(), 1, "yes", 10))
+	assert.Nil(repo.Update(context.Background(), 1, "no", 20))
+
+	for _, userID := range []int{1, 3} {
+		res, err = h(getRequest("1", "1", userID))
+		assert.Nil(err)
+
+		history := changes(res)
+		assert.Len(history, 2)
+		for i, answer := range []string{"yes", "no"} {
+			change := history[i].(map[string]interface{})
+			assert.Equal(answer, change["answer"])
+			assert.Equal(float64(10*(i+1)), change["duration"])
+			assert.Equal(float64(1), change["userId"])
+			assert.NotEmpty(change["changedAt"])
+		}
+	}
+
+	res, err = h(getRequest("1", "1", 2))
+	assert.Nil(res)
+	assert.Equal(http.StatusForbidden, err.(serializer.HTTPError).StatusCode())
+
+	res, err = h(getRequest("2", "1", 1))
+	assert.Nil(res)
+	assert.Equal(serializer.NewHTTPError(http.StatusNotFound, "assignment not found"), err)
+}
+
+func TestDeleteAssignment(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t, &model.User{Login: "alice", Role: model.Worker})
+	repo := repository.NewAssignments(db.DB)
+	h := handler.DeleteAssignment(repo)
+
+	deleteRequest := func(assignmentID, query string) *http.Request {
+		req, _ := http.NewRequest("DELETE", "/experiments/1/assignments/"+assignmentID+query, nil)
+		return chiRequest(req, map[string]string{"experimentId": "1", "assignmentId": assignmentID})
+	}
+
+	res, err := h(deleteRequest("1", ""))
+	assert.Nil(err)
+	assert.Equal(serializer.NewEmptyResponse(), res)
+
+	deleted, err := repo.GetByID(context.Background(), 1)
+	assert.Nil(err)
+	assert.Nil(deleted)
+
+	_, err = h(deleteRequest("1", ""))
+	assert.Equal(serializer.NewHTTPError(http.StatusNotFound, "assignment not found"), err)
+
+	assert.Nil(repo.Update(context.Background(), 2, "yes", 10))
+
+	res, err = h(deleteRequest("2", ""))
+	assert.Nil(res)
+	assert.Equal(http.StatusConflict, err.(serializer.HTTPError).StatusCode())
+
+	_, err = h(deleteRequest("2", "?force=true"))
+	assert.Nil(err)
+
+	deleted, err = repo.GetByID(context.Background(), 2)
+	assert.Nil(err)
+	assert.Nil(deleted)
+}
+
+func TestFlagAssignment(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t,
+		&model.User{Login: "alice", Role: model.Worker},
+		&model.User{Login: "bob", Role: model.Worker},
+	)
+	repo := repository.NewAssignments(db.DB)
+
+	flagRequest := func(assignmentID string, userID int) *http.Request {
+		req, _ := http.NewRequest("PUT", "/experiments/1/assignments/"+assignmentID+"/flag", nil)
+		req = chiRequest(req, map[string]string{"experimentId": "1", "assignmentId": assignmentID})
+		return reqWithUser(req, userID)
+	}
+
+	// the flag is kept along with the answer
+	assert.Nil(repo.Update(context.Background(), 2, "yes", 10))
+	for _, id := range []string{"1", "2"} {
+		res, err := handler.FlagAssignment(repo, true)(flagRequest(id, 1))
+		assert.Nil(err)
+		assert.Equal(true, withoutTimestamps(res)["data"].(map[string]interface{})["flagged"])
+	}
+
+	_, err := handler.FlagAssignment(repo, true)(flagRequest("3", 1))
+	assert.Equal(http.StatusForbidden, err.(serializer.HTTPError).StatusCode())
+
+	_, err = handler.FlagAssignment(repo, false)(flagRequest("1", 1))
+	assert.Nil(err)
+
+	res, err := handler.GetFlaggedAssignments(repo)(flagRequest("", 1))
+	assert.Nil(err)
+
+	flagged := withoutTimestamps(res)["data"].([]interface{})
+	assert.Len(flagged, 1)
+	assignment := flagged[0].(map[string]interface{})
+	assert.Equal(float64(2), assignment["id"])
+	assert.Equal("yes", assignment["answer"])
+	assert.Equal(true, assignment["flagged"])
+}
+
+func TestSearchComments(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t,
+		&model.User{Login: "alice", Role: model.Worker},
+		&model.User{Login: "bob", Role: model.Worker},
+	)
+	repo := repository.NewAssignments(db.DB)
+
+	comments := map[int]string{
+		1: "the same Loop, renamed",
+		2: "nothing to see",
+		3: "a loop and a LOOP",
+		4: "loops are different",
+	}
+	for id, comment := range comments {
+		comment := comment
+		assert.Nil(repo.UpdateAnswer(context.Background(), id, "maybe", 10, nil, &comment, false, nil))
+	}
+
+	// the comments are indexed again when they change
+	assert.Nil(repo.UpdateAnswer(context.Background(), 2, "maybe", 10, nil, nil, false, nil))
+
+	searchRequest := func(experimentID, query string) *http.Request {
+		req, _ := http.NewRequest("GET", "/experiments/"+experimentID+"/comments"+query, nil)
+		return chiRequest(req, map[string]string{"experimentId": experimentID})
+	}
+
+	matchedIDs := func(repo *repository.Assignments, term string) []int {
+		res, err := handler.SearchComments(repo)(searchRequest("1", "?q="+term))
+		assert.Nil(err)
+
+		body, err := json.Marshal(res.Data)
+		assert.Nil(err)
+
+		var matches []struct {
+			ID      int    `json:"id"`
+			Snippet string `json:"snippet"`
+		}
+		assert.Nil(json.Unmarshal(body, &matches))
+
+		ids := make([]int, len(matches))
+		for i, m := range matches {
+			ids[i] = m.ID
+			assert.Contains(m.Snippet, "<mark>", term)
+		}
+
+		return ids
+	}
+
+	// the index matches whole words
+	assert.Equal([]int{1, 3}, matchedIDs(repo, "loop"))
+	assert.Empty(matchedIDs(repo, "see"))
+
+	res, err := handler.SearchComments(repo)(searchRequest("2", "?q=loop"))
+	assert.Nil(err)
+	assert.Equal(serializer.NewCommentMatchesResponse([]*model.Assignment{}, nil), res)
+
+	res, err = handler.SearchComments(repo)(searchRequest("1", "?q=%20"))
+	assert.Nil(res)
+	assert.Equal(http.StatusBadRequest, err.(serializer.HTTPError).StatusCode())
+
+	// a failed check of the index is not kept
+	other := repository.NewAssignments(db.DB)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = other.SearchComments(ctx, 1, "loop")
+	assert.NotNil(err)
+	assert.Equal([]int{1, 3}, matchedIDs(other, "loop"))
+
+	// without the index any part of the comment is matched
+	_, err = db.Exec("DROP TABLE assignment_comments")
+	assert.Nil(err)
+	assert.Equal([]int{1, 3, 4}, matchedIDs(repository.NewAssignments(db.DB), "loop"))
+}
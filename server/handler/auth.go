@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 
@@ -28,9 +29,18 @@ func Login(oAuth *service.OAuth) http.HandlerFunc {
 	}
 }
 
-// OAuthCallback makes exchange with oauth provider, gets&creates user and redirects to index page with JWT token
+// OAuthProvider authenticates the users in OAuthCallback; it is implemented
+// by service.OAuth
+type OAuthProvider interface {
+	ValidateState(r *http.Request, state string) error
+	GetUser(ctx context.Context, code string) (*service.GithubUser, error)
+}
+
+// OAuthCallback makes exchange with oauth provider, gets&creates user and redirects to index page with JWT token.
+// The role given by the provider is only used for new users; the existing ones keep their stored role, as it can
+// be changed by the requesters
 func OAuthCallback(
-	oAuth *service.OAuth,
+	oAuth OAuthProvider,
 	jwt *service.JWT,
 	userRepo *repository.Users,
 	logger logrus.FieldLogger,
@@ -68,7 +78,7 @@ func OAuthCallback(
 			return nil, fmt.Errorf("oauth get user error: %s", err)
 		}
 
-		user, err := userRepo.Get(ghUser.Login)
+		user, err := userRepo.Get(r.Context(), ghUser.Login)
 		if err != nil {
 			return nil, fmt.Errorf("get user from db: %s", err)
 		}
@@ -81,16 +91,15 @@ func OAuthCallback(
 				Role:      ghUser.Role,
 			}
 
-			err = userRepo.Create(user)
+			err = userRepo.Create(r.Context(), user)
 			if err != nil {
 				return nil, fmt.Errorf("can't create user: %s", err)
 			}
 		} else {
 			user.Username = ghUser.Username
 			user.AvatarURL = ghUser.AvatarURL
-			user.Role = ghUser.Role
 
-			if err = userRepo.Update(user); err != nil {
+			if err = userRepo.Update(r.Context(), user); err != nil {
 				return nil, fmt.Errorf("can't update user: %s", err)
 			}
 		}
@@ -103,3 +112,48 @@ func OAuthCallback(
 		return serializer.NewTokenResponse(token), nil
 	}
 }
+
+// RefreshToken returns a function that returns a *serializer.Response with a
+// new token for the user of the token sent in the request. The tokens that can
+// not be refreshed yet are rejected with http.StatusBadRequest
+func RefreshToken(jwt *service.JWT) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		token, err := jwt.RefreshToken(r)
+		if _, ok := err.(*service.DenylistError); ok {
+			return nil, err
+		}
+
+		if err == service.ErrRefreshTooEarly {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		if err != nil {
+			return nil, serializer.NewHTTPError(http.StatusUnauthorized, err.Error())
+		}
+
+		return serializer.NewTokenResponse(token), nil
+	}
+}
+
+// Logout returns a function that revokes the token sent in the request, so
+// it can not be used anymore. The tokens issued without ID by older versions
+// are rejected with http.StatusBadRequest, and the invalid ones with
+// http.StatusUnauthorized
+func Logout(jwt *service.JWT) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		err := jwt.RevokeToken(r)
+		if _, ok := err.(*service.DenylistError); ok {
+			return nil, err
+		}
+
+		if err == service.ErrTokenNotRevocable {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		if err != nil {
+			return nil, serializer.NewHTTPError(http.StatusUnauthorized, err.Error())
+		}
+
+		return serializer.NewEmptyResponse(), nil
+	}
+}
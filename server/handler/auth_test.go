@@ -0,0 +1,124 @@
+package handler_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"github.com/sirupsen/logrus"
+	"github.com/src-d/code-annotation/server/handler"
+	"github.com/src-d/code-annotation/server/model"
+	"github.com/src-d/code-annotation/server/repository"
+	"github.com/src-d/code-annotation/server/serializer"
+	"github.com/src-d/code-annotation/server/service"
+	"github.com/stretchr/testify/assert"
+)
+
+// testOAuth implements handler.OAuthProvider, returning always the same user
+type testOAuth struct {
+	user service.GithubUser
+}
+
+func (o *testOAuth) ValidateState(r *http.Request, state string) error {
+	return nil
+}
+
+func (o *testOAuth) GetUser(ctx context.Context, code string) (*service.GithubUser, error) {
+	user := o.user
+	return &user, nil
+}
+
+func TestOAuthCallbackKeepsRole(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDB()
+	repo := repository.NewUsers(db.DB)
+	oAuth := &testOAuth{service.GithubUser{Login: "alice", Username: "Alice", Role: model.Requester}}
+	callback := handler.OAuthCallback(oAuth, service.NewJWT("key", time.Hour, time.Hour, time.Hour), repo, logrus.New())
+
+	login := func() {
+		req, _ := http.NewRequest("GET", "/oauth-callback?state=s&code=c", nil)
+		_, err := callback(req)
+		assert.Nil(err)
+	}
+
+	// new users get the role of the provider
+	login()
+	assert.Nil(repo.Create(context.Background(), &model.User{Login: "bob", Role: model.Requester}))
+
+	user, err := repo.Get(context.Background(), "alice")
+	assert.Nil(err)
+	assert.Equal(model.Requester, user.Role)
+
+	roleReq, _ := http.NewRequest("PUT", "/users/1/role", strings.NewReader(`{"role": "worker"}`))
+	_, err = handler.UpdateUserRole(repo)(chiRequest(roleReq, map[string]string{"userId": "1"}))
+	assert.Nil(err)
+
+	// the existing users keep their role, but the profile is updated
+	oAuth.user.Username = "Alice Smith"
+	login()
+
+	user, err = repo.Get(context.Background(), "alice")
+	assert.Nil(err)
+	assert.Equal(model.Worker, user.Role)
+	assert.Equal("Alice Smith", user.Username)
+}
+
+func TestLogoutSharedDenylist(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDB()
+	newJWT := func() *service.JWT {
+		j := service.NewJWT("key", time.Hour, time.Hour, time.Hour)
+		j.SetDenylist(repository.NewRevokedTokens(db.DB))
+		return j
+	}
+
+	// the servers restarted or running in parallel share the revoked tokens
+	jwt, other := newJWT(), newJWT()
+	token, err := jwt.MakeToken(&model.User{ID: 1})
+	assert.Nil(err)
+
+	tokenRequest := func() *http.Request {
+		req, _ := http.NewRequest("POST", "/logout", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return req
+	}
+
+	status := func(j *service.JWT) int {
+		w := httptest.NewRecorder()
+		j.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})).ServeHTTP(w, tokenRequest())
+		return w.Code
+	}
+
+	assert.Equal(http.StatusOK, status(other))
+
+	res, err := handler.Logout(jwt)(tokenRequest())
+	assert.Nil(err)
+	assert.Equal(serializer.NewEmptyResponse(), res)
+
+	assert.Equal(http.StatusUnauthorized, status(jwt))
+	assert.Equal(http.StatusUnauthorized, status(other))
+	_, err = other.RefreshToken(tokenRequest())
+	assert.Equal(service.ErrTokenRevoked, err)
+
+	// the token can be revoked again
+	_, err = handler.Logout(other)(tokenRequest())
+	assert.Nil(err)
+
+	// the tokens issued without ID can not be revoked
+	token, err = jwtgo.NewWithClaims(jwtgo.SigningMethodHS256, jwtgo.MapClaims{"ID": 1}).SignedString([]byte("key"))
+	assert.Nil(err)
+	_, err = handler.Logout(jwt)(tokenRequest())
+	assert.Equal(serializer.NewHTTPError(http.StatusBadRequest, service.ErrTokenNotRevocable.Error()), err)
+
+	token = "invalid"
+	_, err = handler.Logout(jwt)(tokenRequest())
+	assert.Equal(http.StatusUnauthorized, err.(serializer.HTTPError).StatusCode())
+}
@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/src-d/code-annotation/server/serializer"
+)
+
+type bodyContextKey struct{}
+
+// MaxBodySize returns a middleware that limits the request body to the given
+// number of bytes. When it is used again for a route already limited by an
+// outer MaxBodySize, the inner limit replaces the outer one, so the routes
+// that need a bigger body can raise it
+func MaxBodySize(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, ok := r.Context().Value(bodyContextKey{}).(io.ReadCloser)
+			if !ok {
+				body = r.Body
+				r = r.WithContext(context.WithValue(r.Context(), bodyContextKey{}, body))
+			}
+
+			r.Body = http.MaxBytesReader(w, body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// bodyError returns the serializer.HTTPError for an error found reading the
+// request body: http.StatusRequestEntityTooLarge when the body is bigger than
+// the limit set by MaxBodySize, or http.StatusBadRequest otherwise
+func bodyError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return serializer.NewHTTPError(http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("the request body can not be bigger than %d bytes", maxBytesErr.Limit))
+	}
+
+	return serializer.NewHTTPError(http.StatusBadRequest, err.Error())
+}
@@ -0,0 +1,71 @@
+package handler_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/src-d/code-annotation/server/handler"
+	"github.com/src-d/code-annotation/server/repository"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaxBodySize(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDB()
+	create := handler.APIHandlerFunc(handler.CreateExperiment(repository.NewExperiments(db.DB)))
+	body := `{"name": "new", "description": "` + strings.Repeat("a", 100) + `"}`
+
+	h := handler.MaxBodySize(64)(create)
+	req, _ := http.NewRequest("POST", "/experiments", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, chiRequest(req, nil))
+	assert.Equal(http.StatusRequestEntityTooLarge, w.Code)
+	assert.Contains(w.Body.String(), "the request body can not be bigger than 64 bytes")
+
+	// an inner limit replaces the outer one
+	h = handler.MaxBodySize(64)(handler.MaxBodySize(1024)(create))
+	req, _ = http.NewRequest("POST", "/experiments", strings.NewReader(body))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, chiRequest(req, nil))
+	assert.Equal(http.StatusOK, w.Code)
+}
+
+func TestMaxBodySizeUpload(t *testing.T) {
+	assert := assert.New(t)
+
+	csvFile, err := ioutil.TempFile("", "cat_test_max_body_size.csv")
+	if err != nil {
+		t.Fatalf("can't create csv file for test %s", err)
+	}
+	defer os.Remove(csvFile.Name())
+
+	csvFile.WriteString("score,leftPath,rightPath,leftContent,rightContent\n" +
+		"0.5,a.go,b.go," + strings.Repeat("a", 1024) + ",package b\n")
+	csvFile.Close()
+
+	db := testDB()
+	upload := handler.APIHandlerFunc(handler.UploadFilePairsCSV(db, 10))
+
+	h := handler.MaxBodySize(512)(upload)
+	req, err := newFileUploadRequest("/experiments/1/file-pairs/csv", nil, "input_csv", csvFile.Name())
+	if err != nil {
+		t.Fatalf("can't create file upload request %s", err)
+	}
+	req = chiRequest(req, map[string]string{"experimentId": "1"})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(http.StatusRequestEntityTooLarge, w.Code)
+
+	h = handler.MaxBodySize(512)(handler.MaxBodySize(4096)(upload))
+	req, _ = newFileUploadRequest("/experiments/1/file-pairs/csv", nil, "input_csv", csvFile.Name())
+	req = chiRequest(req, map[string]string{"experimentId": "1"})
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(http.StatusOK, w.Code)
+}
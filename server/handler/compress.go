@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// incompressibleContentTypes are the prefixes of the content types that are
+// already compressed, and would not get any smaller
+var incompressibleContentTypes = []string{
+	"image/png", "image/jpeg", "image/gif", "image/webp",
+	"video/", "audio/", "font/woff",
+	"application/zip", "application/gzip", "application/x-gzip",
+	"application/x-bzip2", "application/x-xz", "application/x-7z-compressed",
+}
+
+// compressor is implemented by gzip.Writer and flate.Writer
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// Compress returns a middleware that compresses the responses with gzip, or
+// deflate, at the given level when the client accepts it. The responses are
+// sent as they are if they are smaller than minSize bytes, if they are already
+// encoded, or if their content type is already compressed. The level must be
+// valid for compress/flate
+func Compress(minSize, level int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encoding := acceptedEncoding(r)
+			if encoding == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			cw := &compressResponseWriter{
+				ResponseWriter: w,
+				encoding:       encoding,
+				minSize:        minSize,
+				level:          level,
+			}
+			defer cw.close()
+
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// acceptedEncoding returns "gzip" or "deflate" if the client accepts them, in
+// that order of preference, or an empty string otherwise
+func acceptedEncoding(r *http.Request) string {
+	var deflate bool
+	for _, value := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		parts := strings.Split(value, ";")
+		name := strings.ToLower(strings.TrimSpace(parts[0]))
+		// the encodings with q=0 are explicitly refused
+		if len(parts) > 1 && strings.TrimSpace(parts[1]) == "q=0" {
+			continue
+		}
+
+		switch name {
+		case "gzip":
+			return "gzip"
+		case "deflate":
+			deflate = true
+		}
+	}
+
+	if deflate {
+		return "deflate"
+	}
+
+	return ""
+}
+
+// compressResponseWriter keeps the response body until it reaches minSize to
+// decide whether it is compressed
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+	level    int
+
+	status   int
+	buf      []byte
+	decided  bool
+	hijacked bool
+	writer   io.Writer
+	encoder  compressor
+}
+
+func (w *compressResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *compressResponseWriter) Write(b []byte) (int, error) {
+	if w.decided {
+		return w.writer.Write(b)
+	}
+
+	w.buf = append(w.buf, b...)
+	if len(w.buf) < w.minSize {
+		return len(b), nil
+	}
+
+	if err := w.decide(true); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// decide starts the response, compressed if canCompress and the response
+// allows it, and writes the kept body
+func (w *compressResponseWriter) decide(canCompress bool) error {
+	w.decided = true
+	w.writer = w.ResponseWriter
+
+	h := w.Header()
+	if h.Get("Content-Type") == "" && len(w.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+
+	if canCompress && w.compressible() {
+		var err error
+		if w.encoding == "gzip" {
+			w.encoder, err = gzip.NewWriterLevel(w.ResponseWriter, w.level)
+		} else {
+			w.encoder, err = flate.NewWriter(w.ResponseWriter, w.level)
+		}
+		if err != nil {
+			return err
+		}
+
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		w.writer = w.encoder
+	}
+
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+
+	_, err := w.writer.Write(buf)
+	return err
+}
+
+// compressible returns true if the response is not encoded yet and its
+// content type is not already compressed
+func (w *compressResponseWriter) compressible() bool {
+	switch w.status {
+	case http.StatusNoContent, http.StatusNotModified:
+		return false
+	}
+
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+
+	contentType := strings.ToLower(h.Get("Content-Type"))
+	for _, prefix := range incompressibleContentTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Flush sends the response written so far. A response flushed before
+// reaching minSize is streamed, so it is compressed anyway
+func (w *compressResponseWriter) Flush() {
+	if !w.decided {
+		if err := w.decide(true); err != nil {
+			return
+		}
+	}
+
+	if w.encoder != nil {
+		w.encoder.Flush()
+	}
+
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack lets the WebSocket handlers take over the connection, that is
+// never compressed
+func (w *compressResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the response writer does not support hijacking")
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+
+	return conn, rw, err
+}
+
+// close sends the kept body, uncompressed as it did not reach minSize, and
+// finishes the compressed stream if any
+func (w *compressResponseWriter) close() {
+	if w.hijacked {
+		return
+	}
+
+	if !w.decided {
+		w.decide(false)
+	}
+
+	if w.encoder != nil {
+		w.encoder.Close()
+	}
+}
@@ -0,0 +1,76 @@
+package handler_test
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/src-d/code-annotation/server/handler"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func compressRequest(contentType, body, acceptEncoding string) *httptest.ResponseRecorder {
+	h := handler.Compress(64, gzip.DefaultCompression)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Write([]byte(body))
+	}))
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestCompress(t *testing.T) {
+	assert := assert.New(t)
+
+	body := `{"data": "` + strings.Repeat("a", 1024) + `"}`
+
+	w := compressRequest("application/json", body, "deflate, gzip")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal("Accept-Encoding", w.Header().Get("Vary"))
+	assert.True(w.Body.Len() < len(body))
+
+	r, err := gzip.NewReader(w.Body)
+	assert.NoError(err)
+	b, err := ioutil.ReadAll(r)
+	assert.NoError(err)
+	assert.Equal(body, string(b))
+
+	w = compressRequest("application/json", body, "gzip;q=0, deflate")
+	assert.Equal("deflate", w.Header().Get("Content-Encoding"))
+	b, err = ioutil.ReadAll(flate.NewReader(w.Body))
+	assert.NoError(err)
+	assert.Equal(body, string(b))
+}
+
+func TestCompressSkipped(t *testing.T) {
+	assert := assert.New(t)
+
+	body := strings.Repeat("a", 1024)
+
+	// the client does not accept any compression
+	w := compressRequest("text/plain", body, "")
+	assert.Equal("", w.Header().Get("Content-Encoding"))
+	assert.Equal(body, w.Body.String())
+
+	// the body is smaller than the threshold
+	w = compressRequest("application/json", `{"data": 1}`, "gzip")
+	assert.Equal("", w.Header().Get("Content-Encoding"))
+	assert.Equal(`{"data": 1}`, w.Body.String())
+
+	// the content type is already compressed
+	w = compressRequest("image/png", body, "gzip")
+	assert.Equal("", w.Header().Get("Content-Encoding"))
+	assert.Equal(body, w.Body.String())
+}
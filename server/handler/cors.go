@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/src-d/code-annotation/server/service"
+
+	"github.com/rs/cors"
+)
+
+// CORS returns a middleware that sets the CORS headers allowed by the given
+// service.CORSConfig. Preflight requests are answered with
+// http.StatusNoContent without calling the next handler
+func CORS(conf service.CORSConfig) func(http.Handler) http.Handler {
+	c := cors.New(cors.Options{
+		AllowedOrigins:     trimAll(conf.AllowedOrigins),
+		AllowedMethods:     trimAll(conf.AllowedMethods),
+		AllowedHeaders:     trimAll(conf.AllowedHeaders),
+		AllowCredentials:   true,
+		OptionsPassthrough: true,
+	})
+
+	return func(next http.Handler) http.Handler {
+		return c.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isPreflight(r) {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}))
+	}
+}
+
+// isPreflight returns true if the request is a CORS preflight request
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions &&
+		r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// trimAll returns the non empty values of the list, without surrounding spaces
+func trimAll(values []string) []string {
+	var result []string
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,65 @@
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/src-d/code-annotation/server/handler"
+	"github.com/src-d/code-annotation/server/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCORS(t *testing.T) {
+	assert := assert.New(t)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	h := handler.CORS(service.CORSConfig{
+		AllowedOrigins: []string{"https://cat.example.com", " https://other.example.com"},
+		AllowedMethods: []string{"GET", "PUT"},
+		AllowedHeaders: []string{"Authorization"},
+	})(next)
+
+	req, _ := http.NewRequest("OPTIONS", "/api/me", nil)
+	req.Header.Set("Origin", "https://other.example.com")
+	req.Header.Set("Access-Control-Request-Method", "PUT")
+	req.Header.Set("Access-Control-Request-Headers", "Authorization")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(http.StatusNoContent, w.Code)
+	assert.Equal("https://other.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal("PUT", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal("Authorization", w.Header().Get("Access-Control-Allow-Headers"))
+
+	req, _ = http.NewRequest("GET", "/api/me", nil)
+	req.Header.Set("Origin", "https://cat.example.com")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(http.StatusTeapot, w.Code)
+	assert.Equal("https://cat.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+
+	req, _ = http.NewRequest("GET", "/api/me", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(http.StatusTeapot, w.Code)
+	assert.Empty(w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestCORSWildcard(t *testing.T) {
+	assert := assert.New(t)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := handler.CORS(service.CORSConfig{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{"GET"},
+	})(next)
+
+	req, _ := http.NewRequest("GET", "/api/me", nil)
+	req.Header.Set("Origin", "https://any.example.com")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal("https://any.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+}
@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ETagFunc is a function that returns the ETag of the resource requested by
+// the passed http.Request. If the resource has no ETag, it returns ""
+type ETagFunc func(*http.Request) (string, error)
+
+// NewETag returns a strong ETag built as a hash of the passed parts
+func NewETag(parts ...string) string {
+	return fmt.Sprintf(`"%x"`, sha1.Sum([]byte(strings.Join(parts, "\x00"))))
+}
+
+// WithETag returns an http.HandlerFunc for immutable resources. It sets the
+// ETag returned by etagFn in the responses of the passed RequestProcessFunc,
+// and replies with http.StatusNotModified if the request If-None-Match header
+// matches it, without calling the RequestProcessFunc
+func WithETag(etagFn ETagFunc, rp RequestProcessFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		etag, err := etagFn(r)
+		if err != nil {
+			write(w, r, nil, err)
+			return
+		}
+
+		if etag != "" && etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.Header().Set("ETag", etag)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		response, err := rp(r)
+		if err == nil && etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+
+		write(w, r, response, err)
+	}
+}
+
+// etagMatches returns true if the value of an If-None-Match header matches
+// the passed ETag, using the weak comparison
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" ||
+			strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
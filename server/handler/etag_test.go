@@ -0,0 +1,70 @@
+package handler_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/src-d/code-annotation/server/handler"
+	"github.com/src-d/code-annotation/server/repository"
+	"github.com/src-d/code-annotation/server/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithETagFilePair(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t)
+	repo := repository.NewFilePairs(db.DB)
+	h := handler.WithETag(
+		handler.FilePairETag(repo),
+		handler.GetFilePairDetails(repo, service.NewDiff(0), nil))
+
+	pairRequest := func(pairID, ifNoneMatch string) *http.Request {
+		req, _ := http.NewRequest("GET", "/experiments/1/file-pairs/"+pairID, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+
+		return chiRequest(req, map[string]string{"experimentId": "1", "pairId": pairID})
+	}
+
+	w := httptest.NewRecorder()
+	h(w, pairRequest("1", ""))
+	assert.Equal(http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+	assert.NotEmpty(etag)
+
+	w = httptest.NewRecorder()
+	h(w, pairRequest("1", `"other", `+etag))
+	assert.Equal(http.StatusNotModified, w.Code)
+	assert.Equal(etag, w.Header().Get("ETag"))
+	assert.Empty(w.Body.String())
+
+	w = httptest.NewRecorder()
+	h(w, pairRequest("2", etag))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.NotEqual(etag, w.Header().Get("ETag"))
+
+	w = httptest.NewRecorder()
+	h(w, pairRequest("3", ""))
+	assert.Equal(http.StatusNotFound, w.Code)
+	assert.Empty(w.Header().Get("ETag"))
+
+	// the pair must belong to the experiment of the URL
+	req, _ := http.NewRequest("GET", "/experiments/2/file-pairs/1", nil)
+	w = httptest.NewRecorder()
+	h(w, chiRequest(req, map[string]string{"experimentId": "2", "pairId": "1"}))
+	assert.Equal(http.StatusNotFound, w.Code)
+	assert.Empty(w.Header().Get("ETag"))
+
+	// the cached contents of the purged pairs are not revalidated
+	_, err := repo.Purge(context.Background(), 1)
+	assert.Nil(err)
+
+	w = httptest.NewRecorder()
+	h(w, pairRequest("1", etag))
+	assert.Equal(http.StatusGone, w.Code)
+	assert.Empty(w.Header().Get("ETag"))
+}
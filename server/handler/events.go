@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/src-d/code-annotation/server/model"
+	"github.com/src-d/code-annotation/server/serializer"
+	"github.com/src-d/code-annotation/server/service"
+
+	"github.com/pressly/lg"
+)
+
+// EventsKeepAlive is the interval between the keepalive comments sent by
+// ExperimentEvents, to keep the connection open through proxies
+const EventsKeepAlive = 15 * time.Second
+
+// ExperimentEvents returns a function that streams, as Server-Sent Events,
+// an "answer" event with the pair ID and the answer every time an assignment
+// of the experiment is answered. A keepalive comment is sent every keepAlive
+// when there are no events. The stream ends when the client disconnects, or
+// with a "close" event when the server shuts down
+func ExperimentEvents(events *service.Hub, keepAlive time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			write(w, r, nil, err)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			write(w, r, nil, fmt.Errorf("streaming is not supported by the response writer"))
+			return
+		}
+
+		received, unsubscribe, err := events.Subscribe(experimentID)
+		if err == service.ErrTooManySubscribers {
+			write(w, r, nil, serializer.NewHTTPError(http.StatusServiceUnavailable,
+				"too many event subscribers, try again later"))
+			return
+		}
+		if err == service.ErrHubClosed {
+			write(w, r, nil, serializer.NewHTTPError(http.StatusServiceUnavailable,
+				"the server is shutting down"))
+			return
+		}
+		if err != nil {
+			write(w, r, nil, err)
+			return
+		}
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		ticker := time.NewTicker(keepAlive)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-ticker.C:
+				if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+					return
+				}
+			case event, ok := <-received:
+				// the hub is closed when the server is shutting down
+				if !ok {
+					fmt.Fprint(w, "event: close\ndata: the server is shutting down\n\n")
+					flusher.Flush()
+					return
+				}
+
+				content, err := json.Marshal(event)
+				if err != nil {
+					lg.RequestLog(r).Error(err.Error())
+					return
+				}
+
+				if _, err := fmt.Fprintf(w, "event: answer\ndata: %s\n\n", content); err != nil {
+					return
+				}
+			}
+
+			flusher.Flush()
+		}
+	}
+}
+
+// publishAnswer sends the answer of the assignment to the event subscribers
+// of its experiment
+func publishAnswer(events *service.Hub, assignment *model.Assignment, answer string) {
+	if events == nil {
+		return
+	}
+
+	events.Publish(assignment.ExperimentID, serializer.AnnotationEvent{
+		PairID: assignment.PairID,
+		Answer: answer,
+	})
+}
@@ -0,0 +1,111 @@
+package handler_test
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/src-d/code-annotation/server/handler"
+	"github.com/src-d/code-annotation/server/model"
+	"github.com/src-d/code-annotation/server/repository"
+	"github.com/src-d/code-annotation/server/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExperimentEvents(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t, &model.User{Login: "alice", Role: model.Worker})
+	repo := repository.NewAssignments(db.DB)
+	events := service.NewEventHub(1, 10)
+
+	logger, err := service.NewLogger("production", service.LoggerConfig{})
+	assert.Nil(err)
+	logger.Out = ioutil.Discard
+
+	r := chi.NewRouter()
+	r.Use(handler.RequestLogger(logger))
+	r.Get("/experiments/{experimentId}/events", handler.ExperimentEvents(events, 20*time.Millisecond))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, _ := http.NewRequest("GET", server.URL+"/experiments/1/events", nil)
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	assert.Nil(err)
+	defer res.Body.Close()
+	assert.Equal(http.StatusOK, res.StatusCode)
+	assert.Equal("text/event-stream", res.Header.Get("Content-Type"))
+
+	lines := bufio.NewReader(res.Body)
+	readLine := func() string {
+		line, err := lines.ReadString('\n')
+		assert.Nil(err)
+		return strings.TrimSuffix(line, "\n")
+	}
+
+	assert.Equal(": keepalive", readLine())
+	assert.Equal("", readLine())
+
+	// the hub only accepts one subscriber
+	busy, err := http.Get(server.URL + "/experiments/1/events")
+	assert.Nil(err)
+	assert.Equal(http.StatusServiceUnavailable, busy.StatusCode)
+
+	answer := handler.SaveAssignment(repository.NewExperiments(db.DB), repo, time.Minute, time.Hour, 1000, nil, events)
+	req, _ = http.NewRequest("PUT", "/experiments/1/assignments/2", strings.NewReader(`{"answer": "no"}`))
+	_, err = answer(reqWithUser(chiRequest(req, map[string]string{"experimentId": "1", "assignmentId": "2"}), 1))
+	assert.Nil(err)
+
+	line := readLine()
+	for line == ": keepalive" || line == "" {
+		line = readLine()
+	}
+	assert.Equal("event: answer", line)
+	assert.Equal(`data: {"pairId":2,"answer":"no"}`, readLine())
+
+	cancel()
+	for i := 0; i < 50 && events.HasSubscribers(1); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.False(events.HasSubscribers(1))
+}
+
+func TestExperimentEventsHubClosed(t *testing.T) {
+	assert := assert.New(t)
+
+	events := service.NewEventHub(1, 10)
+
+	logger, err := service.NewLogger("production", service.LoggerConfig{})
+	assert.Nil(err)
+	logger.Out = ioutil.Discard
+
+	r := chi.NewRouter()
+	r.Use(handler.RequestLogger(logger))
+	r.Get("/experiments/{experimentId}/events", handler.ExperimentEvents(events, time.Minute))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/experiments/1/events")
+	assert.Nil(err)
+	defer res.Body.Close()
+	assert.Equal(http.StatusOK, res.StatusCode)
+
+	// the subscribers are told to go away when the server shuts down
+	events.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	assert.Nil(err)
+	assert.Equal("event: close\ndata: the server is shutting down\n\n", string(body))
+
+	res, err = http.Get(server.URL + "/experiments/1/events")
+	assert.Nil(err)
+	assert.Equal(http.StatusServiceUnavailable, res.StatusCode)
+}
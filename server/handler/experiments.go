@@ -1,32 +1,795 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/src-d/code-annotation/server/model"
 	"github.com/src-d/code-annotation/server/repository"
 	"github.com/src-d/code-annotation/server/serializer"
 	"github.com/src-d/code-annotation/server/service"
+
+	"github.com/go-chi/chi"
 )
 
-// GetExperimentDetails returns a function that returns a *serializer.Response
-// with the details of a requested experiment
-func GetExperimentDetails(repo *repository.Experiments, assignmentsRepo *repository.Assignments) RequestProcessFunc {
-	return func(r *http.Request) (*serializer.Response, error) {
-		userID, err := service.GetUserID(r.Context())
+// GetExperimentDetails returns a function that returns a *serializer.Response
+// with the details of a requested experiment. Soft-deleted experiments are only
+// returned if the "includeDeleted" query parameter is true
+func GetExperimentDetails(
+	usersRepo *repository.Users,
+	repo *repository.Experiments,
+	assignmentsRepo *repository.Assignments,
+) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		userID, err := service.GetUserID(r.Context())
+		if err != nil {
+			return nil, err
+		}
+
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
+		experiment, err := repo.GetByID(r.Context(), experimentID, includeDeleted(r))
+		if err != nil {
+			return nil, err
+		}
+
+		if experiment == nil {
+			return nil, serializer.NewHTTPError(http.StatusNotFound, "no experiment found")
+		}
+
+		progress, err := experimentProgress(r.Context(), assignmentsRepo, experiment.ID, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		user, err := usersRepo.GetByID(r.Context(), userID)
+		if err != nil {
+			return nil, err
+		}
+
+		if user != nil && user.Role == model.Requester {
+			return serializer.NewExperimentWithSeedResponse(experiment, progress), nil
+		}
+
+		return serializer.NewExperimentResponse(experiment, progress), nil
+	}
+}
+
+type reseedRequest struct {
+	// Seed is a string, as the JavaScript numbers can not hold every int64
+	Seed string `json:"seed"`
+}
+
+// ReseedExperiment returns a function that sets the seed of the random
+// assignment strategy of the requested experiment, passed as a string in the
+// body request, or a new random one if it is empty. The pairs of the unanswered
+// assignments of every user are sorted again following the new seed, so a
+// fresh cohort of users can annotate the file pairs in a reproducible order.
+// It returns the number of reordered assignments
+func ReseedExperiment(repo *repository.Experiments) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, bodyError(err)
+		}
+
+		var req reseedRequest
+		if len(body) > 0 {
+			if err := json.Unmarshal(body, &req); err != nil {
+				return nil, serializer.NewHTTPError(http.StatusBadRequest, err.Error())
+			}
+		}
+
+		seed := time.Now().UnixNano()
+		if req.Seed != "" {
+			seed, err = strconv.ParseInt(req.Seed, 10, 64)
+			if err != nil {
+				return nil, serializer.NewHTTPError(http.StatusBadRequest,
+					fmt.Sprintf("invalid seed %q", req.Seed))
+			}
+		}
+
+		experiment, err := repo.GetByID(r.Context(), experimentID, false)
+		if err != nil {
+			return nil, err
+		}
+
+		if experiment == nil {
+			return nil, serializer.NewHTTPError(http.StatusNotFound, "no experiment found")
+		}
+
+		if experiment.AssignmentStrategy != model.AssignmentRandom {
+			return nil, serializer.NewHTTPError(http.StatusConflict,
+				"the experiment does not use the random assignment strategy")
+		}
+
+		updated, reordered, err := repo.Reseed(r.Context(), experimentID, seed)
+		if err != nil {
+			return nil, err
+		}
+
+		if !updated {
+			return nil, serializer.NewHTTPError(http.StatusNotFound, "no experiment found")
+		}
+
+		return serializer.NewCountResponse(reordered), nil
+	}
+}
+
+// PurgeExperimentBlobs returns a function that deletes the contents of the
+// files of the requested experiment, keeping the rest of the file pairs data
+// so its stats can still be calculated. The experiment must be frozen, and
+// the request must be confirmed with the confirm=true query parameter, as the
+// contents can not be recovered. It returns the number of purged file pairs
+func PurgeExperimentBlobs(repo *repository.Experiments, filePairsRepo *repository.FilePairs) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
+		if r.URL.Query().Get("confirm") != "true" {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest,
+				"the purge must be confirmed with confirm=true")
+		}
+
+		experiment, err := repo.GetByID(r.Context(), experimentID, false)
+		if err != nil {
+			return nil, err
+		}
+
+		if experiment == nil {
+			return nil, serializer.NewHTTPError(http.StatusNotFound, "no experiment found")
+		}
+
+		if !experiment.IsFrozen() {
+			return nil, serializer.NewHTTPError(http.StatusConflict,
+				"the experiment must be frozen to purge its blobs")
+		}
+
+		purged, err := filePairsRepo.Purge(r.Context(), experimentID)
+		if err != nil {
+			return nil, err
+		}
+
+		return serializer.NewCountResponse(purged), nil
+	}
+}
+
+const defaultExperimentsLimit = 50
+
+// GetExperiments returns a function that returns a *serializer.Response
+// with a page of the list of existing experiments. If the "q" query parameter
+// is passed, only the experiments whose name or description contain it are listed.
+// If one or more "tag" query parameters are passed, only the experiments with
+// any of those tags are listed.
+// Soft-deleted experiments are only listed if "includeDeleted" is true.
+// The "sort" query parameter sorts them by "name", "createdAt" or "progress",
+// see experimentsOrder; by default they are sorted by ID. Sorting by progress
+// is more expensive: the progress of every listed experiment is computed
+// before the page is taken, instead of only the ones in the page
+func GetExperiments(repo *repository.Experiments, assignmentsRepo *repository.Assignments) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		userID, err := service.GetUserID(r.Context())
+		if err != nil {
+			return nil, err
+		}
+
+		limit, offset, err := paginationParams(r, defaultExperimentsLimit)
+		if err != nil {
+			return nil, err
+		}
+
+		tags, err := normalizeTags(r.URL.Query()["tag"])
+		if err != nil {
+			return nil, err
+		}
+
+		order, byProgress, err := experimentsOrder(r)
+		if err != nil {
+			return nil, err
+		}
+
+		term := r.URL.Query().Get("q")
+		if byProgress {
+			return progressSortedExperimentsPage(r.Context(), repo, assignmentsRepo, userID,
+				term, tags, limit, offset, includeDeleted(r), order.Desc)
+		}
+
+		experiments, total, err := experimentsPage(
+			r.Context(), repo, term, tags, limit, offset, includeDeleted(r), order)
+		if err != nil {
+			return nil, err
+		}
+
+		progresses, err := experimentsProgress(r.Context(), assignmentsRepo, experiments, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		return serializer.NewExperimentsResponse(experiments, progresses, total), nil
+	}
+}
+
+// GetMyExperiments returns a function that returns a *serializer.Response with
+// the experiments in which the logged user has any assignment, along with the
+// progress of the user in each of them. Unlike GetExperiments, it is not
+// paginated nor filtered
+func GetMyExperiments(repo *repository.Experiments, assignmentsRepo *repository.Assignments) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		userID, err := service.GetUserID(r.Context())
+		if err != nil {
+			return nil, err
+		}
+
+		experiments, err := repo.GetByUser(r.Context(), userID)
+		if err != nil {
+			return nil, err
+		}
+
+		progresses, err := experimentsProgress(r.Context(), assignmentsRepo, experiments, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		return serializer.NewExperimentsResponse(experiments, progresses, len(experiments)), nil
+	}
+}
+
+// sortByProgress is the value of the "sort" query parameter to sort the
+// experiments by the progress of the user, that is not known by the DB
+const sortByProgress = "progress"
+
+// experimentsOrder returns the order of the experiments list set by the "sort"
+// and "order" query parameters. "order" is "asc", the default, or "desc". If
+// they are sorted by progress it returns true, and only Desc is set
+func experimentsOrder(r *http.Request) (repository.ExperimentsOrder, bool, error) {
+	var order repository.ExperimentsOrder
+
+	switch dir := r.URL.Query().Get("order"); dir {
+	case "", "asc":
+	case "desc":
+		order.Desc = true
+	default:
+		return order, false, serializer.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("invalid order %q, it must be asc or desc", dir))
+	}
+
+	switch sortBy := r.URL.Query().Get("sort"); sortBy {
+	case "":
+		order.Sort = repository.SortByID
+	case string(repository.SortByName), string(repository.SortByCreatedAt):
+		order.Sort = repository.ExperimentsSort(sortBy)
+	case sortByProgress:
+		return order, true, nil
+	default:
+		return order, false, serializer.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("invalid sort %q, it must be name, createdAt or progress", sortBy))
+	}
+
+	return order, false, nil
+}
+
+// experimentsProgress returns the progress of the user in each experiment,
+// counting the assignments of all of them with a single query
+func experimentsProgress(
+	ctx context.Context,
+	repo *repository.Assignments,
+	experiments []*model.Experiment,
+	userID int,
+) ([]float32, error) {
+	ids := make([]int, len(experiments))
+	for i, e := range experiments {
+		ids[i] = e.ID
+	}
+
+	byID, err := progressByExperiment(ctx, repo, ids, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	progresses := make([]float32, len(experiments))
+	for i, e := range experiments {
+		progresses[i] = byID[e.ID]
+	}
+
+	return progresses, nil
+}
+
+// progressByExperiment returns the progress of the user in each of the
+// experiments with the given IDs, with a single query. It is 0 for the
+// experiments without assignments for the user
+func progressByExperiment(
+	ctx context.Context,
+	repo *repository.Assignments,
+	experimentIDs []int,
+	userID int,
+) (map[int]float32, error) {
+	counts, err := repo.GetUserProgressByExperiment(ctx, userID, experimentIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	progresses := make(map[int]float32, len(experimentIDs))
+	for _, id := range experimentIDs {
+		progresses[id] = 0
+		if p, ok := counts[id]; ok {
+			progresses[id] = p.Progress()
+		}
+	}
+
+	return progresses, nil
+}
+
+// maxBatchExperiments is the max number of experiments requested at once
+const maxBatchExperiments = 100
+
+// GetExperimentsProgress returns a function that returns a
+// *serializer.Response with the progress of the logged user in each of the
+// requested experiments, by ID. The IDs are passed as in GetFilePairsBatch,
+// in the comma separated "ids" query parameter or in the body request for
+// POST requests
+func GetExperimentsProgress(repo *repository.Assignments) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		userID, err := service.GetUserID(r.Context())
+		if err != nil {
+			return nil, err
+		}
+
+		ids, err := batchIDs(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(ids) > maxBatchExperiments {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("at most %d experiments can be requested at once", maxBatchExperiments))
+		}
+
+		progresses, err := progressByExperiment(r.Context(), repo, ids, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		return serializer.NewExperimentsProgressResponse(progresses), nil
+	}
+}
+
+// progressSortedExperimentsPage returns a Response with a page of the
+// experiments matching the given term and tags, sorted by the progress of the
+// user. The ties are sorted by ID
+func progressSortedExperimentsPage(
+	ctx context.Context,
+	repo *repository.Experiments,
+	assignmentsRepo *repository.Assignments,
+	userID int,
+	term string,
+	tags []string,
+	limit, offset int,
+	includeDeleted bool,
+	desc bool,
+) (*serializer.Response, error) {
+	experiments, err := searchExperiments(ctx, repo, term, tags, includeDeleted, repository.ExperimentsOrder{})
+	if err != nil {
+		return nil, err
+	}
+
+	progresses, err := experimentsProgress(ctx, assignmentsRepo, experiments, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	byProgress := make(map[int]float32, len(experiments))
+	for i, e := range experiments {
+		byProgress[e.ID] = progresses[i]
+	}
+
+	sort.SliceStable(experiments, func(i, j int) bool {
+		if desc {
+			return byProgress[experiments[i].ID] > byProgress[experiments[j].ID]
+		}
+
+		return byProgress[experiments[i].ID] < byProgress[experiments[j].ID]
+	})
+
+	start, end := pageBounds(len(experiments), limit, offset)
+	experiments = experiments[start:end]
+
+	progresses = progresses[:0]
+	for _, e := range experiments {
+		progresses = append(progresses, byProgress[e.ID])
+	}
+
+	return serializer.NewExperimentsResponse(experiments, progresses, len(byProgress)), nil
+}
+
+// experimentsPage returns a page of the experiments matching the given term
+// and with any of the given tags, or of all the experiments if both are empty,
+// and the total number of them. Only the search by tags is paginated here
+// instead of in the DB
+func experimentsPage(
+	ctx context.Context,
+	repo *repository.Experiments,
+	term string,
+	tags []string,
+	limit, offset int,
+	includeDeleted bool,
+	order repository.ExperimentsOrder,
+) ([]*model.Experiment, int, error) {
+	if len(tags) > 0 {
+		experiments, err := repo.SearchByTags(ctx, tags, term, includeDeleted, order)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		start, end := pageBounds(len(experiments), limit, offset)
+		return experiments[start:end], len(experiments), nil
+	}
+
+	if term != "" {
+		experiments, err := repo.SearchByName(ctx, term, limit, offset, includeDeleted, order)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		total, err := repo.CountByName(ctx, term, includeDeleted)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		return experiments, total, nil
+	}
+
+	experiments, err := repo.GetPaginated(ctx, limit, offset, includeDeleted, order)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total, err := repo.Count(ctx, includeDeleted)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return experiments, total, nil
+}
+
+// searchExperiments returns all the experiments matching the given term and
+// with any of the given tags, or all the experiments if both are empty
+func searchExperiments(
+	ctx context.Context,
+	repo *repository.Experiments,
+	term string,
+	tags []string,
+	includeDeleted bool,
+	order repository.ExperimentsOrder,
+) ([]*model.Experiment, error) {
+	switch {
+	case len(tags) > 0:
+		return repo.SearchByTags(ctx, tags, term, includeDeleted, order)
+	case term != "":
+		total, err := repo.CountByName(ctx, term, includeDeleted)
+		if err != nil {
+			return nil, err
+		}
+
+		return repo.SearchByName(ctx, term, total, 0, includeDeleted, order)
+	default:
+		total, err := repo.Count(ctx, includeDeleted)
+		if err != nil {
+			return nil, err
+		}
+
+		return repo.GetPaginated(ctx, total, 0, includeDeleted, order)
+	}
+}
+
+// pageBounds returns the positions of the first and after the last items of
+// the page, in a list with total items
+func pageBounds(total, limit, offset int) (int, int) {
+	if offset > total {
+		offset = total
+	}
+	if offset+limit < total {
+		return offset, offset + limit
+	}
+
+	return offset, total
+}
+
+// GetExperimentUserProgress returns a function that returns a *serializer.Response
+// with the progress of every user in the requested experiment, sorted from
+// the most to the least advanced
+func GetExperimentUserProgress(repo *repository.Experiments, assignmentsRepo *repository.Assignments) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
+		experiment, err := repo.GetByID(r.Context(), experimentID, false)
+		if err != nil {
+			return nil, err
+		}
+
+		if experiment == nil {
+			return nil, serializer.NewHTTPError(http.StatusNotFound, "no experiment found")
+		}
+
+		progresses, err := assignmentsRepo.GetUsersProgress(r.Context(), experimentID)
+		if err != nil {
+			return nil, err
+		}
+
+		sort.SliceStable(progresses, func(i, j int) bool {
+			return progresses[i].Progress() > progresses[j].Progress()
+		})
+
+		return serializer.NewUsersProgressResponse(progresses), nil
+	}
+}
+
+// DuplicateExperiment returns a function that creates a copy of the requested
+// experiment, with the same file pairs but no assignments, and returns it
+func DuplicateExperiment(repo *repository.Experiments) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
+		experiment, err := repo.GetByID(r.Context(), experimentID, false)
+		if err != nil {
+			return nil, err
+		}
+
+		if experiment == nil {
+			return nil, serializer.NewHTTPError(http.StatusNotFound, "no experiment found")
+		}
+
+		name, err := copyName(r.Context(), repo, experiment.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		duplicate, err := repo.Duplicate(r.Context(), experiment, name)
+		if err != nil {
+			return nil, err
+		}
+
+		return serializer.NewExperimentResponse(duplicate, 0), nil
+	}
+}
+
+// copyName returns the name for a copy of the experiment with the given name,
+// appending " (copy)", or " (copy N)" if the previous names are already taken
+func copyName(ctx context.Context, repo *repository.Experiments, name string) (string, error) {
+	candidate := name + " (copy)"
+	for i := 2; ; i++ {
+		exists, err := repo.NameExists(ctx, candidate)
+		if err != nil {
+			return "", err
+		}
+
+		if !exists {
+			return candidate, nil
+		}
+
+		candidate = fmt.Sprintf("%s (copy %d)", name, i)
+	}
+}
+
+// GetExperimentStats returns a function that returns a *serializer.Response
+// with the stats of the requested experiment. The durations stats only take
+// into account the answered assignments with a duration, and the annotators
+// are the users with at least one answered assignment
+func GetExperimentStats(
+	repo *repository.Experiments,
+	filePairsRepo *repository.FilePairs,
+	assignmentsRepo *repository.Assignments,
+) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
+		experiment, err := repo.GetByID(r.Context(), experimentID, false)
+		if err != nil {
+			return nil, err
+		}
+
+		if experiment == nil {
+			return nil, serializer.NewHTTPError(http.StatusNotFound, "no experiment found")
+		}
+
+		durations, err := assignmentsRepo.GetCompleteDurations(r.Context(), experimentID)
+		if err != nil {
+			return nil, err
+		}
+
+		filePairs, err := filePairsRepo.GetStats(r.Context(), experimentID)
+		if err != nil {
+			return nil, err
+		}
+
+		annotators, err := assignmentsRepo.CountAnnotators(r.Context(), experimentID)
+		if err != nil {
+			return nil, err
+		}
+
+		meanConfidence, err := assignmentsRepo.GetMeanConfidence(r.Context(), experimentID)
+		if err != nil {
+			return nil, err
+		}
+
+		return serializer.NewExpStatsResponse(serializer.ExpStatsResponse{
+			MedianDuration: service.Median(durations),
+			MeanDuration:   service.Mean(durations),
+			Durations:      len(durations),
+			FilePairs:      filePairs.Count,
+			TotalLOC:       filePairs.LOC,
+			MeanScore:      filePairs.MeanScore,
+			Annotators:     annotators,
+			MeanConfidence: meanConfidence,
+		}), nil
+	}
+}
+
+// timelineGranularities are the accepted sizes of the timeline buckets
+var timelineGranularities = map[string]time.Duration{
+	"hour": time.Hour,
+	"day":  24 * time.Hour,
+}
+
+// GetExperimentAnnotationTimeline returns a function that returns a
+// *serializer.Response with the answers of the experiment grouped in
+// buckets of the time they were made. The size of the buckets is set by the
+// "granularity" query parameter, "hour" or "day" (default)
+func GetExperimentAnnotationTimeline(repo *repository.Experiments, assignmentsRepo *repository.Assignments) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
+		granularity := r.URL.Query().Get("granularity")
+		if granularity == "" {
+			granularity = "day"
+		}
+
+		bucketSize, ok := timelineGranularities[granularity]
+		if !ok {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("unknown granularity %q", granularity))
+		}
+
+		experiment, err := repo.GetByID(r.Context(), experimentID, false)
+		if err != nil {
+			return nil, err
+		}
+
+		if experiment == nil {
+			return nil, serializer.NewHTTPError(http.StatusNotFound, "no experiment found")
+		}
+
+		counts, err := assignmentsRepo.GetAnswerCounts(r.Context(), experimentID)
+		if err != nil {
+			return nil, err
+		}
+
+		// counts are sorted by time, so the buckets are created in order
+		buckets := make([]*serializer.TimelineBucketResponse, 0)
+		for _, c := range counts {
+			start := c.Time.UTC().Truncate(bucketSize)
+			if len(buckets) == 0 || !buckets[len(buckets)-1].Start.Equal(start) {
+				buckets = append(buckets, &serializer.TimelineBucketResponse{
+					Start:                 start,
+					ExpAnnotationResponse: serializer.NewExpAnnotationResponse(experiment),
+				})
+			}
+
+			buckets[len(buckets)-1].Add(c.Answer, c.Count)
+		}
+
+		return serializer.NewTimelineResponse(buckets), nil
+	}
+}
+
+// GetInterAnnotatorAgreement returns a function that returns a
+// *serializer.Response with the Cohen's kappa of every two users that
+// answered the same FilePairs of the experiment, and their average
+func GetInterAnnotatorAgreement(repo *repository.Experiments, assignmentsRepo *repository.Assignments) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
+		experiment, err := repo.GetByID(r.Context(), experimentID, false)
+		if err != nil {
+			return nil, err
+		}
+
+		if experiment == nil {
+			return nil, serializer.NewHTTPError(http.StatusNotFound, "no experiment found")
+		}
+
+		answers, err := assignmentsRepo.GetAnswersByPair(r.Context(), experimentID)
 		if err != nil {
 			return nil, err
 		}
 
+		ratings := make(map[[2]int][][2]string)
+		for _, pairAnswers := range answers {
+			userIDs := make([]int, 0, len(pairAnswers))
+			for userID := range pairAnswers {
+				userIDs = append(userIDs, userID)
+			}
+			sort.Ints(userIDs)
+
+			for i, userA := range userIDs {
+				for _, userB := range userIDs[i+1:] {
+					users := [2]int{userA, userB}
+					ratings[users] = append(ratings[users],
+						[2]string{pairAnswers[userA], pairAnswers[userB]})
+				}
+			}
+		}
+
+		agreements := make([]serializer.UsersAgreementResponse, 0, len(ratings))
+		var sum float64
+		for users, usersRatings := range ratings {
+			kappa := service.CohenKappa(usersRatings)
+			sum += kappa
+			agreements = append(agreements, serializer.UsersAgreementResponse{
+				UserA: users[0], UserB: users[1], Pairs: len(usersRatings), Kappa: kappa,
+			})
+		}
+
+		sort.Slice(agreements, func(i, j int) bool {
+			if agreements[i].UserA != agreements[j].UserA {
+				return agreements[i].UserA < agreements[j].UserA
+			}
+
+			return agreements[i].UserB < agreements[j].UserB
+		})
+
+		var average float64
+		if len(agreements) > 0 {
+			average = sum / float64(len(agreements))
+		}
+
+		return serializer.NewAgreementResponse(agreements, average), nil
+	}
+}
+
+// GetFleissKappa returns a function that returns a *serializer.Response with
+// the Fleiss' kappa of all the users that answered the experiment. The
+// FilePairs answered by less than two users are excluded
+func GetFleissKappa(repo *repository.Experiments, filePairsRepo *repository.FilePairs, assignmentsRepo *repository.Assignments) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
 		experimentID, err := urlParamInt(r, "experimentId")
 		if err != nil {
 			return nil, err
 		}
 
-		experiment, err := repo.GetByID(experimentID)
+		experiment, err := repo.GetByID(r.Context(), experimentID, false)
 		if err != nil {
 			return nil, err
 		}
@@ -35,63 +798,90 @@ func GetExperimentDetails(repo *repository.Experiments, assignmentsRepo *reposit
 			return nil, serializer.NewHTTPError(http.StatusNotFound, "no experiment found")
 		}
 
-		progress, err := experimentProgress(assignmentsRepo, experiment.ID, userID)
+		pairIDs, err := filePairsRepo.GetIDs(r.Context(), experimentID)
 		if err != nil {
 			return nil, err
 		}
 
-		return serializer.NewExperimentResponse(experiment, progress), nil
+		answers, err := assignmentsRepo.GetAnswersByPair(r.Context(), experimentID)
+		if err != nil {
+			return nil, err
+		}
+
+		items := make([][]string, 0, len(answers))
+		for _, pairAnswers := range answers {
+			if len(pairAnswers) < 2 {
+				continue
+			}
+
+			ratings := make([]string, 0, len(pairAnswers))
+			for _, answer := range pairAnswers {
+				ratings = append(ratings, answer)
+			}
+
+			items = append(items, ratings)
+		}
+
+		return serializer.NewFleissKappaResponse(
+			service.FleissKappa(items), len(items), len(pairIDs)-len(items)), nil
 	}
 }
 
-// GetExperiments returns a function that returns a *serializer.Response
-// with the list of existing experiments
-func GetExperiments(repo *repository.Experiments, assignmentsRepo *repository.Assignments) RequestProcessFunc {
+// GetPairConsensus returns a function that returns a *serializer.Response
+// with, for every FilePair of the experiment with any answer, how many
+// annotators gave each answer and the majority answer. The skipped answers are
+// not counted
+func GetPairConsensus(repo *repository.Experiments, assignmentsRepo *repository.Assignments) RequestProcessFunc {
 	return func(r *http.Request) (*serializer.Response, error) {
-		userID, err := service.GetUserID(r.Context())
+		experimentID, err := urlParamInt(r, "experimentId")
 		if err != nil {
 			return nil, err
 		}
 
-		experiments, err := repo.GetAll()
+		experiment, err := repo.GetByID(r.Context(), experimentID, false)
 		if err != nil {
 			return nil, err
 		}
 
-		var progresses []float32
-		for _, e := range experiments {
-			progress, err := experimentProgress(assignmentsRepo, e.ID, userID)
-			if err != nil {
-				return nil, err
-			}
-			progresses = append(progresses, progress)
+		if experiment == nil {
+			return nil, serializer.NewHTTPError(http.StatusNotFound, "no experiment found")
+		}
+
+		consensus, err := assignmentsRepo.GetPairConsensus(r.Context(), experimentID)
+		if err != nil {
+			return nil, err
 		}
 
-		return serializer.NewExperimentsResponse(experiments, progresses), nil
+		return serializer.NewPairConsensusResponse(consensus), nil
 	}
 }
 
-func experimentProgress(repo *repository.Assignments, experimentID int, userID int) (float32, error) {
-	countAll, err := repo.CountUserAssignment(experimentID, userID)
-	if err != nil {
-		return 0, fmt.Errorf("Error count of assigments from the DB: %v", err)
-	}
+// includeDeleted returns true if the "includeDeleted" query parameter is true
+func includeDeleted(r *http.Request) bool {
+	return r.URL.Query().Get("includeDeleted") == "true"
+}
 
-	countComplete, err := repo.CountCompleteUserAssignment(experimentID, userID)
+// experimentProgress returns the progress of the user in the experiment. Both
+// counts are read with a single query; to get the progress in several
+// experiments use experimentsProgress, that also makes a single query
+func experimentProgress(ctx context.Context, repo *repository.Assignments, experimentID int, userID int) (float32, error) {
+	total, complete, err := repo.CountUserAssignments(ctx, experimentID, userID)
 	if err != nil {
-		return 0, fmt.Errorf("Error count of complete assigments from the DB: %v", err)
-	}
-
-	if countAll == 0 {
-		return 0, nil
+		return 0, fmt.Errorf("Error count of assigments from the DB: %v", err)
 	}
 
-	return 100.0 * float32(countComplete) / float32(countAll), nil
+	p := model.UserProgress{UserID: userID, Completed: complete, Total: total}
+	return p.Progress(), nil
 }
 
 type createExperimentReq struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
+	Name             string `json:"name"`
+	Description      string `json:"description"`
+	OutlierThreshold *int   `json:"outlierThreshold"`
+	// AssignmentStrategy is "sequential" or "random"; sequential if empty
+	AssignmentStrategy string `json:"assignmentStrategy"`
+	// Answers are the accepted answers; model.DefaultAnswers if empty
+	Answers []string `json:"answers"`
 }
 
 // CreateExperiment returns a function that saves the experiment as passed in the body request
@@ -100,7 +890,7 @@ func CreateExperiment(repo *repository.Experiments) RequestProcessFunc {
 		var createExperimentReq createExperimentReq
 		body, err := ioutil.ReadAll(r.Body)
 		if err != nil {
-			return nil, serializer.NewHTTPError(http.StatusBadRequest, err.Error())
+			return nil, bodyError(err)
 		}
 
 		err = json.Unmarshal(body, &createExperimentReq)
@@ -108,12 +898,38 @@ func CreateExperiment(repo *repository.Experiments) RequestProcessFunc {
 			return nil, serializer.NewHTTPError(http.StatusBadRequest, err.Error())
 		}
 
+		name, err := experimentName(createExperimentReq.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := validateOutlierThreshold(createExperimentReq.OutlierThreshold); err != nil {
+			return nil, err
+		}
+
+		strategy, err := assignmentStrategy(createExperimentReq.AssignmentStrategy)
+		if err != nil {
+			return nil, err
+		}
+
+		answers, err := answerScheme(createExperimentReq.Answers)
+		if err != nil {
+			return nil, err
+		}
+
 		experiment := &model.Experiment{
-			Name:        createExperimentReq.Name,
-			Description: createExperimentReq.Description,
+			Name:             name,
+			Description:      strings.TrimSpace(createExperimentReq.Description),
+			OutlierThreshold: createExperimentReq.OutlierThreshold,
+
+			AssignmentStrategy: strategy,
+			Answers:            answers,
+		}
+		if strategy == model.AssignmentRandom {
+			experiment.AssignmentSeed = time.Now().UnixNano()
 		}
 
-		err = repo.Create(experiment)
+		err = repo.Create(r.Context(), experiment)
 		if err != nil {
 			return nil, err
 		}
@@ -122,12 +938,98 @@ func CreateExperiment(repo *repository.Experiments) RequestProcessFunc {
 	}
 }
 
+// experimentName returns the given experiment name without leading and
+// trailing whitespace. It returns a serializer.HTTPError if the name is empty
+func experimentName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", serializer.NewHTTPError(http.StatusBadRequest, "experiment name can not be empty")
+	}
+
+	return name, nil
+}
+
+// assignmentStrategy returns the model.AssignmentStrategy with the given name,
+// or model.AssignmentSequential if it is empty. It returns a
+// serializer.HTTPError if the name is unknown
+func assignmentStrategy(name string) (model.AssignmentStrategy, error) {
+	if name == "" {
+		return model.AssignmentSequential, nil
+	}
+
+	strategy := model.AssignmentStrategy(name)
+	if !strategy.IsValid() {
+		return "", serializer.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("unknown assignment strategy %q", name))
+	}
+
+	return strategy, nil
+}
+
+// maxAnswers is the max number of answers of an experiment answer scheme
+const maxAnswers = 20
+
+// answerScheme returns the given answers without leading and trailing
+// whitespace, or nil if there are none. It returns a serializer.HTTPError if
+// any of them is empty or repeated, or if there are more than maxAnswers
+func answerScheme(answers []string) ([]string, error) {
+	if len(answers) == 0 {
+		return nil, nil
+	}
+
+	if len(answers) > maxAnswers {
+		return nil, serializer.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("an experiment can not have more than %d answers", maxAnswers))
+	}
+
+	result := make([]string, len(answers))
+	seen := make(map[string]bool, len(answers))
+	for i, answer := range answers {
+		answer = strings.TrimSpace(answer)
+		if answer == "" {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest, "answers can not be empty")
+		}
+
+		if seen[answer] {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("repeated answer %q", answer))
+		}
+
+		seen[answer] = true
+		result[i] = answer
+	}
+
+	return result, nil
+}
+
+// validateOutlierThreshold returns a serializer.HTTPError if the given
+// outlier threshold is negative
+func validateOutlierThreshold(threshold *int) error {
+	if threshold != nil && *threshold < 0 {
+		return serializer.NewHTTPError(http.StatusBadRequest, "outlier threshold can not be negative")
+	}
+
+	return nil
+}
+
+// updateExperimentReq holds the fields to update; the absent ones are nil.
+// Version is required, it is the version of the experiment the client last saw
 type updateExperimentReq struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
+	Version     *int    `json:"version"`
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	// OutlierThreshold is kept raw to tell an absent threshold from a null
+	// one, that resets the experiment to the global default
+	OutlierThreshold json.RawMessage `json:"outlierThreshold"`
+	// Deadline is an RFC3339 string, kept raw for the same reason: null
+	// removes the deadline of the experiment
+	Deadline json.RawMessage `json:"deadline"`
 }
 
-// UpdateExperiment returns a function that updates the experiment as passed in the body request
+// UpdateExperiment returns a function that updates the experiment with the
+// fields passed in the body request. The fields that are not passed keep their
+// current value. It returns the updated experiment, or a 409 Conflict error if
+// the experiment was updated after the version passed in the body request
 func UpdateExperiment(repo *repository.Experiments, assignmentsRepo *repository.Assignments) RequestProcessFunc {
 	return func(r *http.Request) (*serializer.Response, error) {
 		userID, err := service.GetUserID(r.Context())
@@ -140,7 +1042,7 @@ func UpdateExperiment(repo *repository.Experiments, assignmentsRepo *repository.
 			return nil, err
 		}
 
-		experiment, err := repo.GetByID(experimentID)
+		experiment, err := repo.GetByID(r.Context(), experimentID, false)
 		if err != nil {
 			return nil, err
 		}
@@ -151,7 +1053,7 @@ func UpdateExperiment(repo *repository.Experiments, assignmentsRepo *repository.
 		var updateExperimentReq updateExperimentReq
 		body, err := ioutil.ReadAll(r.Body)
 		if err != nil {
-			return nil, serializer.NewHTTPError(http.StatusBadRequest, err.Error())
+			return nil, bodyError(err)
 		}
 
 		err = json.Unmarshal(body, &updateExperimentReq)
@@ -159,15 +1061,274 @@ func UpdateExperiment(repo *repository.Experiments, assignmentsRepo *repository.
 			return nil, serializer.NewHTTPError(http.StatusBadRequest, err.Error())
 		}
 
-		experiment.Name = updateExperimentReq.Name
-		experiment.Description = updateExperimentReq.Description
+		if updateExperimentReq.Version == nil {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest, "version is required")
+		}
+
+		if updateExperimentReq.Name != nil {
+			name, err := experimentName(*updateExperimentReq.Name)
+			if err != nil {
+				return nil, err
+			}
+
+			experiment.Name = name
+		}
+
+		if updateExperimentReq.Description != nil {
+			experiment.Description = strings.TrimSpace(*updateExperimentReq.Description)
+		}
+
+		if len(updateExperimentReq.OutlierThreshold) > 0 {
+			var threshold *int
+			if err := json.Unmarshal(updateExperimentReq.OutlierThreshold, &threshold); err != nil {
+				return nil, serializer.NewHTTPError(http.StatusBadRequest, err.Error())
+			}
+
+			if err := validateOutlierThreshold(threshold); err != nil {
+				return nil, err
+			}
+
+			experiment.OutlierThreshold = threshold
+		}
+
+		if len(updateExperimentReq.Deadline) > 0 {
+			var deadline *time.Time
+			if err := json.Unmarshal(updateExperimentReq.Deadline, &deadline); err != nil {
+				return nil, serializer.NewHTTPError(http.StatusBadRequest,
+					"the deadline must be an RFC3339 time or null")
+			}
+
+			experiment.Deadline = deadline
+		}
+
+		experiment.Version = *updateExperimentReq.Version
+		updated, err := repo.Update(r.Context(), experiment)
+		if err != nil {
+			return nil, err
+		}
+
+		if !updated {
+			return nil, serializer.NewHTTPError(http.StatusConflict,
+				"the experiment was modified by someone else, reload it and try again")
+		}
+
+		progress, err := experimentProgress(r.Context(), assignmentsRepo, experiment.ID, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		return serializer.NewExperimentResponse(experiment, progress), nil
+	}
+}
+
+// maxTagLength is the max number of characters of an experiment tag
+const maxTagLength = 50
+
+// normalizeTags returns the given tags trimmed, in lowercase and without
+// duplicates. It returns a serializer.HTTPError if any of them is empty or
+// too long
+func normalizeTags(tags []string) ([]string, error) {
+	var result []string
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest, "tags can not be empty")
+		}
+
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("tags can not be longer than %d characters", maxTagLength))
+		}
+
+		if !seen[tag] {
+			seen[tag] = true
+			result = append(result, tag)
+		}
+	}
+
+	return result, nil
+}
+
+type experimentTagsReq struct {
+	Tags []string `json:"tags"`
+}
+
+// AddExperimentTags returns a function that adds the tags passed in the body
+// request to the requested experiment, and returns the updated experiment.
+// The tags are stored in lowercase
+func AddExperimentTags(repo *repository.Experiments, assignmentsRepo *repository.Assignments) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
+		var req experimentTagsReq
+		body, err := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+		if err == nil {
+			err = json.Unmarshal(body, &req)
+		}
+
+		if err != nil {
+			return nil, bodyError(err)
+		}
+
+		tags, err := normalizeTags(req.Tags)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(tags) == 0 {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest, "no tags to add")
+		}
+
+		experiment, err := repo.GetByID(r.Context(), experimentID, false)
+		if err != nil {
+			return nil, err
+		}
+
+		if experiment == nil {
+			return nil, serializer.NewHTTPError(http.StatusNotFound, "no experiment found")
+		}
+
+		if err := repo.AddTags(r.Context(), experimentID, tags); err != nil {
+			return nil, err
+		}
+
+		return taggedExperimentResponse(r, repo, assignmentsRepo, experimentID)
+	}
+}
+
+// RemoveExperimentTag returns a function that removes the requested tag from
+// the requested experiment, and returns the updated experiment
+func RemoveExperimentTag(repo *repository.Experiments, assignmentsRepo *repository.Assignments) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
+		experiment, err := repo.GetByID(r.Context(), experimentID, false)
+		if err != nil {
+			return nil, err
+		}
+
+		if experiment == nil {
+			return nil, serializer.NewHTTPError(http.StatusNotFound, "no experiment found")
+		}
+
+		tag := strings.ToLower(strings.TrimSpace(chi.URLParam(r, "tag")))
+		removed, err := repo.RemoveTag(r.Context(), experimentID, tag)
+		if err != nil {
+			return nil, err
+		}
+
+		if !removed {
+			return nil, serializer.NewHTTPError(http.StatusNotFound, "the experiment does not have the tag")
+		}
+
+		return taggedExperimentResponse(r, repo, assignmentsRepo, experimentID)
+	}
+}
+
+// taggedExperimentResponse returns the response of AddExperimentTags and
+// RemoveExperimentTag, with the experiment as it is after the change
+func taggedExperimentResponse(
+	r *http.Request,
+	repo *repository.Experiments,
+	assignmentsRepo *repository.Assignments,
+	experimentID int,
+) (*serializer.Response, error) {
+	userID, err := service.GetUserID(r.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	experiment, err := repo.GetByID(r.Context(), experimentID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	progress, err := experimentProgress(r.Context(), assignmentsRepo, experiment.ID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return serializer.NewExperimentResponse(experiment, progress), nil
+}
+
+// DeleteExperiment returns a function that soft-deletes the requested experiment.
+// Its file pairs and assignments are kept in the DB
+func DeleteExperiment(repo *repository.Experiments) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
+		deleted, err := repo.SoftDelete(r.Context(), experimentID)
+		if err != nil {
+			return nil, err
+		}
+
+		if !deleted {
+			return nil, serializer.NewHTTPError(http.StatusNotFound, "no experiment found")
+		}
+
+		experiment, err := repo.GetByID(r.Context(), experimentID, true)
+		if err != nil {
+			return nil, err
+		}
+
+		return serializer.NewExperimentResponse(experiment, 0), nil
+	}
+}
+
+// FreezeExperiment returns a function that makes the requested experiment
+// read-only, so its assignments can not be answered anymore
+func FreezeExperiment(repo *repository.Experiments, assignmentsRepo *repository.Assignments) RequestProcessFunc {
+	return setExperimentStatus(repo, assignmentsRepo, model.ExperimentFrozen)
+}
+
+// UnfreezeExperiment returns a function that makes the requested experiment
+// active again, so its assignments can be answered
+func UnfreezeExperiment(repo *repository.Experiments, assignmentsRepo *repository.Assignments) RequestProcessFunc {
+	return setExperimentStatus(repo, assignmentsRepo, model.ExperimentActive)
+}
+
+func setExperimentStatus(
+	repo *repository.Experiments,
+	assignmentsRepo *repository.Assignments,
+	status model.ExperimentStatus,
+) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		userID, err := service.GetUserID(r.Context())
+		if err != nil {
+			return nil, err
+		}
+
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
+		updated, err := repo.SetStatus(r.Context(), experimentID, status)
+		if err != nil {
+			return nil, err
+		}
+
+		if !updated {
+			return nil, serializer.NewHTTPError(http.StatusNotFound, "no experiment found")
+		}
 
-		err = repo.Update(experiment)
+		experiment, err := repo.GetByID(r.Context(), experimentID, false)
 		if err != nil {
 			return nil, err
 		}
 
-		progress, err := experimentProgress(assignmentsRepo, experiment.ID, userID)
+		progress, err := experimentProgress(r.Context(), assignmentsRepo, experiment.ID, userID)
 		if err != nil {
 			return nil, err
 		}
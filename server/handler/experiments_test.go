@@ -1,14 +1,19 @@
 package handler_test
 
 import (
+	"context"
+	"encoding/json"
 	"net/http"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/src-d/code-annotation/server/handler"
 	"github.com/src-d/code-annotation/server/model"
 	"github.com/src-d/code-annotation/server/repository"
 	"github.com/src-d/code-annotation/server/serializer"
+	"github.com/src-d/code-annotation/server/service"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -24,11 +29,106 @@ func TestCreateExperiment(t *testing.T) {
 	res, err := handler(req)
 	assert.Nil(err)
 
-	assert.Equal(serializer.NewExperimentResponse(&model.Experiment{
-		ID:          2,
-		Name:        "new",
-		Description: "test",
-	}, 0), res)
+	assert.Equal(withoutTimestamps(serializer.NewExperimentResponse(&model.Experiment{
+		ID:                 2,
+		Name:               "new",
+		Description:        "test",
+		Status:             model.ExperimentActive,
+		AssignmentStrategy: model.AssignmentSequential,
+	}, 0)), withoutTimestamps(res))
+
+	json = `{"name": "  trimmed\t", "description": " test "}`
+	req, _ = http.NewRequest("POST", "/experiments", strings.NewReader(json))
+	res, err = handler(req)
+	assert.Nil(err)
+
+	assert.Equal(withoutTimestamps(serializer.NewExperimentResponse(&model.Experiment{
+		ID:                 3,
+		Name:               "trimmed",
+		Description:        "test",
+		Status:             model.ExperimentActive,
+		AssignmentStrategy: model.AssignmentSequential,
+	}, 0)), withoutTimestamps(res))
+
+	json = `{"name": "shuffled", "assignmentStrategy": "random"}`
+	req, _ = http.NewRequest("POST", "/experiments", strings.NewReader(json))
+	res, err = handler(req)
+	assert.Nil(err)
+
+	shuffled, err := repo.GetByID(context.Background(), 4, false)
+	assert.Nil(err)
+	assert.Equal(model.AssignmentRandom, shuffled.AssignmentStrategy)
+	assert.NotZero(shuffled.AssignmentSeed)
+	assert.Equal(serializer.NewExperimentResponse(shuffled, 0), res)
+
+	for _, json := range []string{`{"name": ""}`, `{"name": "  "}`, `{"description": "test"}`} {
+		req, _ = http.NewRequest("POST", "/experiments", strings.NewReader(json))
+		res, err = handler(req)
+		assert.Nil(res)
+		assert.Equal(serializer.NewHTTPError(http.StatusBadRequest, "experiment name can not be empty"), err)
+	}
+
+	json = `{"name": "bad", "assignmentStrategy": "alphabetical"}`
+	req, _ = http.NewRequest("POST", "/experiments", strings.NewReader(json))
+	res, err = handler(req)
+	assert.Nil(res)
+	assert.Equal(serializer.NewHTTPError(http.StatusBadRequest,
+		`unknown assignment strategy "alphabetical"`), err)
+}
+
+func TestCreateExperimentAnswers(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDB()
+	handler := handler.CreateExperiment(repository.NewExperiments(db.DB))
+
+	json := `{"name": "likert", "answers": [" 1", "2", "3", "4", "5 "]}`
+	req, _ := http.NewRequest("POST", "/experiments", strings.NewReader(json))
+	res, err := handler(req)
+	assert.Nil(err)
+
+	created := withoutTimestamps(res)["data"].(map[string]interface{})
+	assert.Equal([]interface{}{"1", "2", "3", "4", "5"}, created["answers"])
+
+	json = `{"name": "yes-no"}`
+	req, _ = http.NewRequest("POST", "/experiments", strings.NewReader(json))
+	res, err = handler(req)
+	assert.Nil(err)
+
+	created = withoutTimestamps(res)["data"].(map[string]interface{})
+	assert.Equal([]interface{}{"yes", "maybe", "no", "skip"}, created["answers"])
+
+	for _, json := range []string{
+		`{"name": "empty", "answers": ["1", " "]}`,
+		`{"name": "repeated", "answers": ["1", "2", "1"]}`,
+	} {
+		req, _ = http.NewRequest("POST", "/experiments", strings.NewReader(json))
+		res, err = handler(req)
+		assert.Nil(res)
+		assert.Equal(http.StatusBadRequest, err.(serializer.HTTPError).StatusCode())
+	}
+}
+
+func TestImportAnnotationsCustomAnswers(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t, &model.User{Login: "alice", Role: model.Worker})
+	_, err := db.Exec(`UPDATE experiments SET answers=$1 WHERE id=1`, `["good", "bad"]`)
+	assert.Nil(err)
+
+	h := handler.ImportAnnotations(repository.NewExperiments(db.DB), repository.NewUsers(db.DB),
+		repository.NewFilePairs(db.DB), repository.NewAssignments(db.DB), time.Minute, 10)
+
+	body := `[
+		{"userId": 1, "pairId": 1, "answer": "good", "duration": 10},
+		{"userId": 1, "pairId": 2, "answer": "yes", "duration": 10}
+	]`
+	req, _ := http.NewRequest("POST", "/experiments/1/annotations", strings.NewReader(body))
+	res, err := h(chiRequest(req, map[string]string{"experimentId": "1"}))
+	assert.Nil(err)
+	assert.Equal(serializer.NewFilePairsUploadResponse(1, 1,
+		serializer.UploadFailure{Row: 2, Reason: `Wrong answer provided: "yes"`},
+	), res)
 }
 
 func TestUpdateExperiment(t *testing.T) {
@@ -39,18 +139,41 @@ func TestUpdateExperiment(t *testing.T) {
 	assignmentsRepo := repository.NewAssignments(db.DB)
 	handler := handler.UpdateExperiment(repo, assignmentsRepo)
 
-	json := `{"name": "new", "description": "test"}`
+	json := `{"version": 0, "name": "new", "description": "test"}`
 	req, _ := http.NewRequest("PUT", "/experiments/1", strings.NewReader(json))
 	req = chiRequest(req, map[string]string{"experimentId": "1"})
 	req = reqWithUser(req, 1)
 	res, err := handler(req)
 	assert.Nil(err)
 
-	assert.Equal(serializer.NewExperimentResponse(&model.Experiment{
-		ID:          1,
-		Name:        "new",
-		Description: "test",
-	}, 0), res)
+	assert.Equal(withoutTimestamps(serializer.NewExperimentResponse(&model.Experiment{
+		ID:                 1,
+		Name:               "new",
+		Description:        "test",
+		Status:             model.ExperimentActive,
+		AssignmentStrategy: model.AssignmentSequential,
+		Version:            1,
+	}, 0)), withoutTimestamps(res))
+
+	req, _ = http.NewRequest("PUT", "/experiments/1", strings.NewReader(`{"version": 1, "name": " "}`))
+	req = chiRequest(req, map[string]string{"experimentId": "1"})
+	req = reqWithUser(req, 1)
+	res, err = handler(req)
+	assert.Nil(res)
+	assert.Equal(serializer.NewHTTPError(http.StatusBadRequest, "experiment name can not be empty"), err)
+
+	// the version 0 was already updated by the first request
+	req, _ = http.NewRequest("PUT", "/experiments/1", strings.NewReader(`{"version": 0, "name": "stale"}`))
+	req = chiRequest(req, map[string]string{"experimentId": "1"})
+	req = reqWithUser(req, 1)
+	res, err = handler(req)
+	assert.Nil(res)
+	assert.Equal(http.StatusConflict, err.(serializer.HTTPError).StatusCode())
+
+	stored, err := repo.GetByID(context.Background(), 1, false)
+	assert.Nil(err)
+	assert.Equal("new", stored.Name)
+	assert.Equal(1, stored.Version)
 
 	req, _ = http.NewRequest("PUT", "/experiments/2", strings.NewReader(json))
 	req = chiRequest(req, map[string]string{"experimentId": "2"})
@@ -59,3 +182,921 @@ func TestUpdateExperiment(t *testing.T) {
 	assert.Nil(res)
 	assert.Equal(serializer.NewHTTPError(http.StatusNotFound, "no experiment found"), err)
 }
+
+func TestUpdateExperimentPartial(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDB()
+	repo := repository.NewExperiments(db.DB)
+	handler := handler.UpdateExperiment(repo, repository.NewAssignments(db.DB))
+
+	update := func(body string) (*serializer.Response, error) {
+		req, _ := http.NewRequest("PATCH", "/experiments/1", strings.NewReader(body))
+		req = chiRequest(req, map[string]string{"experimentId": "1"})
+		return handler(reqWithUser(req, 1))
+	}
+
+	threshold := 5000
+	expected := &model.Experiment{
+		ID:                 1,
+		Name:               "default",
+		Description:        "only the description",
+		Status:             model.ExperimentActive,
+		AssignmentStrategy: model.AssignmentSequential,
+		Version:            1,
+	}
+
+	res, err := update(`{"version": 0, "description": "only the description"}`)
+	assert.Nil(err)
+	assert.Equal(withoutTimestamps(serializer.NewExperimentResponse(expected, 0)), withoutTimestamps(res))
+
+	res, err = update(`{"version": 1, "outlierThreshold": 5000}`)
+	assert.Nil(err)
+	expected.OutlierThreshold = &threshold
+	expected.Version = 2
+	assert.Equal(withoutTimestamps(serializer.NewExperimentResponse(expected, 0)), withoutTimestamps(res))
+
+	// an empty description is a change, a missing threshold is not
+	res, err = update(`{"version": 2, "name": "renamed", "description": ""}`)
+	assert.Nil(err)
+	expected.Name = "renamed"
+	expected.Description = ""
+	expected.Version = 3
+	assert.Equal(withoutTimestamps(serializer.NewExperimentResponse(expected, 0)), withoutTimestamps(res))
+
+	// a null threshold resets it to the global default
+	res, err = update(`{"version": 3, "outlierThreshold": null}`)
+	assert.Nil(err)
+	expected.OutlierThreshold = nil
+	expected.Version = 4
+	assert.Equal(withoutTimestamps(serializer.NewExperimentResponse(expected, 0)), withoutTimestamps(res))
+
+	stored, err := repo.GetByID(context.Background(), 1, false)
+	assert.Nil(err)
+	assert.Equal("renamed", stored.Name)
+	assert.Nil(stored.OutlierThreshold)
+
+	for _, body := range []string{`{"version": 4, "outlierThreshold": -1}`, `{"version": 4, "outlierThreshold": "1m"}`, `{"version": 4, "description": 3}`, `{"name": "no version"}`} {
+		res, err = update(body)
+		assert.Nil(res)
+		assert.Equal(http.StatusBadRequest, err.(serializer.HTTPError).StatusCode(), body)
+	}
+}
+
+func TestExperimentTimestamps(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDB()
+	repo := repository.NewExperiments(db.DB)
+	handler := handler.GetExperimentDetails(repository.NewUsers(db.DB), repo, repository.NewAssignments(db.DB))
+
+	experiment := &model.Experiment{Name: "timestamps"}
+	assert.Nil(repo.Create(context.Background(), experiment))
+	created := *experiment.CreatedAt
+	assert.Equal(created, *experiment.UpdatedAt)
+
+	updated, err := repo.Update(context.Background(), experiment)
+	assert.Nil(err)
+	assert.True(updated)
+
+	req, _ := http.NewRequest("GET", "/experiments/2", nil)
+	req = chiRequest(req, map[string]string{"experimentId": "2"})
+	res, err := handler(reqWithUser(req, 1))
+	assert.Nil(err)
+
+	encoded, err := json.Marshal(res.Data)
+	assert.Nil(err)
+
+	var data struct{ CreatedAt, UpdatedAt string }
+	assert.Nil(json.Unmarshal(encoded, &data))
+	assert.Equal(created.Format(time.RFC3339), data.CreatedAt)
+	assert.Equal(experiment.UpdatedAt.Format(time.RFC3339), data.UpdatedAt)
+	assert.False(experiment.UpdatedAt.Before(created))
+}
+
+func TestGetExperimentsProgress(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t,
+		&model.User{Login: "alice", Role: model.Worker},
+		&model.User{Login: "bob", Role: model.Worker},
+	)
+	assignmentsRepo := repository.NewAssignments(db.DB)
+	progress := handler.GetExperimentsProgress(assignmentsRepo)
+
+	assert.Nil(repository.NewExperiments(db.DB).Create(context.Background(), &model.Experiment{Name: "second"}))
+	assert.Nil(assignmentsRepo.Update(context.Background(), 1, "yes", 10))
+
+	data := func(req *http.Request, userID int) interface{} {
+		res, err := progress(reqWithUser(req, userID))
+		assert.Nil(err)
+		return withoutTimestamps(res)["data"]
+	}
+
+	req, _ := http.NewRequest("GET", "/experiments/progress?ids=1,2,99", nil)
+	assert.Equal(map[string]interface{}{"1": 50.0, "2": 0.0, "99": 0.0}, data(req, 1))
+
+	req, _ = http.NewRequest("POST", "/experiments/progress", strings.NewReader(`{"ids": [1]}`))
+	assert.Equal(map[string]interface{}{"1": 0.0}, data(req, 2))
+
+	req, _ = http.NewRequest("GET", "/experiments/progress?ids=1,x", nil)
+	_, err := progress(reqWithUser(req, 1))
+	assert.Equal(http.StatusBadRequest, err.(serializer.HTTPError).StatusCode())
+}
+
+func TestGetExperimentsPaginated(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDB()
+	repo := repository.NewExperiments(db.DB)
+	assignmentsRepo := repository.NewAssignments(db.DB)
+	handler := handler.GetExperiments(repo, assignmentsRepo)
+
+	for _, name := range []string{"second", "third"} {
+		assert.Nil(repo.Create(context.Background(), &model.Experiment{Name: name}))
+	}
+
+	req, _ := http.NewRequest("GET", "/experiments?limit=1&offset=1", nil)
+	req = reqWithUser(req, 1)
+	res, err := handler(req)
+	assert.Nil(err)
+
+	assert.Equal(withoutTimestamps(serializer.NewExperimentsResponse([]*model.Experiment{
+		{ID: 2, Name: "second", Status: model.ExperimentActive,
+			AssignmentStrategy: model.AssignmentSequential},
+	}, []float32{0}, 3)), withoutTimestamps(res))
+
+	req, _ = http.NewRequest("GET", "/experiments?limit=0", nil)
+	req = reqWithUser(req, 1)
+	res, err = handler(req)
+	assert.Nil(res)
+	assert.Error(err)
+}
+
+func TestGetMyExperiments(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t, &model.User{Login: "alice", Role: model.Worker})
+	repo := repository.NewExperiments(db.DB)
+	assignmentsRepo := repository.NewAssignments(db.DB)
+	handler := handler.GetMyExperiments(repo, assignmentsRepo)
+
+	assert.Nil(repo.Create(context.Background(), &model.Experiment{Name: "unassigned"}))
+	assert.Nil(repository.NewUsers(db.DB).Create(context.Background(), &model.User{Login: "bob", Role: model.Worker}))
+	assert.Nil(assignmentsRepo.Update(context.Background(), 1, "yes", 10))
+
+	req, _ := http.NewRequest("GET", "/me/experiments", nil)
+	res, err := handler(reqWithUser(req, 1))
+	assert.Nil(err)
+
+	experiment, err := repo.GetByID(context.Background(), 1, false)
+	assert.Nil(err)
+	assert.Equal(withoutTimestamps(serializer.NewExperimentsResponse(
+		[]*model.Experiment{experiment}, []float32{50}, 1)), withoutTimestamps(res))
+
+	req, _ = http.NewRequest("GET", "/me/experiments", nil)
+	res, err = handler(reqWithUser(req, 2))
+	assert.Nil(err)
+	assert.Equal(serializer.NewExperimentsResponse([]*model.Experiment{}, nil, 0), res)
+}
+
+func TestGetExperimentsSearch(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDB()
+	repo := repository.NewExperiments(db.DB)
+	assignmentsRepo := repository.NewAssignments(db.DB)
+	handler := handler.GetExperiments(repo, assignmentsRepo)
+
+	assert.Nil(repo.Create(context.Background(), &model.Experiment{Name: "Java pairs", Description: "first"}))
+	assert.Nil(repo.Create(context.Background(), &model.Experiment{Name: "Go pairs", Description: "uses JAVA style"}))
+	assert.Nil(repo.Create(context.Background(), &model.Experiment{Name: "100%", Description: "python"}))
+
+	req, _ := http.NewRequest("GET", "/experiments?q=java", nil)
+	req = reqWithUser(req, 1)
+	res, err := handler(req)
+	assert.Nil(err)
+
+	assert.Equal(withoutTimestamps(serializer.NewExperimentsResponse([]*model.Experiment{
+		{ID: 2, Name: "Java pairs", Description: "first", Status: model.ExperimentActive,
+			AssignmentStrategy: model.AssignmentSequential},
+		{ID: 3, Name: "Go pairs", Description: "uses JAVA style", Status: model.ExperimentActive,
+			AssignmentStrategy: model.AssignmentSequential},
+	}, []float32{0, 0}, 2)), withoutTimestamps(res))
+
+	// the total counts every match, not only the ones in the page
+	req, _ = http.NewRequest("GET", "/experiments?q=java&limit=1&offset=1", nil)
+	req = reqWithUser(req, 1)
+	res, err = handler(req)
+	assert.Nil(err)
+
+	assert.Equal(withoutTimestamps(serializer.NewExperimentsResponse([]*model.Experiment{
+		{ID: 3, Name: "Go pairs", Description: "uses JAVA style", Status: model.ExperimentActive,
+			AssignmentStrategy: model.AssignmentSequential},
+	}, []float32{0}, 2)), withoutTimestamps(res))
+
+	req, _ = http.NewRequest("GET", "/experiments?q=0%25", nil)
+	req = reqWithUser(req, 1)
+	res, err = handler(req)
+	assert.Nil(err)
+
+	assert.Equal(withoutTimestamps(serializer.NewExperimentsResponse([]*model.Experiment{
+		{ID: 4, Name: "100%", Description: "python", Status: model.ExperimentActive,
+			AssignmentStrategy: model.AssignmentSequential},
+	}, []float32{0}, 1)), withoutTimestamps(res))
+}
+
+func TestGetExperimentsSorted(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t, &model.User{Login: "alice", Role: model.Worker})
+	repo := repository.NewExperiments(db.DB)
+	assignmentsRepo := repository.NewAssignments(db.DB)
+	handler := handler.GetExperiments(repo, assignmentsRepo)
+
+	for _, name := range []string{"beta", "Alpha", "gamma"} {
+		assert.Nil(repo.Create(context.Background(), &model.Experiment{Name: name}))
+	}
+
+	// alice only made progress in the default experiment
+	assert.Nil(assignmentsRepo.Update(context.Background(), 1, "yes", 10))
+
+	listIDs := func(query string) ([]int, error) {
+		req, _ := http.NewRequest("GET", "/experiments?"+query, nil)
+		res, err := handler(reqWithUser(req, 1))
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := json.Marshal(res.Data)
+		assert.Nil(err)
+
+		var experiments []struct{ ID int }
+		assert.Nil(json.Unmarshal(data, &experiments))
+
+		ids := make([]int, len(experiments))
+		for i, e := range experiments {
+			ids[i] = e.ID
+		}
+
+		return ids, nil
+	}
+
+	for query, expected := range map[string][]int{
+		"":                                   {1, 2, 3, 4},
+		"sort=name":                          {3, 2, 1, 4},
+		"sort=name&order=desc":               {4, 1, 2, 3},
+		"sort=createdAt&order=desc":          {4, 3, 2, 1},
+		"sort=name&order=desc&q=a":           {4, 1, 2, 3},
+		"sort=progress":                      {2, 3, 4, 1},
+		"sort=progress&order=desc":           {1, 2, 3, 4},
+		"sort=progress&limit=2&offset=1":     {3, 4},
+		"sort=progress&order=desc&q=gamma":   {4},
+		"sort=createdAt&limit=2&offset=3":    {4},
+		"sort=progress&limit=2&offset=9":     {},
+		"sort=name&order=asc&limit=1":        {3},
+		"sort=createdAt&order=desc&offset=2": {2, 1},
+	} {
+		ids, err := listIDs(query)
+		assert.Nil(err, query)
+		assert.Equal(expected, ids, query)
+	}
+
+	for _, query := range []string{"sort=id", "sort=progress&order=up"} {
+		_, err := listIDs(query)
+		assert.Equal(http.StatusBadRequest, err.(serializer.HTTPError).StatusCode(), query)
+	}
+}
+
+func TestExperimentTags(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDB()
+	repo := repository.NewExperiments(db.DB)
+	assignmentsRepo := repository.NewAssignments(db.DB)
+	addTags := handler.AddExperimentTags(repo, assignmentsRepo)
+	removeTag := handler.RemoveExperimentTag(repo, assignmentsRepo)
+	list := handler.GetExperiments(repo, assignmentsRepo)
+
+	assert.Nil(repo.Create(context.Background(), &model.Experiment{Name: "Java pairs"}))
+	assert.Nil(repo.Create(context.Background(), &model.Experiment{Name: "Go pairs"}))
+	assert.Nil(repo.Create(context.Background(), &model.Experiment{Name: "Python pairs"}))
+
+	add := func(id, body string) (*serializer.Response, error) {
+		req, _ := http.NewRequest("POST", "/experiments/"+id+"/tags", strings.NewReader(body))
+		req = chiRequest(req, map[string]string{"experimentId": id})
+		return addTags(reqWithUser(req, 1))
+	}
+
+	res, err := add("2", `{"tags": ["Pilot ", "java", "pilot"]}`)
+	assert.Nil(err)
+	assert.Equal(withoutTimestamps(serializer.NewExperimentResponse(&model.Experiment{
+		ID: 2, Name: "Java pairs", Status: model.ExperimentActive,
+		AssignmentStrategy: model.AssignmentSequential, Tags: []string{"java", "pilot"},
+	}, 0)), withoutTimestamps(res))
+
+	// the existing tags are skipped
+	_, err = add("2", `{"tags": ["java"]}`)
+	assert.Nil(err)
+	_, err = add("3", `{"tags": ["go", "pilot"]}`)
+	assert.Nil(err)
+	_, err = add("4", `{"tags": ["python"]}`)
+	assert.Nil(err)
+
+	for _, body := range []string{`{"tags": []}`, `{"tags": [" "]}`, `{"tags": "go"}`} {
+		res, err = add("2", body)
+		assert.Nil(res)
+		assert.Equal(http.StatusBadRequest, err.(serializer.HTTPError).StatusCode(), body)
+	}
+
+	res, err = add("9", `{"tags": ["go"]}`)
+	assert.Nil(res)
+	assert.Equal(http.StatusNotFound, err.(serializer.HTTPError).StatusCode())
+
+	req, _ := http.NewRequest("GET", "/experiments?tag=python&tag=GO", nil)
+	res, err = list(reqWithUser(req, 1))
+	assert.Nil(err)
+	assert.Equal(withoutTimestamps(serializer.NewExperimentsResponse([]*model.Experiment{
+		{ID: 3, Name: "Go pairs", Status: model.ExperimentActive,
+			AssignmentStrategy: model.AssignmentSequential, Tags: []string{"go", "pilot"}},
+		{ID: 4, Name: "Python pairs", Status: model.ExperimentActive,
+			AssignmentStrategy: model.AssignmentSequential, Tags: []string{"python"}},
+	}, []float32{0, 0}, 2)), withoutTimestamps(res))
+
+	req, _ = http.NewRequest("GET", "/experiments?tag=pilot&q=java", nil)
+	res, err = list(reqWithUser(req, 1))
+	assert.Nil(err)
+	assert.Equal(withoutTimestamps(serializer.NewExperimentsResponse([]*model.Experiment{
+		{ID: 2, Name: "Java pairs", Status: model.ExperimentActive,
+			AssignmentStrategy: model.AssignmentSequential, Tags: []string{"java", "pilot"}},
+	}, []float32{0}, 1)), withoutTimestamps(res))
+
+	remove := func(id, tag string) (*serializer.Response, error) {
+		req, _ := http.NewRequest("DELETE", "/experiments/"+id+"/tags/"+tag, nil)
+		req = chiRequest(req, map[string]string{"experimentId": id, "tag": tag})
+		return removeTag(reqWithUser(req, 1))
+	}
+
+	res, err = remove("2", "Pilot")
+	assert.Nil(err)
+	assert.Equal(withoutTimestamps(serializer.NewExperimentResponse(&model.Experiment{
+		ID: 2, Name: "Java pairs", Status: model.ExperimentActive,
+		AssignmentStrategy: model.AssignmentSequential, Tags: []string{"java"},
+	}, 0)), withoutTimestamps(res))
+
+	res, err = remove("2", "pilot")
+	assert.Nil(res)
+	assert.Equal(http.StatusNotFound, err.(serializer.HTTPError).StatusCode())
+}
+
+func TestDeleteExperiment(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDB()
+	repo := repository.NewExperiments(db.DB)
+	assignmentsRepo := repository.NewAssignments(db.DB)
+	deleteHandler := handler.DeleteExperiment(repo)
+	listHandler := handler.GetExperiments(repo, assignmentsRepo)
+
+	req, _ := http.NewRequest("DELETE", "/experiments/1", nil)
+	req = chiRequest(req, map[string]string{"experimentId": "1"})
+	res, err := deleteHandler(req)
+	assert.Nil(err)
+
+	experiment, err := repo.GetByID(context.Background(), 1, true)
+	assert.Nil(err)
+	assert.True(experiment.IsDeleted())
+	assert.Equal(serializer.NewExperimentResponse(experiment, 0), res)
+
+	res, err = deleteHandler(req)
+	assert.Nil(res)
+	assert.Equal(serializer.NewHTTPError(http.StatusNotFound, "no experiment found"), err)
+
+	req, _ = http.NewRequest("GET", "/experiments", nil)
+	req = reqWithUser(req, 1)
+	res, err = listHandler(req)
+	assert.Nil(err)
+	assert.Equal(0, *res.Total)
+
+	req, _ = http.NewRequest("GET", "/experiments?includeDeleted=true", nil)
+	req = reqWithUser(req, 1)
+	res, err = listHandler(req)
+	assert.Nil(err)
+	assert.Equal(1, *res.Total)
+}
+
+func TestGetExperimentUserProgress(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t,
+		&model.User{Login: "alice", Role: model.Worker},
+		&model.User{Login: "bob", Role: model.Worker},
+	)
+	repo := repository.NewExperiments(db.DB)
+	assignmentsRepo := repository.NewAssignments(db.DB)
+	handler := handler.GetExperimentUserProgress(repo, assignmentsRepo)
+
+	// assignments 3 and 4 belong to bob
+	assert.Nil(assignmentsRepo.Update(context.Background(), 3, "yes", 10))
+
+	req, _ := http.NewRequest("GET", "/experiments/1/progress", nil)
+	req = chiRequest(req, map[string]string{"experimentId": "1"})
+	res, err := handler(req)
+	assert.Nil(err)
+
+	assert.Equal(serializer.NewUsersProgressResponse([]*model.UserProgress{
+		{UserID: 2, Login: "bob", Completed: 1, Total: 2},
+		{UserID: 1, Login: "alice", Completed: 0, Total: 2},
+	}), res)
+}
+
+func TestGetExperimentStats(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t,
+		&model.User{Login: "alice", Role: model.Worker},
+		&model.User{Login: "bob", Role: model.Worker},
+	)
+	filePairsRepo := repository.NewFilePairs(db.DB)
+	assignmentsRepo := repository.NewAssignments(db.DB)
+	handler := handler.GetExperimentStats(
+		repository.NewExperiments(db.DB), filePairsRepo, assignmentsRepo)
+
+	// the answers without confidence are not part of its mean
+	confidence := 4
+	assert.Nil(assignmentsRepo.UpdateAnswer(context.Background(), 1, "yes", 10, &confidence, nil, false, nil))
+	assert.Nil(assignmentsRepo.Update(context.Background(), 2, "no", 30))
+
+	pairs, err := filePairsRepo.GetAll(context.Background(), 1)
+	assert.Nil(err)
+
+	var loc int
+	var score float64
+	for _, fp := range pairs {
+		loc += strings.Count(fp.Left.Content, "\n") + strings.Count(fp.Right.Content, "\n") + 2
+		score += fp.Score
+	}
+
+	req, _ := http.NewRequest("GET", "/experiments/1/stats", nil)
+	req = chiRequest(req, map[string]string{"experimentId": "1"})
+	res, err := handler(req)
+	assert.Nil(err)
+
+	meanConfidence := 4.0
+
+	assert.Equal(serializer.NewExpStatsResponse(serializer.ExpStatsResponse{
+		MedianDuration: 20,
+		MeanDuration:   20,
+		Durations:      2,
+		FilePairs:      2,
+		TotalLOC:       loc,
+		MeanScore:      score / 2,
+		Annotators:     1,
+		MeanConfidence: &meanConfidence,
+	}), res)
+
+	req = chiRequest(req, map[string]string{"experimentId": "5"})
+	res, err = handler(req)
+	assert.Nil(res)
+	assert.Equal(http.StatusNotFound, err.(serializer.HTTPError).StatusCode())
+}
+
+func TestGetExperimentAnnotationTimeline(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t,
+		&model.User{Login: "alice", Role: model.Worker},
+		&model.User{Login: "bob", Role: model.Worker},
+	)
+	repo := repository.NewExperiments(db.DB)
+	assignmentsRepo := repository.NewAssignments(db.DB)
+	handler := handler.GetExperimentAnnotationTimeline(repo, assignmentsRepo)
+
+	day := time.Date(2018, 1, 2, 0, 0, 0, 0, time.UTC)
+	answers := []struct {
+		id     int
+		answer string
+		time   time.Time
+	}{
+		{1, "yes", day.Add(10 * time.Minute)},
+		{2, "no", day.Add(50 * time.Minute)},
+		{3, "yes", day.Add(3 * time.Hour)},
+		{4, "skip", day.Add(26 * time.Hour)},
+	}
+	for _, a := range answers {
+		assert.Nil(assignmentsRepo.Update(context.Background(), a.id, a.answer, 10))
+		_, err := db.DB.Exec("UPDATE assignments SET updated_at=$1 WHERE id=$2", a.time, a.id)
+		assert.Nil(err)
+	}
+
+	timeline := func(granularity string) (*serializer.Response, error) {
+		req, _ := http.NewRequest("GET", "/experiments/1/timeline?granularity="+granularity, nil)
+		return handler(chiRequest(req, map[string]string{"experimentId": "1"}))
+	}
+
+	// every answer of the experiment scheme is included in the buckets
+	counts := func(yes, maybe, no, skip int) map[string]int {
+		return map[string]int{"yes": yes, "maybe": maybe, "no": no, "skip": skip}
+	}
+
+	res, err := timeline("")
+	assert.Nil(err)
+	assert.Equal(serializer.NewTimelineResponse([]*serializer.TimelineBucketResponse{
+		{Start: day, ExpAnnotationResponse: serializer.ExpAnnotationResponse{
+			Yes: 2, No: 1, Total: 3, Answers: counts(2, 0, 1, 0)}},
+		{Start: day.Add(24 * time.Hour), ExpAnnotationResponse: serializer.ExpAnnotationResponse{
+			Skip: 1, Total: 1, Answers: counts(0, 0, 0, 1)}},
+	}), res)
+
+	res, err = timeline("hour")
+	assert.Nil(err)
+	assert.Equal(serializer.NewTimelineResponse([]*serializer.TimelineBucketResponse{
+		{Start: day, ExpAnnotationResponse: serializer.ExpAnnotationResponse{
+			Yes: 1, No: 1, Total: 2, Answers: counts(1, 0, 1, 0)}},
+		{Start: day.Add(3 * time.Hour), ExpAnnotationResponse: serializer.ExpAnnotationResponse{
+			Yes: 1, Total: 1, Answers: counts(1, 0, 0, 0)}},
+		{Start: day.Add(26 * time.Hour), ExpAnnotationResponse: serializer.ExpAnnotationResponse{
+			Skip: 1, Total: 1, Answers: counts(0, 0, 0, 1)}},
+	}), res)
+
+	_, err = timeline("week")
+	assert.Equal(serializer.NewHTTPError(http.StatusBadRequest, `unknown granularity "week"`), err)
+}
+
+func TestGetInterAnnotatorAgreement(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t,
+		&model.User{Login: "alice", Role: model.Worker},
+		&model.User{Login: "bob", Role: model.Worker},
+		&model.User{Login: "carol", Role: model.Worker},
+	)
+	repo := repository.NewExperiments(db.DB)
+	assignmentsRepo := repository.NewAssignments(db.DB)
+	handler := handler.GetInterAnnotatorAgreement(repo, assignmentsRepo)
+
+	// assignments of alice: 1, 2; bob: 3, 4; carol: 5, 6
+	for id, answer := range map[int]string{1: "yes", 2: "no", 3: "yes", 4: "no", 5: "no"} {
+		assert.Nil(assignmentsRepo.Update(context.Background(), id, answer, 10))
+	}
+
+	req, _ := http.NewRequest("GET", "/experiments/1/agreement", nil)
+	res, err := handler(chiRequest(req, map[string]string{"experimentId": "1"}))
+	assert.Nil(err)
+
+	assert.Equal(serializer.NewAgreementResponse([]serializer.UsersAgreementResponse{
+		{UserA: 1, UserB: 2, Pairs: 2, Kappa: 1},
+		{UserA: 1, UserB: 3, Pairs: 1, Kappa: 0},
+		{UserA: 2, UserB: 3, Pairs: 1, Kappa: 0},
+	}, 1.0/3), res)
+}
+
+func TestGetFleissKappa(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t,
+		&model.User{Login: "alice", Role: model.Worker},
+		&model.User{Login: "bob", Role: model.Worker},
+		&model.User{Login: "carol", Role: model.Worker},
+	)
+	repo := repository.NewExperiments(db.DB)
+	assignmentsRepo := repository.NewAssignments(db.DB)
+	handler := handler.GetFleissKappa(repo, repository.NewFilePairs(db.DB), assignmentsRepo)
+
+	// pair 1 is answered by everyone, pair 2 only by alice
+	for id, answer := range map[int]string{1: "yes", 2: "no", 3: "yes", 5: "yes"} {
+		assert.Nil(assignmentsRepo.Update(context.Background(), id, answer, 10))
+	}
+
+	req, _ := http.NewRequest("GET", "/experiments/1/agreement/fleiss", nil)
+	res, err := handler(chiRequest(req, map[string]string{"experimentId": "1"}))
+	assert.Nil(err)
+
+	assert.Equal(serializer.NewFleissKappaResponse(1, 1, 1), res)
+}
+
+func TestGetPairConsensus(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t,
+		&model.User{Login: "alice", Role: model.Worker},
+		&model.User{Login: "bob", Role: model.Worker},
+		&model.User{Login: "carol", Role: model.Worker},
+		&model.User{Login: "dave", Role: model.Worker},
+	)
+	repo := repository.NewExperiments(db.DB)
+	assignmentsRepo := repository.NewAssignments(db.DB)
+	handler := handler.GetPairConsensus(repo, assignmentsRepo)
+
+	// pair 1 has a majority, the skip is not a vote; pair 2 is a tie
+	for id, answer := range map[int]string{1: "yes", 3: "yes", 5: "no", 7: "skip", 2: "yes", 4: "no"} {
+		assert.Nil(assignmentsRepo.Update(context.Background(), id, answer, 10))
+	}
+
+	req, _ := http.NewRequest("GET", "/experiments/1/consensus", nil)
+	res, err := handler(chiRequest(req, map[string]string{"experimentId": "1"}))
+	assert.Nil(err)
+
+	content, err := json.Marshal(res)
+	assert.Nil(err)
+	assert.JSONEq(`{"status": 200, "data": [
+		{"pairId": 1, "answers": {"yes": 2, "no": 1}, "votes": 3, "majority": "yes", "tie": false},
+		{"pairId": 2, "answers": {"yes": 1, "no": 1}, "votes": 2, "majority": null, "tie": true}
+	]}`, string(content))
+
+	req, _ = http.NewRequest("GET", "/experiments/2/consensus", nil)
+	_, err = handler(chiRequest(req, map[string]string{"experimentId": "2"}))
+	assert.Equal(serializer.NewHTTPError(http.StatusNotFound, "no experiment found"), err)
+}
+
+func TestDuplicateExperiment(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t, &model.User{Login: "alice", Role: model.Worker})
+	repo := repository.NewExperiments(db.DB)
+	filePairsRepo := repository.NewFilePairs(db.DB)
+	handler := handler.DuplicateExperiment(repo)
+
+	assert.Nil(repo.AddTags(context.Background(), 1, []string{"pilot"}))
+	gold := "yes"
+	ok, err := filePairsRepo.SetGoldAnswer(context.Background(), 1, 2, &gold)
+	assert.Nil(err)
+	assert.True(ok)
+
+	req, _ := http.NewRequest("POST", "/experiments/1/duplicate", nil)
+	req = chiRequest(req, map[string]string{"experimentId": "1"})
+	res, err := handler(req)
+	assert.Nil(err)
+
+	assert.Equal(withoutTimestamps(serializer.NewExperimentResponse(&model.Experiment{
+		ID:                 2,
+		Name:               "default (copy)",
+		Description:        "Default experiment",
+		Status:             model.ExperimentActive,
+		AssignmentStrategy: model.AssignmentSequential,
+		Tags:               []string{"pilot"},
+	}, 0)), withoutTimestamps(res))
+
+	original, err := filePairsRepo.GetAll(context.Background(), 1)
+	assert.Nil(err)
+	copied, err := filePairsRepo.GetAll(context.Background(), 2)
+	assert.Nil(err)
+	if assert.Len(copied, len(original)) {
+		assert.Equal(original[0].Left.Path, copied[0].Left.Path)
+		assert.Equal(2, copied[0].ExperimentID)
+	}
+
+	// the gold answers are copied too
+	var golds int
+	assert.Nil(db.QueryRow(`SELECT COUNT(*) FROM file_pairs
+		WHERE experiment_id=2 AND path_a=$1 AND gold_answer='yes'`, original[1].Left.Path).Scan(&golds))
+	assert.Equal(1, golds)
+
+	count, err := repository.NewAssignments(db.DB).CountUserAssignment(context.Background(), 2, 1)
+	assert.Nil(err)
+	assert.Equal(0, count)
+
+	res, err = handler(req)
+	assert.Nil(err)
+	assert.Equal(withoutTimestamps(serializer.NewExperimentResponse(&model.Experiment{
+		ID:                 3,
+		Name:               "default (copy 2)",
+		Description:        "Default experiment",
+		Status:             model.ExperimentActive,
+		AssignmentStrategy: model.AssignmentSequential,
+		Tags:               []string{"pilot"},
+	}, 0)), withoutTimestamps(res))
+}
+
+func TestFreezeExperiment(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t, &model.User{Login: "alice", Role: model.Worker})
+	repo := repository.NewExperiments(db.DB)
+	assignmentsRepo := repository.NewAssignments(db.DB)
+	freeze := handler.FreezeExperiment(repo, assignmentsRepo)
+	unfreeze := handler.UnfreezeExperiment(repo, assignmentsRepo)
+	answer := handler.SaveAssignment(repo, assignmentsRepo, time.Minute, time.Hour, 1000, nil, nil)
+
+	req, _ := http.NewRequest("POST", "/experiments/1/freeze", nil)
+	req = reqWithUser(chiRequest(req, map[string]string{"experimentId": "1"}), 1)
+	res, err := freeze(req)
+	assert.Nil(err)
+	assert.Equal(withoutTimestamps(serializer.NewExperimentResponse(&model.Experiment{
+		ID:                 1,
+		Name:               "default",
+		Description:        "Default experiment",
+		Status:             model.ExperimentFrozen,
+		AssignmentStrategy: model.AssignmentSequential,
+	}, 0)), withoutTimestamps(res))
+
+	res, err = answer(answerRequest("1", 1, `{"answer": "yes", "duration": 10}`))
+	assert.Nil(res)
+	assert.Equal(http.StatusConflict, err.(serializer.HTTPError).StatusCode())
+
+	_, err = unfreeze(req)
+	assert.Nil(err)
+
+	_, err = answer(answerRequest("1", 1, `{"answer": "yes", "duration": 10}`))
+	assert.Nil(err)
+
+	req, _ = http.NewRequest("POST", "/experiments/2/freeze", nil)
+	req = reqWithUser(chiRequest(req, map[string]string{"experimentId": "2"}), 1)
+	_, err = freeze(req)
+	assert.Equal(serializer.NewHTTPError(http.StatusNotFound, "no experiment found"), err)
+}
+
+func TestPurgeExperimentBlobs(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t, &model.User{Login: "alice", Role: model.Worker})
+	repo := repository.NewExperiments(db.DB)
+	filePairsRepo := repository.NewFilePairs(db.DB)
+	purge := handler.PurgeExperimentBlobs(repo, filePairsRepo)
+	stats := handler.GetExperimentStats(repo, filePairsRepo, repository.NewAssignments(db.DB))
+
+	purgeRequest := func(query string) (*serializer.Response, error) {
+		req, _ := http.NewRequest("POST", "/experiments/1/purge-blobs"+query, nil)
+		return purge(chiRequest(req, map[string]string{"experimentId": "1"}))
+	}
+
+	statsRequest, _ := http.NewRequest("GET", "/experiments/1/stats", nil)
+	statsRequest = chiRequest(statsRequest, map[string]string{"experimentId": "1"})
+	before, err := stats(statsRequest)
+	assert.Nil(err)
+
+	_, err = purgeRequest("")
+	assert.Equal(http.StatusBadRequest, err.(serializer.HTTPError).StatusCode())
+
+	// only the frozen experiments can be purged
+	_, err = purgeRequest("?confirm=true")
+	assert.Equal(http.StatusConflict, err.(serializer.HTTPError).StatusCode())
+
+	_, err = repo.SetStatus(context.Background(), 1, model.ExperimentFrozen)
+	assert.Nil(err)
+
+	res, err := purgeRequest("?confirm=true")
+	assert.Nil(err)
+	assert.Equal(serializer.NewCountResponse(2), res)
+
+	res, err = purgeRequest("?confirm=true")
+	assert.Nil(err)
+	assert.Equal(serializer.NewCountResponse(0), res)
+
+	// the stats are kept
+	after, err := stats(statsRequest)
+	assert.Nil(err)
+	assert.Equal(before, after)
+
+	details := handler.GetFilePairDetails(filePairsRepo, service.NewDiff(0), nil)
+	req, _ := http.NewRequest("GET", "/file-pairs/1", nil)
+	_, err = details(chiRequest(req, map[string]string{"experimentId": "1", "pairId": "1"}))
+	assert.Equal(http.StatusGone, err.(serializer.HTTPError).StatusCode())
+
+	blobID := "3a6e3a6e3a6e3a6e3a6e3a6e3a6e3a6e3a6e3a6e"
+	req, _ = http.NewRequest("GET", "/blobs/"+blobID, nil)
+	_, err = handler.GetBlob(filePairsRepo)(chiRequest(req, map[string]string{"blobId": blobID}))
+	assert.Equal(http.StatusGone, err.(serializer.HTTPError).StatusCode())
+}
+
+func TestReseedExperiment(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t,
+		&model.User{Login: "alice", Role: model.Worker},
+		&model.User{Login: "bob", Role: model.Requester},
+	)
+	repo := repository.NewExperiments(db.DB)
+	assignmentsRepo := repository.NewAssignments(db.DB)
+	reseed := handler.ReseedExperiment(repo)
+	details := handler.GetExperimentDetails(repository.NewUsers(db.DB), repo, assignmentsRepo)
+
+	reseedRequest := func(body string) (*serializer.Response, error) {
+		req, _ := http.NewRequest("POST", "/experiments/1/reseed", strings.NewReader(body))
+		return reseed(reqWithUser(chiRequest(req, map[string]string{"experimentId": "1"}), 2))
+	}
+
+	detailsRequest := func(userID int) *serializer.Response {
+		req, _ := http.NewRequest("GET", "/experiments/1", nil)
+		res, err := details(reqWithUser(chiRequest(req, map[string]string{"experimentId": "1"}), userID))
+		assert.Nil(err)
+		return res
+	}
+
+	seed := func(res *serializer.Response) interface{} {
+		return withoutTimestamps(res)["data"].(map[string]interface{})["assignmentSeed"]
+	}
+
+	pairs := func(userID int) ([]int, map[int]bool) {
+		as, err := assignmentsRepo.GetByUserAndExperiment(context.Background(), userID, 1)
+		assert.Nil(err)
+
+		var order []int
+		set := make(map[int]bool)
+		for _, a := range as {
+			if !a.Answer.Valid {
+				order = append(order, a.PairID)
+			}
+			set[a.PairID] = true
+		}
+		return order, set
+	}
+
+	// only the experiments with the random strategy can be reseeded
+	_, err := reseedRequest(`{"seed": "42"}`)
+	assert.Equal(http.StatusConflict, err.(serializer.HTTPError).StatusCode())
+
+	_, err = db.DB.Exec(`UPDATE experiments SET assignment_strategy='random', assignment_seed=1 WHERE id=1`)
+	assert.Nil(err)
+
+	for _, body := range []string{`{"seed": "x"}`, `{"seed": 42}`} {
+		_, err = reseedRequest(body)
+		assert.Equal(http.StatusBadRequest, err.(serializer.HTTPError).StatusCode(), body)
+	}
+
+	assert.Nil(assignmentsRepo.Update(context.Background(), 1, "yes", 10))
+
+	aliceOrder, alicePairs := pairs(1)
+	bobOrder, bobPairs := pairs(2)
+
+	res, err := reseedRequest(`{"seed": "9007199254740993"}`)
+	assert.Nil(err)
+	assert.Equal(map[string]interface{}{"count": float64(len(aliceOrder) + len(bobOrder))},
+		withoutTimestamps(res)["data"])
+	assert.Equal("9007199254740993", seed(detailsRequest(2)))
+
+	// every user keeps the same pairs, and the answered assignment is not changed
+	newAliceOrder, newAlicePairs := pairs(1)
+	newBobOrder, newBobPairs := pairs(2)
+	assert.Equal(alicePairs, newAlicePairs)
+	assert.Equal(bobPairs, newBobPairs)
+	exp := &model.Experiment{AssignmentStrategy: model.AssignmentRandom, AssignmentSeed: 9007199254740993}
+	sort.Ints(aliceOrder)
+	sort.Ints(bobOrder)
+	assert.Equal(exp.OrderPairs(1, aliceOrder), newAliceOrder)
+	assert.Equal(exp.OrderPairs(2, bobOrder), newBobOrder)
+
+	answered, err := assignmentsRepo.GetByID(context.Background(), 1)
+	assert.Nil(err)
+	assert.Equal("yes", answered.AnswerStr())
+
+	// a new seed is generated without body
+	_, err = reseedRequest("")
+	assert.Nil(err)
+	assert.NotEqual("9007199254740993", seed(detailsRequest(2)))
+
+	// the seed is only shown to the requesters
+	for userID, shown := range map[int]bool{1: false, 2: true} {
+		assert.Equal(shown, seed(detailsRequest(userID)) != nil)
+	}
+}
+
+func TestExperimentDeadline(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t, &model.User{Login: "alice", Role: model.Worker})
+	repo := repository.NewExperiments(db.DB)
+	assignmentsRepo := repository.NewAssignments(db.DB)
+	update := handler.UpdateExperiment(repo, assignmentsRepo)
+	answer := handler.SaveAssignment(repo, assignmentsRepo, time.Minute, time.Hour, 1000, nil, nil)
+
+	updateRequest := func(body string) *http.Request {
+		req, _ := http.NewRequest("PATCH", "/experiments/1", strings.NewReader(body))
+		return reqWithUser(chiRequest(req, map[string]string{"experimentId": "1"}), 1)
+	}
+
+	res, err := update(updateRequest(`{"version": 0, "deadline": "2000-01-02T03:04:05Z"}`))
+	assert.Nil(err)
+	data := withoutTimestamps(res)["data"].(map[string]interface{})
+	assert.Equal("2000-01-02T03:04:05Z", data["deadline"])
+	assert.Equal(true, data["closed"])
+
+	res, err = answer(answerRequest("1", 1, `{"answer": "yes", "duration": 10}`))
+	assert.Nil(res)
+	assert.Equal(serializer.NewHTTPError(http.StatusForbidden,
+		"the deadline of the experiment has passed, its assignments can not be answered"), err)
+
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	res, err = update(updateRequest(`{"version": 1, "deadline": "` + future + `"}`))
+	assert.Nil(err)
+	data = withoutTimestamps(res)["data"].(map[string]interface{})
+	assert.Equal(future, data["deadline"])
+	assert.Equal(false, data["closed"])
+
+	_, err = answer(answerRequest("1", 1, `{"answer": "yes", "duration": 10}`))
+	assert.Nil(err)
+
+	// the deadline is kept when it is not passed, and removed with null
+	res, err = update(updateRequest(`{"version": 2, "name": "renamed"}`))
+	assert.Nil(err)
+	assert.Equal(future, withoutTimestamps(res)["data"].(map[string]interface{})["deadline"])
+
+	res, err = update(updateRequest(`{"version": 3, "deadline": null}`))
+	assert.Nil(err)
+	assert.Nil(withoutTimestamps(res)["data"].(map[string]interface{})["deadline"])
+
+	res, err = update(updateRequest(`{"version": 4, "deadline": "tomorrow"}`))
+	assert.Nil(res)
+	assert.Equal(serializer.NewHTTPError(http.StatusBadRequest,
+		"the deadline must be an RFC3339 time or null"), err)
+}
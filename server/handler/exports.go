@@ -1,17 +1,29 @@
 package handler
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"regexp"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/pressly/lg"
 	"github.com/src-d/code-annotation/server/dbutil"
+	"github.com/src-d/code-annotation/server/model"
+	"github.com/src-d/code-annotation/server/repository"
 	"github.com/src-d/code-annotation/server/serializer"
 
 	"github.com/go-chi/chi"
@@ -55,7 +67,8 @@ func (h *Export) List(r *http.Request) (*serializer.Response, error) {
 }
 
 // Create creates new export file and returns a *serializer.Response
-// with the name of new file
+// with the name of new file. If the "anonymize" query parameter is true, the
+// login, name and avatar of the users are replaced with pseudonyms
 func (h *Export) Create(r *http.Request) (*serializer.Response, error) {
 	filepath := fmt.Sprintf("%s/%s-export.db",
 		h.exportsPath, time.Now().Format(time.RFC3339))
@@ -78,6 +91,12 @@ func (h *Export) Create(r *http.Request) (*serializer.Response, error) {
 		return nil, err
 	}
 
+	if anonymize(r) {
+		if err := dbutil.AnonymizeUsers(destDB); err != nil {
+			return nil, err
+		}
+	}
+
 	lg.RequestLog(r).Info("new SQLite file created: " + filepath)
 
 	return &serializer.Response{
@@ -109,3 +128,327 @@ func (h *Export) Download(w http.ResponseWriter, r *http.Request) {
 
 	io.Copy(w, file)
 }
+
+// exportFlushInterval is the number of exported rows written between two
+// flushes of the response
+const exportFlushInterval = 100
+
+// ExportExperimentAnnotationsJSONL returns a http.HandlerFunc that streams the
+// annotations of the requested experiment as newline-delimited JSON, with one
+// object per assignment. If the "anonymize" query parameter is true, the user
+// ID is removed and the login is replaced with a pseudonym, see
+// experimentPseudonyms
+func ExportExperimentAnnotationsJSONL(
+	experimentsRepo *repository.Experiments,
+	assignmentsRepo *repository.Assignments,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			write(w, r, nil, err)
+			return
+		}
+
+		experiment, err := experimentsRepo.GetByID(r.Context(), experimentID, false)
+		if err != nil {
+			write(w, r, nil, err)
+			return
+		}
+
+		if experiment == nil {
+			write(w, r, nil, serializer.NewHTTPError(http.StatusNotFound, "no experiment found"))
+			return
+		}
+
+		var pseudonyms map[int]string
+		if anonymize(r) {
+			pseudonyms, err = experimentPseudonyms(r.Context(), experimentsRepo, assignmentsRepo, experimentID)
+			if err != nil {
+				write(w, r, nil, err)
+				return
+			}
+		}
+
+		flusher, _ := w.(http.Flusher)
+		enc := json.NewEncoder(w)
+		written := 0
+
+		err = assignmentsRepo.ForEachAnnotation(r.Context(), experimentID, func(a *model.Annotation) error {
+			if written == 0 {
+				w.Header().Set("Content-Type", "application/x-ndjson")
+				w.Header().Set("Content-Disposition", fmt.Sprintf(
+					"attachment; filename=experiment-%d-annotations.jsonl", experimentID))
+			}
+
+			record := serializer.NewAnnotationRecord(a)
+			if pseudonyms != nil {
+				record.UserID = nil
+				record.Login = pseudonyms[a.UserID]
+			}
+
+			if err := enc.Encode(record); err != nil {
+				return err
+			}
+
+			written++
+			if flusher != nil && written%exportFlushInterval == 0 {
+				flusher.Flush()
+			}
+
+			return nil
+		})
+
+		if err != nil && written == 0 {
+			write(w, r, nil, err)
+			return
+		}
+
+		if err != nil {
+			// the response is already being sent, the error can only be logged
+			lg.RequestLog(r).Error(fmt.Sprintf("annotations export interrupted: %s", err))
+			return
+		}
+
+		if written == 0 {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+}
+
+var filePairsCSVHeader = []string{"id", "leftPath", "rightPath", "score", "leftLoc", "rightLoc"}
+
+// ExportFilePairsCSV returns a http.HandlerFunc that streams the file pairs
+// of the requested experiment as CSV, with their paths, score and lines of
+// code. The file is named after the experiment, see exportFilename
+func ExportFilePairsCSV(
+	experimentsRepo *repository.Experiments,
+	filePairsRepo *repository.FilePairs,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		experiment, err := exportedExperiment(r, experimentsRepo)
+		if err != nil {
+			write(w, r, nil, err)
+			return
+		}
+
+		writeCSVExport(w, r, exportFilename(experiment)+"-file-pairs.csv", filePairsCSVHeader,
+			func(row func([]string) error) error {
+				return filePairsRepo.ForEach(r.Context(), experiment.ID, func(fp *model.FilePair) error {
+					return row([]string{
+						strconv.Itoa(fp.ID),
+						fp.Left.Path,
+						fp.Right.Path,
+						strconv.FormatFloat(fp.Score, 'f', -1, 64),
+						strconv.Itoa(fp.Left.LOC()),
+						strconv.Itoa(fp.Right.LOC()),
+					})
+				})
+			})
+	}
+}
+
+var consensusCSVHeader = []string{"pairId", "leftPath", "rightPath", "score", "majorityAnswer", "voteCount"}
+
+// ExportConsensusCSV returns a http.HandlerFunc that streams, as CSV, the
+// file pairs of the requested experiment with their paths, score, majority
+// answer and number of votes, as returned by GetPairConsensus. The majority
+// answer is empty for ties. The file pairs with less votes than the
+// "minVotes" query parameter, 1 by default, are excluded. The file is named
+// after the experiment, see exportFilename
+func ExportConsensusCSV(
+	experimentsRepo *repository.Experiments,
+	filePairsRepo *repository.FilePairs,
+	assignmentsRepo *repository.Assignments,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		minVotes, err := urlQueryInt(r, "minVotes", 1)
+		if err != nil {
+			write(w, r, nil, err)
+			return
+		}
+
+		experiment, err := exportedExperiment(r, experimentsRepo)
+		if err != nil {
+			write(w, r, nil, err)
+			return
+		}
+
+		// only the answer counts are kept in memory, the file pairs are streamed
+		consensus, err := assignmentsRepo.GetPairConsensus(r.Context(), experiment.ID)
+		if err != nil {
+			write(w, r, nil, err)
+			return
+		}
+
+		byPair := make(map[int]*model.PairConsensus, len(consensus))
+		for _, c := range consensus {
+			byPair[c.PairID] = c
+		}
+
+		writeCSVExport(w, r, exportFilename(experiment)+"-consensus.csv", consensusCSVHeader,
+			func(row func([]string) error) error {
+				return filePairsRepo.ForEach(r.Context(), experiment.ID, func(fp *model.FilePair) error {
+					c, ok := byPair[fp.ID]
+					if !ok {
+						c = &model.PairConsensus{PairID: fp.ID}
+					}
+
+					if c.Votes < minVotes {
+						return nil
+					}
+
+					majority, _ := c.Majority()
+					return row([]string{
+						strconv.Itoa(fp.ID),
+						fp.Left.Path,
+						fp.Right.Path,
+						strconv.FormatFloat(fp.Score, 'f', -1, 64),
+						majority,
+						strconv.Itoa(c.Votes),
+					})
+				})
+			})
+	}
+}
+
+// exportedExperiment returns the experiment requested in the URL. It returns
+// a serializer.HTTPError if it does not exist
+func exportedExperiment(r *http.Request, repo *repository.Experiments) (*model.Experiment, error) {
+	experimentID, err := urlParamInt(r, "experimentId")
+	if err != nil {
+		return nil, err
+	}
+
+	experiment, err := repo.GetByID(r.Context(), experimentID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if experiment == nil {
+		return nil, serializer.NewHTTPError(http.StatusNotFound, "no experiment found")
+	}
+
+	return experiment, nil
+}
+
+// writeCSVExport streams as an attachment with the given filename the CSV
+// with the given header and the rows passed by forEach to its row function.
+// The headers are only sent with the first row, so an error before it is
+// still sent as a regular error response; after it, the errors can only be
+// logged
+func writeCSVExport(
+	w http.ResponseWriter,
+	r *http.Request,
+	filename string,
+	header []string,
+	forEach func(row func([]string) error) error,
+) {
+	flusher, _ := w.(http.Flusher)
+	cw := csv.NewWriter(w)
+	written := 0
+
+	start := func() error {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+
+		return cw.Write(header)
+	}
+
+	err := forEach(func(record []string) error {
+		if written == 0 {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+
+		written++
+		if written%exportFlushInterval == 0 {
+			cw.Flush()
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+
+		return cw.Error()
+	})
+
+	if err != nil && written == 0 {
+		write(w, r, nil, err)
+		return
+	}
+
+	if err != nil {
+		// the response is already being sent, the error can only be logged
+		lg.RequestLog(r).Error(fmt.Sprintf("%s export interrupted: %s", filename, err))
+		return
+	}
+
+	if written == 0 {
+		// only the header is sent when there are no rows
+		start()
+	}
+
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		lg.RequestLog(r).Error(fmt.Sprintf("%s export interrupted: %s", filename, err))
+	}
+}
+
+var unsafeFilenameChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// exportFilename returns the name of the experiment with only letters, digits,
+// '-' and '_', so it can be used as a file name in any file system. If nothing
+// is left, the experiment ID is used instead
+func exportFilename(experiment *model.Experiment) string {
+	name := strings.Trim(unsafeFilenameChars.ReplaceAllString(experiment.Name, "-"), "-")
+	if name == "" {
+		return fmt.Sprintf("experiment-%d", experiment.ID)
+	}
+
+	return name
+}
+
+func anonymize(r *http.Request) bool {
+	return r.URL.Query().Get("anonymize") == "true"
+}
+
+// experimentPseudonyms returns, for each user with assignments in the
+// experiment, the pseudonym used instead of the user ID and login. They are
+// built with a keyed hash of the user ID, using a random key stored for the
+// experiment, so a user keeps the same pseudonym in every export of the
+// experiment, and the real user can not be found from it without the key
+func experimentPseudonyms(
+	ctx context.Context,
+	experimentsRepo *repository.Experiments,
+	assignmentsRepo *repository.Assignments,
+	experimentID int,
+) (map[int]string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("can't generate pseudonym key: %s", err)
+	}
+
+	key, err := experimentsRepo.PseudonymKey(ctx, experimentID, hex.EncodeToString(b))
+	if err != nil {
+		return nil, err
+	}
+
+	progresses, err := assignmentsRepo.GetUsersProgress(ctx, experimentID)
+	if err != nil {
+		return nil, err
+	}
+
+	pseudonyms := make(map[int]string, len(progresses))
+	for _, p := range progresses {
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write([]byte(strconv.Itoa(p.UserID)))
+		pseudonyms[p.UserID] = "annotator-" + hex.EncodeToString(mac.Sum(nil))[:12]
+	}
+
+	return pseudonyms, nil
+}
@@ -0,0 +1,219 @@
+package handler_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/src-d/code-annotation/server/handler"
+	"github.com/src-d/code-annotation/server/model"
+	"github.com/src-d/code-annotation/server/repository"
+	"github.com/src-d/code-annotation/server/serializer"
+	"github.com/src-d/code-annotation/server/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportExperimentAnnotationsJSONL(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t, &model.User{Login: "alice", Role: model.Worker})
+	assignmentsRepo := repository.NewAssignments(db.DB)
+	handler := handler.ExportExperimentAnnotationsJSONL(
+		repository.NewExperiments(db.DB), assignmentsRepo)
+
+	comment := `looks "similar", but not the same`
+	assert.Nil(assignmentsRepo.UpdateAnswer(context.Background(), 1, "yes", 10, nil, &comment, false, nil))
+
+	req, _ := http.NewRequest("GET", "/experiments/1/exports/annotations.jsonl", nil)
+	req = chiRequest(req, map[string]string{"experimentId": "1"})
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("application/x-ndjson", w.Header().Get("Content-Type"))
+
+	var records []serializer.AnnotationRecord
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		var record serializer.AnnotationRecord
+		assert.Nil(json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+
+	if assert.Len(records, 2) {
+		assert.Equal("alice", records[0].Login)
+		assert.Equal("project/src/a", records[0].LeftPath)
+		assert.Equal("yes", *records[0].Answer)
+		assert.Equal(10, records[0].Duration)
+		assert.Equal(comment, *records[0].Comment)
+		assert.Nil(records[1].Answer)
+		assert.Nil(records[1].Comment)
+	}
+
+	req, _ = http.NewRequest("GET", "/experiments/2/exports/annotations.jsonl", nil)
+	req = chiRequest(req, map[string]string{"experimentId": "2"})
+	w = httptest.NewRecorder()
+	handler(w, req)
+
+	assert.Equal(http.StatusNotFound, w.Code)
+}
+
+func TestExportExperimentAnnotationsJSONLCanceled(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t, &model.User{Login: "alice", Role: model.Worker})
+	export := handler.ExportExperimentAnnotationsJSONL(
+		repository.NewExperiments(db.DB), repository.NewAssignments(db.DB))
+
+	logger, err := service.NewLogger("production", service.LoggerConfig{})
+	assert.Nil(err)
+	logger.Out = ioutil.Discard
+
+	// the client went away before the export started
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	req, _ := http.NewRequest("GET", "/experiments/1/exports/annotations.jsonl", nil)
+	req = chiRequest(req.WithContext(ctx), map[string]string{"experimentId": "1"})
+	w := httptest.NewRecorder()
+	handler.RequestLogger(logger)(export).ServeHTTP(w, req)
+
+	assert.Equal(http.StatusInternalServerError, w.Code)
+	assert.NotContains(w.Body.String(), "project/src/a")
+}
+
+func TestExportExperimentAnnotationsJSONLAnonymized(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t,
+		&model.User{Login: "alice", Role: model.Worker},
+		&model.User{Login: "bob", Role: model.Worker},
+		&model.User{Login: "carol", Role: model.Worker},
+	)
+	assignmentsRepo := repository.NewAssignments(db.DB)
+	handler := handler.ExportExperimentAnnotationsJSONL(
+		repository.NewExperiments(db.DB), assignmentsRepo)
+
+	export := func() []string {
+		req, _ := http.NewRequest("GET", "/experiments/1/exports/annotations.jsonl?anonymize=true", nil)
+		req = chiRequest(req, map[string]string{"experimentId": "1"})
+		w := httptest.NewRecorder()
+		handler(w, req)
+
+		assert.Equal(http.StatusOK, w.Code)
+
+		var users []string
+		scanner := bufio.NewScanner(w.Body)
+		for scanner.Scan() {
+			var record serializer.AnnotationRecord
+			assert.Nil(json.Unmarshal(scanner.Bytes(), &record))
+			assert.Nil(record.UserID)
+			assert.Regexp(`^annotator-[0-9a-f]{12}$`, record.Login)
+			users = append(users, record.Login)
+		}
+
+		return users
+	}
+
+	users := export()
+	if assert.Len(users, 6) {
+		assert.Equal(users[0], users[1])
+		assert.NotEqual(users[1], users[2])
+		assert.NotEqual(users[3], users[4])
+	}
+
+	// the pseudonyms are kept when users are removed from the experiment
+	assert.Nil(assignmentsRepo.Delete(context.Background(), 1))
+	assert.Nil(assignmentsRepo.Delete(context.Background(), 2))
+	assert.Equal(users[2:], export())
+}
+
+func TestExportFilePairsCSV(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t)
+	handler := handler.ExportFilePairsCSV(
+		repository.NewExperiments(db.DB), repository.NewFilePairs(db.DB))
+
+	_, err := db.Exec(`UPDATE experiments SET name = 'Clones / v2: "java"?' WHERE id = 1`)
+	assert.Nil(err)
+
+	req, _ := http.NewRequest("GET", "/experiments/1/exports/file-pairs.csv", nil)
+	req = chiRequest(req, map[string]string{"experimentId": "1"})
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("text/csv", w.Header().Get("Content-Type"))
+	assert.Equal("attachment; filename=Clones-v2-java-file-pairs.csv",
+		w.Header().Get("Content-Disposition"))
+
+	records, err := csv.NewReader(w.Body).ReadAll()
+	assert.Nil(err)
+	if assert.Len(records, 3) {
+		assert.Equal([]string{"id", "leftPath", "rightPath", "score", "leftLoc", "rightLoc"}, records[0])
+		assert.Equal([]string{"1", "project/src/a", "other_project/src/b", "0.9512810301340767", "1", "2"}, records[1])
+		assert.Equal("2", records[2][0])
+	}
+
+	req, _ = http.NewRequest("GET", "/experiments/2/exports/file-pairs.csv", nil)
+	req = chiRequest(req, map[string]string{"experimentId": "2"})
+	w = httptest.NewRecorder()
+	handler(w, req)
+
+	assert.Equal(http.StatusNotFound, w.Code)
+}
+
+func TestExportConsensusCSV(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t,
+		&model.User{Login: "alice", Role: model.Worker},
+		&model.User{Login: "bob", Role: model.Worker},
+	)
+	assignmentsRepo := repository.NewAssignments(db.DB)
+	handler := handler.ExportConsensusCSV(repository.NewExperiments(db.DB),
+		repository.NewFilePairs(db.DB), assignmentsRepo)
+
+	// pair 1 has a majority, pair 2 only one vote
+	for id, answer := range map[int]string{1: "yes", 3: "yes", 2: "no", 4: "skip"} {
+		assert.Nil(assignmentsRepo.Update(context.Background(), id, answer, 10))
+	}
+
+	export := func(query string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/experiments/1/exports/consensus.csv"+query, nil)
+		req = chiRequest(req, map[string]string{"experimentId": "1"})
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	w := export("")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("attachment; filename=default-consensus.csv", w.Header().Get("Content-Disposition"))
+
+	records, err := csv.NewReader(w.Body).ReadAll()
+	assert.Nil(err)
+	assert.Equal([][]string{
+		{"pairId", "leftPath", "rightPath", "score", "majorityAnswer", "voteCount"},
+		{"1", "project/src/a", "other_project/src/b", "0.9512810301340767", "yes", "2"},
+		{"2", records[2][1], records[2][2], records[2][3], "no", "1"},
+	}, records)
+
+	records, err = csv.NewReader(export("?minVotes=2").Body).ReadAll()
+	assert.Nil(err)
+	assert.Len(records, 2)
+	assert.Equal("1", records[1][0])
+
+	// only the header is sent when no pair has enough votes
+	records, err = csv.NewReader(export("?minVotes=3").Body).ReadAll()
+	assert.Nil(err)
+	assert.Len(records, 1)
+
+	assert.Equal(http.StatusBadRequest, export("?minVotes=many").Code)
+}
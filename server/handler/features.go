@@ -1,15 +1,23 @@
 package handler
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
 	"net/http"
 
 	"github.com/src-d/code-annotation/server/model"
 	"github.com/src-d/code-annotation/server/repository"
 	"github.com/src-d/code-annotation/server/serializer"
+	"github.com/src-d/code-annotation/server/service"
 )
 
 // GetFeatures returns a function that returns a *serializer.Response
-// with the list of features for blobId
+// with the list of features for blobId. If the "recompute" query parameter is
+// true, the response also includes the score calculated from the current
+// feature weights
 func GetFeatures(filePairRepo *repository.FilePairs, featuresRepo *repository.Features) RequestProcessFunc {
 	return func(r *http.Request) (*serializer.Response, error) {
 		filePairID, err := urlParamInt(r, "pairId")
@@ -17,32 +25,125 @@ func GetFeatures(filePairRepo *repository.FilePairs, featuresRepo *repository.Fe
 			return nil, err
 		}
 
-		filePair, err := filePairRepo.GetByID(filePairID)
+		filePair, err := filePairRepo.GetByID(r.Context(), filePairID)
 		if err != nil {
 			return nil, err
 		}
 
-		featuresA, featuresB, score, err := getFeatures(featuresRepo, filePair)
+		featuresA, featuresB, score, err := getFeatures(r.Context(), featuresRepo, filePair)
 		if err != nil {
 			return nil, err
 		}
 
-		return serializer.NewFeaturesResponse(featuresA, featuresB, score), nil
+		return featuresResponse(r, featuresA, featuresB, score), nil
 	}
 }
 
+// UpdateFeatureWeights returns a function that sets the weights of the
+// features of both blobs of the file pair to the ones passed in the body
+// request, as a map of feature name to weight, and returns a
+// *serializer.Response with the updated features. As in GetFeatures, the
+// "recompute" query parameter adds the score calculated from the new weights
+func UpdateFeatureWeights(filePairRepo *repository.FilePairs, featuresRepo *repository.Features) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		filePairID, err := urlParamInt(r, "pairId")
+		if err != nil {
+			return nil, err
+		}
+
+		var weights map[string]float64
+		body, err := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+		if err == nil {
+			err = json.Unmarshal(body, &weights)
+		}
+
+		if err != nil {
+			return nil, bodyError(err)
+		}
+
+		filePair, err := filePairRepo.GetByID(r.Context(), filePairID)
+		if err != nil {
+			return nil, err
+		}
+
+		if filePair == nil {
+			return nil, serializer.NewHTTPError(http.StatusNotFound, "no file-pair found")
+		}
+
+		featuresA, featuresB, _, err := getFeatures(r.Context(), featuresRepo, filePair)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := validateWeights(weights, append(featuresA, featuresB...)); err != nil {
+			return nil, err
+		}
+
+		blobIDs := []string{filePair.Left.BlobID, filePair.Right.BlobID}
+		if err := featuresRepo.UpdateWeights(r.Context(), blobIDs, weights); err != nil {
+			return nil, err
+		}
+
+		featuresA, featuresB, score, err := getFeatures(r.Context(), featuresRepo, filePair)
+		if err != nil {
+			return nil, err
+		}
+
+		return featuresResponse(r, featuresA, featuresB, score), nil
+	}
+}
+
+// featuresResponse returns a *serializer.Response with the given features and
+// score, and the recomputed score if the "recompute" query parameter is true
+func featuresResponse(r *http.Request, featuresA, featuresB []*model.Feature, score *model.Feature) *serializer.Response {
+	if r.URL.Query().Get("recompute") != "true" {
+		return serializer.NewFeaturesResponse(featuresA, featuresB, score)
+	}
+
+	recomputed := &model.Feature{Name: "score", Weight: service.FeaturesScore(featuresA, featuresB)}
+	return serializer.NewRecomputedFeaturesResponse(featuresA, featuresB, score, recomputed)
+}
+
+// validateWeights returns a serializer.HTTPError if any of the weights is not
+// a finite number, or does not belong to any of the given features
+func validateWeights(weights map[string]float64, features []*model.Feature) error {
+	if len(weights) == 0 {
+		return serializer.NewHTTPError(http.StatusBadRequest, "no feature weights to update")
+	}
+
+	known := make(map[string]bool, len(features))
+	for _, f := range features {
+		known[f.Name] = true
+	}
+
+	for name, weight := range weights {
+		if !known[name] {
+			return serializer.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("unknown feature %q", name))
+		}
+
+		if math.IsNaN(weight) || math.IsInf(weight, 0) {
+			return serializer.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("the weight of feature %q must be a finite number", name))
+		}
+	}
+
+	return nil
+}
+
 // TODO (dpordomingo): in the future it should take the UAST of both blobs DB
 // and make a request to ML feature extractor API
-func getFeatures(repo *repository.Features, pair *model.FilePair) ([]*model.Feature, []*model.Feature, *model.Feature, error) {
+func getFeatures(ctx context.Context, repo *repository.Features, pair *model.FilePair) ([]*model.Feature, []*model.Feature, *model.Feature, error) {
 	blobIDA := pair.Left.BlobID
 	blobIDB := pair.Right.BlobID
 
-	featuresA, err := repo.GetAll(blobIDA)
+	featuresA, err := repo.GetAll(ctx, blobIDA)
 	if err != nil {
 		return nil, nil, nil, err
 	}
 
-	featuresB, err := repo.GetAll(blobIDB)
+	featuresB, err := repo.GetAll(ctx, blobIDB)
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -0,0 +1,78 @@
+package handler_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/src-d/code-annotation/server/handler"
+	"github.com/src-d/code-annotation/server/model"
+	"github.com/src-d/code-annotation/server/repository"
+	"github.com/src-d/code-annotation/server/serializer"
+	"github.com/src-d/code-annotation/server/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateFeatureWeights(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t)
+	filePairsRepo := repository.NewFilePairs(db.DB)
+	featuresRepo := repository.NewFeatures(db.DB)
+	handler := handler.UpdateFeatureWeights(filePairsRepo, featuresRepo)
+
+	fp, err := filePairsRepo.GetByID(context.Background(), 1)
+	assert.Nil(err)
+
+	for _, name := range []string{"identifiers", "literals"} {
+		_, err := db.DB.Exec(`INSERT INTO features (blob_id, name, weight) VALUES ($1, $2, 1)`,
+			fp.Left.BlobID, name)
+		assert.Nil(err)
+	}
+
+	update := func(pairID, body string) (*serializer.Response, error) {
+		req, _ := http.NewRequest("PUT", "/file-pair/"+pairID+"/features", strings.NewReader(body))
+		return handler(chiRequest(req, map[string]string{"pairId": pairID}))
+	}
+
+	res, err := update("1", `{"literals": 0.25}`)
+	assert.Nil(err)
+
+	features, err := featuresRepo.GetAll(context.Background(), fp.Left.BlobID)
+	assert.Nil(err)
+	assert.Equal([]*model.Feature{{Name: "identifiers", Weight: 1}, {Name: "literals", Weight: 0.25}}, features)
+
+	rightFeatures, err := featuresRepo.GetAll(context.Background(), fp.Right.BlobID)
+	assert.Nil(err)
+	assert.Equal(serializer.NewFeaturesResponse(features, rightFeatures,
+		&model.Feature{Name: "score", Weight: fp.Score}), res)
+
+	req, _ := http.NewRequest("PUT", "/file-pair/1/features?recompute=true", strings.NewReader(`{"identifiers": 0.75}`))
+	res, err = handler(chiRequest(req, map[string]string{"pairId": "1"}))
+	assert.Nil(err)
+
+	features, err = featuresRepo.GetAll(context.Background(), fp.Left.BlobID)
+	assert.Nil(err)
+	rightFeatures, err = featuresRepo.GetAll(context.Background(), fp.Right.BlobID)
+	assert.Nil(err)
+	assert.Equal(serializer.NewRecomputedFeaturesResponse(features, rightFeatures,
+		&model.Feature{Name: "score", Weight: fp.Score},
+		&model.Feature{Name: "score", Weight: service.FeaturesScore(features, rightFeatures)}), res)
+
+	res, err = update("1", `{"unknown": 1}`)
+	assert.Nil(res)
+	assert.Equal(serializer.NewHTTPError(http.StatusBadRequest, `unknown feature "unknown"`), err)
+
+	res, err = update("1", `{"literals": "high"}`)
+	assert.Nil(res)
+	assert.Equal(http.StatusBadRequest, err.(serializer.HTTPError).StatusCode())
+
+	res, err = update("1", `{}`)
+	assert.Nil(res)
+	assert.Equal(serializer.NewHTTPError(http.StatusBadRequest, "no feature weights to update"), err)
+
+	res, err = update("9", `{"literals": 1}`)
+	assert.Nil(res)
+	assert.Equal(serializer.NewHTTPError(http.StatusNotFound, "no file-pair found"), err)
+}
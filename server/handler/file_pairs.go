@@ -1,92 +1,619 @@
 package handler
 
 import (
+	"archive/zip"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"path"
+	"strconv"
 	"strings"
 
+	"github.com/go-chi/chi"
 	"github.com/pressly/lg"
 	"github.com/src-d/code-annotation/server/dbutil"
+	"github.com/src-d/code-annotation/server/model"
 	"github.com/src-d/code-annotation/server/repository"
 	"github.com/src-d/code-annotation/server/serializer"
 	"github.com/src-d/code-annotation/server/service"
 )
 
 // GetFilePairDetails returns a function that returns a *serializer.Response
-// with the details of the requested FilePair
-func GetFilePairDetails(repo *repository.FilePairs, diff *service.Diff) RequestProcessFunc {
+// with the details of the requested FilePair of the experiment
+func GetFilePairDetails(repo *repository.FilePairs, diff *service.Diff, cache *service.DiffCache) RequestProcessFunc {
 	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
 		pairID, err := urlParamInt(r, "pairId")
 		if err != nil {
 			return nil, err
 		}
 
-		filePair, err := repo.GetByID(pairID)
+		filePair, err := repo.GetByID(r.Context(), pairID)
 		if err != nil {
 			return nil, err
 		}
 
-		if filePair == nil {
+		if filePair == nil || filePair.ExperimentID != experimentID {
 			return nil, serializer.NewHTTPError(http.StatusNotFound, "no file-pair found")
 		}
 
-		var preprocessors []service.DiffPreprocessorFunc
+		generate, err := filePairDiffFunc(r, diff, cache)
+		if err != nil {
+			return nil, err
+		}
+
+		d, err := filePairDetails(filePair, generate)
+		if err != nil {
+			return nil, err
+		}
+
+		return serializer.NewFilePairResponse(d), nil
+	}
+}
+
+// GetFilePairArchive returns a http.HandlerFunc that streams a zip with the
+// files of the requested file pair of the experiment. The left and right
+// files, and the base one of three-way pairs, are stored in the left/,
+// right/ and base/ directories, under their paths
+func GetFilePairArchive(repo *repository.FilePairs) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filePair, err := archivedFilePair(r, repo)
+		if err != nil {
+			write(w, r, nil, err)
+			return
+		}
+
+		files := map[string]*model.File{"left": &filePair.Left, "right": &filePair.Right}
+		dirs := []string{"left", "right"}
+		if filePair.Base != nil {
+			files["base"] = filePair.Base
+			dirs = append(dirs, "base")
+		}
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition",
+			fmt.Sprintf("attachment; filename=file-pair-%d.zip", filePair.ID))
+		w.WriteHeader(http.StatusOK)
+
+		zw := zip.NewWriter(w)
+		for _, dir := range dirs {
+			fw, err := zw.Create(archivePath(dir, files[dir]))
+			if err == nil {
+				_, err = io.WriteString(fw, files[dir].Content)
+			}
+
+			if err != nil {
+				// the response is already being sent, the error can only be logged
+				lg.RequestLog(r).Error(fmt.Sprintf("file pair archive interrupted: %s", err))
+				return
+			}
+		}
+
+		if err := zw.Close(); err != nil {
+			lg.RequestLog(r).Error(fmt.Sprintf("file pair archive interrupted: %s", err))
+		}
+	}
+}
+
+// archivedFilePair returns the FilePair requested to GetFilePairArchive. It
+// returns a serializer.HTTPError if it is not found in the experiment, or if
+// its contents were purged
+func archivedFilePair(r *http.Request, repo *repository.FilePairs) (*model.FilePair, error) {
+	experimentID, err := urlParamInt(r, "experimentId")
+	if err != nil {
+		return nil, err
+	}
+
+	pairID, err := urlParamInt(r, "pairId")
+	if err != nil {
+		return nil, err
+	}
+
+	filePair, err := repo.GetByID(r.Context(), pairID)
+	if err != nil {
+		return nil, err
+	}
+
+	if filePair == nil || filePair.ExperimentID != experimentID {
+		return nil, serializer.NewHTTPError(http.StatusNotFound, "no file-pair found")
+	}
+
+	if filePair.Left.Purged {
+		return nil, purgedError()
+	}
+
+	return filePair, nil
+}
+
+// archivePath returns the path of the file in the zip, inside the given
+// directory. The path is cleaned so it can not point outside of it, and the
+// blob ID is used if it has no path
+func archivePath(dir string, f *model.File) string {
+	name := strings.TrimPrefix(path.Clean("/"+f.Path), "/")
+	if name == "" {
+		name = f.BlobID
+	}
+
+	return dir + "/" + name
+}
+
+// maxBatchFilePairs is the max number of file pairs requested at once
+const maxBatchFilePairs = 100
+
+type batchRequest struct {
+	IDs []int `json:"ids"`
+}
+
+// GetFilePairsBatch returns a function that returns a *serializer.Response
+// with the details of several file pairs of the experiment, as
+// GetFilePairDetails does. The IDs are passed in the comma separated "ids"
+// query parameter, or in the body request for POST requests. The file pairs
+// are returned in the requested order; the IDs without a file pair in the
+// experiment, or repeated, are skipped
+func GetFilePairsBatch(repo *repository.FilePairs, diff *service.Diff, cache *service.DiffCache) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
+		ids, err := batchIDs(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(ids) > maxBatchFilePairs {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("at most %d file pairs can be requested at once", maxBatchFilePairs))
+		}
 
-		if r.URL.Query().Get("showInvisible") == "1" {
-			preprocessors = append(preprocessors, service.ReplaceInvisible)
+		generate, err := filePairDiffFunc(r, diff, cache)
+		if err != nil {
+			return nil, err
 		}
 
-		diffString, err := diff.Generate(
-			filePair.Left.Path,
-			filePair.Right.Path,
-			filePair.Left.Content,
-			filePair.Right.Content,
-			preprocessors...,
-		)
+		filePairs, err := repo.GetByIDs(r.Context(), experimentID, ids)
 		if err != nil {
 			return nil, err
 		}
 
-		leftLOC := len(strings.Split(filePair.Left.Content, "\n"))
-		rightLOC := len(strings.Split(filePair.Right.Content, "\n"))
+		byID := make(map[int]*model.FilePair, len(filePairs))
+		for _, fp := range filePairs {
+			byID[fp.ID] = fp
+		}
+
+		details := make([]serializer.FilePairDetails, 0, len(filePairs))
+		for _, id := range ids {
+			fp, ok := byID[id]
+			if !ok {
+				continue
+			}
+
+			// the repeated IDs are only returned the first time
+			delete(byID, id)
+
+			d, err := filePairDetails(fp, generate)
+			if err != nil {
+				return nil, err
+			}
+
+			details = append(details, d)
+		}
+
+		return serializer.NewFilePairsDetailsResponse(details), nil
+	}
+}
+
+// batchIDs returns the IDs requested to the batch endpoints, as
+// GetFilePairsBatch
+func batchIDs(r *http.Request) ([]int, error) {
+	if r.Method == http.MethodPost {
+		var req batchRequest
+		body, err := ioutil.ReadAll(r.Body)
+		if err == nil {
+			err = json.Unmarshal(body, &req)
+		}
+
+		if err != nil {
+			return nil, bodyError(err)
+		}
+
+		return req.IDs, nil
+	}
+
+	param := r.URL.Query().Get("ids")
+	if param == "" {
+		return nil, nil
+	}
+
+	var ids []int
+	for _, s := range strings.Split(param, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("ids must be a comma separated list of integers, got %q", param))
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// diffFunc generates the diff between two files of the FilePair with the
+// given ID. base is empty for the diff between its left and right files, or
+// the side compared with its base file
+type diffFunc func(pairID int, base string, from, to *model.File) (diff string, truncated bool, err error)
 
-		return serializer.NewFilePairResponse(filePair, diffString, leftLOC, rightLOC), nil
+// filePairDiffFunc returns the diffFunc for the "showInvisible" and "diffMode"
+// query parameters of the request. The diffs are stored in the cache, if it
+// is not nil, and taken from it when possible. The diffs of the files bigger
+// than the max blob size of the service.Diff only compare their beginning,
+// and they are flagged as truncated
+func filePairDiffFunc(r *http.Request, diff *service.Diff, cache *service.DiffCache) (diffFunc, error) {
+	var preprocessors []service.DiffPreprocessorFunc
+
+	showInvisible := r.URL.Query().Get("showInvisible") == "1"
+	if showInvisible {
+		preprocessors = append(preprocessors, service.ReplaceInvisible)
+	}
+
+	diffMode, err := service.ParseDiffMode(r.URL.Query().Get("diffMode"))
+	if err != nil {
+		return nil, serializer.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	generate := diff.Generate
+	if diffMode == service.WordDiff {
+		generate = diff.GenerateWords
 	}
+
+	return func(pairID int, base string, from, to *model.File) (string, bool, error) {
+		truncated := diff.Truncated(from.Content, to.Content)
+
+		key := service.DiffCacheKey{PairID: pairID, Base: base, Mode: diffMode, ShowInvisible: showInvisible}
+		if cache != nil {
+			if d, ok := cache.Get(key, from.BlobID, to.BlobID); ok {
+				return d, truncated, nil
+			}
+		}
+
+		d, err := generate(from.Path, to.Path, from.Content, to.Content, preprocessors...)
+		if err != nil {
+			return "", false, err
+		}
+
+		if cache != nil {
+			cache.Add(key, from.BlobID, to.BlobID, d)
+		}
+
+		return d, truncated, nil
+	}, nil
 }
 
+// filePairDetails returns the diff, with the number of changes, lines of code
+// and languages of the FilePair. For three-way pairs, the diffs of both sides
+// against the base file, and its lines of code and language, are returned too.
+// The lines of code are counted in the whole files, even if the diffs are
+// truncated. The details of the purged FilePairs are gone, 410 is returned
+func filePairDetails(fp *model.FilePair, generate diffFunc) (serializer.FilePairDetails, error) {
+	if fp.Left.Purged {
+		return serializer.FilePairDetails{}, purgedError()
+	}
+
+	diffString, truncated, err := generate(fp.ID, "", &fp.Left, &fp.Right)
+	if err != nil {
+		return serializer.FilePairDetails{}, err
+	}
+
+	d := serializer.FilePairDetails{
+		FilePair:  fp,
+		Diff:      diffString,
+		Truncated: truncated,
+		LeftLOC:   countLines(fp.Left.Content),
+		RightLOC:  countLines(fp.Right.Content),
+		LeftLang:  service.DetectLanguage(fp.Left.Path, fp.Left.Content),
+		RightLang: service.DetectLanguage(fp.Right.Path, fp.Right.Content),
+	}
+
+	stats := service.CountDiffStats(diffString)
+	d.AddedLines, d.RemovedLines, d.ChangedLines = stats.Added, stats.Removed, stats.Changed
+
+	if fp.Base == nil {
+		return d, nil
+	}
+
+	var leftTruncated, rightTruncated bool
+	if d.LeftBaseDiff, leftTruncated, err = generate(fp.ID, "left", fp.Base, &fp.Left); err != nil {
+		return serializer.FilePairDetails{}, err
+	}
+
+	if d.RightBaseDiff, rightTruncated, err = generate(fp.ID, "right", fp.Base, &fp.Right); err != nil {
+		return serializer.FilePairDetails{}, err
+	}
+
+	d.Truncated = d.Truncated || leftTruncated || rightTruncated
+
+	d.BaseLOC = countLines(fp.Base.Content)
+	d.BaseLang = service.DetectLanguage(fp.Base.Path, fp.Base.Content)
+	return d, nil
+}
+
+// FilePairETag returns an ETagFunc for the requested FilePair of the
+// experiment. As the diff depends on the query parameters, they are part of
+// the ETag too. The purged pairs have no ETag, so the cached copies of their
+// contents are not revalidated
+func FilePairETag(repo *repository.FilePairs) ETagFunc {
+	return func(r *http.Request) (string, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return "", err
+		}
+
+		pairID, err := urlParamInt(r, "pairId")
+		if err != nil {
+			return "", err
+		}
+
+		leftBlobID, rightBlobID, err := repo.GetBlobIDs(r.Context(), experimentID, pairID)
+		if err != nil || leftBlobID == "" && rightBlobID == "" {
+			return "", err
+		}
+
+		return NewETag(strconv.Itoa(pairID), leftBlobID, rightBlobID, r.URL.RawQuery), nil
+	}
+}
+
+// maxBlobContentSize is the max number of bytes of a blob content returned
+// by GetBlob, bigger contents are truncated
+const maxBlobContentSize = 256 * 1024
+
+type goldAnswerRequest struct {
+	Answer *string `json:"answer"`
+}
+
+// SetFilePairGoldAnswer returns a function that sets the expected answer of
+// the requested file pair, passed in the body request, to evaluate the
+// quality of the annotators. The answer must be accepted by the experiment
+// answer scheme. A null answer makes it a regular pair again
+func SetFilePairGoldAnswer(experimentsRepo *repository.Experiments, repo *repository.FilePairs) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
+		pairID, err := urlParamInt(r, "pairId")
+		if err != nil {
+			return nil, err
+		}
+
+		var req goldAnswerRequest
+		body, err := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+		if err == nil {
+			err = json.Unmarshal(body, &req)
+		}
+
+		if err != nil {
+			return nil, bodyError(err)
+		}
+
+		experiment, err := experimentsRepo.GetByID(r.Context(), experimentID, false)
+		if err != nil {
+			return nil, err
+		}
+
+		if experiment == nil {
+			return nil, serializer.NewHTTPError(http.StatusNotFound, "no experiment found")
+		}
+
+		// a skipped pair has no answer to compare with
+		if req.Answer != nil && (!experiment.IsValidAnswer(*req.Answer) || *req.Answer == "skip") {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("invalid gold answer %q", *req.Answer))
+		}
+
+		updated, err := repo.SetGoldAnswer(r.Context(), experimentID, pairID, req.Answer)
+		if err != nil {
+			return nil, err
+		}
+
+		if !updated {
+			return nil, serializer.NewHTTPError(http.StatusNotFound, "no file-pair found")
+		}
+
+		return serializer.NewEmptyResponse(), nil
+	}
+}
+
+// GetBlob returns a function that returns a *serializer.Response
+// with the content of the requested blob
+func GetBlob(repo *repository.FilePairs) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		blob, err := repo.GetBlob(r.Context(), chi.URLParam(r, "blobId"))
+		if err != nil {
+			return nil, err
+		}
+
+		if blob == nil {
+			return nil, serializer.NewHTTPError(http.StatusNotFound, "no blob found")
+		}
+
+		if blob.Purged {
+			return nil, purgedError()
+		}
+
+		content, truncated := service.TruncateContent(blob.Content, maxBlobContentSize)
+
+		return serializer.NewBlobResponse(blob, content, truncated,
+			service.DetectLanguage(blob.Path, blob.Content), countLines(blob.Content)), nil
+	}
+}
+
+// purgedError is returned for the contents of the experiments whose blobs
+// were purged by PurgeExperimentBlobs
+func purgedError() error {
+	return serializer.NewHTTPError(http.StatusGone, "the contents of the experiment were purged")
+}
+
+// countLines returns the number of lines of the content
+func countLines(content string) int {
+	return len(strings.Split(content, "\n"))
+}
+
+const defaultFilePairsLimit = 100
+
 // GetFilePairs returns a function that returns a *serializer.Response
-// with the list of file pairs for the given experiment ID
-func GetFilePairs(repo *repository.FilePairs) RequestProcessFunc {
+// with a page of the file pairs for the given experiment ID, and the total
+// number of them. The page is set with the limit and offset query parameters.
+// If the "path" query parameter is passed, only the file pairs whose left or
+// right path contain it are listed. With the includeAnnotationCount=true query
+// parameter every file pair includes its number of annotations
+func GetFilePairs(repo *repository.FilePairs, assignmentRepo *repository.Assignments) RequestProcessFunc {
 	return func(r *http.Request) (*serializer.Response, error) {
 		experimentID, err := urlParamInt(r, "experimentId")
 		if err != nil {
 			return nil, err
 		}
 
-		filePairs, err := repo.GetAll(experimentID)
+		limit, offset, err := paginationParams(r, defaultFilePairsLimit)
+		if err != nil {
+			return nil, err
+		}
+
+		filePairs, total, err := filePairsPage(r.Context(), repo, experimentID, r.URL.Query().Get("path"), limit, offset)
 		if err != nil {
 			return nil, err
 		}
 
-		return serializer.NewListFilePairsResponse(filePairs), nil
+		var annotationCounts map[int]int
+		if r.URL.Query().Get("includeAnnotationCount") == "true" {
+			ids := make([]int, len(filePairs))
+			for i, fp := range filePairs {
+				ids[i] = fp.ID
+			}
+
+			annotationCounts, err = assignmentRepo.CountAnnotationsByPair(r.Context(), experimentID, ids)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return serializer.NewListFilePairsResponse(filePairs, total, annotationCounts), nil
 	}
 }
 
-// UploadFilePairs returns a function that imports file pair from import db file to the experiment
-func UploadFilePairs(db *dbutil.DB) RequestProcessFunc {
+// filePairsPage returns a page of the experiment file pairs whose paths match
+// the given term, or of all of them if the term is empty, and the total number
+// of them
+func filePairsPage(
+	ctx context.Context,
+	repo *repository.FilePairs,
+	experimentID int,
+	term string,
+	limit, offset int,
+) ([]*model.FilePair, int, error) {
+	if term == "" {
+		filePairs, err := repo.GetPaginated(ctx, experimentID, limit, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		total, err := repo.Count(ctx, experimentID)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		return filePairs, total, nil
+	}
+
+	filePairs, err := repo.SearchByPath(ctx, experimentID, term, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total, err := repo.CountByPath(ctx, experimentID, term)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return filePairs, total, nil
+}
+
+// UploadFilePairsCSV returns a function that imports file pairs from a CSV
+// file to the experiment. The CSV must have the columns leftPath, rightPath,
+// leftContent, rightContent and score. The response includes the details of
+// up to maxFailureDetails failed rows. With the dryRun=true query parameter the
+// file is only validated, and nothing is imported
+func UploadFilePairsCSV(db *dbutil.DB, maxFailureDetails int) RequestProcessFunc {
 	return func(r *http.Request) (*serializer.Response, error) {
 		experimentID, err := urlParamInt(r, "experimentId")
 		if err != nil {
 			return nil, err
 		}
 
-		file, _, err := r.FormFile("input_db")
+		file, _, err := r.FormFile("input_csv")
+		if err != nil {
+			return nil, bodyError(err)
+		}
+		defer file.Close()
+
+		details := &failureDetails{max: maxFailureDetails}
+		success, failures, err := dbutil.ImportCSV(file, *db, dbutil.Options{
+			Logger:    lg.RequestLog(r),
+			OnFailure: details.add,
+			DryRun:    dryRun(r),
+		}, experimentID)
 		if err != nil {
 			return nil, serializer.NewHTTPError(http.StatusBadRequest, err.Error())
 		}
+
+		return serializer.NewFilePairsUploadResponse(success, failures, details.list...), nil
+	}
+}
+
+// dryRun returns true if the "dryRun" query parameter is true
+func dryRun(r *http.Request) bool {
+	return r.URL.Query().Get("dryRun") == "true"
+}
+
+// failureDetails collects the details of the failed rows of an upload, up to
+// max of them
+type failureDetails struct {
+	max  int
+	list []serializer.UploadFailure
+}
+
+func (d *failureDetails) add(f dbutil.ImportFailure) {
+	if len(d.list) < d.max {
+		d.list = append(d.list, serializer.UploadFailure{Row: f.Row, Reason: f.Reason})
+	}
+}
+
+// UploadFilePairs returns a function that imports file pair from import db file to the experiment.
+// The response includes the details of up to maxFailureDetails failed rows.
+// With the dryRun=true query parameter the file is only validated, and nothing
+// is imported
+func UploadFilePairs(db *dbutil.DB, maxFailureDetails int) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
+		file, _, err := r.FormFile("input_db")
+		if err != nil {
+			return nil, bodyError(err)
+		}
 		defer file.Close()
 
 		// need to save on disk to be able to open using sql.Open
@@ -108,12 +635,16 @@ func UploadFilePairs(db *dbutil.DB) RequestProcessFunc {
 			return nil, fmt.Errorf("can't open input db %s", err)
 		}
 
-		success, failures, err := dbutil.ImportFiles(
-			inputDB, *db, dbutil.Options{Logger: lg.RequestLog(r)}, experimentID)
+		details := &failureDetails{max: maxFailureDetails}
+		success, failures, err := dbutil.ImportFiles(inputDB, *db, dbutil.Options{
+			Logger:    lg.RequestLog(r),
+			OnFailure: details.add,
+			DryRun:    dryRun(r),
+		}, experimentID)
 		if err != nil {
 			return nil, err
 		}
 
-		return serializer.NewFilePairsUploadResponse(success, failures), nil
+		return serializer.NewFilePairsUploadResponse(success, failures, details.list...), nil
 	}
 }
@@ -1,17 +1,25 @@
 package handler_test
 
 import (
+	"archive/zip"
 	"bytes"
+	"context"
 	"database/sql"
+	"encoding/json"
 	"io/ioutil"
 	"mime/multipart"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/src-d/code-annotation/server/handler"
+	"github.com/src-d/code-annotation/server/model"
+	"github.com/src-d/code-annotation/server/repository"
 	"github.com/src-d/code-annotation/server/serializer"
+	"github.com/src-d/code-annotation/server/service"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -38,7 +46,7 @@ func TestUploadFilePairs(t *testing.T) {
 
 	// create db & handler
 	db := testDB()
-	handler := handler.UploadFilePairs(db)
+	handler := handler.UploadFilePairs(db, 10)
 
 	req, err := newFileUploadRequest("/experiments/1/file-pairs", nil, "input_db", dbPath)
 	req = chiRequest(req, map[string]string{"experimentId": "1"})
@@ -89,3 +97,449 @@ func newFileUploadRequest(uri string, params map[string]string, paramName, path
 	r.Header.Set("Content-Type", writer.FormDataContentType())
 	return r, nil
 }
+
+func TestGetFilePairArchive(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t)
+	repo := repository.NewFilePairs(db.DB)
+	archive := handler.GetFilePairArchive(repo)
+
+	get := func(experimentID, pairID string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/experiments/"+experimentID+"/file-pairs/"+pairID+"/archive.zip", nil)
+		w := httptest.NewRecorder()
+		archive(w, chiRequest(req, map[string]string{"experimentId": experimentID, "pairId": pairID}))
+		return w
+	}
+
+	w := get("1", "1")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("application/zip", w.Header().Get("Content-Type"))
+	assert.Equal("attachment; filename=file-pair-1.zip", w.Header().Get("Content-Disposition"))
+
+	pair, err := repo.GetByID(context.Background(), 1)
+	assert.Nil(err)
+
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	assert.Nil(err)
+
+	expected := map[string]string{
+		"left/" + strings.TrimPrefix(pair.Left.Path, "/"):   pair.Left.Content,
+		"right/" + strings.TrimPrefix(pair.Right.Path, "/"): pair.Right.Content,
+	}
+	contents := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		assert.Nil(err)
+		content, err := ioutil.ReadAll(rc)
+		assert.Nil(err)
+		rc.Close()
+		contents[f.Name] = string(content)
+	}
+	assert.Equal(expected, contents)
+
+	for _, ids := range [][]string{{"1", "99"}, {"2", "1"}} {
+		assert.Equal(http.StatusNotFound, get(ids[0], ids[1]).Code)
+	}
+}
+
+func TestGetBlob(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t)
+	repo := repository.NewFilePairs(db.DB)
+	handler := handler.GetBlob(repo)
+
+	blobID := "3a6e3a6e3a6e3a6e3a6e3a6e3a6e3a6e3a6e3a6e"
+	req, _ := http.NewRequest("GET", "/blobs/"+blobID, nil)
+	res, err := handler(chiRequest(req, map[string]string{"blobId": blobID}))
+	assert.Nil(err)
+
+	blob, err := repo.GetBlob(context.Background(), blobID)
+	assert.Nil(err)
+	assert.Equal("project/src/a", blob.Path)
+	assert.Equal(serializer.NewBlobResponse(blob, "Some text", false, "text", 1), res)
+
+	req, _ = http.NewRequest("GET", "/blobs/missing", nil)
+	_, err = handler(chiRequest(req, map[string]string{"blobId": "missing"}))
+	assert.Equal(serializer.NewHTTPError(http.StatusNotFound, "no blob found"), err)
+}
+
+func TestSetFilePairGoldAnswer(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t)
+	handler := handler.SetFilePairGoldAnswer(repository.NewExperiments(db.DB), repository.NewFilePairs(db.DB))
+
+	setGold := func(experimentID, pairID, body string) (*serializer.Response, error) {
+		req, _ := http.NewRequest("PUT", "/experiments/"+experimentID+"/file-pairs/"+pairID+"/gold",
+			strings.NewReader(body))
+		req = chiRequest(req, map[string]string{"experimentId": experimentID, "pairId": pairID})
+		return handler(req)
+	}
+
+	for _, body := range []string{`{"answer": "yes"}`, `{"answer": null}`} {
+		res, err := setGold("1", "1", body)
+		assert.Nil(err, body)
+		assert.Equal(serializer.NewEmptyResponse(), res)
+	}
+
+	for _, body := range []string{`{"answer": "skip"}`, `{"answer": "perhaps"}`, `{"answer": 1}`} {
+		_, err := setGold("1", "1", body)
+		assert.Equal(http.StatusBadRequest, err.(serializer.HTTPError).StatusCode(), body)
+	}
+
+	_, err := setGold("1", "9", `{"answer": "yes"}`)
+	assert.Equal(serializer.NewHTTPError(http.StatusNotFound, "no file-pair found"), err)
+
+	_, err = setGold("9", "1", `{"answer": "yes"}`)
+	assert.Equal(serializer.NewHTTPError(http.StatusNotFound, "no experiment found"), err)
+}
+
+func TestGetFilePairsPaginated(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t)
+	repo := repository.NewFilePairs(db.DB)
+	handler := handler.GetFilePairs(repo, repository.NewAssignments(db.DB))
+
+	req, _ := http.NewRequest("GET", "/file-pairs?limit=1&offset=1", nil)
+	res, err := handler(chiRequest(req, map[string]string{"experimentId": "1"}))
+	assert.Nil(err)
+
+	fp, err := repo.GetByID(context.Background(), 2)
+	assert.Nil(err)
+	assert.Equal(serializer.NewListFilePairsResponse([]*model.FilePair{fp}, 2, nil), res)
+
+	req, _ = http.NewRequest("GET", "/file-pairs?offset=-1", nil)
+	res, err = handler(chiRequest(req, map[string]string{"experimentId": "1"}))
+	assert.Nil(res)
+	assert.Error(err)
+}
+
+func TestGetFilePairsAnnotationCount(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t,
+		&model.User{Login: "alice", Role: model.Worker},
+		&model.User{Login: "bob", Role: model.Worker},
+	)
+	assignmentsRepo := repository.NewAssignments(db.DB)
+	handler := handler.GetFilePairs(repository.NewFilePairs(db.DB), assignmentsRepo)
+
+	for _, id := range []int{1, 3} {
+		assert.Nil(assignmentsRepo.Update(context.Background(), id, "yes", 10))
+	}
+
+	req, _ := http.NewRequest("GET", "/file-pairs?includeAnnotationCount=true", nil)
+	res, err := handler(chiRequest(req, map[string]string{"experimentId": "1"}))
+	assert.Nil(err)
+
+	content, err := json.Marshal(res)
+	assert.Nil(err)
+	assert.JSONEq(`{"status": 200, "total": 2, "data": [
+		{"id": 1, "leftPath": "project/src/a", "rightPath": "other_project/src/b", "annotationCount": 2},
+		{"id": 2, "leftPath": "dashboard/src/services/api.js", "rightPath": "dashboard/src/services/api.js",
+			"annotationCount": 0}
+	]}`, string(content))
+
+	// the counts are only included when they are requested
+	req, _ = http.NewRequest("GET", "/file-pairs", nil)
+	res, err = handler(chiRequest(req, map[string]string{"experimentId": "1"}))
+	assert.Nil(err)
+	content, err = json.Marshal(res)
+	assert.Nil(err)
+	assert.NotContains(string(content), "annotationCount")
+}
+
+func TestGetFilePairsSearchByPath(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t)
+	repo := repository.NewFilePairs(db.DB)
+	handler := handler.GetFilePairs(repo, repository.NewAssignments(db.DB))
+
+	fp, err := repo.GetByID(context.Background(), 1)
+	assert.Nil(err)
+
+	req, _ := http.NewRequest("GET", "/file-pairs?path=SRC/B", nil)
+	res, err := handler(chiRequest(req, map[string]string{"experimentId": "1"}))
+	assert.Nil(err)
+	assert.Equal(serializer.NewListFilePairsResponse([]*model.FilePair{fp}, 1, nil), res)
+
+	req, _ = http.NewRequest("GET", "/file-pairs?path=missing", nil)
+	res, err = handler(chiRequest(req, map[string]string{"experimentId": "1"}))
+	assert.Nil(err)
+	assert.Equal(serializer.NewListFilePairsResponse([]*model.FilePair{}, 0, nil), res)
+
+	// the total counts every match, not only the ones in the page
+	second, err := repo.GetByID(context.Background(), 2)
+	assert.Nil(err)
+
+	req, _ = http.NewRequest("GET", "/file-pairs?path=src&limit=1&offset=1", nil)
+	res, err = handler(chiRequest(req, map[string]string{"experimentId": "1"}))
+	assert.Nil(err)
+	assert.Equal(serializer.NewListFilePairsResponse([]*model.FilePair{second}, 2, nil), res)
+}
+
+func TestGetFilePairsBatch(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t)
+	repo := repository.NewFilePairs(db.DB)
+	diff := service.NewDiff(0)
+	batch := handler.GetFilePairsBatch(repo, diff, nil)
+
+	detailsJSON := func(pairID string) string {
+		req, _ := http.NewRequest("GET", "/file-pairs/"+pairID, nil)
+		res, err := handler.GetFilePairDetails(repo, diff, nil)(chiRequest(req, map[string]string{"experimentId": "1", "pairId": pairID}))
+		assert.Nil(err)
+
+		body, err := json.Marshal(res.Data)
+		assert.Nil(err)
+		return string(body)
+	}
+
+	batchJSON := func(req *http.Request) string {
+		res, err := batch(chiRequest(req, map[string]string{"experimentId": "1"}))
+		assert.Nil(err)
+
+		body, err := json.Marshal(res.Data)
+		assert.Nil(err)
+		return string(body)
+	}
+
+	// the order is kept, and the missing and repeated IDs are skipped
+	expected := "[" + detailsJSON("2") + "," + detailsJSON("1") + "]"
+
+	req, _ := http.NewRequest("GET", "/file-pairs/batch?ids=2,99,1,2", nil)
+	assert.Equal(expected, batchJSON(req))
+
+	req, _ = http.NewRequest("POST", "/file-pairs/batch", strings.NewReader(`{"ids": [2, 99, 1, 2]}`))
+	assert.Equal(expected, batchJSON(req))
+
+	req, _ = http.NewRequest("GET", "/file-pairs/batch", nil)
+	assert.Equal("[]", batchJSON(req))
+
+	// the file pairs of other experiments are skipped
+	req, _ = http.NewRequest("GET", "/file-pairs/batch?ids=1,2", nil)
+	res, err := batch(chiRequest(req, map[string]string{"experimentId": "2"}))
+	assert.Nil(err)
+	assert.Equal(serializer.NewFilePairsDetailsResponse([]serializer.FilePairDetails{}), res)
+
+	tooMany := strings.Repeat("1,", 100) + "1"
+	for _, query := range []string{"?ids=1,a", "?ids=1&diffMode=unknown", "?ids=" + tooMany} {
+		req, _ = http.NewRequest("GET", "/file-pairs/batch"+query, nil)
+		res, err = batch(chiRequest(req, map[string]string{"experimentId": "1"}))
+		assert.Nil(res)
+		assert.Equal(http.StatusBadRequest, err.(serializer.HTTPError).StatusCode(), query)
+	}
+}
+
+func TestGetFilePairDetailsDiffStats(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t)
+	details := handler.GetFilePairDetails(repository.NewFilePairs(db.DB), service.NewDiff(0), nil)
+
+	for _, query := range []string{"", "?diffMode=word"} {
+		req, _ := http.NewRequest("GET", "/file-pairs/1"+query, nil)
+		res, err := details(chiRequest(req, map[string]string{"experimentId": "1", "pairId": "1"}))
+		assert.Nil(err)
+
+		data := withoutTimestamps(res)["data"].(map[string]interface{})
+		stats := service.CountDiffStats(data["diff"].(string))
+		assert.Equal(float64(stats.Added), data["addedLines"], query)
+		assert.Equal(float64(stats.Removed), data["removedLines"], query)
+		assert.Equal(float64(stats.Changed), data["changedLines"], query)
+	}
+}
+
+func TestGetFilePairDetailsTruncated(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t)
+	repo := repository.NewFilePairs(db.DB)
+
+	details := func(diff *service.Diff) map[string]interface{} {
+		req, _ := http.NewRequest("GET", "/file-pairs/2", nil)
+		res, err := handler.GetFilePairDetails(repo, diff, nil)(chiRequest(req, map[string]string{"experimentId": "1", "pairId": "2"}))
+		assert.Nil(err)
+		return withoutTimestamps(res)["data"].(map[string]interface{})
+	}
+
+	whole := details(service.NewDiff(0))
+	truncated := details(service.NewDiff(16))
+
+	assert.Equal(false, whole["truncated"])
+	assert.Equal(true, truncated["truncated"])
+	assert.True(len(truncated["diff"].(string)) < len(whole["diff"].(string)))
+	assert.Equal(whole["leftLoc"], truncated["leftLoc"])
+	assert.Equal(whole["rightLoc"], truncated["rightLoc"])
+}
+
+func TestGetFilePairDetailsCache(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t)
+	repo := repository.NewFilePairs(db.DB)
+	cache := service.NewDiffCache(1 << 20)
+	details := handler.GetFilePairDetails(repo, service.NewDiff(0), cache)
+
+	request := func(query string) *serializer.Response {
+		req, _ := http.NewRequest("GET", "/file-pairs/1"+query, nil)
+		res, err := details(chiRequest(req, map[string]string{"experimentId": "1", "pairId": "1"}))
+		assert.Nil(err)
+		return res
+	}
+
+	uncached := request("")
+	assert.Equal(uncached, request(""))
+	assert.NotEqual(uncached, request("?diffMode=word"))
+
+	assert.Equal(uint64(1), cache.Hits())
+	assert.Equal(uint64(2), cache.Misses())
+	assert.Equal(2, cache.Len())
+}
+
+func TestUploadFilePairsCSV(t *testing.T) {
+	assert := assert.New(t)
+
+	csvFile, err := ioutil.TempFile("", "cat_test_upload_file_pairs.csv")
+	if err != nil {
+		t.Fatalf("can't create csv file for test %s", err)
+	}
+	defer os.Remove(csvFile.Name())
+
+	csvFile.WriteString("score,leftPath,rightPath,leftContent,rightContent\n" +
+		"0.5,a.go,b.go,package a,\"package b\nfunc B() {}\"\n" +
+		"high,c.go,d.go,package c,package d\n" +
+		"0.7,e.go,f.go\n" +
+		"1,g.go,h.go,package g,package h\n")
+	csvFile.Close()
+
+	db := testDB()
+
+	req, err := newFileUploadRequest("/experiments/1/file-pairs/csv", nil, "input_csv", csvFile.Name())
+	if err != nil {
+		t.Fatalf("can't create file upload request %s", err)
+	}
+	req = chiRequest(req, map[string]string{"experimentId": "1"})
+	res, err := handler.UploadFilePairsCSV(db, 10)(req)
+	assert.Nil(err)
+
+	assert.Equal(serializer.NewFilePairsUploadResponse(2, 2,
+		serializer.UploadFailure{Row: 3, Reason: `invalid score "high"`},
+		serializer.UploadFailure{Row: 4, Reason: "expected 5 fields, got 3"},
+	), res)
+
+	pairs, err := repository.NewFilePairs(db.DB).GetAll(context.Background(), 1)
+	assert.Nil(err)
+	assert.Len(pairs, 2)
+	assert.Equal("b.go", pairs[0].Right.Path)
+	assert.Equal("package b\nfunc B() {}", pairs[0].Right.Content)
+	// git hash-object of "package a"
+	assert.Equal("d63f93ea49151ebcba21c23f2429ac04619dc15c", pairs[0].Left.BlobID)
+
+	// the failure details are capped, but all the failures are counted
+	req, _ = newFileUploadRequest("/experiments/1/file-pairs/csv", nil, "input_csv", csvFile.Name())
+	req = chiRequest(req, map[string]string{"experimentId": "1"})
+	res, err = handler.UploadFilePairsCSV(db, 1)(req)
+	assert.Nil(err)
+
+	assert.Equal(serializer.NewFilePairsUploadResponse(2, 2,
+		serializer.UploadFailure{Row: 3, Reason: `invalid score "high"`},
+	), res)
+}
+
+func TestUploadFilePairsCSVDryRun(t *testing.T) {
+	assert := assert.New(t)
+
+	csvFile, err := ioutil.TempFile("", "cat_test_upload_file_pairs_dry_run.csv")
+	if err != nil {
+		t.Fatalf("can't create csv file for test %s", err)
+	}
+	defer os.Remove(csvFile.Name())
+
+	csvFile.WriteString("score,leftPath,rightPath,leftContent,rightContent\n" +
+		"0.5,a.go,b.go,package a,package b\n" +
+		"high,c.go,d.go,package c,package d\n")
+	csvFile.Close()
+
+	db := testDB()
+
+	req, err := newFileUploadRequest("/experiments/1/file-pairs/csv?dryRun=true", nil, "input_csv", csvFile.Name())
+	if err != nil {
+		t.Fatalf("can't create file upload request %s", err)
+	}
+	req = chiRequest(req, map[string]string{"experimentId": "1"})
+	res, err := handler.UploadFilePairsCSV(db, 10)(req)
+	assert.Nil(err)
+
+	assert.Equal(serializer.NewFilePairsUploadResponse(1, 1,
+		serializer.UploadFailure{Row: 3, Reason: `invalid score "high"`},
+	), res)
+
+	pairs, err := repository.NewFilePairs(db.DB).GetAll(context.Background(), 1)
+	assert.Nil(err)
+	assert.Len(pairs, 0)
+}
+
+func TestGetFilePairDetailsThreeWay(t *testing.T) {
+	assert := assert.New(t)
+
+	csvFile, err := ioutil.TempFile("", "cat_test_three_way_file_pairs.csv")
+	if err != nil {
+		t.Fatalf("can't create csv file for test %s", err)
+	}
+	defer os.Remove(csvFile.Name())
+
+	csvFile.WriteString("score,basePath,baseContent,leftPath,rightPath,leftContent,rightContent\n" +
+		"0.5,base.go,package base,a.go,b.go,package a,package b\n" +
+		"0.7,,,c.go,d.go,package c,package d\n")
+	csvFile.Close()
+
+	db := testDB()
+	req, err := newFileUploadRequest("/experiments/1/file-pairs/csv", nil, "input_csv", csvFile.Name())
+	if err != nil {
+		t.Fatalf("can't create file upload request %s", err)
+	}
+	_, err = handler.UploadFilePairsCSV(db, 10)(chiRequest(req, map[string]string{"experimentId": "1"}))
+	assert.Nil(err)
+
+	details := handler.GetFilePairDetails(repository.NewFilePairs(db.DB), service.NewDiff(0), nil)
+	request := func(pairID string) map[string]interface{} {
+		req, _ := http.NewRequest("GET", "/file-pairs/"+pairID, nil)
+		res, err := details(chiRequest(req, map[string]string{"experimentId": "1", "pairId": pairID}))
+		assert.Nil(err)
+
+		var decoded struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		content, err := json.Marshal(res)
+		assert.Nil(err)
+		assert.Nil(json.Unmarshal(content, &decoded))
+		return decoded.Data
+	}
+
+	threeWay := request("1")
+	// git hash-object of "package base"
+	assert.Equal("93a8d18f118c10339a36b8a6ae50d3807ffdfdff", threeWay["baseBlobId"])
+	assert.Equal(float64(1), threeWay["baseLoc"])
+	assert.Contains(threeWay["leftBaseDiff"], "-package base")
+	assert.Contains(threeWay["leftBaseDiff"], "+package a")
+	assert.Contains(threeWay["rightBaseDiff"], "+package b")
+	assert.Contains(threeWay["diff"], "+package b")
+
+	// the base can be requested as any other blob
+	blob, err := handler.GetBlob(repository.NewFilePairs(db.DB))(
+		chiRequest(httptest.NewRequest("GET", "/blobs/x", nil),
+			map[string]string{"blobId": threeWay["baseBlobId"].(string)}))
+	assert.Nil(err)
+	assert.NotNil(blob)
+
+	twoWay := request("2")
+	for _, field := range []string{"baseBlobId", "baseLoc", "baseLang", "leftBaseDiff", "rightBaseDiff"} {
+		assert.NotContains(twoWay, field)
+	}
+}
@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/src-d/code-annotation/server/repository"
+	"github.com/src-d/code-annotation/server/serializer"
+)
+
+// readinessTimeout is the max time to wait for the DB in Readiness
+const readinessTimeout = 2 * time.Second
+
+// Health returns a function that returns an empty *serializer.Response while
+// the server is alive
+func Health() RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		return serializer.NewEmptyResponse(), nil
+	}
+}
+
+// Readiness returns a function that returns an empty *serializer.Response if
+// the server can serve requests. If the DB is not reachable, it returns a
+// serializer.HTTPError with http.StatusServiceUnavailable
+func Readiness(repo *repository.Health) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+		defer cancel()
+
+		if err := repo.Ping(ctx); err != nil {
+			return nil, serializer.NewHTTPError(
+				http.StatusServiceUnavailable, "the database is not reachable")
+		}
+
+		return serializer.NewEmptyResponse(), nil
+	}
+}
@@ -0,0 +1,30 @@
+package handler_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/src-d/code-annotation/server/handler"
+	"github.com/src-d/code-annotation/server/repository"
+	"github.com/src-d/code-annotation/server/serializer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadiness(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDB()
+	h := handler.Readiness(repository.NewHealth(db.DB))
+
+	req, _ := http.NewRequest("GET", "/readyz", nil)
+	res, err := h(req)
+	assert.Nil(err)
+	assert.Equal(serializer.NewEmptyResponse(), res)
+
+	db.Close()
+
+	res, err = h(req)
+	assert.Nil(res)
+	assert.Equal(serializer.NewHTTPError(
+		http.StatusServiceUnavailable, "the database is not reachable"), err)
+}
@@ -3,17 +3,29 @@ package handler_test
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"io/ioutil"
 	"net/http"
+	"path/filepath"
+	"testing"
 
 	"github.com/go-chi/chi"
 	"github.com/pressly/lg"
 	"github.com/sirupsen/logrus"
 	"github.com/src-d/code-annotation/server/dbutil"
+	"github.com/src-d/code-annotation/server/model"
+	"github.com/src-d/code-annotation/server/repository"
+	"github.com/src-d/code-annotation/server/serializer"
 	"github.com/src-d/code-annotation/server/service"
 )
 
 func testDB() *dbutil.DB {
-	db, err := sql.Open("sqlite3", ":memory:")
+	return openTestDB(":memory:")
+}
+
+// openTestDB returns a bootstrapped and initialized DB for the given DSN
+func openTestDB(dsn string) *dbutil.DB {
+	db, err := sql.Open("sqlite3", dsn)
 	if err != nil {
 		panic(err)
 	}
@@ -30,6 +42,49 @@ func testDB() *dbutil.DB {
 	return &dbWrapper
 }
 
+// testDBWithPairs returns a testDB with the file pairs from
+// testdata/import_db.sql imported into the default experiment, and the given
+// users (with IDs starting at 1) and their assignments created.
+// The DB is stored in a temporary file, so it is shared by all its connections.
+// It is closed and removed when the test finishes
+func testDBWithPairs(t *testing.T, users ...*model.User) *dbutil.DB {
+	db := openTestDB(filepath.Join(t.TempDir(), "cat_test.db"))
+	t.Cleanup(func() { db.Close() })
+
+	originDB, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		panic(err)
+	}
+	defer originDB.Close()
+
+	sqlQuery, err := ioutil.ReadFile("testdata/import_db.sql")
+	if err != nil {
+		panic(err)
+	}
+	if _, err := originDB.Exec(string(sqlQuery)); err != nil {
+		panic(err)
+	}
+
+	origin := dbutil.DB{DB: originDB, Driver: dbutil.Sqlite}
+	if _, _, err := dbutil.ImportFiles(origin, *db, dbutil.Options{}, 1); err != nil {
+		panic(err)
+	}
+
+	usersRepo := repository.NewUsers(db.DB)
+	assignmentsRepo := repository.NewAssignments(db.DB)
+	for _, u := range users {
+		if err := usersRepo.Create(context.Background(), u); err != nil {
+			panic(err)
+		}
+
+		if _, err := assignmentsRepo.Initialize(context.Background(), u.ID, 1); err != nil {
+			panic(err)
+		}
+	}
+
+	return db
+}
+
 func chiRequest(req *http.Request, params map[string]string) *http.Request {
 	ctx := lg.WithLoggerContext(req.Context(), logrus.StandardLogger())
 
@@ -48,3 +103,35 @@ func reqWithUser(req *http.Request, userID int) *http.Request {
 	ctx := service.SetUserID(req.Context(), userID)
 	return req.WithContext(ctx)
 }
+
+// withoutTimestamps returns the JSON decoded Response of one or more
+// experiments without their timestamps, that can not be known in advance
+func withoutTimestamps(res *serializer.Response) map[string]interface{} {
+	if res == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(res)
+	if err != nil {
+		panic(err)
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		panic(err)
+	}
+
+	experiments, ok := decoded["data"].([]interface{})
+	if !ok {
+		experiments = []interface{}{decoded["data"]}
+	}
+
+	for _, e := range experiments {
+		if e, ok := e.(map[string]interface{}); ok {
+			delete(e, "createdAt")
+			delete(e, "updatedAt")
+		}
+	}
+
+	return decoded
+}
@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/src-d/code-annotation/server/serializer"
+	"github.com/src-d/code-annotation/server/service"
+)
+
+// IdempotencyKeyHeader is the request header used by the clients to identify
+// a request that they may send again, when they can not know if it succeeded
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLength is the max number of characters of an idempotency key
+const maxIdempotencyKeyLength = 255
+
+// Idempotent returns a RequestProcessMiddleware that only calls the wrapped
+// RequestProcessFunc once for every key sent in the IdempotencyKeyHeader by
+// the logged user to the same URL. The repeated requests get the original
+// response, kept in the passed service.IdempotencyStore. The requests that fail
+// are not kept, so they can be retried with the same key. The requests without
+// the header are always processed
+func Idempotent(store *service.IdempotencyStore) RequestProcessMiddleware {
+	return func(next RequestProcessFunc) RequestProcessFunc {
+		return func(r *http.Request) (*serializer.Response, error) {
+			key := strings.TrimSpace(r.Header.Get(IdempotencyKeyHeader))
+			if key == "" {
+				return next(r)
+			}
+
+			if len(key) > maxIdempotencyKeyLength {
+				return nil, serializer.NewHTTPError(http.StatusBadRequest,
+					fmt.Sprintf("%s can not be longer than %d characters",
+						IdempotencyKeyHeader, maxIdempotencyKeyLength))
+			}
+
+			userID, err := service.GetUserID(r.Context())
+			if err != nil {
+				return nil, err
+			}
+
+			key = fmt.Sprintf("%d %s %s %s", userID, r.Method, r.URL.Path, key)
+			state, result := store.Begin(key)
+			switch state {
+			case service.IdempotencyInProgress:
+				return nil, serializer.NewHTTPError(http.StatusConflict,
+					"a request with the same "+IdempotencyKeyHeader+" is still in progress")
+			case service.IdempotencyDone:
+				return result.(*serializer.Response), nil
+			}
+
+			res, err := next(r)
+			if err != nil {
+				store.Release(key)
+				return nil, err
+			}
+
+			store.Finish(key, res)
+			return res, nil
+		}
+	}
+}
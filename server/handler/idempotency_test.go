@@ -0,0 +1,62 @@
+package handler_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/src-d/code-annotation/server/handler"
+	"github.com/src-d/code-annotation/server/model"
+	"github.com/src-d/code-annotation/server/repository"
+	"github.com/src-d/code-annotation/server/serializer"
+	"github.com/src-d/code-annotation/server/service"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotent(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t, &model.User{Login: "alice", Role: model.Worker})
+	repo := repository.NewAssignments(db.DB)
+	h := handler.Idempotent(service.NewIdempotencyStore(time.Hour))(
+		handler.SaveAssignment(repository.NewExperiments(db.DB), repo, time.Minute, time.Hour, 1000, nil, nil))
+
+	answer := func(body, key string) (*serializer.Response, error) {
+		req := answerRequest("1", 1, body)
+		req.Header.Set(handler.IdempotencyKeyHeader, key)
+		return h(req)
+	}
+
+	res, err := answer(`{"answer": "yes", "duration": 10}`, "first")
+	assert.Nil(err)
+	assert.Equal(serializer.NewCountResponse(1), res)
+
+	first, err := repo.GetByID(context.Background(), 1)
+	assert.Nil(err)
+
+	// the repeated request is not processed again
+	res, err = answer(`{"answer": "no", "duration": 20}`, "first")
+	assert.Nil(err)
+	assert.Equal(serializer.NewCountResponse(1), res)
+
+	repeated, err := repo.GetByID(context.Background(), 1)
+	assert.Nil(err)
+	assert.Equal(first, repeated)
+
+	// the failed requests can be retried
+	_, err = answer(`{"answer": "perhaps"}`, "second")
+	assert.Equal(http.StatusBadRequest, err.(serializer.HTTPError).StatusCode())
+
+	_, err = answer(`{"answer": "no", "duration": 20}`, "second")
+	assert.Nil(err)
+
+	second, err := repo.GetByID(context.Background(), 1)
+	assert.Nil(err)
+	assert.Equal("no", second.AnswerStr())
+
+	_, err = answer(`{"answer": "yes"}`, strings.Repeat("a", 256))
+	assert.Equal(http.StatusBadRequest, err.(serializer.HTTPError).StatusCode())
+}
@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/src-d/code-annotation/server/service"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/pressly/lg"
+	"github.com/sirupsen/logrus"
+)
+
+// RequestLogger returns a middleware that logs every request once it is
+// complete, with its method, path, status code, latency and, for the
+// authenticated requests, the user ID. The log entry is also made available
+// to the handlers with lg.RequestLog
+func RequestLogger(logger *logrus.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			entry := &lg.HTTPLoggerEntry{Logger: logger.WithFields(logrus.Fields{
+				"method": r.Method,
+				"path":   r.URL.Path,
+			})}
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			start := time.Now()
+
+			defer func() {
+				if rec := recover(); rec != nil {
+					entry.Logger = entry.Logger.WithField("stack", string(debug.Stack()))
+					entry.Logger.Errorf("panic: %+v", rec)
+					http.Error(ww, http.StatusText(http.StatusInternalServerError),
+						http.StatusInternalServerError)
+				}
+
+				status := ww.Status()
+				if status == 0 {
+					status = http.StatusOK
+				}
+
+				entry.Logger.WithFields(logrus.Fields{
+					"status":     status,
+					"latency_ms": float64(time.Since(start).Nanoseconds()) / float64(time.Millisecond),
+				}).Info("request complete")
+			}()
+
+			next.ServeHTTP(ww, r.WithContext(lg.WithLogEntry(r.Context(), entry)))
+		})
+	}
+}
+
+// logUserID adds the ID of the authenticated user, if any, to the request
+// log entry
+func logUserID(r *http.Request) {
+	if userID, err := service.GetUserID(r.Context()); err == nil {
+		lg.SetRequestEntryField(r, "userId", userID)
+	}
+}
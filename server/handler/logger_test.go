@@ -0,0 +1,45 @@
+package handler_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/src-d/code-annotation/server/handler"
+	"github.com/src-d/code-annotation/server/serializer"
+	"github.com/src-d/code-annotation/server/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestLogger(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf bytes.Buffer
+	logger, err := service.NewLogger("production", service.LoggerConfig{})
+	assert.Nil(err)
+	logger.Out = &buf
+
+	notFound := handler.APIHandlerFunc(func(r *http.Request) (*serializer.Response, error) {
+		return nil, serializer.NewHTTPError(http.StatusNotFound, "no experiment found")
+	})
+	h := handler.RequestLogger(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		notFound(w, reqWithUser(r, 5))
+	}))
+
+	req, _ := http.NewRequest("GET", "/api/experiments/3", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(http.StatusNotFound, w.Code)
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	var entry map[string]interface{}
+	assert.Nil(json.Unmarshal(lines[len(lines)-1], &entry))
+	assert.Equal("request complete", entry["message"])
+	assert.Equal("GET", entry["method"])
+	assert.Equal("/api/experiments/3", entry["path"])
+	assert.Equal(float64(http.StatusNotFound), entry["status"])
+	assert.Equal(float64(5), entry["userId"])
+	assert.Contains(entry, "latency_ms")
+}
@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/src-d/code-annotation/server/service"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+)
+
+// RequestMetrics returns a middleware that records the duration of every
+// request, labeled with its route pattern instead of its path so the metrics
+// do not grow with every ID. The requests that do not match any route are
+// labeled as "unmatched"
+func RequestMetrics(metrics *service.Metrics) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			start := time.Now()
+
+			defer func() {
+				status := ww.Status()
+				if status == 0 {
+					status = http.StatusOK
+				}
+
+				route := "unmatched"
+				if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+					route = rctx.RoutePattern()
+				}
+
+				metrics.ObserveRequest(route, r.Method, status, time.Since(start))
+			}()
+
+			next.ServeHTTP(ww, r)
+		})
+	}
+}
+
+// Metrics returns a function that writes all the metrics in the Prometheus
+// text format. It does not log, as it can be served outside of the Router
+func Metrics(metrics *service.Metrics) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", service.MetricsContentType)
+		// the write only fails if the client went away
+		metrics.Write(w)
+	}
+}
@@ -0,0 +1,43 @@
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/src-d/code-annotation/server/handler"
+	"github.com/src-d/code-annotation/server/service"
+
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestMetrics(t *testing.T) {
+	assert := assert.New(t)
+
+	metrics := service.NewMetrics()
+	r := chi.NewRouter()
+	r.Use(handler.RequestMetrics(metrics))
+	r.Get("/api/experiments/{experimentId}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	r.Get("/metrics", handler.Metrics(metrics))
+
+	for _, path := range []string{"/api/experiments/1", "/api/experiments/2", "/unknown"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(service.MetricsContentType, w.Header().Get("Content-Type"))
+
+	body := w.Body.String()
+	assert.Contains(body, `cat_http_request_duration_seconds_count{route="/api/experiments/{experimentId}",method="GET",status="404"} 2`)
+	assert.Contains(body, `cat_http_request_duration_seconds_count{route="unmatched",method="GET",status="404"} 1`)
+	assert.False(strings.Contains(body, "/api/experiments/1"))
+}
@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/src-d/code-annotation/server/repository"
+	"github.com/src-d/code-annotation/server/serializer"
+	"github.com/src-d/code-annotation/server/service"
+
+	"github.com/pressly/lg"
+)
+
+// ExperimentProgressWebSocket returns a function that upgrades the request to
+// a WebSocket, and sends through it the annotation results of the experiment,
+// with the same shape as GetFilePairAnnotations, every time one of its
+// assignments is answered. The current results are sent on connection, and
+// the connection is closed when the server shuts down. Only the requests from
+// the same host or from allowedOrigins are accepted, see newWebSocketUpgrader
+func ExperimentProgressWebSocket(
+	repo *repository.Assignments,
+	progress *service.Hub,
+	allowedOrigins []string,
+) http.HandlerFunc {
+	upgrader := newWebSocketUpgrader(allowedOrigins)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			write(w, r, nil, err)
+			return
+		}
+
+		updates, unsubscribe, err := progress.Subscribe(experimentID)
+		if err == service.ErrTooManySubscribers {
+			write(w, r, nil, serializer.NewHTTPError(http.StatusServiceUnavailable,
+				"too many progress subscribers, try again later"))
+			return
+		}
+		if err == service.ErrHubClosed {
+			write(w, r, nil, serializer.NewHTTPError(http.StatusServiceUnavailable,
+				"the server is shutting down"))
+			return
+		}
+		if err != nil {
+			write(w, r, nil, err)
+			return
+		}
+		defer unsubscribe()
+
+		current, err := experimentProgressUpdate(r.Context(), repo, experimentID)
+		if err != nil {
+			write(w, r, nil, err)
+			return
+		}
+
+		// the upgrader writes the error response if the handshake fails
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			lg.RequestLog(r).Warn(err.Error())
+			return
+		}
+		defer conn.Close()
+
+		closed := make(chan error, 1)
+		go discardWebSocketMessages(conn, closed)
+
+		var update interface{} = current
+		for {
+			content, err := json.Marshal(update)
+			if err != nil {
+				lg.RequestLog(r).Error(err.Error())
+				return
+			}
+
+			if err := writeWebSocketText(conn, content); err != nil {
+				return
+			}
+
+			var ok bool
+			select {
+			case <-closed:
+				return
+			case update, ok = <-updates:
+			}
+
+			// the hub is closed when the server is shutting down
+			if !ok {
+				closeWebSocketGoingAway(conn)
+				return
+			}
+		}
+	}
+}
+
+// experimentProgressUpdate returns the current annotation results of the
+// experiment, as sent by ExperimentProgressWebSocket
+func experimentProgressUpdate(ctx context.Context, repo *repository.Assignments, experimentID int) (*serializer.Response, error) {
+	totals, err := repo.GetAnswerTotals(ctx, experimentID)
+	if err != nil {
+		return nil, err
+	}
+
+	var data serializer.ExpAnnotationResponse
+	for answer, n := range totals {
+		data.Add(answer, n)
+	}
+
+	return serializer.NewExpAnnotationsResponse(data, serializer.MaybeSeparate), nil
+}
+
+// publishProgress sends the current annotation results of the experiment to
+// its progress subscribers, if any. Errors are logged but not returned, as
+// the answer was already saved
+func publishProgress(r *http.Request, progress *service.Hub, repo *repository.Assignments, experimentID int) {
+	if progress == nil || !progress.HasSubscribers(experimentID) {
+		return
+	}
+
+	update, err := experimentProgressUpdate(r.Context(), repo, experimentID)
+	if err != nil {
+		lg.RequestLog(r).Warn("experiment progress not published: " + err.Error())
+		return
+	}
+
+	progress.Publish(experimentID, update)
+}
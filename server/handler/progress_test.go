@@ -0,0 +1,94 @@
+package handler_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/gorilla/websocket"
+	"github.com/src-d/code-annotation/server/handler"
+	"github.com/src-d/code-annotation/server/model"
+	"github.com/src-d/code-annotation/server/repository"
+	"github.com/src-d/code-annotation/server/serializer"
+	"github.com/src-d/code-annotation/server/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExperimentProgressWebSocket(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t, &model.User{Login: "alice", Role: model.Worker})
+	repo := repository.NewAssignments(db.DB)
+	hub := service.NewProgressHub(1)
+
+	logger, err := service.NewLogger("production", service.LoggerConfig{})
+	assert.Nil(err)
+	logger.Out = ioutil.Discard
+
+	r := chi.NewRouter()
+	r.Use(handler.RequestLogger(logger))
+	r.Get("/ws/experiments/{experimentId}/progress",
+		handler.ExperimentProgressWebSocket(repo, hub, []string{"http://ui.example.com"}))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/experiments/1/progress"
+	dial := func(origin string) (*websocket.Conn, *http.Response, error) {
+		header := http.Header{}
+		if origin != "" {
+			header.Set("Origin", origin)
+		}
+
+		return websocket.DefaultDialer.Dial(url, header)
+	}
+
+	// only the same host and the allowed origins are accepted
+	_, res, err := dial("http://evil.example.com")
+	assert.Equal(websocket.ErrBadHandshake, err)
+	assert.Equal(http.StatusForbidden, res.StatusCode)
+
+	conn, _, err := dial("http://ui.example.com")
+	assert.Nil(err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	readUpdate := func() serializer.ExpAnnotationResponse {
+		var update struct {
+			Data serializer.ExpAnnotationResponse `json:"data"`
+		}
+		assert.Nil(conn.ReadJSON(&update))
+		return update.Data
+	}
+
+	assert.Equal(serializer.ExpAnnotationResponse{Unanswered: 2, Total: 2}, readUpdate())
+
+	// the hub only accepts one subscriber
+	req, _ := http.NewRequest("GET", server.URL+"/ws/experiments/1/progress", nil)
+	busy, err := http.DefaultClient.Do(req)
+	assert.Nil(err)
+	assert.Equal(http.StatusServiceUnavailable, busy.StatusCode)
+
+	answer := handler.SaveAssignment(repository.NewExperiments(db.DB), repo, time.Minute, time.Hour, 1000, hub, nil)
+	req, _ = http.NewRequest("PUT", "/experiments/1/assignments/1", strings.NewReader(`{"answer": "yes"}`))
+	_, err = answer(reqWithUser(chiRequest(req, map[string]string{"experimentId": "1", "assignmentId": "1"}), 1))
+	assert.Nil(err)
+
+	assert.Equal(serializer.ExpAnnotationResponse{
+		Yes: 1, Unanswered: 1, Total: 2, Answers: map[string]int{"yes": 1}}, readUpdate())
+
+	// the close message is answered
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	assert.Nil(conn.WriteMessage(websocket.CloseMessage, msg))
+
+	_, _, err = conn.ReadMessage()
+	assert.True(websocket.IsCloseError(err, websocket.CloseNormalClosure))
+
+	for i := 0; i < 50 && hub.HasSubscribers(1); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.False(hub.HasSubscribers(1))
+}
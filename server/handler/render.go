@@ -20,6 +20,7 @@ type RequestProcessFunc func(*http.Request) (*serializer.Response, error)
 func APIHandlerFunc(rp RequestProcessFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		response, err := rp(r)
+		logUserID(r)
 		if response == nil {
 			response = serializer.NewEmptyResponse()
 		}
@@ -31,6 +32,7 @@ func APIHandlerFunc(rp RequestProcessFunc) http.HandlerFunc {
 // write is the responsible of writing the response with the data from the passed *serializer.Response and error
 // If the passed error has StatusCode, the http.Response will be returned with the StatusCode of the passed error
 // If the passed error has not StatusCode, the http.Response will be returned as a http.StatusInternalServerError
+// If there is no error and the passed *serializer.Response has no content, the http.Response will be empty
 func write(w http.ResponseWriter, r *http.Request, response *serializer.Response, err error) {
 	var statusCode int
 
@@ -39,6 +41,11 @@ func write(w http.ResponseWriter, r *http.Request, response *serializer.Response
 		response = serializer.NewEmptyResponse()
 	}
 
+	if err == nil && response.Status == http.StatusNoContent {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
 	if err == nil {
 		statusCode = http.StatusOK
 	} else if httpError, ok := err.(serializer.HTTPError); ok {
@@ -82,3 +89,43 @@ func urlParamInt(r *http.Request, key string) (int, error) {
 
 	return val, err
 }
+
+// urlQueryInt returns the query parameter from an http.Request object. If the
+// param is not set, it returns defaultVal. If the param cannot be converted
+// to int, it returns a serializer.NewHTTPError
+func urlQueryInt(r *http.Request, key string, defaultVal int) (int, error) {
+	str := r.URL.Query().Get(key)
+	if str == "" {
+		return defaultVal, nil
+	}
+
+	val, err := strconv.Atoi(str)
+	if err != nil {
+		err = serializer.NewHTTPError(
+			http.StatusBadRequest,
+			fmt.Sprintf("Wrong format for query parameter %q; received %q", key, str))
+	}
+
+	return val, err
+}
+
+// paginationParams returns the limit and offset query parameters from an
+// http.Request object, using defaultLimit and 0 when they are not set
+func paginationParams(r *http.Request, defaultLimit int) (limit int, offset int, err error) {
+	limit, err = urlQueryInt(r, "limit", defaultLimit)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	offset, err = urlQueryInt(r, "offset", 0)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if limit <= 0 || offset < 0 {
+		return 0, 0, serializer.NewHTTPError(http.StatusBadRequest,
+			"limit must be greater than 0 and offset can not be negative")
+	}
+
+	return limit, offset, nil
+}
@@ -3,18 +3,25 @@ package handler
 import (
 	"bytes"
 	"encoding/json"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"path"
 
 	"github.com/src-d/code-annotation/server/assets"
 )
 
-// Static contains handlers to serve static using go-bindata
+// Static contains handlers to serve static using go-bindata, or from a
+// directory of the file system
 type Static struct {
 	dir     string
 	options options
+
+	asset     func(name string) ([]byte, error)
+	assetInfo func(name string) (os.FileInfo, error)
 }
 
-// NewStatic creates new Static
+// NewStatic creates new Static that serves the go-bindata assets under dir
 func NewStatic(dir, serverURL, gaTrackingID string) *Static {
 	return &Static{
 		dir: dir,
@@ -22,9 +29,22 @@ func NewStatic(dir, serverURL, gaTrackingID string) *Static {
 			ServerURL:    serverURL,
 			GaTrackingID: gaTrackingID,
 		},
+		asset:     assets.Asset,
+		assetInfo: assets.AssetInfo,
 	}
 }
 
+// NewStaticFromDir creates a new Static that serves the files of the given
+// directory of the file system, instead of the go-bindata assets. It is used
+// to deploy a frontend built separately
+func NewStaticFromDir(dir, serverURL, gaTrackingID string) *Static {
+	s := NewStatic(dir, serverURL, gaTrackingID)
+	s.asset = ioutil.ReadFile
+	s.assetInfo = os.Stat
+
+	return s
+}
+
 // struct which will be marshalled and exposed to frontend
 type options struct {
 	ServerURL    string      `json:"SERVER_URL"`
@@ -32,22 +52,24 @@ type options struct {
 	InitalState  interface{} `json:"initialState"`
 }
 
-// ServeHTTP serves any static file from static directory or fallbacks on index.hml
+// ServeHTTP serves any static file from static directory or fallbacks on
+// index.html, so the frontend routes are handled by the SPA. The path is
+// cleaned first, so no file outside of the static directory is served
 func (s *Static) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	filepath := s.dir + r.URL.Path
-	b, err := assets.Asset(filepath)
+	name := path.Join(s.dir, path.Clean("/"+r.URL.Path))
+	b, err := s.asset(name)
 	if err != nil {
 		s.ServeIndexHTML(nil)(w, r)
 		return
 	}
-	s.serveAsset(w, r, filepath, b)
+	s.serveAsset(w, r, name, b)
 }
 
 // ServeIndexHTML serves index.html file with initial state
 func (s *Static) ServeIndexHTML(initialState interface{}) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		filepath := s.dir + "/index.html"
-		b, err := assets.Asset(filepath)
+		name := path.Join(s.dir, "index.html")
+		b, err := s.asset(name)
 		if err != nil {
 			http.NotFound(w, r)
 			return
@@ -61,14 +83,15 @@ func (s *Static) ServeIndexHTML(initialState interface{}) http.HandlerFunc {
 			return
 		}
 		b = bytes.Replace(b, []byte("window.REPLACE_BY_SERVER"), bData, 1)
-		s.serveAsset(w, r, filepath, b)
+		s.serveAsset(w, r, name, b)
 	}
 }
 
-func (s *Static) serveAsset(w http.ResponseWriter, r *http.Request, filepath string, content []byte) {
-	info, err := assets.AssetInfo(filepath)
+func (s *Static) serveAsset(w http.ResponseWriter, r *http.Request, name string, content []byte) {
+	info, err := s.assetInfo(name)
 	if err != nil {
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
 	}
 	http.ServeContent(w, r, info.Name(), info.ModTime(), bytes.NewReader(content))
 }
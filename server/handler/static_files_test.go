@@ -0,0 +1,51 @@
+package handler_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/src-d/code-annotation/server/handler"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStaticFromDir(t *testing.T) {
+	assert := assert.New(t)
+
+	root, err := ioutil.TempDir("", "cat_test_static")
+	if err != nil {
+		t.Fatalf("can't create the static dir for test %s", err)
+	}
+	defer os.RemoveAll(root)
+
+	dir := filepath.Join(root, "build")
+	assert.Nil(os.MkdirAll(filepath.Join(dir, "static"), 0755))
+	assert.Nil(ioutil.WriteFile(filepath.Join(dir, "index.html"),
+		[]byte("<script>window.options = window.REPLACE_BY_SERVER</script>"), 0644))
+	assert.Nil(ioutil.WriteFile(filepath.Join(dir, "static", "app.js"), []byte("app()"), 0644))
+	assert.Nil(ioutil.WriteFile(filepath.Join(root, "secret.txt"), []byte("secret"), 0644))
+
+	static := handler.NewStaticFromDir(dir, "//cat.example.com", "")
+	index := `<script>window.options = {"SERVER_URL":"//cat.example.com","GA_TRACKING_ID":"","initialState":null}</script>`
+
+	for path, expected := range map[string]string{
+		"/static/app.js": "app()",
+		// the frontend routes get the index, to be handled by the SPA
+		"/":                        index,
+		"/experiments/1":           index,
+		"/static/":                 index,
+		"/static/../../secret.txt": index,
+	} {
+		req, _ := http.NewRequest("GET", "http://cat.example.com", nil)
+		req.URL.Path = path
+		w := httptest.NewRecorder()
+		static.ServeHTTP(w, req)
+
+		assert.Equal(http.StatusOK, w.Code, path)
+		assert.Equal(expected, w.Body.String(), path)
+	}
+}
@@ -1,31 +1,156 @@
 package handler
 
 import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 
+	"github.com/src-d/code-annotation/server/model"
 	"github.com/src-d/code-annotation/server/repository"
 	"github.com/src-d/code-annotation/server/serializer"
 	"github.com/src-d/code-annotation/server/service"
 )
 
 // Me handler returns a function that returns a *serializer.Response
-// with the information about the current user
+// with the information about the current user, including the role. If there
+// is no user in the request, or it does not exist anymore, it returns a
+// 401 Unauthorized error so the client asks for a new login
 func Me(usersRepo *repository.Users) RequestProcessFunc {
 	return func(r *http.Request) (*serializer.Response, error) {
 		userID, err := service.GetUserID(r.Context())
 		if err != nil {
-			return nil, err
+			return nil, serializer.NewHTTPError(http.StatusUnauthorized, err.Error())
 		}
 
-		u, err := usersRepo.GetByID(userID)
+		u, err := usersRepo.GetByID(r.Context(), userID)
 		if err != nil {
 			return nil, err
 		}
 
 		if u == nil {
-			return nil, serializer.NewHTTPError(http.StatusNotFound, "user not found")
+			return nil, serializer.NewHTTPError(http.StatusUnauthorized, "user not found")
 		}
 
 		return serializer.NewUserResponse(u), nil
 	}
 }
+
+const defaultUsersLimit = 50
+
+// GetUsers returns a function that returns a *serializer.Response with a page
+// of the list of users. The "role" query parameter lists only the users with
+// that role, and "q" the ones whose login or username contain it
+func GetUsers(repo *repository.Users) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		limit, offset, err := paginationParams(r, defaultUsersLimit)
+		if err != nil {
+			return nil, err
+		}
+
+		role := model.Role(r.URL.Query().Get("role"))
+		if role != "" && !role.IsValid() {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("invalid role %q", role))
+		}
+
+		term := r.URL.Query().Get("q")
+		users, err := repo.GetPaginated(r.Context(), role, term, limit, offset)
+		if err != nil {
+			return nil, err
+		}
+
+		total, err := repo.Count(r.Context(), role, term)
+		if err != nil {
+			return nil, err
+		}
+
+		return serializer.NewUsersResponse(users, total), nil
+	}
+}
+
+type updateUserRoleRequest struct {
+	Role model.Role `json:"role"`
+}
+
+// UpdateUserRole returns a function that changes the role of the requested
+// user to the one passed in the body request, and returns the updated user.
+// The last requester can not be made a worker. The role is set again from
+// the GitHub access rules when the user logs in, see service.OAuth
+func UpdateUserRole(repo *repository.Users) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		userID, err := urlParamInt(r, "userId")
+		if err != nil {
+			return nil, err
+		}
+
+		var req updateUserRoleRequest
+		body, err := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+		if err == nil {
+			err = json.Unmarshal(body, &req)
+		}
+
+		if err != nil {
+			return nil, bodyError(err)
+		}
+
+		if !req.Role.IsValid() {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("invalid role %q", req.Role))
+		}
+
+		user, err := repo.GetByID(r.Context(), userID)
+		if err != nil {
+			return nil, err
+		}
+
+		if user == nil {
+			return nil, serializer.NewHTTPError(http.StatusNotFound, "user not found")
+		}
+
+		updated, err := repo.UpdateRole(r.Context(), userID, req.Role)
+		if err != nil {
+			return nil, err
+		}
+
+		if !updated {
+			return nil, serializer.NewHTTPError(http.StatusConflict,
+				"the last requester can not be made a worker")
+		}
+
+		user.Role = req.Role
+		return serializer.NewUserResponse(user), nil
+	}
+}
+
+const defaultLeaderboardLimit = 20
+
+// GetLeaderboard returns a function that returns a *serializer.Response with
+// the users that answered the most assignments, sorted from the most to the
+// least. The "experimentId" query parameter restricts the count to an
+// experiment, and "limit" sets the max number of users listed
+func GetLeaderboard(repo *repository.Assignments) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlQueryInt(r, "experimentId", 0)
+		if err != nil {
+			return nil, err
+		}
+
+		limit, err := urlQueryInt(r, "limit", defaultLeaderboardLimit)
+		if err != nil {
+			return nil, err
+		}
+
+		if limit <= 0 {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest, "limit must be greater than 0")
+		}
+
+		entries, err := repo.GetLeaderboard(r.Context(), experimentID, limit)
+		if err != nil {
+			return nil, err
+		}
+
+		return serializer.NewLeaderboardResponse(entries), nil
+	}
+}
@@ -0,0 +1,174 @@
+package handler_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/src-d/code-annotation/server/handler"
+	"github.com/src-d/code-annotation/server/model"
+	"github.com/src-d/code-annotation/server/repository"
+	"github.com/src-d/code-annotation/server/serializer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMe(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDB()
+	repo := repository.NewUsers(db.DB)
+	handler := handler.Me(repo)
+
+	alice := &model.User{Login: "alice", Username: "Alice", Role: model.Requester}
+	assert.Nil(repo.Create(context.Background(), alice))
+
+	req, _ := http.NewRequest("GET", "/me", nil)
+	res, err := handler(reqWithUser(req, alice.ID))
+	assert.Nil(err)
+	assert.Equal(serializer.NewUserResponse(alice), res)
+
+	res, err = handler(reqWithUser(req, 9))
+	assert.Nil(res)
+	assert.Equal(http.StatusUnauthorized, err.(serializer.HTTPError).StatusCode())
+
+	res, err = handler(req)
+	assert.Nil(res)
+	assert.Equal(http.StatusUnauthorized, err.(serializer.HTTPError).StatusCode())
+}
+
+func TestGetUsers(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDB()
+	repo := repository.NewUsers(db.DB)
+	handler := handler.GetUsers(repo)
+
+	users := []*model.User{
+		{Login: "alice", Username: "Alice Smith", Role: model.Worker},
+		{Login: "bob", Username: "Bob", Role: model.Requester},
+		{Login: "carol", Username: "Carol", Role: model.Worker},
+	}
+	for _, u := range users {
+		assert.Nil(repo.Create(context.Background(), u))
+	}
+	alice, bob, carol := users[0], users[1], users[2]
+
+	for query, expected := range map[string]*serializer.Response{
+		"":                     serializer.NewUsersResponse([]*model.User{alice, bob, carol}, 3),
+		"?role=worker":         serializer.NewUsersResponse([]*model.User{alice, carol}, 2),
+		"?q=SMI":               serializer.NewUsersResponse([]*model.User{alice}, 1),
+		"?q=o&role=worker":     serializer.NewUsersResponse([]*model.User{carol}, 1),
+		"?q=%25":               serializer.NewUsersResponse([]*model.User{}, 0),
+		"?limit=1&offset=1":    serializer.NewUsersResponse([]*model.User{bob}, 3),
+		"?role=requester&q=bo": serializer.NewUsersResponse([]*model.User{bob}, 1),
+	} {
+		req, _ := http.NewRequest("GET", "/users"+query, nil)
+		res, err := handler(req)
+		assert.Nil(err, query)
+		assert.Equal(expected, res, query)
+	}
+
+	for _, query := range []string{"?role=admin", "?limit=0"} {
+		req, _ := http.NewRequest("GET", "/users"+query, nil)
+		res, err := handler(req)
+		assert.Nil(res)
+		assert.Equal(http.StatusBadRequest, err.(serializer.HTTPError).StatusCode(), query)
+	}
+}
+
+func TestUpdateUserRole(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDB()
+	repo := repository.NewUsers(db.DB)
+	handler := handler.UpdateUserRole(repo)
+
+	alice := &model.User{Login: "alice", Role: model.Requester}
+	bob := &model.User{Login: "bob", Role: model.Worker}
+	for _, u := range []*model.User{alice, bob} {
+		assert.Nil(repo.Create(context.Background(), u))
+	}
+
+	update := func(userID, body string) (*serializer.Response, error) {
+		req, _ := http.NewRequest("PUT", "/users/"+userID+"/role", strings.NewReader(body))
+		req = chiRequest(req, map[string]string{"userId": userID})
+		return handler(req)
+	}
+
+	res, err := update("2", `{"role": "requester"}`)
+	assert.Nil(err)
+	assert.Equal(serializer.NewUserResponse(&model.User{
+		ID: 2, Login: "bob", Role: model.Requester}), res)
+
+	res, err = update("1", `{"role": "worker"}`)
+	assert.Nil(err)
+	assert.Equal(serializer.NewUserResponse(&model.User{
+		ID: 1, Login: "alice", Role: model.Worker}), res)
+
+	// bob is the last requester
+	res, err = update("2", `{"role": "worker"}`)
+	assert.Nil(res)
+	assert.Equal(http.StatusConflict, err.(serializer.HTTPError).StatusCode())
+
+	user, err := repo.GetByID(context.Background(), 2)
+	assert.Nil(err)
+	assert.Equal(model.Requester, user.Role)
+
+	// but the role can be set again
+	_, err = update("2", `{"role": "requester"}`)
+	assert.Nil(err)
+
+	for _, body := range []string{`{"role": "admin"}`, `{}`, `{"role": 1}`} {
+		res, err = update("1", body)
+		assert.Nil(res)
+		assert.Equal(http.StatusBadRequest, err.(serializer.HTTPError).StatusCode(), body)
+	}
+
+	res, err = update("9", `{"role": "worker"}`)
+	assert.Nil(res)
+	assert.Equal(http.StatusNotFound, err.(serializer.HTTPError).StatusCode())
+}
+
+func TestGetLeaderboard(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDBWithPairs(t,
+		&model.User{Login: "alice", Role: model.Worker},
+		&model.User{Login: "bob", Role: model.Worker},
+		&model.User{Login: "carol", Role: model.Worker},
+	)
+	repo := repository.NewAssignments(db.DB)
+	handler := handler.GetLeaderboard(repo)
+
+	// alice answers 1, bob 2 and carol none
+	for _, id := range []int{1, 3, 4} {
+		assert.Nil(repo.Update(context.Background(), id, "yes", 10))
+	}
+
+	leaderboard := func(query string) (*serializer.Response, error) {
+		req, _ := http.NewRequest("GET", "/leaderboard"+query, nil)
+		return handler(req)
+	}
+
+	res, err := leaderboard("")
+	assert.Nil(err)
+	assert.Equal(serializer.NewLeaderboardResponse([]*model.LeaderboardEntry{
+		{UserID: 2, Login: "bob", Completed: 2},
+		{UserID: 1, Login: "alice", Completed: 1},
+	}), res)
+
+	res, err = leaderboard("?limit=1&experimentId=1")
+	assert.Nil(err)
+	assert.Equal(serializer.NewLeaderboardResponse([]*model.LeaderboardEntry{
+		{UserID: 2, Login: "bob", Completed: 2},
+	}), res)
+
+	res, err = leaderboard("?experimentId=2")
+	assert.Nil(err)
+	assert.Equal(serializer.NewLeaderboardResponse([]*model.LeaderboardEntry{}), res)
+
+	res, err = leaderboard("?limit=0")
+	assert.Nil(res)
+	assert.Equal(serializer.NewHTTPError(http.StatusBadRequest, "limit must be greater than 0"), err)
+}
@@ -2,14 +2,23 @@ package handler
 
 import (
 	"net/http"
+	"runtime"
 
 	"github.com/src-d/code-annotation/server/serializer"
 )
 
+// BuildInfo holds the metadata of the server build
+type BuildInfo struct {
+	Version   string
+	GitCommit string
+	BuildTime string
+}
+
 // Version returns a function that returns a *serializer.Response
 // with a current version of server
-func Version(version string) RequestProcessFunc {
+func Version(info BuildInfo) RequestProcessFunc {
 	return func(r *http.Request) (*serializer.Response, error) {
-		return serializer.NewVersionResponse(version), nil
+		return serializer.NewVersionResponse(
+			info.Version, info.GitCommit, info.BuildTime, runtime.Version()), nil
 	}
 }
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// maxWebSocketReadPayload is the max size of the messages accepted from
+// clients. They are discarded anyway, so they are expected to be small
+const maxWebSocketReadPayload = 4096
+
+// websocketWriteTimeout is the time given to write a message to a client
+const websocketWriteTimeout = 10 * time.Second
+
+// newWebSocketUpgrader returns the websocket.Upgrader of the WebSocket
+// handlers. Besides the requests from the same host, it accepts the ones from
+// the allowed origins, as set in service.CORSConfig; "*" allows any origin
+func newWebSocketUpgrader(allowedOrigins []string) *websocket.Upgrader {
+	origins := trimAll(allowedOrigins)
+
+	return &websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			return allowedWebSocketOrigin(r, origins)
+		},
+	}
+}
+
+// allowedWebSocketOrigin returns true if the request has no Origin header, as
+// it does not come from a browser, or if it comes from the same host or from
+// one of the allowed origins
+func allowedWebSocketOrigin(r *http.Request, allowedOrigins []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+
+	for _, allowed := range allowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// discardWebSocketMessages reads the messages sent by the client until the
+// connection is closed, so the pings and close messages are answered, and
+// sends the error that ended it to closed. The data messages are discarded
+func discardWebSocketMessages(conn *websocket.Conn, closed chan<- error) {
+	conn.SetReadLimit(maxWebSocketReadPayload)
+	for {
+		if _, _, err := conn.NextReader(); err != nil {
+			closed <- err
+			return
+		}
+	}
+}
+
+// writeWebSocketText sends the data in a text message
+func writeWebSocketText(conn *websocket.Conn, data []byte) error {
+	conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+	return conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// closeWebSocketGoingAway tells the client that the server is going away
+func closeWebSocketGoingAway(conn *websocket.Conn) error {
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "")
+	return conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(websocketWriteTimeout))
+}
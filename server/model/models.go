@@ -3,7 +3,12 @@ package model
 import (
 	"database/sql"
 	"database/sql/driver"
+	"encoding/binary"
 	"errors"
+	"hash/fnv"
+	"math/rand"
+	"strings"
+	"time"
 )
 
 // User of the application; can be Requester or Workers
@@ -20,6 +25,151 @@ type Experiment struct {
 	ID          int
 	Name        string
 	Description string
+	DeletedAt   *time.Time // nil unless the Experiment was soft-deleted
+	// OutlierThreshold is the duration, in milliseconds, above which an answer
+	// is considered an outlier. If it is nil, the global default is used
+	OutlierThreshold *int
+	Status           ExperimentStatus
+	// AssignmentStrategy sets the order of the FilePairs assigned to each
+	// user. AssignmentSeed makes the random order reproducible
+	AssignmentStrategy AssignmentStrategy
+	AssignmentSeed     int64
+	// Tags are the lowercase labels of the Experiment, sorted
+	Tags []string
+	// Version is increased on every update of the Experiment, to detect the
+	// concurrent ones
+	Version   int
+	CreatedAt *time.Time
+	UpdatedAt *time.Time // time of the last change of its settings or status
+	// Deadline is the time after which the Assignments can not be answered
+	// anymore. If it is nil, they can be answered at any time
+	Deadline *time.Time
+	// Answers are the accepted answers of its Assignments. If it is empty,
+	// DefaultAnswers are used
+	Answers []string
+}
+
+// ExperimentStatus tells if the Assignments of an Experiment can be answered
+type ExperimentStatus string
+
+const (
+	// ExperimentActive is the status of an Experiment that can be answered
+	ExperimentActive ExperimentStatus = "active"
+	// ExperimentFrozen is the status of a read-only Experiment
+	ExperimentFrozen ExperimentStatus = "frozen"
+)
+
+// AssignmentStrategy defines the order in which the FilePairs of an
+// Experiment are assigned to every user
+type AssignmentStrategy string
+
+const (
+	// AssignmentSequential assigns the FilePairs in the order they were added
+	AssignmentSequential AssignmentStrategy = "sequential"
+	// AssignmentRandom assigns the FilePairs in a different random order for
+	// every user
+	AssignmentRandom AssignmentStrategy = "random"
+)
+
+// IsValid returns true if s is a known AssignmentStrategy
+func (s AssignmentStrategy) IsValid() bool {
+	return s == AssignmentSequential || s == AssignmentRandom
+}
+
+// OrderPairs returns the given FilePair IDs in the order they must be
+// assigned to the given user. With AssignmentRandom the order is shuffled,
+// and always the same for a given AssignmentSeed and user
+func (e *Experiment) OrderPairs(userID int, pairIDs []int) []int {
+	ordered := make([]int, len(pairIDs))
+	copy(ordered, pairIDs)
+
+	if e.AssignmentStrategy != AssignmentRandom {
+		return ordered
+	}
+
+	rnd := rand.New(rand.NewSource(pairOrderSeed(e.AssignmentSeed, userID)))
+	for i := len(ordered) - 1; i > 0; i-- {
+		j := rnd.Intn(i + 1)
+		ordered[i], ordered[j] = ordered[j], ordered[i]
+	}
+
+	return ordered
+}
+
+// pairOrderSeed mixes the assignment seed and the user ID with a hash, so
+// close seeds do not give the order of another user
+func pairOrderSeed(seed int64, userID int) int64 {
+	var buf [16]byte
+	binary.BigEndian.PutUint64(buf[:8], uint64(seed))
+	binary.BigEndian.PutUint64(buf[8:], uint64(userID))
+
+	h := fnv.New64a()
+	h.Write(buf[:])
+	return int64(h.Sum64())
+}
+
+// IsFrozen returns true if the Experiment is read-only
+func (e *Experiment) IsFrozen() bool {
+	return e.Status == ExperimentFrozen
+}
+
+// IsClosed returns true if the Deadline of the Experiment has passed
+func (e *Experiment) IsClosed() bool {
+	return e.Deadline != nil && !time.Now().Before(*e.Deadline)
+}
+
+// IsOutlier returns true if the given answer duration is above the
+// OutlierThreshold of the Experiment, or above defaultThreshold if it has
+// none. Both thresholds are in milliseconds, and 0 disables the check
+func (e *Experiment) IsOutlier(duration, defaultThreshold int) bool {
+	threshold := defaultThreshold
+	if e.OutlierThreshold != nil {
+		threshold = *e.OutlierThreshold
+	}
+
+	return threshold > 0 && duration > threshold
+}
+
+// AnswerScheme returns the accepted answers of the Experiment Assignments
+func (e *Experiment) AnswerScheme() []string {
+	if len(e.Answers) == 0 {
+		return DefaultAnswers
+	}
+
+	return e.Answers
+}
+
+// IsValidAnswer returns true if the given answer is accepted by the
+// Experiment answer scheme
+func (e *Experiment) IsValidAnswer(answer string) bool {
+	for _, a := range e.AnswerScheme() {
+		if a == answer {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsDeleted returns true if the Experiment was soft-deleted
+func (e *Experiment) IsDeleted() bool {
+	return e.DeletedAt != nil
+}
+
+// APIKey gives programmatic access to an Experiment, acting as its User. Only
+// the hash of the key is stored, so the key itself can not be retrieved
+type APIKey struct {
+	ID           int
+	UserID       int
+	ExperimentID int
+	Name         string
+	CreatedAt    *time.Time
+	RevokedAt    *time.Time // nil while the key can be used
+}
+
+// IsRevoked returns true if the APIKey can not be used anymore
+func (k *APIKey) IsRevoked() bool {
+	return k.RevokedAt != nil
 }
 
 // Assignment tracks the answer of a worker to a given FilePair of an Experiment
@@ -30,6 +180,23 @@ type Assignment struct {
 	ExperimentID int
 	Answer       sql.NullString
 	Duration     int
+	CreatedAt    *time.Time
+	UpdatedAt    *time.Time // time of the last answer, nil if never answered
+	Outlier      bool       // true if the answer duration is too long to be trusted
+	// Confidence is reported by the user with the answer, from MinConfidence
+	// to MaxConfidence. It is nil if the user did not report it
+	Confidence *int
+	// Comment is an optional explanation of the answer by the user
+	Comment *string
+	// Flagged is set by the user to ask a supervisor to review the file pair,
+	// whether it is answered or not
+	Flagged bool
+	// SessionID identifies the annotation session of the user, as reported
+	// by the client with the last answer. It is nil if none was reported
+	SessionID *string
+	// PendingDuration is the time spent on the assignment, in milliseconds,
+	// reported by the client before answering it. It is nil once answered
+	PendingDuration *int
 }
 
 // AnswerStr returns the string value, using "" if it's not set
@@ -41,6 +208,128 @@ func (a *Assignment) AnswerStr() string {
 	return ""
 }
 
+// Annotation is an Assignment with the data of its User and FilePair needed
+// to export it
+type Annotation struct {
+	Assignment
+	Login     string
+	LeftPath  string
+	RightPath string
+}
+
+// UserProgress holds how many Assignments of an Experiment a User has, and
+// how many of them are answered
+type UserProgress struct {
+	UserID    int
+	Login     string
+	Completed int
+	Total     int
+}
+
+// Progress returns the percentage of completed Assignments
+func (p *UserProgress) Progress() float32 {
+	if p.Total == 0 {
+		return 0
+	}
+
+	return 100.0 * float32(p.Completed) / float32(p.Total)
+}
+
+// LeaderboardEntry holds how many Assignments a User has answered
+type LeaderboardEntry struct {
+	UserID    int
+	Login     string
+	Completed int
+}
+
+// AnswerCount holds how many Assignments were answered with Answer at Time
+type AnswerCount struct {
+	Time   time.Time
+	Answer string
+	Count  int
+}
+
+// PairSkipRate holds how many of the answers to a FilePair were skipped
+type PairSkipRate struct {
+	PairID  int
+	Skips   int
+	Answers int
+}
+
+// Rate returns the fraction of skipped answers, or 0 if there are none
+func (s *PairSkipRate) Rate() float64 {
+	if s.Answers == 0 {
+		return 0
+	}
+
+	return float64(s.Skips) / float64(s.Answers)
+}
+
+// Session holds how many answers a User gave to an Experiment in one
+// annotation session, and how long they took in total, in milliseconds
+type Session struct {
+	ID       string
+	Answers  int
+	Duration int
+}
+
+// AnswerChange is an answer given to an Assignment, kept in its history even
+// after it is replaced by a new one. Duration is in milliseconds. Answer is
+// empty when the answers of the user were reset
+type AnswerChange struct {
+	AssignmentID int
+	UserID       int
+	Answer       string
+	Duration     int
+	ChangedAt    time.Time
+}
+
+// QualityScore holds how many answers of a User to the gold standard
+// FilePairs of an Experiment, those with a known answer, were evaluated and
+// how many of them were correct
+type QualityScore struct {
+	Evaluated int
+	Correct   int
+}
+
+// Accuracy returns the ratio of correct answers, or nil if none was evaluated
+func (q QualityScore) Accuracy() *float64 {
+	if q.Evaluated == 0 {
+		return nil
+	}
+
+	accuracy := float64(q.Correct) / float64(q.Evaluated)
+	return &accuracy
+}
+
+// PairConsensus holds how many annotators gave each answer to a FilePair.
+// Votes is the total of them
+type PairConsensus struct {
+	PairID  int
+	Answers map[string]int
+	Votes   int
+}
+
+// Majority returns the answer given by most annotators. If several answers
+// share the highest count there is no majority, and tie is true
+func (c *PairConsensus) Majority() (answer string, tie bool) {
+	best := 0
+	for a, n := range c.Answers {
+		switch {
+		case n > best:
+			answer, best, tie = a, n, false
+		case n == best:
+			tie = true
+		}
+	}
+
+	if tie {
+		return "", true
+	}
+
+	return answer, false
+}
+
 // FilePair represents the pairs of files to annotate
 type FilePair struct {
 	ID           int
@@ -48,6 +337,18 @@ type FilePair struct {
 	ExperimentID int
 	Left         File
 	Right        File
+	// Base is the common ancestor of Left and Right in three-way pairs. It is
+	// nil for the regular, two-way, pairs. Only its BlobID, Path and Content
+	// are stored
+	Base *File
+}
+
+// FilePairStats holds aggregates of the FilePairs of an Experiment. LOC is
+// the total of lines of code of both files of every pair
+type FilePairStats struct {
+	Count     int
+	LOC       int
+	MeanScore float64
 }
 
 // File contains the info of a File
@@ -59,6 +360,20 @@ type File struct {
 	Content      string
 	UAST         []byte
 	Hash         string
+	// Purged is true if the Content and UAST of the File were deleted to save
+	// space. Its lines of code are kept in PurgedLOC
+	Purged    bool
+	PurgedLOC int
+}
+
+// LOC returns the number of lines of code of the File, as the number of line
+// breaks of its Content plus one
+func (f *File) LOC() int {
+	if f.Purged {
+		return f.PurgedLOC
+	}
+
+	return strings.Count(f.Content, "\n") + 1
 }
 
 // Feature represents one name-value feature of file
@@ -77,7 +392,7 @@ func (r Role) String() string {
 
 // Value returns the string value of the Role
 func (r Role) Value() (driver.Value, error) {
-	if isValidRole(r) {
+	if r.IsValid() {
 		return string(r), nil
 	}
 
@@ -94,7 +409,7 @@ func (r *Role) Scan(value interface{}) error {
 		role = v
 	}
 
-	if role != "" && isValidRole(Role(role)) {
+	if role != "" && Role(role).IsValid() {
 		*r = Role(role)
 		return nil
 	}
@@ -102,7 +417,8 @@ func (r *Role) Scan(value interface{}) error {
 	return errors.New("can't scan a valid Role")
 }
 
-func isValidRole(r Role) bool {
+// IsValid returns true if r is a known Role
+func (r Role) IsValid() bool {
 	for _, role := range []Role{Worker, Requester} {
 		if r == role {
 			return true
@@ -119,10 +435,23 @@ const (
 	Worker Role = "worker"
 )
 
-// Answers lists the accepted answers
-var Answers = map[string]string{
-	"yes":   "yes",
-	"maybe": "maybe",
-	"no":    "no",
-	"skip":  "skip",
+// DefaultAnswers are the accepted answers of the Experiments without their
+// own answer scheme
+var DefaultAnswers = []string{"yes", "maybe", "no", "skip"}
+
+// IsValidAnswer returns true if the given answer is one of the DefaultAnswers
+func IsValidAnswer(answer string) bool {
+	return (&Experiment{}).IsValidAnswer(answer)
+}
+
+// Range of the confidence reported with an answer
+const (
+	MinConfidence = 1
+	MaxConfidence = 5
+)
+
+// IsValidConfidence returns true if the given confidence is nil or it is in
+// the accepted range
+func IsValidConfidence(confidence *int) bool {
+	return confidence == nil || (*confidence >= MinConfidence && *confidence <= MaxConfidence)
 }
@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/src-d/code-annotation/server/model"
+)
+
+// APIKeys repository
+type APIKeys struct {
+	db *sql.DB
+}
+
+// NewAPIKeys returns a new APIKeys repository
+func NewAPIKeys(db *sql.DB) *APIKeys {
+	return &APIKeys{db: db}
+}
+
+const (
+	selectAPIKeysColumns = `SELECT id, user_id, experiment_id, name, created_at, revoked_at FROM api_keys`
+
+	insertAPIKeySQL = `INSERT INTO api_keys (user_id, experiment_id, name, key_hash, created_at)
+		VALUES ($1, $2, $3, $4, $5)`
+	selectAPIKeyIDWhereHashSQL     = `SELECT id FROM api_keys WHERE key_hash=$1`
+	selectActiveAPIKeyWhereHashSQL = selectAPIKeysColumns + ` WHERE key_hash=$1 AND revoked_at IS NULL`
+	selectAPIKeysWhereExpSQL       = selectAPIKeysColumns + ` WHERE experiment_id=$1 ORDER BY id`
+	revokeAPIKeySQL                = `UPDATE api_keys SET revoked_at=$1
+		WHERE id=$2 AND experiment_id=$3 AND revoked_at IS NULL`
+)
+
+// Create stores the APIKey with the hash of its key, and sets its ID and
+// creation time
+func (repo *APIKeys) Create(ctx context.Context, k *model.APIKey, hash string) error {
+	now := time.Now().UTC()
+	_, err := repo.db.ExecContext(ctx, insertAPIKeySQL, k.UserID, k.ExperimentID, k.Name, hash, now)
+	if err != nil {
+		return fmt.Errorf("DB error: %v", err)
+	}
+
+	// the hash is unique, and it is used instead of LastInsertId for the
+	// same reasons as experimentIDByName
+	var id int
+	if err := repo.db.QueryRowContext(ctx, selectAPIKeyIDWhereHashSQL, hash).Scan(&id); err != nil {
+		return fmt.Errorf("DB error: %v", err)
+	}
+
+	k.ID = id
+	k.CreatedAt = &now
+	k.RevokedAt = nil
+
+	return nil
+}
+
+// getWithQuery builds an APIKey from the given sql Row or Rows. If the APIKey
+// does not exist, it returns nil, nil
+func (repo *APIKeys) getWithQuery(queryRow scannable) (*model.APIKey, error) {
+	var k model.APIKey
+
+	err := queryRow.Scan(&k.ID, &k.UserID, &k.ExperimentID, &k.Name, &k.CreatedAt, &k.RevokedAt)
+
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil
+	case err != nil:
+		return nil, fmt.Errorf("Error getting API key from the DB: %v", err)
+	default:
+		return &k, nil
+	}
+}
+
+// GetActiveByHash returns the APIKey not revoked yet with the given hash of its
+// key. If there is no such APIKey, it returns nil, nil
+func (repo *APIKeys) GetActiveByHash(ctx context.Context, hash string) (*model.APIKey, error) {
+	return repo.getWithQuery(repo.db.QueryRowContext(ctx, selectActiveAPIKeyWhereHashSQL, hash))
+}
+
+// GetAll returns all the APIKeys of the given experiment, including the
+// revoked ones, ordered by ID
+func (repo *APIKeys) GetAll(ctx context.Context, experimentID int) ([]*model.APIKey, error) {
+	rows, err := repo.db.QueryContext(ctx, selectAPIKeysWhereExpSQL, experimentID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting API keys from the DB: %v", err)
+	}
+	defer rows.Close()
+
+	results := make([]*model.APIKey, 0)
+
+	for rows.Next() {
+		k, err := repo.getWithQuery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("DB error: %v", err)
+		}
+
+		results = append(results, k)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("DB error: %v", err)
+	}
+
+	return results, nil
+}
+
+// Revoke sets the revocation time of the APIKey with the given ID and
+// experiment, so it can not be used anymore. It returns false if there is no
+// such APIKey, or if it was already revoked
+func (repo *APIKeys) Revoke(ctx context.Context, experimentID, id int) (bool, error) {
+	res, err := repo.db.ExecContext(ctx, revokeAPIKeySQL, time.Now().UTC(), id, experimentID)
+	if err != nil {
+		return false, fmt.Errorf("DB error: %v", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("DB error: %v", err)
+	}
+
+	return n > 0, nil
+}
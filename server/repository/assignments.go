@@ -1,8 +1,13 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/src-d/code-annotation/server/model"
 )
@@ -10,6 +15,11 @@ import (
 // Assignments repository
 type Assignments struct {
 	db *sql.DB
+
+	// commentsIndex tells if the DB has the full-text index of the comments.
+	// It is nil until the first search
+	commentsIndexMu sync.Mutex
+	commentsIndex   *bool
 }
 
 // NewAssignments returns a new Assignments repository
@@ -18,20 +28,60 @@ func NewAssignments(db *sql.DB) *Assignments {
 }
 
 const (
-	insertAssignmentsSQL             = `INSERT INTO assignments (user_id, pair_id, experiment_id, answer, duration) VALUES ($1, $2, $3, $4, $5)`
-	selectIDFilePairsSQL             = `SELECT id FROM file_pairs WHERE experiment_id=$1 AND id NOT IN (SELECT pair_id FROM assignments WHERE experiment_id=$1 AND user_id=$2)`
-	selectAssignmentsSQL             = `SELECT * FROM assignments WHERE user_id=$1 AND experiment_id=$2`
-	selectAssignmentsWhereExpPairSQL = `SELECT * FROM assignments WHERE experiment_id=$1 AND pair_id=$2`
-	updateAssignmentsSQL             = `UPDATE assignments SET answer='%v', duration=%v WHERE id=%v`
+	selectAssignmentsColumns = `SELECT
+		id, user_id, pair_id, experiment_id, answer, duration, created_at, updated_at, outlier,
+		confidence, comment, flagged, session_id, pending_duration FROM assignments`
+
+	insertAssignmentsSQL             = `INSERT INTO assignments (user_id, pair_id, experiment_id, answer, duration, created_at) VALUES ($1, $2, $3, $4, $5, $6)`
+	selectIDFilePairsSQL             = `SELECT id FROM file_pairs WHERE experiment_id=$1 AND id NOT IN (SELECT pair_id FROM assignments WHERE experiment_id=$1 AND user_id=$2) ORDER BY id`
+	selectAssignmentsWhereIDSQL      = selectAssignmentsColumns + ` WHERE id=$1`
+	selectAssignmentsSQL             = selectAssignmentsColumns + ` WHERE user_id=$1 AND experiment_id=$2`
+	selectAssignmentsWhereExpPairSQL = selectAssignmentsColumns + ` WHERE experiment_id=$1 AND pair_id=$2`
+	updateAssignmentsSQL             = `UPDATE assignments SET answer=$1, duration=$2, updated_at=$3, outlier=$4, confidence=$5, comment=$6, session_id=$7, pending_duration=NULL WHERE id=$8`
 	countPendingIDsSQL               = `SELECT count(id) FROM file_pairs WHERE experiment_id=$1 AND id NOT IN (SELECT pair_id FROM assignments WHERE experiment_id=$1 AND user_id=$2)`
 	countUserAssigmentsSQL           = `SELECT COUNT(*) FROM assignments WHERE experiment_id=$1 AND user_id=$2`
 	countCompleteUserAssigmentsSQL   = `SELECT COUNT(*) FROM assignments WHERE experiment_id=$1 AND user_id=$2 AND answer IS NOT null`
+	countAllUserAssigmentsSQL        = `SELECT COUNT(*), COUNT(answer) FROM assignments WHERE experiment_id=$1 AND user_id=$2`
+	selectAnnotationsSQL             = `SELECT
+		a.id, a.user_id, a.pair_id, a.experiment_id, a.answer, a.duration, a.comment,
+		u.login, fp.path_a, fp.path_b
+		FROM assignments a
+		JOIN users u ON u.id = a.user_id
+		JOIN file_pairs fp ON fp.id = a.pair_id
+		WHERE a.experiment_id=$1
+		ORDER BY a.id`
+	selectAssignedPairsSQL   = `SELECT user_id, pair_id FROM assignments WHERE experiment_id=$1`
+	bulkInsertAssignmentsSQL = `INSERT INTO assignments (user_id, pair_id, experiment_id, answer, duration, created_at) VALUES `
+	selectUsersProgressSQL   = `SELECT a.user_id, u.login, COUNT(a.answer), COUNT(*)
+		FROM assignments a
+		JOIN users u ON u.id = a.user_id
+		WHERE a.experiment_id=$1
+		GROUP BY a.user_id, u.login`
+)
+
+// The assignments of an experiment are managed once they are created, moved or
+// deleted explicitly; from then on they are never initialized automatically
+const (
+	selectAssignmentsManagedSQL = `SELECT assignments_managed FROM experiments WHERE id=$1`
+	updateAssignmentsManagedSQL = `UPDATE experiments SET assignments_managed=$1 WHERE id=$2`
 )
 
 // IsInitialized returns true if the assignments are initialized for the given
-// user and experiment IDs. If it's false, Initialize should be called
-func (repo *Assignments) IsInitialized(userID, experimentID int) (bool, error) {
-	row := repo.db.QueryRow(countPendingIDsSQL, experimentID, userID)
+// user and experiment IDs. If it's false, Initialize should be called. The
+// experiments whose assignments are managed, with CreateBatch, Reassign or
+// Delete, are always initialized, so the users only get the existing ones
+func (repo *Assignments) IsInitialized(ctx context.Context, userID, experimentID int) (bool, error) {
+	var managed sql.NullBool
+	err := repo.db.QueryRowContext(ctx, selectAssignmentsManagedSQL, experimentID).Scan(&managed)
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+
+	if managed.Valid && managed.Bool {
+		return true, nil
+	}
+
+	row := repo.db.QueryRowContext(ctx, countPendingIDsSQL, experimentID, userID)
 
 	var count int
 	if err := row.Scan(&count); err != nil {
@@ -41,31 +91,61 @@ func (repo *Assignments) IsInitialized(userID, experimentID int) (bool, error) {
 	return count == 0, nil
 }
 
-// Initialize builds the assignments for the given user and experiment IDs
-func (repo *Assignments) Initialize(userID int, experimentID int) (int, error) {
-	tx, err := repo.db.Begin()
+// setManaged marks the assignments of the experiment as managed, see IsInitialized
+func setManaged(ctx context.Context, tx *sql.Tx, experimentID int) error {
+	if _, err := tx.ExecContext(ctx, updateAssignmentsManagedSQL, true, experimentID); err != nil {
+		return fmt.Errorf("DB error: %v", err)
+	}
+
+	return nil
+}
+
+// Initialize builds the assignments for the given user and experiment IDs,
+// following the assignment strategy of the experiment
+func (repo *Assignments) Initialize(ctx context.Context, userID int, experimentID int) (int, error) {
+	rows, err := repo.db.QueryContext(ctx, selectIDFilePairsSQL, experimentID, userID)
+	if err != nil {
+		return 0, fmt.Errorf("Error getting file_pairs from the DB: %v", err)
+	}
+	defer rows.Close()
+
+	var pairIDs []int
+	for rows.Next() {
+		var pairID int
+		if err := rows.Scan(&pairID); err != nil {
+			return 0, fmt.Errorf("DB error: %v", err)
+		}
+
+		pairIDs = append(pairIDs, pairID)
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("DB error: %v", err)
+	}
+
+	exp, err := assignmentOrder(ctx, repo.db, experimentID)
 	if err != nil {
 		return 0, err
 	}
 
-	insert, err := tx.Prepare(insertAssignmentsSQL)
+	tx, err := repo.db.BeginTx(ctx, nil)
 	if err != nil {
-		return 0, fmt.Errorf("DB error: %v", err)
+		return 0, err
 	}
 
-	rows, err := repo.db.Query(selectIDFilePairsSQL, experimentID, userID)
+	insert, err := tx.PrepareContext(ctx, insertAssignmentsSQL)
 	if err != nil {
-		return 0, fmt.Errorf("Error getting file_pairs from the DB: %v", err)
+		tx.Rollback()
+		return 0, fmt.Errorf("DB error: %v", err)
 	}
-	defer rows.Close()
 
 	duration := 0
 	created := 0
-	for rows.Next() {
-		var pairID int
-		rows.Scan(&pairID)
-		_, err := insert.Exec(userID, pairID, experimentID, nil, duration)
+	now := time.Now().UTC()
+	for _, pairID := range exp.OrderPairs(userID, pairIDs) {
+		_, err := insert.ExecContext(ctx, userID, pairID, experimentID, nil, duration, now)
 		if err != nil {
+			tx.Rollback()
 			return 0, fmt.Errorf("DB error: %v", err)
 		}
 
@@ -80,13 +160,36 @@ func (repo *Assignments) Initialize(userID int, experimentID int) (int, error) {
 	return created, nil
 }
 
+const selectAssignmentStrategySQL = `SELECT assignment_strategy, assignment_seed
+	FROM experiments WHERE id=$1`
+
+// assignmentOrder returns an Experiment with the assignment strategy and seed
+// of the experiment with the given ID, to order its FilePairs with OrderPairs
+func assignmentOrder(ctx context.Context, q queryer, experimentID int) (*model.Experiment, error) {
+	var strategy sql.NullString
+	var seed sql.NullInt64
+
+	err := q.QueryRowContext(ctx, selectAssignmentStrategySQL, experimentID).Scan(&strategy, &seed)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("DB error: %v", err)
+	}
+
+	return &model.Experiment{
+		ID:                 experimentID,
+		AssignmentStrategy: model.AssignmentStrategy(strategy.String),
+		AssignmentSeed:     seed.Int64,
+	}, nil
+}
+
 // getWithQuery builds a Assignment from the given sql QueryRow. If the
 // Assignment does not exist, it returns nil, nil
 func (repo *Assignments) getWithQuery(queryRow scannable) (*model.Assignment, error) {
 	var as model.Assignment
+	var outlier, flagged sql.NullBool
 
 	err := queryRow.Scan(&as.ID, &as.UserID, &as.PairID, &as.ExperimentID,
-		&as.Answer, &as.Duration)
+		&as.Answer, &as.Duration, &as.CreatedAt, &as.UpdatedAt, &outlier, &as.Confidence, &as.Comment,
+		&flagged, &as.SessionID, &as.PendingDuration)
 
 	switch {
 	case err == sql.ErrNoRows:
@@ -94,19 +197,23 @@ func (repo *Assignments) getWithQuery(queryRow scannable) (*model.Assignment, er
 	case err != nil:
 		return nil, fmt.Errorf("Error getting assignment from the DB: %v", err)
 	default:
+		// assignments answered before outliers were flagged have no value
+		as.Outlier = outlier.Valid && outlier.Bool
+		// the same for the assignments created before they could be flagged
+		as.Flagged = flagged.Valid && flagged.Bool
 		return &as, nil
 	}
 }
 
 // GetByID returns the Assignment with the given ID. If the Assignment does not
 // exist, it returns nil, nil
-func (repo *Assignments) GetByID(id int) (*model.Assignment, error) {
+func (repo *Assignments) GetByID(ctx context.Context, id int) (*model.Assignment, error) {
 	return repo.getWithQuery(
-		repo.db.QueryRow("SELECT * FROM assignments WHERE id=$1", id))
+		repo.db.QueryRowContext(ctx, selectAssignmentsWhereIDSQL, id))
 }
 
-func (repo *Assignments) getAssignmentsWithQuery(query string, args ...interface{}) ([]*model.Assignment, error) {
-	rows, err := repo.db.Query(query, args...)
+func (repo *Assignments) getAssignmentsWithQuery(ctx context.Context, query string, args ...interface{}) ([]*model.Assignment, error) {
+	rows, err := repo.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("error getting assignments from the DB: %v", err)
 	}
@@ -131,33 +238,177 @@ func (repo *Assignments) getAssignmentsWithQuery(query string, args ...interface
 }
 
 // GetAll returns all the Assignments for the given user and experiment IDs
-func (repo *Assignments) GetAll(userID, experimentID int) ([]*model.Assignment, error) {
-	return repo.getAssignmentsWithQuery(selectAssignmentsSQL, userID, experimentID)
+func (repo *Assignments) GetAll(ctx context.Context, userID, experimentID int) ([]*model.Assignment, error) {
+	return repo.getAssignmentsWithQuery(ctx, selectAssignmentsSQL, userID, experimentID)
+}
+
+const selectAssignmentsOrderedSQL = selectAssignmentsColumns +
+	` WHERE user_id=$1 AND experiment_id=$2 ORDER BY id`
+
+// GetByUserAndExperiment returns all the Assignments for the given user and
+// experiment IDs, ordered by ID
+func (repo *Assignments) GetByUserAndExperiment(ctx context.Context, userID, experimentID int) ([]*model.Assignment, error) {
+	return repo.getAssignmentsWithQuery(ctx, selectAssignmentsOrderedSQL, userID, experimentID)
+}
+
+const selectNextUnansweredSQL = selectAssignmentsColumns +
+	` WHERE user_id=$1 AND experiment_id=$2 AND answer IS NULL ORDER BY id LIMIT 1`
+
+// GetNextUnanswered returns the unanswered Assignment with the lowest ID for
+// the given user and experiment IDs. If all of them are answered, it returns nil, nil
+func (repo *Assignments) GetNextUnanswered(ctx context.Context, userID, experimentID int) (*model.Assignment, error) {
+	return repo.getWithQuery(repo.db.QueryRowContext(ctx, selectNextUnansweredSQL, userID, experimentID))
+}
+
+const selectPreviousSQL = selectAssignmentsColumns +
+	` WHERE user_id=$1 AND experiment_id=$2 AND id < $3 ORDER BY id DESC LIMIT 1`
+
+// GetPrevious returns the Assignment, answered or not, that comes before the
+// one with the given ID for the given user and experiment IDs, following the
+// ID order. If it is the first one, it returns nil, nil
+func (repo *Assignments) GetPrevious(ctx context.Context, userID, experimentID, assignmentID int) (*model.Assignment, error) {
+	return repo.getWithQuery(repo.db.QueryRowContext(ctx, selectPreviousSQL, userID, experimentID, assignmentID))
+}
+
+const selectUnansweredScoresSQL = `SELECT a.id, fp.score
+	FROM assignments a
+	JOIN file_pairs fp ON fp.id = a.pair_id
+	WHERE a.user_id=$1 AND a.experiment_id=$2 AND a.answer IS NULL
+	ORDER BY a.id`
+
+// GetUnansweredScores returns the IDs of the unanswered Assignments for the
+// given user and experiment IDs, ordered by ID, and the scores of their FilePairs
+func (repo *Assignments) GetUnansweredScores(ctx context.Context, userID, experimentID int) ([]int, []float64, error) {
+	rows, err := repo.db.QueryContext(ctx, selectUnansweredScoresSQL, userID, experimentID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("DB error: %v", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	var scores []float64
+	for rows.Next() {
+		var id int
+		var score float64
+		if err := rows.Scan(&id, &score); err != nil {
+			return nil, nil, fmt.Errorf("DB error: %v", err)
+		}
+
+		ids = append(ids, id)
+		scores = append(scores, score)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("DB error: %v", err)
+	}
+
+	return ids, scores, nil
 }
 
 // GetByExperimentPair returns all the Assignments for the given experiment and pair IDs
-func (repo *Assignments) GetByExperimentPair(experimentID, filePairID int) ([]*model.Assignment, error) {
+func (repo *Assignments) GetByExperimentPair(ctx context.Context, experimentID, filePairID int) ([]*model.Assignment, error) {
 	return repo.getAssignmentsWithQuery(
-		selectAssignmentsWhereExpPairSQL, experimentID, filePairID)
+		ctx, selectAssignmentsWhereExpPairSQL, experimentID, filePairID)
 }
 
 // Update updates the Assignment identified by the given user and pair IDs,
-// with the given answer and duration
-func (repo *Assignments) Update(assignmentID int, answer string, duration int) error {
-	if _, ok := model.Answers[answer]; !ok {
+// with the given answer and duration. The duration is not flagged as an
+// outlier, and no confidence, comment nor session is set
+func (repo *Assignments) Update(ctx context.Context, assignmentID int, answer string, duration int) error {
+	return repo.UpdateAnswer(ctx, assignmentID, answer, duration, nil, nil, false, nil)
+}
+
+// The answer history copies the values just saved in the assignment, in the
+// same transaction
+const (
+	insertAnswerHistorySQL = `INSERT INTO answer_history
+		(assignment_id, user_id, answer, duration, changed_at)
+		SELECT id, user_id, answer, duration, updated_at FROM assignments WHERE id=$1`
+	insertAnswerHistoryWherePairSQL = `INSERT INTO answer_history
+		(assignment_id, user_id, answer, duration, changed_at)
+		SELECT id, user_id, answer, duration, updated_at FROM assignments
+		WHERE experiment_id=$1 AND user_id=$2 AND pair_id=$3`
+	selectAnswerHistorySQL = `SELECT assignment_id, user_id, answer, duration, changed_at
+		FROM answer_history WHERE assignment_id=$1 ORDER BY changed_at, id`
+	deleteAnswerHistorySQL = `DELETE FROM answer_history WHERE assignment_id=$1`
+)
+
+// UpdateAnswer sets the answer, duration, confidence, comment, outlier flag and
+// session of the Assignment with the given ID, and its update time, and clears
+// its pending duration. It can be called on an already answered Assignment to
+// replace its answer; its creation time is kept. Every answer is also added to
+// the history of the Assignment. The answer must be already validated against
+// the Experiment answer scheme
+func (repo *Assignments) UpdateAnswer(
+	ctx context.Context,
+	id int,
+	answer string,
+	duration int,
+	confidence *int,
+	comment *string,
+	outlier bool,
+	sessionID *string,
+) error {
+	if answer == "" {
 		return fmt.Errorf("Wrong answer provided: '%s'", answer)
 	}
 
-	cmd := fmt.Sprintf(updateAssignmentsSQL, answer, duration, assignmentID)
+	if !model.IsValidConfidence(confidence) {
+		return fmt.Errorf("Wrong confidence provided: %d", *confidence)
+	}
+
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, updateAssignmentsSQL,
+		answer, duration, time.Now().UTC(), outlier, confidence, comment, sessionID, id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, insertAnswerHistorySQL, id); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("DB error: %v", err)
+	}
+
+	return tx.Commit()
+}
+
+// GetAnswerHistory returns every answer given to the Assignment with the given
+// ID, from the first one to the current one
+func (repo *Assignments) GetAnswerHistory(ctx context.Context, id int) ([]*model.AnswerChange, error) {
+	rows, err := repo.db.QueryContext(ctx, selectAnswerHistorySQL, id)
+	if err != nil {
+		return nil, fmt.Errorf("DB error: %v", err)
+	}
+	defer rows.Close()
+
+	var result []*model.AnswerChange
+	for rows.Next() {
+		var c model.AnswerChange
+		var answer sql.NullString
+		if err := rows.Scan(&c.AssignmentID, &c.UserID, &answer, &c.Duration, &c.ChangedAt); err != nil {
+			return nil, fmt.Errorf("DB error: %v", err)
+		}
+
+		c.Answer = answer.String
+
+		result = append(result, &c)
+	}
 
-	_, err := repo.db.Exec(cmd)
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("DB error: %v", err)
+	}
 
-	return err
+	return result, nil
 }
 
 // CountUserAssignment returns number of assigments in given experiment for the given user
-func (repo *Assignments) CountUserAssignment(experimentID, userID int) (int, error) {
-	row := repo.db.QueryRow(countUserAssigmentsSQL, experimentID, userID)
+func (repo *Assignments) CountUserAssignment(ctx context.Context, experimentID, userID int) (int, error) {
+	row := repo.db.QueryRowContext(ctx, countUserAssigmentsSQL, experimentID, userID)
 
 	var count int
 	if err := row.Scan(&count); err != nil {
@@ -168,8 +419,8 @@ func (repo *Assignments) CountUserAssignment(experimentID, userID int) (int, err
 }
 
 // CountCompleteUserAssignment returns number of assigments with an answer in given experiment for the given user
-func (repo *Assignments) CountCompleteUserAssignment(experimentID, userID int) (int, error) {
-	row := repo.db.QueryRow(countCompleteUserAssigmentsSQL, experimentID, userID)
+func (repo *Assignments) CountCompleteUserAssignment(ctx context.Context, experimentID, userID int) (int, error) {
+	row := repo.db.QueryRowContext(ctx, countCompleteUserAssigmentsSQL, experimentID, userID)
 
 	var count int
 	if err := row.Scan(&count); err != nil {
@@ -178,3 +429,1041 @@ func (repo *Assignments) CountCompleteUserAssignment(experimentID, userID int) (
 
 	return count, nil
 }
+
+// CountUserAssignments returns, with a single query, the number of assignments
+// in given experiment for the given user and how many of them have an answer
+func (repo *Assignments) CountUserAssignments(ctx context.Context, experimentID, userID int) (total int, complete int, err error) {
+	row := repo.db.QueryRowContext(ctx, countAllUserAssigmentsSQL, experimentID, userID)
+	if err := row.Scan(&total, &complete); err != nil {
+		return 0, 0, err
+	}
+
+	return total, complete, nil
+}
+
+// countUserAssignmentsByExpSQL takes the placeholders of the experiment IDs
+const countUserAssignmentsByExpSQL = `SELECT experiment_id, COUNT(*), COUNT(answer) FROM assignments
+	WHERE user_id=$1 AND experiment_id IN (%s)
+	GROUP BY experiment_id`
+
+// GetUserProgressByExperiment returns, with a single query, the number of
+// assignments of the given user in each of the given experiments, and how
+// many of them have an answer. The experiments without assignments for the
+// user are not included
+func (repo *Assignments) GetUserProgressByExperiment(
+	ctx context.Context,
+	userID int,
+	experimentIDs []int,
+) (map[int]*model.UserProgress, error) {
+	results := make(map[int]*model.UserProgress)
+	if len(experimentIDs) == 0 {
+		return results, nil
+	}
+
+	args := make([]interface{}, len(experimentIDs)+1)
+	args[0] = userID
+	for i, id := range experimentIDs {
+		args[i+1] = id
+	}
+
+	query := fmt.Sprintf(countUserAssignmentsByExpSQL, placeholders(2, len(experimentIDs)))
+	rows, err := repo.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error getting assignment counts from the DB: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var experimentID int
+		p := model.UserProgress{UserID: userID}
+		if err := rows.Scan(&experimentID, &p.Total, &p.Completed); err != nil {
+			return nil, fmt.Errorf("DB error: %v", err)
+		}
+
+		results[experimentID] = &p
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("DB error: %v", err)
+	}
+
+	return results, nil
+}
+
+// ForEachAnnotation calls fn with every Assignment of the given experiment, in
+// order, along with its User and FilePair data. The rows are read one by one
+// so the whole set is never kept in memory. If fn returns an error the
+// iteration stops, and the error is returned
+func (repo *Assignments) ForEachAnnotation(ctx context.Context, experimentID int, fn func(*model.Annotation) error) error {
+	rows, err := repo.db.QueryContext(ctx, selectAnnotationsSQL, experimentID)
+	if err != nil {
+		return fmt.Errorf("error getting annotations from the DB: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var an model.Annotation
+
+		err := rows.Scan(&an.ID, &an.UserID, &an.PairID, &an.ExperimentID,
+			&an.Answer, &an.Duration, &an.Comment, &an.Login, &an.LeftPath, &an.RightPath)
+		if err != nil {
+			return fmt.Errorf("DB error: %v", err)
+		}
+
+		if err := fn(&an); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("DB error: %v", err)
+	}
+
+	return nil
+}
+
+// GetUsersProgress returns the progress of every User with Assignments in the
+// given experiment
+func (repo *Assignments) GetUsersProgress(ctx context.Context, experimentID int) ([]*model.UserProgress, error) {
+	rows, err := repo.db.QueryContext(ctx, selectUsersProgressSQL, experimentID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting users progress from the DB: %v", err)
+	}
+	defer rows.Close()
+
+	results := make([]*model.UserProgress, 0)
+
+	for rows.Next() {
+		var p model.UserProgress
+		if err := rows.Scan(&p.UserID, &p.Login, &p.Completed, &p.Total); err != nil {
+			return nil, fmt.Errorf("DB error: %v", err)
+		}
+
+		results = append(results, &p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("DB error: %v", err)
+	}
+
+	return results, nil
+}
+
+const selectMeanConfidenceSQL = `SELECT AVG(confidence) FROM assignments
+	WHERE experiment_id=$1 AND answer IS NOT NULL AND confidence IS NOT NULL`
+
+// GetMeanConfidence returns the mean confidence of the answered Assignments
+// of the given experiment. It returns nil if none of them has a confidence
+func (repo *Assignments) GetMeanConfidence(ctx context.Context, experimentID int) (*float64, error) {
+	var mean sql.NullFloat64
+	if err := repo.db.QueryRowContext(ctx, selectMeanConfidenceSQL, experimentID).Scan(&mean); err != nil {
+		return nil, fmt.Errorf("DB error: %v", err)
+	}
+
+	if !mean.Valid {
+		return nil, nil
+	}
+
+	return &mean.Float64, nil
+}
+
+// skipped pairs are not evaluated, the user gave no answer for them
+const selectQualityScoreSQL = `SELECT COUNT(*), COUNT(CASE WHEN a.answer = fp.gold_answer THEN 1 END)
+	FROM assignments a
+	JOIN file_pairs fp ON fp.id = a.pair_id
+	WHERE a.experiment_id=$1 AND a.user_id=$2 AND fp.gold_answer IS NOT NULL
+	AND a.answer IS NOT NULL AND a.answer <> 'skip'`
+
+// GetQualityScore compares the answers of the given user to the gold
+// standard FilePairs of the experiment with their expected answers
+func (repo *Assignments) GetQualityScore(ctx context.Context, experimentID, userID int) (model.QualityScore, error) {
+	var score model.QualityScore
+	err := repo.db.QueryRowContext(ctx, selectQualityScoreSQL, experimentID, userID).
+		Scan(&score.Evaluated, &score.Correct)
+	if err != nil {
+		return score, fmt.Errorf("DB error: %v", err)
+	}
+
+	return score, nil
+}
+
+const selectUserSessionsSQL = `SELECT session_id, COUNT(*), COALESCE(SUM(duration), 0)
+	FROM assignments
+	WHERE experiment_id=$1 AND user_id=$2 AND answer IS NOT NULL AND session_id IS NOT NULL
+	GROUP BY session_id ORDER BY MIN(updated_at), session_id`
+
+// GetUserSessions returns the annotation sessions in which the given user
+// answered the assignments of the experiment, ordered by their first answer.
+// The answers given without a session are not counted
+func (repo *Assignments) GetUserSessions(ctx context.Context, experimentID, userID int) ([]*model.Session, error) {
+	rows, err := repo.db.QueryContext(ctx, selectUserSessionsSQL, experimentID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("DB error: %v", err)
+	}
+	defer rows.Close()
+
+	var result []*model.Session
+	for rows.Next() {
+		var s model.Session
+		if err := rows.Scan(&s.ID, &s.Answers, &s.Duration); err != nil {
+			return nil, fmt.Errorf("DB error: %v", err)
+		}
+
+		result = append(result, &s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("DB error: %v", err)
+	}
+
+	return result, nil
+}
+
+const selectHighSkipPairsSQL = `SELECT pair_id, skips, answers FROM (
+		SELECT pair_id, SUM(CASE WHEN answer = 'skip' THEN 1 ELSE 0 END) AS skips, COUNT(*) AS answers
+		FROM assignments
+		WHERE experiment_id=$1 AND answer IS NOT NULL
+		GROUP BY pair_id
+	) rates
+	WHERE skips > CAST($2 AS DOUBLE PRECISION) * answers
+	ORDER BY 1.0 * skips / answers DESC, pair_id`
+
+// GetHighSkipPairs returns how many times every FilePair of the given
+// experiment was skipped, for the ones whose fraction of skipped answers is
+// above minRate. They are sorted by that fraction, from the highest one, and
+// then by FilePair ID
+func (repo *Assignments) GetHighSkipPairs(ctx context.Context, experimentID int, minRate float64) ([]*model.PairSkipRate, error) {
+	rows, err := repo.db.QueryContext(ctx, selectHighSkipPairsSQL, experimentID, minRate)
+	if err != nil {
+		return nil, fmt.Errorf("DB error: %v", err)
+	}
+	defer rows.Close()
+
+	var result []*model.PairSkipRate
+	for rows.Next() {
+		var s model.PairSkipRate
+		if err := rows.Scan(&s.PairID, &s.Skips, &s.Answers); err != nil {
+			return nil, fmt.Errorf("DB error: %v", err)
+		}
+
+		result = append(result, &s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("DB error: %v", err)
+	}
+
+	return result, nil
+}
+
+const selectPairAnswersSQL = `SELECT pair_id, answer, COUNT(*) FROM assignments
+	WHERE experiment_id=$1 AND answer IS NOT NULL AND answer <> 'skip'
+	GROUP BY pair_id, answer ORDER BY pair_id`
+
+// GetPairConsensus returns how many times every FilePair of the given
+// experiment was given each answer, ordered by FilePair ID. The skipped
+// answers are not votes, and the FilePairs without votes are not returned
+func (repo *Assignments) GetPairConsensus(ctx context.Context, experimentID int) ([]*model.PairConsensus, error) {
+	rows, err := repo.db.QueryContext(ctx, selectPairAnswersSQL, experimentID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting answers from the DB: %v", err)
+	}
+	defer rows.Close()
+
+	results := make([]*model.PairConsensus, 0)
+
+	var current *model.PairConsensus
+	for rows.Next() {
+		var pairID, count int
+		var answer string
+		if err := rows.Scan(&pairID, &answer, &count); err != nil {
+			return nil, fmt.Errorf("DB error: %v", err)
+		}
+
+		if current == nil || current.PairID != pairID {
+			current = &model.PairConsensus{PairID: pairID, Answers: make(map[string]int)}
+			results = append(results, current)
+		}
+
+		current.Answers[answer] = count
+		current.Votes += count
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("DB error: %v", err)
+	}
+
+	return results, nil
+}
+
+const countAnnotatorsSQL = `SELECT COUNT(DISTINCT user_id) FROM assignments
+	WHERE experiment_id=$1 AND answer IS NOT NULL`
+
+// CountAnnotators returns the number of Users with at least one answered
+// Assignment in the given experiment
+func (repo *Assignments) CountAnnotators(ctx context.Context, experimentID int) (int, error) {
+	var count int
+	if err := repo.db.QueryRowContext(ctx, countAnnotatorsSQL, experimentID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("DB error: %v", err)
+	}
+
+	return count, nil
+}
+
+// The experiment filter of the leaderboard query is ignored when it is 0
+const selectLeaderboardSQL = `SELECT a.user_id, u.login, COUNT(*) AS completed
+	FROM assignments a
+	JOIN users u ON u.id = a.user_id
+	JOIN experiments e ON e.id = a.experiment_id
+	WHERE a.answer IS NOT NULL AND e.deleted_at IS NULL
+	AND ($1 = 0 OR a.experiment_id = $1)
+	GROUP BY a.user_id, u.login
+	ORDER BY completed DESC, a.user_id
+	LIMIT $2`
+
+// GetLeaderboard returns at most limit Users sorted by the number of answered
+// Assignments, from the most to the least. If experimentID is not 0, only the
+// Assignments of that experiment are counted. Soft-deleted experiments are
+// excluded
+func (repo *Assignments) GetLeaderboard(ctx context.Context, experimentID, limit int) ([]*model.LeaderboardEntry, error) {
+	rows, err := repo.db.QueryContext(ctx, selectLeaderboardSQL, experimentID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error getting the leaderboard from the DB: %v", err)
+	}
+	defer rows.Close()
+
+	results := make([]*model.LeaderboardEntry, 0)
+
+	for rows.Next() {
+		var e model.LeaderboardEntry
+		if err := rows.Scan(&e.UserID, &e.Login, &e.Completed); err != nil {
+			return nil, fmt.Errorf("DB error: %v", err)
+		}
+
+		results = append(results, &e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("DB error: %v", err)
+	}
+
+	return results, nil
+}
+
+// maxBulkInsertRows is the max number of rows inserted by each INSERT statement,
+// to stay under the SQLite limit of 999 arguments per statement
+const maxBulkInsertRows = 150
+
+// CreateBatch creates, in a single transaction, an Assignment in the given
+// experiment for each combination of the given user and pair IDs. The
+// combinations already assigned are skipped. It returns the number of
+// created Assignments
+func (repo *Assignments) CreateBatch(ctx context.Context, experimentID int, userIDs, pairIDs []int) (int, error) {
+	pairsByUser := make(map[int][]int, len(userIDs))
+	for _, userID := range userIDs {
+		pairsByUser[userID] = pairIDs
+	}
+
+	return repo.CreateBatchByUser(ctx, experimentID, userIDs, pairsByUser)
+}
+
+// CreateBatchByUser creates, in a single transaction, an Assignment in the
+// given experiment for each one of the given users and each one of the pair
+// IDs in pairsByUser for that user. The combinations already assigned are
+// skipped. The assignments of the experiment become managed, see
+// IsInitialized. It returns the number of created Assignments
+func (repo *Assignments) CreateBatchByUser(ctx context.Context, experimentID int, userIDs []int, pairsByUser map[int][]int) (int, error) {
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	created, err := repo.createBatch(ctx, tx, experimentID, userIDs, pairsByUser)
+	if err == nil {
+		err = setManaged(ctx, tx, experimentID)
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("DB error: %v", err)
+	}
+
+	return created, nil
+}
+
+type userPair struct {
+	userID int
+	pairID int
+}
+
+func (repo *Assignments) createBatch(ctx context.Context, tx *sql.Tx, experimentID int, userIDs []int, pairsByUser map[int][]int) (int, error) {
+	rows, err := tx.QueryContext(ctx, selectAssignedPairsSQL, experimentID)
+	if err != nil {
+		return 0, fmt.Errorf("error getting assignments from the DB: %v", err)
+	}
+
+	assigned := make(map[userPair]bool)
+	for rows.Next() {
+		var up userPair
+		if err := rows.Scan(&up.userID, &up.pairID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("DB error: %v", err)
+		}
+
+		assigned[up] = true
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("DB error: %v", err)
+	}
+
+	exp, err := assignmentOrder(ctx, tx, experimentID)
+	if err != nil {
+		return 0, err
+	}
+
+	var pending []userPair
+	for _, userID := range userIDs {
+		for _, pairID := range exp.OrderPairs(userID, pairsByUser[userID]) {
+			up := userPair{userID, pairID}
+			if !assigned[up] {
+				assigned[up] = true
+				pending = append(pending, up)
+			}
+		}
+	}
+
+	now := time.Now().UTC()
+	for start := 0; start < len(pending); start += maxBulkInsertRows {
+		end := start + maxBulkInsertRows
+		if end > len(pending) {
+			end = len(pending)
+		}
+
+		var values []string
+		var args []interface{}
+		for i, up := range pending[start:end] {
+			n := i * 4
+			values = append(values, fmt.Sprintf("($%d, $%d, $%d, NULL, 0, $%d)", n+1, n+2, n+3, n+4))
+			args = append(args, up.userID, up.pairID, experimentID, now)
+		}
+
+		if _, err := tx.ExecContext(ctx, bulkInsertAssignmentsSQL+strings.Join(values, ", "), args...); err != nil {
+			return 0, fmt.Errorf("DB error: %v", err)
+		}
+	}
+
+	return len(pending), nil
+}
+
+const (
+	updateImportedAnswerSQL = `UPDATE assignments SET answer=$1, duration=$2, updated_at=$3, outlier=$4
+		WHERE experiment_id=$5 AND user_id=$6 AND pair_id=$7`
+	insertImportedAnswerSQL = `INSERT INTO assignments
+		(user_id, pair_id, experiment_id, answer, duration, created_at, updated_at, outlier)
+		VALUES ($1, $2, $3, $4, $5, $6, $6, $7)`
+)
+
+// ImportAnswers sets, in a single transaction, the answer, duration and
+// outlier flag of the given Assignments of the experiment, identified by
+// their user and pair IDs, and adds them to their history. The ones that do
+// not exist yet are created. It returns the number of saved Assignments
+func (repo *Assignments) ImportAnswers(ctx context.Context, experimentID int, as []*model.Assignment) (int, error) {
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UTC()
+	for _, a := range as {
+		res, err := tx.ExecContext(ctx, updateImportedAnswerSQL,
+			a.Answer, a.Duration, now, a.Outlier, experimentID, a.UserID, a.PairID)
+		if err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("DB error: %v", err)
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("DB error: %v", err)
+		}
+
+		if n == 0 {
+			_, err = tx.ExecContext(ctx, insertImportedAnswerSQL,
+				a.UserID, a.PairID, experimentID, a.Answer, a.Duration, now, a.Outlier)
+			if err != nil {
+				tx.Rollback()
+				return 0, fmt.Errorf("DB error: %v", err)
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, insertAnswerHistoryWherePairSQL, experimentID, a.UserID, a.PairID)
+		if err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("DB error: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("DB error: %v", err)
+	}
+
+	return len(as), nil
+}
+
+// The pairs already assigned to the new user are skipped to keep the
+// assignments unique
+const reassignAssignmentsSQL = `UPDATE assignments SET user_id=$1
+	WHERE experiment_id=$2 AND user_id=$3 AND answer IS NULL
+	AND pair_id NOT IN (
+		SELECT pair_id FROM assignments WHERE experiment_id=$2 AND user_id=$1)`
+
+// Reassign moves, in a single transaction, the unanswered Assignments of the
+// given experiment from one user to another. The answered Assignments, and
+// the ones for pairs that the new user already has, are kept by the original
+// user. The assignments of the experiment become managed, see IsInitialized.
+// It returns the number of moved Assignments
+func (repo *Assignments) Reassign(ctx context.Context, experimentID, fromUserID, toUserID int) (int, error) {
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := tx.ExecContext(ctx, reassignAssignmentsSQL, toUserID, experimentID, fromUserID)
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("DB error: %v", err)
+	}
+
+	moved, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("DB error: %v", err)
+	}
+
+	if err := setManaged(ctx, tx, experimentID); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("DB error: %v", err)
+	}
+
+	return int(moved), nil
+}
+
+// Every Assignment the user worked on is reset: the answered ones, and also
+// the unanswered ones with a pending duration or a flag. The reset of an
+// answer is kept in the history as an empty answer
+const (
+	insertResetHistorySQL = `INSERT INTO answer_history
+		(assignment_id, user_id, answer, duration, changed_at)
+		SELECT id, user_id, NULL, 0, $1 FROM assignments
+		WHERE experiment_id=$2 AND user_id=$3 AND answer IS NOT NULL`
+	resetUserAssignmentsSQL = `UPDATE assignments
+		SET answer=NULL, duration=0, updated_at=NULL, outlier=NULL, confidence=NULL, comment=NULL,
+		session_id=NULL, pending_duration=NULL, flagged=NULL
+		WHERE experiment_id=$1 AND user_id=$2
+		AND (answer IS NOT NULL OR pending_duration IS NOT NULL OR flagged=$3)`
+)
+
+// ResetUser clears, in a single transaction, the answers of the given user in
+// the experiment, along with their duration, confidence, comment, session,
+// pending duration and flag. The Assignments are kept, so the user can answer
+// them again, and the reset is added to the history of the answered ones. It
+// returns the number of reset Assignments
+func (repo *Assignments) ResetUser(ctx context.Context, experimentID, userID int) (int, error) {
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, insertResetHistorySQL, time.Now().UTC(), experimentID, userID)
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("DB error: %v", err)
+	}
+
+	res, err := tx.ExecContext(ctx, resetUserAssignmentsSQL, experimentID, userID, true)
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("DB error: %v", err)
+	}
+
+	reset, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("DB error: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("DB error: %v", err)
+	}
+
+	return int(reset), nil
+}
+
+const selectCompleteDurationsSQL = `SELECT duration FROM assignments
+	WHERE experiment_id=$1 AND answer IS NOT NULL AND duration > 0
+	AND (outlier IS NULL OR NOT outlier)`
+
+// GetCompleteDurations returns the durations of the answered Assignments of the
+// given experiment. The Assignments without duration, or flagged as outliers,
+// are skipped
+func (repo *Assignments) GetCompleteDurations(ctx context.Context, experimentID int) ([]int, error) {
+	rows, err := repo.db.QueryContext(ctx, selectCompleteDurationsSQL, experimentID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting durations from the DB: %v", err)
+	}
+	defer rows.Close()
+
+	results := make([]int, 0)
+
+	for rows.Next() {
+		var duration int
+		if err := rows.Scan(&duration); err != nil {
+			return nil, fmt.Errorf("DB error: %v", err)
+		}
+
+		results = append(results, duration)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("DB error: %v", err)
+	}
+
+	return results, nil
+}
+
+const selectAnswerCountsSQL = `SELECT updated_at, answer, COUNT(*) FROM assignments
+	WHERE experiment_id=$1 AND answer IS NOT NULL AND updated_at IS NOT NULL
+	GROUP BY updated_at, answer ORDER BY updated_at`
+
+// GetAnswerCounts returns how many Assignments of the given experiment were
+// answered with each answer, grouped by the time of the answer
+func (repo *Assignments) GetAnswerCounts(ctx context.Context, experimentID int) ([]*model.AnswerCount, error) {
+	rows, err := repo.db.QueryContext(ctx, selectAnswerCountsSQL, experimentID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting answer counts from the DB: %v", err)
+	}
+	defer rows.Close()
+
+	results := make([]*model.AnswerCount, 0)
+
+	for rows.Next() {
+		var c model.AnswerCount
+		if err := rows.Scan(&c.Time, &c.Answer, &c.Count); err != nil {
+			return nil, fmt.Errorf("DB error: %v", err)
+		}
+
+		results = append(results, &c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("DB error: %v", err)
+	}
+
+	return results, nil
+}
+
+const selectAnswerTotalsSQL = `SELECT answer, COUNT(*) FROM assignments
+	WHERE experiment_id=$1 GROUP BY answer`
+
+// GetAnswerTotals returns how many Assignments of the given experiment were
+// answered with each answer. The unanswered ones are counted with an empty
+// answer
+func (repo *Assignments) GetAnswerTotals(ctx context.Context, experimentID int) (map[string]int, error) {
+	rows, err := repo.db.QueryContext(ctx, selectAnswerTotalsSQL, experimentID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting answer counts from the DB: %v", err)
+	}
+	defer rows.Close()
+
+	results := make(map[string]int)
+
+	for rows.Next() {
+		var answer sql.NullString
+		var count int
+		if err := rows.Scan(&answer, &count); err != nil {
+			return nil, fmt.Errorf("DB error: %v", err)
+		}
+
+		results[answer.String] += count
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("DB error: %v", err)
+	}
+
+	return results, nil
+}
+
+const selectAnswersSQL = `SELECT pair_id, user_id, answer FROM assignments
+	WHERE experiment_id=$1 AND answer IS NOT NULL`
+
+// GetAnswersByPair returns the answers of the given experiment grouped by
+// FilePair ID, and then by User ID
+func (repo *Assignments) GetAnswersByPair(ctx context.Context, experimentID int) (map[int]map[int]string, error) {
+	rows, err := repo.db.QueryContext(ctx, selectAnswersSQL, experimentID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting answers from the DB: %v", err)
+	}
+	defer rows.Close()
+
+	results := make(map[int]map[int]string)
+
+	for rows.Next() {
+		var pairID, userID int
+		var answer string
+		if err := rows.Scan(&pairID, &userID, &answer); err != nil {
+			return nil, fmt.Errorf("DB error: %v", err)
+		}
+
+		if results[pairID] == nil {
+			results[pairID] = make(map[int]string)
+		}
+
+		results[pairID][userID] = answer
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("DB error: %v", err)
+	}
+
+	return results, nil
+}
+
+const updateAssignmentPendingDurationSQL = `UPDATE assignments SET pending_duration=$1 WHERE id=$2`
+
+// SetPendingDuration records the time spent so far, in milliseconds, on the
+// Assignment with the given ID, until it is answered. Its answer is not
+// modified. It returns false if the Assignment does not exist
+func (repo *Assignments) SetPendingDuration(ctx context.Context, id int, duration int) (bool, error) {
+	res, err := repo.db.ExecContext(ctx, updateAssignmentPendingDurationSQL, duration, id)
+	if err != nil {
+		return false, fmt.Errorf("DB error: %v", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("DB error: %v", err)
+	}
+
+	return n > 0, nil
+}
+
+const (
+	updateAssignmentFlaggedSQL  = `UPDATE assignments SET flagged=$1 WHERE id=$2`
+	selectFlaggedAssignmentsSQL = selectAssignmentsColumns +
+		` WHERE experiment_id=$1 AND flagged=$2 ORDER BY id`
+)
+
+// SetFlagged flags, or unflags, the Assignment with the given ID for review.
+// Its answer is not modified. It returns false if the Assignment does not exist
+func (repo *Assignments) SetFlagged(ctx context.Context, id int, flagged bool) (bool, error) {
+	res, err := repo.db.ExecContext(ctx, updateAssignmentFlaggedSQL, flagged, id)
+	if err != nil {
+		return false, fmt.Errorf("DB error: %v", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("DB error: %v", err)
+	}
+
+	return n > 0, nil
+}
+
+// GetFlagged returns the Assignments of the given experiment flagged for
+// review, ordered by ID
+func (repo *Assignments) GetFlagged(ctx context.Context, experimentID int) ([]*model.Assignment, error) {
+	return repo.getAssignmentsWithQuery(ctx, selectFlaggedAssignmentsSQL, experimentID, true)
+}
+
+// countAnnotationsByPairSQL takes the placeholders of the FilePair IDs
+const countAnnotationsByPairSQL = `SELECT pair_id, COUNT(*) FROM assignments
+	WHERE experiment_id=$1 AND answer IS NOT NULL AND pair_id IN (%s)
+	GROUP BY pair_id`
+
+// CountAnnotationsByPair returns how many answered Assignments of the given
+// experiment each of the given FilePair IDs has. The FilePairs without any
+// answer are not included
+func (repo *Assignments) CountAnnotationsByPair(ctx context.Context, experimentID int, pairIDs []int) (map[int]int, error) {
+	results := make(map[int]int)
+	if len(pairIDs) == 0 {
+		return results, nil
+	}
+
+	args := make([]interface{}, len(pairIDs)+1)
+	args[0] = experimentID
+	for i, id := range pairIDs {
+		args[i+1] = id
+	}
+
+	query := fmt.Sprintf(countAnnotationsByPairSQL, placeholders(2, len(pairIDs)))
+	rows, err := repo.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error getting annotation counts from the DB: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var pairID, count int
+		if err := rows.Scan(&pairID, &count); err != nil {
+			return nil, fmt.Errorf("DB error: %v", err)
+		}
+
+		results[pairID] = count
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("DB error: %v", err)
+	}
+
+	return results, nil
+}
+
+const (
+	deleteAssignmentSQL                = `DELETE FROM assignments WHERE id=$1`
+	updateAssignmentsManagedWhereIDSQL = `UPDATE experiments SET assignments_managed=$1
+		WHERE id IN (SELECT experiment_id FROM assignments WHERE id=$2)`
+)
+
+// Delete removes the Assignment with the given ID, with its answer history.
+// The assignments of its experiment become managed, see IsInitialized, so it
+// is not created again
+func (repo *Assignments) Delete(ctx context.Context, id int) error {
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, updateAssignmentsManagedWhereIDSQL, true, id); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("DB error: %v", err)
+	}
+
+	for _, query := range []string{deleteAnswerHistorySQL, deleteAssignmentSQL} {
+		if _, err := tx.ExecContext(ctx, query, id); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("DB error: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("DB error: %v", err)
+	}
+
+	return nil
+}
+
+const (
+	selectCommentsIndexSQL = `SELECT docid FROM assignment_comments LIMIT 1`
+	// the MATCH query is a phrase, so the operators of the search term are
+	// not applied
+	selectAssignmentsWhereCommentMatchSQL = selectAssignmentsColumns + ` WHERE experiment_id=$1
+		AND id IN (SELECT docid FROM assignment_comments WHERE comment MATCH $2) ORDER BY id`
+	selectAssignmentsWhereCommentLikeSQL = selectAssignmentsColumns + ` WHERE experiment_id=$1
+		AND LOWER(comment) LIKE $2 ESCAPE '\' ORDER BY id`
+)
+
+// SearchComments returns the Assignments of the given experiment whose
+// comment contains the given term, ordered by ID. The full-text index of the
+// comments is used if the DB has it; then only whole words are matched.
+// Otherwise the term is matched anywhere in the comment, ignoring the case
+func (repo *Assignments) SearchComments(ctx context.Context, experimentID int, term string) ([]*model.Assignment, error) {
+	indexed, err := repo.hasCommentsIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if indexed {
+		phrase := `"` + strings.Replace(term, `"`, `""`, -1) + `"`
+		return repo.getAssignmentsWithQuery(ctx, selectAssignmentsWhereCommentMatchSQL, experimentID, phrase)
+	}
+
+	return repo.getAssignmentsWithQuery(ctx, selectAssignmentsWhereCommentLikeSQL, experimentID, likePattern(term))
+}
+
+// hasCommentsIndex returns true if the DB has the full-text index of the
+// comments, see dbutil.Bootstrap. The result is kept once the index is found
+// or known to be missing; any other error is returned, and the check is made
+// again on the next call
+func (repo *Assignments) hasCommentsIndex(ctx context.Context) (bool, error) {
+	repo.commentsIndexMu.Lock()
+	defer repo.commentsIndexMu.Unlock()
+
+	if repo.commentsIndex != nil {
+		return *repo.commentsIndex, nil
+	}
+
+	var docid int
+	err := repo.db.QueryRowContext(ctx, selectCommentsIndexSQL).Scan(&docid)
+
+	var indexed bool
+	switch {
+	case err == nil || err == sql.ErrNoRows:
+		indexed = true
+	case isMissingIndexError(err):
+		indexed = false
+	default:
+		return false, fmt.Errorf("DB error: %v", err)
+	}
+
+	repo.commentsIndex = &indexed
+	return indexed, nil
+}
+
+// isMissingIndexError returns true if the error is caused by a missing
+// full-text index table, as in PostgreSQL, or in SQLite built without FTS4
+func isMissingIndexError(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "no such table") ||
+		strings.Contains(msg, "no such module") ||
+		strings.Contains(msg, "does not exist")
+}
+
+// The unanswered assignments are reordered in place: their pairs are cleared
+// first, as the pairs of a user are unique, and then set in the new order
+const (
+	selectUnansweredAssignmentsSQL = `SELECT id, user_id, pair_id, created_at, flagged, session_id, pending_duration
+		FROM assignments WHERE experiment_id=$1 AND answer IS NULL ORDER BY user_id, id`
+	selectUnansweredHistorySQL = `SELECT h.id, h.assignment_id FROM answer_history h
+		JOIN assignments a ON a.id = h.assignment_id
+		WHERE a.experiment_id=$1 AND a.answer IS NULL`
+	clearUnansweredPairsSQL = `UPDATE assignments SET pair_id=NULL WHERE experiment_id=$1 AND answer IS NULL`
+	updateReorderedSQL      = `UPDATE assignments SET pair_id=$1, created_at=$2, flagged=$3, session_id=$4,
+		pending_duration=$5 WHERE id=$6`
+	updateHistoryAssignmentSQL = `UPDATE answer_history SET assignment_id=$1 WHERE id=$2`
+)
+
+// unansweredRow is an unanswered assignment moved by reorderUnanswered, with
+// the data that belongs to its pair
+type unansweredRow struct {
+	id              int
+	pairID          int
+	createdAt       *time.Time
+	flagged         sql.NullBool
+	sessionID       *string
+	pendingDuration *int
+	history         []int
+}
+
+// reorderUnanswered sorts again the pairs of the unanswered Assignments of
+// every user in the experiment, following its current assignment strategy
+// and seed, as if they were created now. No Assignment is created nor
+// deleted; each pair keeps its flag, session, pending duration and answer history, and
+// the answered Assignments are not changed. It returns the number of
+// reordered Assignments
+func (repo *Assignments) reorderUnanswered(ctx context.Context, tx *sql.Tx, experimentID int) (int, error) {
+	exp, err := assignmentOrder(ctx, tx, experimentID)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := tx.QueryContext(ctx, selectUnansweredAssignmentsSQL, experimentID)
+	if err != nil {
+		return 0, fmt.Errorf("DB error: %v", err)
+	}
+
+	var userIDs []int
+	byUser := make(map[int][]*unansweredRow)
+	byID := make(map[int]*unansweredRow)
+	for rows.Next() {
+		var row unansweredRow
+		var userID int
+		err := rows.Scan(&row.id, &userID, &row.pairID, &row.createdAt, &row.flagged, &row.sessionID, &row.pendingDuration)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("DB error: %v", err)
+		}
+
+		if _, ok := byUser[userID]; !ok {
+			userIDs = append(userIDs, userID)
+		}
+		byUser[userID] = append(byUser[userID], &row)
+		byID[row.id] = &row
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("DB error: %v", err)
+	}
+
+	if err := repo.loadUnansweredHistory(ctx, tx, experimentID, byID); err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.ExecContext(ctx, clearUnansweredPairsSQL, experimentID); err != nil {
+		return 0, fmt.Errorf("DB error: %v", err)
+	}
+
+	for _, userID := range userIDs {
+		userRows := byUser[userID]
+
+		byPair := make(map[int]*unansweredRow, len(userRows))
+		pairIDs := make([]int, len(userRows))
+		for i, row := range userRows {
+			byPair[row.pairID] = row
+			pairIDs[i] = row.pairID
+		}
+		sort.Ints(pairIDs)
+
+		// the rows are sorted by ID, so they get the pairs in the new order
+		for i, pairID := range exp.OrderPairs(userID, pairIDs) {
+			id, src := userRows[i].id, byPair[pairID]
+			_, err := tx.ExecContext(ctx, updateReorderedSQL,
+				pairID, src.createdAt, src.flagged, src.sessionID, src.pendingDuration, id)
+			if err != nil {
+				return 0, fmt.Errorf("DB error: %v", err)
+			}
+
+			for _, historyID := range src.history {
+				if _, err := tx.ExecContext(ctx, updateHistoryAssignmentSQL, id, historyID); err != nil {
+					return 0, fmt.Errorf("DB error: %v", err)
+				}
+			}
+		}
+	}
+
+	return len(byID), nil
+}
+
+// loadUnansweredHistory sets the IDs of the answer history rows of the given
+// unanswered Assignments, by Assignment ID
+func (repo *Assignments) loadUnansweredHistory(
+	ctx context.Context,
+	tx *sql.Tx,
+	experimentID int,
+	byID map[int]*unansweredRow,
+) error {
+	rows, err := tx.QueryContext(ctx, selectUnansweredHistorySQL, experimentID)
+	if err != nil {
+		return fmt.Errorf("DB error: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, assignmentID int
+		if err := rows.Scan(&id, &assignmentID); err != nil {
+			return fmt.Errorf("DB error: %v", err)
+		}
+
+		if row, ok := byID[assignmentID]; ok {
+			row.history = append(row.history, id)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("DB error: %v", err)
+	}
+
+	return nil
+}
@@ -1,6 +1,37 @@
 package repository
 
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
 // scannable is used to call .Scan for both sql.Row and sql.Rows
 type scannable interface {
 	Scan(dest ...interface{}) error
 }
+
+// queryer is used to call .QueryRowContext for both sql.DB and sql.Tx
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// likePattern returns a lowercase LIKE pattern matching any string that
+// contains term. The LIKE wildcards in term are escaped using '\'
+func likePattern(term string) string {
+	return "%" + likeEscaper.Replace(strings.ToLower(term)) + "%"
+}
+
+// placeholders returns n comma separated query placeholders, starting at
+// $first
+func placeholders(first, n int) string {
+	ps := make([]string, n)
+	for i := range ps {
+		ps[i] = fmt.Sprintf("$%d", first+i)
+	}
+
+	return strings.Join(ps, ", ")
+}
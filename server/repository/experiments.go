@@ -1,8 +1,11 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/src-d/code-annotation/server/model"
 )
@@ -22,8 +25,13 @@ func NewExperiments(db *sql.DB) *Experiments {
 // Experiment does not exist, it returns nil, nil
 func (repo *Experiments) getWithQuery(queryRow scannable) (*model.Experiment, error) {
 	var exp model.Experiment
+	var status, strategy sql.NullString
+	var seed, version sql.NullInt64
+	var answers sql.NullString
 
-	err := queryRow.Scan(&exp.ID, &exp.Name, &exp.Description)
+	err := queryRow.Scan(&exp.ID, &exp.Name, &exp.Description, &exp.DeletedAt,
+		&exp.OutlierThreshold, &status, &strategy, &seed, &version, &exp.CreatedAt, &exp.UpdatedAt, &exp.Deadline,
+		&answers)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -32,23 +40,187 @@ func (repo *Experiments) getWithQuery(queryRow scannable) (*model.Experiment, er
 		return nil, fmt.Errorf("Error getting experiment from the DB: %v", err)
 	}
 
+	exp.Status = model.ExperimentActive
+	if status.Valid && status.String != "" {
+		exp.Status = model.ExperimentStatus(status.String)
+	}
+
+	exp.AssignmentStrategy = model.AssignmentSequential
+	if strategy.Valid && strategy.String != "" {
+		exp.AssignmentStrategy = model.AssignmentStrategy(strategy.String)
+	}
+	exp.AssignmentSeed = seed.Int64
+	exp.Version = int(version.Int64)
+
+	if answers.Valid && answers.String != "" {
+		if err := json.Unmarshal([]byte(answers.String), &exp.Answers); err != nil {
+			return nil, fmt.Errorf("invalid answers of experiment %d: %v", exp.ID, err)
+		}
+	}
+
 	return &exp, nil
 }
 
-const selectExperimentsWhereIDSQL = `SELECT * FROM experiments WHERE id=$1`
-const selectExperimentsSQL = `SELECT * FROM experiments`
-const insertExperimentSQL = `INSERT INTO experiments (name, description) VALUES ($1, $2)`
-const updateExperimentSQL = `UPDATE experiments SET name=$1, description=$2 WHERE id=$3`
+// answersValue returns the value stored for the given answers, a JSON array,
+// or nil if there are none and the default ones are used
+func answersValue(answers []string) (interface{}, error) {
+	if len(answers) == 0 {
+		return nil, nil
+	}
+
+	b, err := json.Marshal(answers)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(b), nil
+}
+
+// The queries listing experiments take an includeDeleted argument; when it is
+// false the soft-deleted experiments are excluded
+const (
+	selectExperimentsColumns = `SELECT id, name, description, deleted_at, outlier_threshold, status,
+		assignment_strategy, assignment_seed, version, created_at, updated_at, deadline, answers FROM experiments`
+	selectExperimentsWhereIDSQL   = selectExperimentsColumns + ` WHERE id=$1 AND ($2 OR deleted_at IS NULL)`
+	selectExperimentsSQL          = selectExperimentsColumns + ` WHERE ($1 OR deleted_at IS NULL)`
+	selectExperimentsWhereTermSQL = selectExperimentsColumns + ` WHERE ($1 OR deleted_at IS NULL)
+		AND (LOWER(name) LIKE $2 ESCAPE '\' OR LOWER(description) LIKE $2 ESCAPE '\')`
+	countExperimentsSQL          = `SELECT COUNT(*) FROM experiments WHERE ($1 OR deleted_at IS NULL)`
+	countExperimentsWhereTermSQL = countExperimentsSQL +
+		` AND (LOWER(name) LIKE $2 ESCAPE '\' OR LOWER(description) LIKE $2 ESCAPE '\')`
+	insertExperimentSQL = `INSERT INTO experiments
+		(name, description, outlier_threshold, status, assignment_strategy, assignment_seed, version,
+		created_at, updated_at, answers)
+		VALUES ($1, $2, $3, 'active', $4, $5, 0, $6, $6, $7)`
+	updateExperimentSQL = `UPDATE experiments SET name=$1, description=$2, outlier_threshold=$3,
+		deadline=$4, version=version+1, updated_at=$5 WHERE id=$6 AND version=$7`
+	softDeleteExperimentSQL        = `UPDATE experiments SET deleted_at=$1 WHERE id=$2 AND deleted_at IS NULL`
+	updateExperimentStatusSQL      = `UPDATE experiments SET status=$1, updated_at=$2 WHERE id=$3 AND deleted_at IS NULL`
+	countExperimentsWhereNameSQL   = `SELECT COUNT(*) FROM experiments WHERE name=$1`
+	selectExperimentIDWhereNameSQL = `SELECT id FROM experiments WHERE name=$1`
+	copyFilePairsSQL               = `INSERT INTO file_pairs (
+		blob_id_a, repository_id_a, commit_hash_a, path_a, content_a, hash_a, uast_a,
+		blob_id_b, repository_id_b, commit_hash_b, path_b, content_b, hash_b, uast_b,
+		score, experiment_id, blob_id_base, path_base, content_base, purged, loc_a, loc_b, gold_answer)
+		SELECT
+		blob_id_a, repository_id_a, commit_hash_a, path_a, content_a, hash_a, uast_a,
+		blob_id_b, repository_id_b, commit_hash_b, path_b, content_b, hash_b, uast_b,
+		score, $1, blob_id_base, path_base, content_base, purged, loc_a, loc_b, gold_answer
+		FROM file_pairs WHERE experiment_id=$2 ORDER BY id`
+	copyTagsSQL = `INSERT INTO experiment_tags (experiment_id, tag)
+		SELECT CAST($1 AS INTEGER), tag FROM experiment_tags WHERE experiment_id=$2`
+)
 
 // GetByID returns the Experiment with the given ID. If the Experiment does not
-// exist, it returns nil, nil
-func (repo *Experiments) GetByID(id int) (*model.Experiment, error) {
-	return repo.getWithQuery(repo.db.QueryRow(selectExperimentsWhereIDSQL, id))
+// exist, or it was soft-deleted and includeDeleted is false, it returns nil, nil
+func (repo *Experiments) GetByID(ctx context.Context, id int, includeDeleted bool) (*model.Experiment, error) {
+	exp, err := repo.getWithQuery(repo.db.QueryRowContext(ctx, selectExperimentsWhereIDSQL, id, includeDeleted))
+	if err != nil || exp == nil {
+		return exp, err
+	}
+
+	if err := repo.loadTags(ctx, []*model.Experiment{exp}); err != nil {
+		return nil, err
+	}
+
+	return exp, nil
 }
 
-// GetAll returns all the Experiments
-func (repo *Experiments) GetAll() ([]*model.Experiment, error) {
-	rows, err := repo.db.Query(selectExperimentsSQL)
+// ExperimentsSort is the field the listed Experiments are sorted by
+type ExperimentsSort string
+
+const (
+	// SortByID sorts the Experiments in the order they were created
+	SortByID ExperimentsSort = "id"
+	// SortByName sorts the Experiments by name, ignoring the case
+	SortByName ExperimentsSort = "name"
+	// SortByCreatedAt sorts the Experiments by creation time. The ones created
+	// before it was stored are sorted first, by ID
+	SortByCreatedAt ExperimentsSort = "createdAt"
+)
+
+// ExperimentsOrder sets how the listed Experiments are sorted. The zero value
+// sorts them by ID. The ties are always sorted by ID, in ascending order
+type ExperimentsOrder struct {
+	Sort ExperimentsSort
+	Desc bool
+}
+
+func (o ExperimentsOrder) sql() string {
+	direction := "ASC"
+	if o.Desc {
+		direction = "DESC"
+	}
+
+	switch o.Sort {
+	case SortByName:
+		return " ORDER BY LOWER(name) " + direction + ", id ASC"
+	case SortByCreatedAt:
+		return " ORDER BY created_at " + direction + ", id ASC"
+	default:
+		return " ORDER BY id " + direction
+	}
+}
+
+// GetPaginated returns at most limit Experiments, skipping the first offset
+// ones, sorted as set by order
+func (repo *Experiments) GetPaginated(
+	ctx context.Context,
+	limit, offset int,
+	includeDeleted bool,
+	order ExperimentsOrder,
+) ([]*model.Experiment, error) {
+	return repo.getExperimentsWithQuery(ctx, selectExperimentsSQL+order.sql()+` LIMIT $2 OFFSET $3`,
+		includeDeleted, limit, offset)
+}
+
+const selectExperimentsWhereUserSQL = selectExperimentsColumns + ` WHERE deleted_at IS NULL
+	AND id IN (SELECT experiment_id FROM assignments WHERE user_id=$1) ORDER BY id`
+
+// GetByUser returns the Experiments in which the given user has at least one
+// Assignment, ordered by ID. The soft-deleted ones are not returned
+func (repo *Experiments) GetByUser(ctx context.Context, userID int) ([]*model.Experiment, error) {
+	return repo.getExperimentsWithQuery(ctx, selectExperimentsWhereUserSQL, userID)
+}
+
+// SearchByName returns at most limit Experiments whose name or description
+// contain the given term, ignoring the case, skipping the first offset ones,
+// sorted as set by order
+func (repo *Experiments) SearchByName(
+	ctx context.Context,
+	term string,
+	limit, offset int,
+	includeDeleted bool,
+	order ExperimentsOrder,
+) ([]*model.Experiment, error) {
+	return repo.getExperimentsWithQuery(ctx, selectExperimentsWhereTermSQL+order.sql()+` LIMIT $3 OFFSET $4`,
+		includeDeleted, likePattern(term), limit, offset)
+}
+
+// Count returns the total number of Experiments
+func (repo *Experiments) Count(ctx context.Context, includeDeleted bool) (int, error) {
+	return repo.countWithQuery(ctx, countExperimentsSQL, includeDeleted)
+}
+
+// CountByName returns the number of Experiments whose name or description
+// contain the given term, ignoring the case
+func (repo *Experiments) CountByName(ctx context.Context, term string, includeDeleted bool) (int, error) {
+	return repo.countWithQuery(ctx, countExperimentsWhereTermSQL, includeDeleted, likePattern(term))
+}
+
+func (repo *Experiments) countWithQuery(ctx context.Context, query string, args ...interface{}) (int, error) {
+	row := repo.db.QueryRowContext(ctx, query, args...)
+
+	var count int
+	if err := row.Scan(&count); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (repo *Experiments) getExperimentsWithQuery(ctx context.Context, query string, args ...interface{}) ([]*model.Experiment, error) {
+	rows, err := repo.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("error getting experiments from the DB: %v", err)
 	}
@@ -69,27 +241,365 @@ func (repo *Experiments) GetAll() ([]*model.Experiment, error) {
 		return nil, fmt.Errorf("DB error: %v", err)
 	}
 
+	if err := repo.loadTags(ctx, results); err != nil {
+		return nil, err
+	}
+
 	return results, nil
 }
 
-// Create experiment model in database. On success the assigned ID is set
-func (repo *Experiments) Create(m *model.Experiment) error {
-	r, err := repo.db.Exec(insertExperimentSQL, m.Name, m.Description)
+// selectExperimentsWhereTagsSQL takes the placeholders of the tags
+const selectExperimentsWhereTagsSQL = selectExperimentsColumns + ` WHERE ($1 OR deleted_at IS NULL)
+	AND id IN (SELECT experiment_id FROM experiment_tags WHERE tag IN (%s))`
+
+// SearchByTags returns all the Experiments that have any of the given tags,
+// sorted as set by order. If term is not empty, only the ones whose name or
+// description contain it, ignoring the case, are returned
+func (repo *Experiments) SearchByTags(
+	ctx context.Context,
+	tags []string,
+	term string,
+	includeDeleted bool,
+	order ExperimentsOrder,
+) ([]*model.Experiment, error) {
+	args := []interface{}{includeDeleted}
+	for _, tag := range tags {
+		args = append(args, tag)
+	}
+
+	query := fmt.Sprintf(selectExperimentsWhereTagsSQL, placeholders(2, len(tags)))
+	if term != "" {
+		args = append(args, likePattern(term))
+		query += fmt.Sprintf(` AND (LOWER(name) LIKE $%[1]d ESCAPE '\' OR LOWER(description) LIKE $%[1]d ESCAPE '\')`, len(args))
+	}
+
+	return repo.getExperimentsWithQuery(ctx, query+order.sql(), args...)
+}
+
+// selectTagsSQL takes the placeholders of the experiment IDs
+const selectTagsSQL = `SELECT experiment_id, tag FROM experiment_tags
+	WHERE experiment_id IN (%s) ORDER BY tag`
+
+// loadTags sets the Tags of the given Experiments
+func (repo *Experiments) loadTags(ctx context.Context, exps []*model.Experiment) error {
+	if len(exps) == 0 {
+		return nil
+	}
+
+	byID := make(map[int]*model.Experiment, len(exps))
+	args := make([]interface{}, len(exps))
+	for i, exp := range exps {
+		byID[exp.ID] = exp
+		args[i] = exp.ID
+	}
+
+	rows, err := repo.db.QueryContext(ctx, fmt.Sprintf(selectTagsSQL, placeholders(1, len(exps))), args...)
+	if err != nil {
+		return fmt.Errorf("error getting experiment tags from the DB: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var tag string
+		if err := rows.Scan(&id, &tag); err != nil {
+			return fmt.Errorf("DB error: %v", err)
+		}
+
+		if exp, ok := byID[id]; ok {
+			exp.Tags = append(exp.Tags, tag)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("DB error: %v", err)
+	}
+
+	return nil
+}
+
+// The casts let PostgreSQL know the types of the selected arguments
+const insertTagSQL = `INSERT INTO experiment_tags (experiment_id, tag)
+	SELECT CAST($1 AS INTEGER), CAST($2 AS TEXT)
+	WHERE NOT EXISTS (SELECT 1 FROM experiment_tags WHERE experiment_id=$1 AND tag=$2)`
+
+// AddTags adds, in a single transaction, the given tags to the Experiment
+// with the given ID. The tags it already has are skipped
+func (repo *Experiments) AddTags(ctx context.Context, id int, tags []string) error {
+	tx, err := repo.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	newID, err := r.LastInsertId()
+
+	for _, tag := range tags {
+		if _, err := tx.ExecContext(ctx, insertTagSQL, id, tag); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("DB error: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("DB error: %v", err)
+	}
+
+	return nil
+}
+
+const deleteTagSQL = `DELETE FROM experiment_tags WHERE experiment_id=$1 AND tag=$2`
+
+// RemoveTag removes the tag from the Experiment with the given ID. It returns
+// false if the Experiment did not have it
+func (repo *Experiments) RemoveTag(ctx context.Context, id int, tag string) (bool, error) {
+	r, err := repo.db.ExecContext(ctx, deleteTagSQL, id, tag)
+	if err != nil {
+		return false, fmt.Errorf("DB error: %v", err)
+	}
+
+	n, err := r.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("DB error: %v", err)
+	}
+
+	return n > 0, nil
+}
+
+// Create experiment model in database. On success the assigned ID is set.
+// If the AssignmentStrategy is not set, AssignmentSequential is used
+func (repo *Experiments) Create(ctx context.Context, m *model.Experiment) error {
+	if m.AssignmentStrategy == "" {
+		m.AssignmentStrategy = model.AssignmentSequential
+	}
+
+	answers, err := answersValue(m.Answers)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	_, err = repo.db.ExecContext(ctx, insertExperimentSQL, m.Name, m.Description, m.OutlierThreshold,
+		string(m.AssignmentStrategy), m.AssignmentSeed, now, answers)
+	if err != nil {
+		return err
+	}
+
+	newID, err := experimentIDByName(ctx, repo.db, m.Name)
 	if err != nil {
 		return err
 	}
 
-	m.ID = int(newID)
+	m.ID = newID
+	m.Status = model.ExperimentActive
+	m.CreatedAt = &now
+	m.UpdatedAt = &now
 
 	return nil
 }
 
-// Update experiment model in database
-func (repo *Experiments) Update(m *model.Experiment) error {
-	_, err := repo.db.Exec(updateExperimentSQL, m.Name, m.Description, m.ID)
-	return err
+// experimentIDByName returns the ID of the Experiment with the given name.
+// It is used instead of LastInsertId, that is not supported by PostgreSQL,
+// and RETURNING, that is not supported by older SQLite versions
+func experimentIDByName(ctx context.Context, q queryer, name string) (int, error) {
+	var id int
+	if err := q.QueryRowContext(ctx, selectExperimentIDWhereNameSQL, name).Scan(&id); err != nil {
+		return 0, fmt.Errorf("DB error: %v", err)
+	}
+
+	return id, nil
+}
+
+// Update saves the Experiment model in database if its Version is still the
+// stored one, increases the Version and sets UpdatedAt. It returns false,
+// without changing the model, if the Experiment was updated since the Version
+// was read, or if there is no such Experiment
+func (repo *Experiments) Update(ctx context.Context, m *model.Experiment) (bool, error) {
+	now := time.Now().UTC()
+	r, err := repo.db.ExecContext(ctx, updateExperimentSQL,
+		m.Name, m.Description, m.OutlierThreshold, m.Deadline, now, m.ID, m.Version)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := r.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	if n == 0 {
+		return false, nil
+	}
+
+	m.Version++
+	m.UpdatedAt = &now
+	return true, nil
+}
+
+// SoftDelete marks the Experiment with the given ID as deleted, keeping its
+// data in the DB. It returns false if there is no such Experiment or it was
+// already deleted
+func (repo *Experiments) SoftDelete(ctx context.Context, id int) (bool, error) {
+	r, err := repo.db.ExecContext(ctx, softDeleteExperimentSQL, time.Now().UTC(), id)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := r.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// SetStatus changes the status of the Experiment with the given ID. It returns
+// false if there is no such Experiment or it was deleted
+func (repo *Experiments) SetStatus(ctx context.Context, id int, status model.ExperimentStatus) (bool, error) {
+	r, err := repo.db.ExecContext(ctx, updateExperimentStatusSQL, string(status), time.Now().UTC(), id)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := r.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+const updateExperimentSeedSQL = `UPDATE experiments SET assignment_seed=$1, version=version+1, updated_at=$2
+	WHERE id=$3 AND deleted_at IS NULL`
+
+// Reseed sets, in a single transaction, the assignment seed of the Experiment
+// with the given ID, and sorts the pairs of the unanswered Assignments of
+// each user in the order of the new seed. Every user keeps the same pairs,
+// and the answered Assignments are not changed. It returns false if there is
+// no such Experiment or it was deleted, and the number of reordered Assignments
+func (repo *Experiments) Reseed(ctx context.Context, id int, seed int64) (bool, int, error) {
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, 0, err
+	}
+
+	r, err := tx.ExecContext(ctx, updateExperimentSeedSQL, seed, time.Now().UTC(), id)
+	if err != nil {
+		tx.Rollback()
+		return false, 0, fmt.Errorf("DB error: %v", err)
+	}
+
+	n, err := r.RowsAffected()
+	if err != nil || n == 0 {
+		tx.Rollback()
+		return false, 0, err
+	}
+
+	reordered, err := repo.assignments.reorderUnanswered(ctx, tx, id)
+	if err != nil {
+		tx.Rollback()
+		return false, 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, 0, fmt.Errorf("DB error: %v", err)
+	}
+
+	return true, reordered, nil
+}
+
+const (
+	selectPseudonymKeySQL = `SELECT secret FROM pseudonym_keys WHERE experiment_id=$1`
+	insertPseudonymKeySQL = `INSERT INTO pseudonym_keys (experiment_id, secret)
+		SELECT $1, $2 WHERE NOT EXISTS (SELECT 1 FROM pseudonym_keys WHERE experiment_id=$1)`
+)
+
+// PseudonymKey returns the key used to build the pseudonyms of the users in
+// the anonymized exports of the Experiment with the given ID. The first time,
+// the given key is stored and returned, so the pseudonyms are the same in
+// every export
+func (repo *Experiments) PseudonymKey(ctx context.Context, id int, newKey string) (string, error) {
+	// if the key is stored by a concurrent request the insert may fail, but
+	// then the stored key can be read anyway
+	_, insertErr := repo.db.ExecContext(ctx, insertPseudonymKeySQL, id, newKey)
+
+	var key string
+	err := repo.db.QueryRowContext(ctx, selectPseudonymKeySQL, id).Scan(&key)
+	if err == sql.ErrNoRows && insertErr != nil {
+		return "", fmt.Errorf("DB error: %v", insertErr)
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("DB error: %v", err)
+	}
+
+	return key, nil
+}
+
+// NameExists returns true if there is an Experiment, even a soft-deleted one,
+// with the given name
+func (repo *Experiments) NameExists(ctx context.Context, name string) (bool, error) {
+	row := repo.db.QueryRowContext(ctx, countExperimentsWhereNameSQL, name)
+
+	var count int
+	if err := row.Scan(&count); err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// Duplicate creates a new Experiment with the given name and the settings
+// and tags of the passed one, and copies all the FilePairs of the passed Experiment
+// into it. The Assignments and the Deadline are not copied. It returns the new Experiment
+func (repo *Experiments) Duplicate(ctx context.Context, m *model.Experiment, name string) (*model.Experiment, error) {
+	answers, err := answersValue(m.Answers)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	_, err = tx.ExecContext(ctx, insertExperimentSQL, name, m.Description, m.OutlierThreshold,
+		string(m.AssignmentStrategy), m.AssignmentSeed, now, answers)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	newID, err := experimentIDByName(ctx, tx, name)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, copyFilePairsSQL, newID, m.ID); err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("can't copy file pairs: %v", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, copyTagsSQL, newID, m.ID); err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("can't copy tags: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &model.Experiment{
+		ID:               newID,
+		Name:             name,
+		Description:      m.Description,
+		OutlierThreshold: m.OutlierThreshold,
+		Status:           model.ExperimentActive,
+
+		AssignmentStrategy: m.AssignmentStrategy,
+		AssignmentSeed:     m.AssignmentSeed,
+		Tags:               m.Tags,
+		Answers:            m.Answers,
+		CreatedAt:          &now,
+		UpdatedAt:          &now,
+	}, nil
 }
@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 
@@ -20,8 +21,8 @@ func NewFeatures(db *sql.DB) *Features {
 const selectFeaturesSQL = `SELECT name, weight FROM features WHERE blob_id=$1`
 
 // GetAll returns a list of all features for blobID
-func (repo *Features) GetAll(blobID string) ([]*model.Feature, error) {
-	rows, err := repo.db.Query(selectFeaturesSQL, blobID)
+func (repo *Features) GetAll(ctx context.Context, blobID string) ([]*model.Feature, error) {
+	rows, err := repo.db.QueryContext(ctx, selectFeaturesSQL, blobID)
 	if err != nil {
 		return nil, fmt.Errorf("Error getting features from the DB: %v", err)
 	}
@@ -42,3 +43,37 @@ func (repo *Features) GetAll(blobID string) ([]*model.Feature, error) {
 
 	return results, nil
 }
+
+const updateFeatureWeightSQL = `UPDATE features SET weight=$1 WHERE blob_id=$2 AND name=$3`
+
+// UpdateWeights sets, in a single transaction, the weight of the features of
+// the given blobs to the one with the same name in weights. The features
+// missing in weights are not modified
+func (repo *Features) UpdateWeights(ctx context.Context, blobIDs []string, weights map[string]float64) error {
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	update, err := tx.PrepareContext(ctx, updateFeatureWeightSQL)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("DB error: %v", err)
+	}
+	defer update.Close()
+
+	for _, blobID := range blobIDs {
+		for name, weight := range weights {
+			if _, err := update.ExecContext(ctx, weight, blobID, name); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("DB error: %v", err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("DB error: %v", err)
+	}
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 
@@ -21,6 +22,9 @@ func NewFilePairs(db *sql.DB) *FilePairs {
 // does not exist, it returns nil, nil
 func (repo *FilePairs) getWithQuery(queryRow scannable) (*model.FilePair, error) {
 	var pair model.FilePair
+	var baseBlobID, basePath, baseContent sql.NullString
+	var purged sql.NullBool
+	var locA, locB sql.NullInt64
 
 	err := queryRow.Scan(&pair.ID,
 		&pair.Left.BlobID, &pair.Left.RepositoryID, &pair.Left.CommitHash,
@@ -29,38 +33,158 @@ func (repo *FilePairs) getWithQuery(queryRow scannable) (*model.FilePair, error)
 		&pair.Right.BlobID, &pair.Right.RepositoryID, &pair.Right.CommitHash,
 		&pair.Right.Path, &pair.Right.Content, &pair.Right.Hash, &pair.Right.UAST,
 
-		&pair.Score, &pair.ExperimentID)
+		&pair.Score, &pair.ExperimentID,
+
+		&baseBlobID, &basePath, &baseContent,
+
+		&purged, &locA, &locB)
 
 	switch {
 	case err == sql.ErrNoRows:
 		return nil, nil
 	case err != nil:
 		return nil, fmt.Errorf("Error getting file pair from the DB: %v", err)
-	default:
-		return &pair, nil
 	}
+
+	// the pairs created before they could be purged have no value
+	if purged.Valid && purged.Bool {
+		pair.Left.Purged, pair.Left.PurgedLOC = true, int(locA.Int64)
+		pair.Right.Purged, pair.Right.PurgedLOC = true, int(locB.Int64)
+	}
+
+	if baseBlobID.Valid && baseBlobID.String != "" {
+		pair.Base = &model.File{
+			BlobID:  baseBlobID.String,
+			Path:    basePath.String,
+			Content: baseContent.String,
+			Purged:  pair.Left.Purged,
+		}
+	}
+
+	return &pair, nil
 }
 
 const (
 	selectFilePairsSQL = `SELECT id,
 		blob_id_a, repository_id_a, commit_hash_a, path_a, content_a, hash_a, uast_a,
 		blob_id_b, repository_id_b, commit_hash_b, path_b, content_b, hash_b, uast_b,
-		score, experiment_id FROM file_pairs WHERE id=$1`
+		score, experiment_id, blob_id_base, path_base, content_base,
+		purged, loc_a, loc_b FROM file_pairs WHERE id=$1`
 	selectFilePairsWhereExpSQL = `SELECT id,
 		blob_id_a, repository_id_a, commit_hash_a, path_a, content_a, hash_a, uast_a,
 		blob_id_b, repository_id_b, commit_hash_b, path_b, content_b, hash_b, uast_b,
-		score, experiment_id FROM file_pairs WHERE experiment_id=$1`
+		score, experiment_id, blob_id_base, path_base, content_base,
+		purged, loc_a, loc_b FROM file_pairs WHERE experiment_id=$1`
+	selectFilePairsWhereExpPaginatedSQL = selectFilePairsWhereExpSQL + ` ORDER BY id LIMIT $2 OFFSET $3`
+	selectFilePairsWhereExpOrderedSQL   = selectFilePairsWhereExpSQL + ` ORDER BY id`
+	whereFilePairPathSQL                = ` AND (LOWER(path_a) LIKE $2 ESCAPE '\' OR LOWER(path_b) LIKE $2 ESCAPE '\')`
+	selectFilePairsWhereExpAndPathSQL   = selectFilePairsWhereExpSQL + whereFilePairPathSQL +
+		` ORDER BY id LIMIT $3 OFFSET $4`
+	selectFilePairIDsWhereExpSQL     = `SELECT id FROM file_pairs WHERE experiment_id=$1 ORDER BY id`
+	countFilePairsWhereExpSQL        = `SELECT COUNT(*) FROM file_pairs WHERE experiment_id=$1`
+	countFilePairsWhereExpAndPathSQL = countFilePairsWhereExpSQL + whereFilePairPathSQL
 )
 
 // GetByID returns the FilePair with the given ID. If the FilePair does not
 // exist, it returns nil, nil
-func (repo *FilePairs) GetByID(id int) (*model.FilePair, error) {
-	return repo.getWithQuery(repo.db.QueryRow(selectFilePairsSQL, id))
+func (repo *FilePairs) GetByID(ctx context.Context, id int) (*model.FilePair, error) {
+	return repo.getWithQuery(repo.db.QueryRowContext(ctx, selectFilePairsSQL, id))
 }
 
 // GetAll returns all the FilePairs for the given experiment ID
-func (repo *FilePairs) GetAll(experimentID int) ([]*model.FilePair, error) {
-	rows, err := repo.db.Query(selectFilePairsWhereExpSQL, experimentID)
+func (repo *FilePairs) GetAll(ctx context.Context, experimentID int) ([]*model.FilePair, error) {
+	return repo.getFilePairsWithQuery(ctx, selectFilePairsWhereExpSQL, experimentID)
+}
+
+// GetPaginated returns at most limit FilePairs for the given experiment ID,
+// skipping the first offset ones
+func (repo *FilePairs) GetPaginated(ctx context.Context, experimentID, limit, offset int) ([]*model.FilePair, error) {
+	return repo.getFilePairsWithQuery(ctx, selectFilePairsWhereExpPaginatedSQL, experimentID, limit, offset)
+}
+
+// SearchByPath returns at most limit FilePairs for the given experiment ID
+// whose left or right path contain the given term, ignoring the case, skipping
+// the first offset ones
+func (repo *FilePairs) SearchByPath(
+	ctx context.Context,
+	experimentID int,
+	term string,
+	limit, offset int,
+) ([]*model.FilePair, error) {
+	return repo.getFilePairsWithQuery(ctx, selectFilePairsWhereExpAndPathSQL,
+		experimentID, likePattern(term), limit, offset)
+}
+
+// Count returns the total number of FilePairs for the given experiment ID
+func (repo *FilePairs) Count(ctx context.Context, experimentID int) (int, error) {
+	return repo.countWithQuery(ctx, countFilePairsWhereExpSQL, experimentID)
+}
+
+// CountByPath returns the number of FilePairs for the given experiment ID
+// whose left or right path contain the given term, ignoring the case
+func (repo *FilePairs) CountByPath(ctx context.Context, experimentID int, term string) (int, error) {
+	return repo.countWithQuery(ctx, countFilePairsWhereExpAndPathSQL, experimentID, likePattern(term))
+}
+
+func (repo *FilePairs) countWithQuery(ctx context.Context, query string, args ...interface{}) (int, error) {
+	row := repo.db.QueryRowContext(ctx, query, args...)
+
+	var count int
+	if err := row.Scan(&count); err != nil {
+		return 0, fmt.Errorf("DB error: %v", err)
+	}
+
+	return count, nil
+}
+
+// selectFilePairStatsSQL counts the lines of code the same way the file pair
+// responses do, as the number of line breaks plus one. The purged pairs use
+// the lines counted before their content was deleted
+var selectFilePairStatsSQL = `SELECT COUNT(*),
+	COALESCE(SUM(COALESCE(loc_a, ` + countLinesSQL("content_a") + `) +
+		COALESCE(loc_b, ` + countLinesSQL("content_b") + `)), 0),
+	COALESCE(AVG(score), 0)
+	FROM file_pairs WHERE experiment_id=$1`
+
+func countLinesSQL(column string) string {
+	return fmt.Sprintf("(LENGTH(COALESCE(%[1]s, '')) - LENGTH(REPLACE(COALESCE(%[1]s, ''), '\n', '')) + 1)", column)
+}
+
+// GetStats returns the number of FilePairs for the given experiment ID, with
+// their total lines of code and their mean score
+func (repo *FilePairs) GetStats(ctx context.Context, experimentID int) (*model.FilePairStats, error) {
+	row := repo.db.QueryRowContext(ctx, selectFilePairStatsSQL, experimentID)
+
+	var stats model.FilePairStats
+	if err := row.Scan(&stats.Count, &stats.LOC, &stats.MeanScore); err != nil {
+		return nil, fmt.Errorf("DB error: %v", err)
+	}
+
+	return &stats, nil
+}
+
+// selectFilePairsWhereExpAndIDsSQL takes the placeholders of the IDs
+const selectFilePairsWhereExpAndIDsSQL = selectFilePairsWhereExpSQL + ` AND id IN (%s)`
+
+// GetByIDs returns the FilePairs of the given experiment with any of the given
+// IDs, in no particular order. The IDs without a FilePair are ignored
+func (repo *FilePairs) GetByIDs(ctx context.Context, experimentID int, ids []int) ([]*model.FilePair, error) {
+	if len(ids) == 0 {
+		return []*model.FilePair{}, nil
+	}
+
+	args := make([]interface{}, len(ids)+1)
+	args[0] = experimentID
+	for i, id := range ids {
+		args[i+1] = id
+	}
+
+	query := fmt.Sprintf(selectFilePairsWhereExpAndIDsSQL, placeholders(2, len(ids)))
+	return repo.getFilePairsWithQuery(ctx, query, args...)
+}
+
+func (repo *FilePairs) getFilePairsWithQuery(ctx context.Context, query string, args ...interface{}) ([]*model.FilePair, error) {
+	rows, err := repo.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("error getting file pairs from the DB: %v", err)
 	}
@@ -83,3 +207,157 @@ func (repo *FilePairs) GetAll(experimentID int) ([]*model.FilePair, error) {
 
 	return results, nil
 }
+
+// ForEach calls fn with every FilePair of the given experiment, in order. The
+// rows are read one by one so the whole set is never kept in memory. If fn
+// returns an error the iteration stops, and the error is returned
+func (repo *FilePairs) ForEach(ctx context.Context, experimentID int, fn func(*model.FilePair) error) error {
+	rows, err := repo.db.QueryContext(ctx, selectFilePairsWhereExpOrderedSQL, experimentID)
+	if err != nil {
+		return fmt.Errorf("error getting file pairs from the DB: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		fp, err := repo.getWithQuery(rows)
+		if err != nil {
+			return fmt.Errorf("DB error: %v", err)
+		}
+
+		if err := fn(fp); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("DB error: %v", err)
+	}
+
+	return nil
+}
+
+// GetIDs returns the IDs of all the FilePairs for the given experiment ID
+func (repo *FilePairs) GetIDs(ctx context.Context, experimentID int) ([]int, error) {
+	rows, err := repo.db.QueryContext(ctx, selectFilePairIDsWhereExpSQL, experimentID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting file pairs from the DB: %v", err)
+	}
+	defer rows.Close()
+
+	results := make([]int, 0)
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("DB error: %v", err)
+		}
+
+		results = append(results, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("DB error: %v", err)
+	}
+
+	return results, nil
+}
+
+const selectBlobSQL = `SELECT
+		blob_id_a, repository_id_a, commit_hash_a, path_a, content_a, hash_a, uast_a, purged, loc_a
+		FROM file_pairs WHERE blob_id_a=$1
+	UNION ALL SELECT
+		blob_id_b, repository_id_b, commit_hash_b, path_b, content_b, hash_b, uast_b, purged, loc_b
+		FROM file_pairs WHERE blob_id_b=$1
+	UNION ALL SELECT
+		blob_id_base, '', '', path_base, content_base, '', NULL, purged, NULL
+		FROM file_pairs WHERE blob_id_base=$1
+	LIMIT 1`
+
+// GetBlob returns the File with the given blob ID from any of the FilePairs,
+// including their base files.
+// If the blob does not exist, it returns nil, nil
+func (repo *FilePairs) GetBlob(ctx context.Context, blobID string) (*model.File, error) {
+	var f model.File
+	var purged sql.NullBool
+	var loc sql.NullInt64
+
+	err := repo.db.QueryRowContext(ctx, selectBlobSQL, blobID).Scan(&f.BlobID,
+		&f.RepositoryID, &f.CommitHash, &f.Path, &f.Content, &f.Hash, &f.UAST, &purged, &loc)
+
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil
+	case err != nil:
+		return nil, fmt.Errorf("Error getting blob from the DB: %v", err)
+	}
+
+	f.Purged, f.PurgedLOC = purged.Valid && purged.Bool, int(loc.Int64)
+	return &f, nil
+}
+
+const selectBlobIDsSQL = `SELECT blob_id_a, blob_id_b FROM file_pairs
+	WHERE id=$1 AND experiment_id=$2 AND purged IS NULL`
+
+// GetBlobIDs returns the left and right blob IDs of the FilePair with the
+// given ID in the given experiment. If the FilePair does not exist, or if its
+// contents were purged, it returns empty strings
+func (repo *FilePairs) GetBlobIDs(ctx context.Context, experimentID, id int) (string, string, error) {
+	var left, right string
+
+	err := repo.db.QueryRowContext(ctx, selectBlobIDsSQL, id, experimentID).Scan(&left, &right)
+
+	switch {
+	case err == sql.ErrNoRows:
+		return "", "", nil
+	case err != nil:
+		return "", "", fmt.Errorf("Error getting blob IDs from the DB: %v", err)
+	default:
+		return left, right, nil
+	}
+}
+
+// The gold answers are kept out of model.FilePair, and of every other query,
+// so they are never sent to the annotators
+const updateGoldAnswerSQL = `UPDATE file_pairs SET gold_answer=$1 WHERE id=$2 AND experiment_id=$3`
+
+// SetGoldAnswer sets the expected answer of the FilePair with the given ID in
+// the given experiment, making it a gold standard pair. A nil answer makes it
+// a regular pair again. It returns false if there is no such FilePair
+func (repo *FilePairs) SetGoldAnswer(ctx context.Context, experimentID, id int, answer *string) (bool, error) {
+	r, err := repo.db.ExecContext(ctx, updateGoldAnswerSQL, answer, id, experimentID)
+	if err != nil {
+		return false, fmt.Errorf("DB error: %v", err)
+	}
+
+	n, err := r.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("DB error: %v", err)
+	}
+
+	return n > 0, nil
+}
+
+// purgeFilePairsSQL keeps the lines of code of the files before emptying
+// them, as the expressions of SET use the values previous to the update
+var purgeFilePairsSQL = `UPDATE file_pairs SET purged=$1,
+	loc_a=` + countLinesSQL("content_a") + `, loc_b=` + countLinesSQL("content_b") + `,
+	content_a='', content_b='', uast_a=NULL, uast_b=NULL,
+	content_base=CASE WHEN content_base IS NULL THEN NULL ELSE '' END
+	WHERE experiment_id=$2 AND purged IS NULL`
+
+// Purge deletes the contents and UASTs of all the FilePairs of the given
+// experiment, keeping the rest of their data and their lines of code. It
+// returns the number of FilePairs purged, the ones already purged are skipped
+func (repo *FilePairs) Purge(ctx context.Context, experimentID int) (int, error) {
+	r, err := repo.db.ExecContext(ctx, purgeFilePairsSQL, true, experimentID)
+	if err != nil {
+		return 0, fmt.Errorf("DB error: %v", err)
+	}
+
+	n, err := r.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("DB error: %v", err)
+	}
+
+	return int(n), nil
+}
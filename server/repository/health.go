@@ -0,0 +1,26 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Health repository
+type Health struct {
+	db *sql.DB
+}
+
+// NewHealth returns a new Health repository
+func NewHealth(db *sql.DB) *Health {
+	return &Health{db: db}
+}
+
+// Ping checks that the DB is reachable
+func (repo *Health) Ping(ctx context.Context) error {
+	if err := repo.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("DB error: %v", err)
+	}
+
+	return nil
+}
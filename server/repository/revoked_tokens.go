@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// RevokedTokens repository, holding the IDs of the revoked JWT until they
+// expire. It implements service.TokenDenylist
+type RevokedTokens struct {
+	db *sql.DB
+}
+
+// NewRevokedTokens returns a new RevokedTokens repository
+func NewRevokedTokens(db *sql.DB) *RevokedTokens {
+	return &RevokedTokens{db: db}
+}
+
+const (
+	deleteExpiredRevokedTokensSQL = `DELETE FROM revoked_tokens WHERE expires_at<=$1`
+	insertRevokedTokenSQL         = `INSERT INTO revoked_tokens (token_id, expires_at)
+		SELECT $1, $2 WHERE NOT EXISTS (SELECT 1 FROM revoked_tokens WHERE token_id=$1)`
+	selectRevokedTokenSQL = `SELECT COUNT(*) FROM revoked_tokens WHERE token_id=$1 AND expires_at>$2`
+)
+
+// Add denies the token with the given ID until the given time. The expired
+// tokens are deleted, so the table only grows with the ones that could still
+// be used
+func (repo *RevokedTokens) Add(ctx context.Context, id string, until time.Time) error {
+	now := revokedTokensTime(time.Now())
+	if _, err := repo.db.ExecContext(ctx, deleteExpiredRevokedTokensSQL, now); err != nil {
+		return fmt.Errorf("DB error: %v", err)
+	}
+
+	if !until.After(now) {
+		return nil
+	}
+
+	if _, err := repo.db.ExecContext(ctx, insertRevokedTokenSQL, id, revokedTokensTime(until)); err != nil {
+		return fmt.Errorf("DB error: %v", err)
+	}
+
+	return nil
+}
+
+// Contains returns true if the token with the given ID is denied
+func (repo *RevokedTokens) Contains(ctx context.Context, id string) (bool, error) {
+	var count int
+	err := repo.db.QueryRowContext(ctx, selectRevokedTokenSQL, id, revokedTokensTime(time.Now())).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("DB error: %v", err)
+	}
+
+	return count > 0, nil
+}
+
+// revokedTokensTime returns the time in UTC truncated to seconds, as the tokens
+// expire, so SQLite can compare the stored times as strings
+func revokedTokensTime(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Second)
+}
@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 
@@ -19,23 +20,28 @@ func NewUsers(db *sql.DB) *Users {
 
 const (
 	insertUsersSQL           = `INSERT INTO users (login, username, avatar_url, role) VALUES ($1, $2, $3, $4)`
-	updateUsersSQL           = `UPDATE users SET username = $1, avatar_url = $2, role = $3 WHERE login = $4`
+	updateUsersSQL           = `UPDATE users SET username = $1, avatar_url = $2 WHERE login = $3`
 	selectUsersWhereLoginSQL = `SELECT * FROM users WHERE login=$1`
 	selectUsersWhereIDSQL    = `SELECT * FROM users WHERE id=$1`
+	// an empty role or term does not filter the users
+	usersFilterSQL = ` WHERE ($1 = '' OR role = $1)
+		AND ($2 = '' OR LOWER(login) LIKE $2 ESCAPE '\' OR LOWER(username) LIKE $2 ESCAPE '\')`
+	selectUsersPaginatedSQL = `SELECT * FROM users` + usersFilterSQL + ` ORDER BY id LIMIT $3 OFFSET $4`
+	countUsersSQL           = `SELECT COUNT(*) FROM users` + usersFilterSQL
 )
 
 // Create stores a User into the DB. If the User is created, the argument
 // is updated to point to that new User
-func (repo *Users) Create(user *model.User) error {
+func (repo *Users) Create(ctx context.Context, user *model.User) error {
 
-	_, err := repo.db.Exec(insertUsersSQL,
+	_, err := repo.db.ExecContext(ctx, insertUsersSQL,
 		user.Login, user.Username, user.AvatarURL, user.Role)
 
 	if err != nil {
 		return err
 	}
 
-	newUser, err := repo.Get(user.Login)
+	newUser, err := repo.Get(ctx, user.Login)
 	if newUser != nil {
 		*user = *newUser
 	}
@@ -43,16 +49,17 @@ func (repo *Users) Create(user *model.User) error {
 	return err
 }
 
-// Update the given user in the database
-func (repo *Users) Update(user *model.User) error {
-	_, err := repo.db.Exec(updateUsersSQL, user.Username, user.AvatarURL, user.Role, user.Login)
+// Update the GitHub profile of the given user in the database. The role is
+// not changed, it is only set with UpdateRole
+func (repo *Users) Update(ctx context.Context, user *model.User) error {
+	_, err := repo.db.ExecContext(ctx, updateUsersSQL, user.Username, user.AvatarURL, user.Login)
 
 	return err
 }
 
-// getWithQuery builds a User from the given sql QueryRow. If the User does not
-// exist, it returns nil, nil
-func (repo *Users) getWithQuery(queryRow *sql.Row) (*model.User, error) {
+// getWithQuery builds a User from the given sql Row or Rows. If the User does
+// not exist, it returns nil, nil
+func (repo *Users) getWithQuery(queryRow scannable) (*model.User, error) {
 	var user model.User
 
 	err := queryRow.Scan(&user.ID, &user.Login, &user.Username, &user.AvatarURL, &user.Role)
@@ -69,12 +76,91 @@ func (repo *Users) getWithQuery(queryRow *sql.Row) (*model.User, error) {
 
 // Get returns the User with the given GitHub login name. If the User does not
 // exist, it returns nil, nil
-func (repo *Users) Get(login string) (*model.User, error) {
-	return repo.getWithQuery(repo.db.QueryRow(selectUsersWhereLoginSQL, login))
+func (repo *Users) Get(ctx context.Context, login string) (*model.User, error) {
+	return repo.getWithQuery(repo.db.QueryRowContext(ctx, selectUsersWhereLoginSQL, login))
 }
 
 // GetByID returns the User with the given ID. If the User does not
 // exist, it returns nil, nil
-func (repo *Users) GetByID(id int) (*model.User, error) {
-	return repo.getWithQuery(repo.db.QueryRow(selectUsersWhereIDSQL, id))
+func (repo *Users) GetByID(ctx context.Context, id int) (*model.User, error) {
+	return repo.getWithQuery(repo.db.QueryRowContext(ctx, selectUsersWhereIDSQL, id))
+}
+
+// usersFilterArgs returns the arguments of usersFilterSQL
+func usersFilterArgs(role model.Role, term string) []interface{} {
+	pattern := ""
+	if term != "" {
+		pattern = likePattern(term)
+	}
+
+	return []interface{}{string(role), pattern}
+}
+
+// GetPaginated returns at most limit Users, skipping the first offset ones,
+// ordered by ID. If role is not empty, only the Users with that role are
+// returned. If term is not empty, only the ones whose login or username
+// contain it, ignoring the case, are returned
+func (repo *Users) GetPaginated(
+	ctx context.Context,
+	role model.Role,
+	term string,
+	limit, offset int,
+) ([]*model.User, error) {
+	args := append(usersFilterArgs(role, term), limit, offset)
+	rows, err := repo.db.QueryContext(ctx, selectUsersPaginatedSQL, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error getting users from the DB: %v", err)
+	}
+	defer rows.Close()
+
+	results := make([]*model.User, 0)
+
+	for rows.Next() {
+		user, err := repo.getWithQuery(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("DB error: %v", err)
+	}
+
+	return results, nil
+}
+
+// Count returns the number of Users with the given role and term, see
+// GetPaginated
+func (repo *Users) Count(ctx context.Context, role model.Role, term string) (int, error) {
+	var count int
+	err := repo.db.QueryRowContext(ctx, countUsersSQL, usersFilterArgs(role, term)...).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("DB error: %v", err)
+	}
+
+	return count, nil
+}
+
+// the role of a requester is only changed if there is another one
+const updateUserRoleSQL = `UPDATE users SET role=$1 WHERE id=$2
+	AND ($1 = 'requester' OR role <> 'requester'
+		OR (SELECT COUNT(*) FROM users WHERE role = 'requester') > 1)`
+
+// UpdateRole changes the Role of the User with the given ID. It returns false
+// if there is no such User, or if it is the last Requester and the new Role
+// is not Requester
+func (repo *Users) UpdateRole(ctx context.Context, id int, role model.Role) (bool, error) {
+	r, err := repo.db.ExecContext(ctx, updateUserRoleSQL, role, id)
+	if err != nil {
+		return false, fmt.Errorf("DB error: %v", err)
+	}
+
+	n, err := r.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("DB error: %v", err)
+	}
+
+	return n > 0, nil
 }
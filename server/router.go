@@ -2,6 +2,7 @@ package server
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/src-d/code-annotation/server/dbutil"
 	"github.com/src-d/code-annotation/server/handler"
@@ -11,24 +12,43 @@ import (
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
-	"github.com/pressly/lg"
-	"github.com/rs/cors"
 	"github.com/sirupsen/logrus"
 )
 
+// Config holds the services and settings used by Router. It is built by the
+// server command from the environment
+type Config struct {
+	Logger          *logrus.Logger
+	JWT             *service.JWT
+	OAuth           *service.OAuth
+	AuthRateLimit   service.RateLimitStore
+	IdempotencyKeys *service.IdempotencyStore
+	CORS            service.CORSConfig
+	ProgressHub     *service.Hub
+	EventHub        *service.Hub
+	Metrics         *service.Metrics
+	Diff            *service.Diff
+	DiffCache       *service.DiffCache
+	// Static serves the frontend; it is not served if nil
+	Static *handler.Static
+	DB     *dbutil.DB
+
+	ExportsPath       string
+	OutlierThreshold  time.Duration
+	MaxDuration       time.Duration
+	MaxFailureDetails int
+	MaxCommentLength  int
+	MaxBodySize       int64
+	MaxUploadSize     int64
+	CompressMinSize   int
+	CompressLevel     int
+	BuildInfo         handler.BuildInfo
+}
+
 // Router returns a Handler to serve the code-anotation backend
-func Router(
-	logger *logrus.Logger,
-	jwt *service.JWT,
-	oauth *service.OAuth,
-	diffService *service.Diff,
-	static *handler.Static,
-	dbWrapper *dbutil.DB,
-	exportsPath string,
-	version string,
-) http.Handler {
-
-	db := dbWrapper.SQLDB()
+func Router(conf Config) http.Handler {
+
+	db := conf.DB.SQLDB()
 
 	// create repos
 	userRepo := repository.NewUsers(db)
@@ -36,78 +56,205 @@ func Router(
 	assignmentRepo := repository.NewAssignments(db)
 	filePairRepo := repository.NewFilePairs(db)
 	featureRepo := repository.NewFeatures(db)
+	healthRepo := repository.NewHealth(db)
+	apiKeyRepo := repository.NewAPIKeys(db)
 
-	// cors options
-	corsOptions := cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "OPTIONS"},
-		AllowedHeaders:   []string{"Location", "Authorization", "Content-Type"},
-		AllowCredentials: true,
-	}
-
-	requesterACL := service.NewACL(userRepo, model.Requester)
-	export := handler.NewExport(dbWrapper, exportsPath)
+	requireRequester := handler.RequireRole(userRepo, model.Requester)
+	requesterOnly := handler.RequireRoleHandler(userRepo, model.Requester)
+	rateLimit := handler.RateLimit(conf.AuthRateLimit)
+	idempotent := handler.Idempotent(conf.IdempotencyKeys)
+	export := handler.NewExport(conf.DB, conf.ExportsPath)
+	apiKeyAuth := service.NewAPIKeyAuth(apiKeyRepo)
 
 	r := chi.NewRouter()
 
 	r.Use(middleware.Recoverer)
-	r.Use(cors.New(corsOptions).Handler)
-	r.Use(lg.RequestLogger(logger))
+	r.Use(handler.CORS(conf.CORS))
+	r.Use(handler.RequestMetrics(conf.Metrics))
+	r.Use(handler.RequestLogger(conf.Logger))
+	r.Use(handler.Compress(conf.CompressMinSize, conf.CompressLevel))
+
+	r.Get("/login", handler.Login(conf.OAuth))
+	r.Get("/api/auth", handler.APIHandlerFunc(
+		rateLimit(handler.OAuthCallback(conf.OAuth, conf.JWT, userRepo, conf.Logger))))
+	r.Post("/api/auth/refresh", handler.APIHandlerFunc(
+		rateLimit(handler.RefreshToken(conf.JWT))))
 
-	r.Get("/login", handler.Login(oauth))
-	r.Get("/api/auth", handler.APIHandlerFunc(handler.OAuthCallback(oauth, jwt, userRepo, logger)))
+	r.Route("/ws", func(r chi.Router) {
+		r.Use(conf.JWT.Middleware)
+		r.Use(requesterOnly)
+
+		r.Get("/experiments/{experimentId}/progress",
+			handler.ExperimentProgressWebSocket(assignmentRepo, conf.ProgressHub, conf.CORS.AllowedOrigins))
+	})
 
 	r.Route("/api", func(r chi.Router) {
-		r.Use(jwt.Middleware)
+		r.Use(apiKeyAuth.Middleware(conf.JWT.Middleware))
+		r.Use(handler.MaxBodySize(conf.MaxBodySize))
 
 		r.Get("/me", handler.APIHandlerFunc(handler.Me(userRepo)))
+		r.Get("/me/experiments", handler.APIHandlerFunc(handler.GetMyExperiments(experimentRepo, assignmentRepo)))
+		r.Post("/logout", handler.APIHandlerFunc(handler.Logout(conf.JWT)))
+		r.Get("/users", handler.APIHandlerFunc(
+			requireRequester(handler.GetUsers(userRepo))))
+		r.Put("/users/{userId}/role", handler.APIHandlerFunc(
+			requireRequester(handler.UpdateUserRole(userRepo))))
+		r.Get("/leaderboard", handler.APIHandlerFunc(handler.GetLeaderboard(assignmentRepo)))
 
 		r.Get("/experiments", handler.APIHandlerFunc(handler.GetExperiments(experimentRepo, assignmentRepo)))
-		r.With(requesterACL.Middleware).
-			Post("/experiments", handler.APIHandlerFunc(handler.CreateExperiment(experimentRepo)))
+		r.Get("/experiments/progress", handler.APIHandlerFunc(handler.GetExperimentsProgress(assignmentRepo)))
+		r.Post("/experiments/progress", handler.APIHandlerFunc(handler.GetExperimentsProgress(assignmentRepo)))
+		r.Post("/experiments", handler.APIHandlerFunc(
+			requireRequester(handler.CreateExperiment(experimentRepo))))
 
 		r.Route("/experiments/{experimentId}", func(r chi.Router) {
 
-			r.Get("/", handler.APIHandlerFunc(handler.GetExperimentDetails(experimentRepo, assignmentRepo)))
-			r.With(requesterACL.Middleware).
-				Put("/", handler.APIHandlerFunc(handler.UpdateExperiment(experimentRepo, assignmentRepo)))
+			r.Get("/", handler.APIHandlerFunc(handler.GetExperimentDetails(userRepo, experimentRepo, assignmentRepo)))
+			r.Put("/", handler.APIHandlerFunc(
+				requireRequester(handler.UpdateExperiment(experimentRepo, assignmentRepo))))
+			r.Patch("/", handler.APIHandlerFunc(
+				requireRequester(handler.UpdateExperiment(experimentRepo, assignmentRepo))))
+			r.Delete("/", handler.APIHandlerFunc(
+				requireRequester(handler.DeleteExperiment(experimentRepo))))
+			r.Post("/duplicate", handler.APIHandlerFunc(
+				requireRequester(handler.DuplicateExperiment(experimentRepo))))
+			r.Post("/freeze", handler.APIHandlerFunc(
+				requireRequester(handler.FreezeExperiment(experimentRepo, assignmentRepo))))
+			r.Post("/unfreeze", handler.APIHandlerFunc(
+				requireRequester(handler.UnfreezeExperiment(experimentRepo, assignmentRepo))))
+			r.Post("/reseed", handler.APIHandlerFunc(
+				requireRequester(handler.ReseedExperiment(experimentRepo))))
+			r.Post("/purge-blobs", handler.APIHandlerFunc(
+				requireRequester(handler.PurgeExperimentBlobs(experimentRepo, filePairRepo))))
+			r.Post("/tags", handler.APIHandlerFunc(
+				requireRequester(handler.AddExperimentTags(experimentRepo, assignmentRepo))))
+			r.Delete("/tags/{tag}", handler.APIHandlerFunc(
+				requireRequester(handler.RemoveExperimentTag(experimentRepo, assignmentRepo))))
+
+			r.Get("/progress", handler.APIHandlerFunc(
+				requireRequester(handler.GetExperimentUserProgress(experimentRepo, assignmentRepo))))
+			r.Get("/stats", handler.APIHandlerFunc(
+				requireRequester(handler.GetExperimentStats(experimentRepo, filePairRepo, assignmentRepo))))
+			r.Get("/timeline", handler.APIHandlerFunc(
+				requireRequester(handler.GetExperimentAnnotationTimeline(experimentRepo, assignmentRepo))))
+			r.Get("/agreement", handler.APIHandlerFunc(
+				requireRequester(handler.GetInterAnnotatorAgreement(experimentRepo, assignmentRepo))))
+			r.With(requesterOnly).
+				Get("/events", handler.ExperimentEvents(conf.EventHub, handler.EventsKeepAlive))
+			r.Get("/agreement/fleiss", handler.APIHandlerFunc(
+				requireRequester(handler.GetFleissKappa(experimentRepo, filePairRepo, assignmentRepo))))
+			r.Get("/consensus", handler.APIHandlerFunc(
+				requireRequester(handler.GetPairConsensus(experimentRepo, assignmentRepo))))
+			r.Get("/comments", handler.APIHandlerFunc(
+				requireRequester(handler.SearchComments(assignmentRepo))))
+			r.Get("/users/{userId}/quality", handler.APIHandlerFunc(
+				requireRequester(handler.GetUserQualityScore(userRepo, assignmentRepo))))
+			r.Get("/users/{userId}/confusion", handler.APIHandlerFunc(
+				requireRequester(handler.GetConfusionMatrix(userRepo, assignmentRepo))))
+			r.Get("/users/{userId}/sessions", handler.APIHandlerFunc(
+				requireRequester(handler.GetUserSessions(userRepo, assignmentRepo))))
 
 			r.Route("/assignments", func(r chi.Router) {
 
 				r.Get("/", handler.APIHandlerFunc(handler.GetAssignmentsForUserExperiment(assignmentRepo)))
-				r.Put("/{assignmentId}", handler.APIHandlerFunc(handler.SaveAssignment(assignmentRepo)))
+				r.Get("/mine", handler.APIHandlerFunc(handler.GetUserAssignments(assignmentRepo)))
+				r.Get("/next", handler.APIHandlerFunc(handler.GetNextUnansweredAssignment(assignmentRepo)))
+				r.Get("/remaining", handler.APIHandlerFunc(handler.GetRemainingCount(assignmentRepo)))
+				r.Get("/flagged", handler.APIHandlerFunc(
+					requireRequester(handler.GetFlaggedAssignments(assignmentRepo))))
+				r.Get("/{assignmentId}", handler.APIHandlerFunc(handler.GetAssignment(userRepo, assignmentRepo)))
+				r.Get("/{assignmentId}/previous", handler.APIHandlerFunc(handler.GetPreviousAssignment(assignmentRepo)))
+				r.Get("/{assignmentId}/history", handler.APIHandlerFunc(handler.GetAnswerHistory(userRepo, assignmentRepo)))
+				r.Post("/", handler.APIHandlerFunc(
+					requireRequester(handler.AssignFilePairs(userRepo, filePairRepo, assignmentRepo))))
+				r.Post("/reassign", handler.APIHandlerFunc(
+					requireRequester(handler.ReassignAssignments(userRepo, assignmentRepo))))
+				r.Post("/reset", handler.APIHandlerFunc(
+					requireRequester(handler.ResetUserAssignments(userRepo, assignmentRepo, conf.ProgressHub))))
+				saveAnswer := handler.APIHandlerFunc(idempotent(
+					handler.SaveAssignment(experimentRepo, assignmentRepo, conf.OutlierThreshold, conf.MaxDuration,
+						conf.MaxCommentLength, conf.ProgressHub, conf.EventHub)))
+				r.Put("/{assignmentId}", saveAnswer)
+				r.Put("/{assignmentId}/answer", saveAnswer)
+				r.Delete("/{assignmentId}", handler.APIHandlerFunc(
+					requireRequester(handler.DeleteAssignment(assignmentRepo))))
+				r.Put("/{assignmentId}/heartbeat", handler.APIHandlerFunc(
+					handler.HeartbeatAssignment(experimentRepo, assignmentRepo, conf.MaxDuration)))
+				r.Put("/{assignmentId}/flag", handler.APIHandlerFunc(handler.FlagAssignment(assignmentRepo, true)))
+				r.Delete("/{assignmentId}/flag", handler.APIHandlerFunc(handler.FlagAssignment(assignmentRepo, false)))
 			})
 
 			r.Route("/file-pairs", func(r chi.Router) {
-				r.Use(requesterACL.Middleware)
+				r.Get("/", handler.APIHandlerFunc(
+					requireRequester(handler.GetFilePairs(filePairRepo, assignmentRepo))))
+				r.With(handler.MaxBodySize(conf.MaxUploadSize)).Post("/", handler.APIHandlerFunc(
+					requireRequester(handler.UploadFilePairs(conf.DB, conf.MaxFailureDetails))))
+				r.With(handler.MaxBodySize(conf.MaxUploadSize)).Post("/csv", handler.APIHandlerFunc(
+					requireRequester(handler.UploadFilePairsCSV(conf.DB, conf.MaxFailureDetails))))
+				r.Get("/skipped", handler.APIHandlerFunc(
+					requireRequester(handler.GetHighSkipPairs(assignmentRepo))))
+				r.Get("/{pairId}/annotations", handler.APIHandlerFunc(
+					requireRequester(handler.GetFilePairAnnotations(assignmentRepo))))
+				r.Get("/{pairId}/archive.zip", handler.GetFilePairArchive(filePairRepo))
+				r.Put("/{pairId}/gold", handler.APIHandlerFunc(
+					requireRequester(handler.SetFilePairGoldAnswer(experimentRepo, filePairRepo))))
+			})
+
+			// registered here instead of in the /file-pairs route, so {pairId}
+			// does not take it
+			r.Get("/file-pairs/batch", handler.APIHandlerFunc(handler.GetFilePairsBatch(filePairRepo, conf.Diff, conf.DiffCache)))
+			r.Post("/file-pairs/batch", handler.APIHandlerFunc(handler.GetFilePairsBatch(filePairRepo, conf.Diff, conf.DiffCache)))
+			r.Get("/file-pairs/{pairId}", handler.WithETag(
+				handler.FilePairETag(filePairRepo),
+				handler.GetFilePairDetails(filePairRepo, conf.Diff, conf.DiffCache)))
+
+			r.With(handler.MaxBodySize(conf.MaxUploadSize)).Post("/annotations", handler.APIHandlerFunc(
+				requireRequester(handler.ImportAnnotations(experimentRepo, userRepo, filePairRepo, assignmentRepo,
+					conf.OutlierThreshold, conf.MaxFailureDetails))))
 
-				r.Get("/", handler.APIHandlerFunc(handler.GetFilePairs(filePairRepo)))
-				r.Post("/", handler.APIHandlerFunc(handler.UploadFilePairs(dbWrapper)))
-				r.Get("/{pairId}/annotations", handler.APIHandlerFunc(handler.GetFilePairAnnotations(assignmentRepo)))
+			r.Route("/api-keys", func(r chi.Router) {
+				r.Get("/", handler.APIHandlerFunc(
+					requireRequester(handler.GetAPIKeys(apiKeyRepo))))
+				r.Post("/", handler.APIHandlerFunc(
+					requireRequester(handler.CreateAPIKey(experimentRepo, userRepo, apiKeyRepo))))
+				r.Delete("/{apiKeyId}", handler.APIHandlerFunc(
+					requireRequester(handler.RevokeAPIKey(apiKeyRepo))))
 			})
 
-			r.Get("/file-pairs/{pairId}", handler.APIHandlerFunc(handler.GetFilePairDetails(filePairRepo, diffService)))
+			r.Route("/exports", func(r chi.Router) {
+				r.Use(requesterOnly)
+
+				r.Get("/annotations.jsonl", handler.ExportExperimentAnnotationsJSONL(experimentRepo, assignmentRepo))
+				r.Get("/file-pairs.csv", handler.ExportFilePairsCSV(experimentRepo, filePairRepo))
+				r.Get("/consensus.csv", handler.ExportConsensusCSV(experimentRepo, filePairRepo, assignmentRepo))
+			})
 		})
 
-		r.Route("/file-pair", func(r chi.Router) {
-			r.Use(requesterACL.Middleware)
+		r.Get("/blobs/{blobId}", handler.APIHandlerFunc(handler.GetBlob(filePairRepo)))
 
-			r.Get("/{pairId}/features", handler.APIHandlerFunc(handler.GetFeatures(filePairRepo, featureRepo)))
+		r.Route("/file-pair", func(r chi.Router) {
+			r.Get("/{pairId}/features", handler.APIHandlerFunc(
+				requireRequester(handler.GetFeatures(filePairRepo, featureRepo))))
+			r.Put("/{pairId}/features", handler.APIHandlerFunc(
+				requireRequester(handler.UpdateFeatureWeights(filePairRepo, featureRepo))))
 		})
 
 		r.Route("/exports", func(r chi.Router) {
-			r.Use(requesterACL.Middleware)
-
-			r.Get("/", handler.APIHandlerFunc(export.List))
-			r.Post("/", handler.APIHandlerFunc(export.Create))
-			r.Get("/{filename}/download", export.Download)
+			r.Get("/", handler.APIHandlerFunc(requireRequester(export.List)))
+			r.Post("/", handler.APIHandlerFunc(requireRequester(export.Create)))
+			r.With(requesterOnly).Get("/{filename}/download", export.Download)
 		})
 	})
 
-	r.Get("/version", handler.APIHandlerFunc(handler.Version(version)))
+	r.Get("/version", handler.APIHandlerFunc(handler.Version(conf.BuildInfo)))
+	r.Get("/healthz", handler.APIHandlerFunc(handler.Health()))
+	r.Get("/readyz", handler.APIHandlerFunc(handler.Readiness(healthRepo)))
 
-	r.Get("/static/*", static.ServeHTTP)
-	r.Get("/*", static.ServeHTTP)
+	// the API routes above always take precedence over the frontend ones
+	if conf.Static != nil {
+		r.Get("/static/*", conf.Static.ServeHTTP)
+		r.Get("/*", conf.Static.ServeHTTP)
+	}
 
 	return r
 }
@@ -2,7 +2,9 @@ package serializer
 
 import (
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/src-d/code-annotation/server/model"
 )
@@ -17,6 +19,7 @@ type HTTPError interface {
 type Response struct {
 	Status int         `json:"status"`
 	Data   interface{} `json:"data,omitempty"`
+	Total  *int        `json:"total,omitempty"`
 	Errors []HTTPError `json:"errors,omitempty"`
 }
 
@@ -62,6 +65,20 @@ func newResponse(c interface{}) *Response {
 	}
 }
 
+// newPaginatedResponse returns a Response for a page of a list, including
+// the total number of elements in the list
+func newPaginatedResponse(c interface{}, total int) *Response {
+	resp := newResponse(c)
+	resp.Total = &total
+
+	return resp
+}
+
+// NewNoContentResponse returns a Response without content
+func NewNoContentResponse() *Response {
+	return newResponse(nil)
+}
+
 // NewEmptyResponse returns an empty Response
 func NewEmptyResponse() *Response {
 	return &Response{}
@@ -72,28 +89,125 @@ type experimentResponse struct {
 	Name        string  `json:"name"`
 	Description string  `json:"description"`
 	Progress    float32 `json:"progress"`
+	Deleted     bool    `json:"deleted"`
+	// OutlierThreshold is in milliseconds, nil if the default one is used
+	OutlierThreshold   *int     `json:"outlierThreshold"`
+	Status             string   `json:"status"`
+	AssignmentStrategy string   `json:"assignmentStrategy"`
+	Tags               []string `json:"tags"`
+	Version            int      `json:"version"`
+	// CreatedAt and UpdatedAt are RFC3339 strings
+	CreatedAt *string `json:"createdAt"`
+	UpdatedAt *string `json:"updatedAt"`
+	// Deadline is an RFC3339 string, nil if there is none. Closed is true
+	// once it has passed
+	Deadline *string `json:"deadline"`
+	Closed   bool    `json:"closed"`
+	// Answers are the accepted answers of its assignments
+	Answers []string `json:"answers"`
+	// AssignmentSeed is only sent to the requesters, for the experiments with
+	// the random assignment strategy. It is a string, as the JavaScript
+	// numbers can not hold every int64
+	AssignmentSeed *string `json:"assignmentSeed,omitempty"`
 }
 
 // NewExperimentResponse returns a Response for the passed Experiment
 func NewExperimentResponse(e *model.Experiment, progress float32) *Response {
-	return newResponse(experimentResponse{
+	return newResponse(newExperimentResponse(e, progress))
+}
+
+// NewExperimentWithSeedResponse returns a Response for the passed Experiment
+// as NewExperimentResponse does, including its assignment seed if it uses
+// the random assignment strategy
+func NewExperimentWithSeedResponse(e *model.Experiment, progress float32) *Response {
+	res := newExperimentResponse(e, progress)
+	if e.AssignmentStrategy == model.AssignmentRandom {
+		seed := strconv.FormatInt(e.AssignmentSeed, 10)
+		res.AssignmentSeed = &seed
+	}
+
+	return newResponse(res)
+}
+
+func newExperimentResponse(e *model.Experiment, progress float32) experimentResponse {
+	return experimentResponse{
 		ID:          e.ID,
 		Name:        e.Name,
 		Description: e.Description,
 		Progress:    progress,
-	})
+		Deleted:     e.IsDeleted(),
+
+		OutlierThreshold: e.OutlierThreshold,
+		Status:           string(e.Status),
+
+		AssignmentStrategy: string(e.AssignmentStrategy),
+		Tags:               experimentTags(e),
+		Version:            e.Version,
+		CreatedAt:          formatTime(e.CreatedAt),
+		UpdatedAt:          formatTime(e.UpdatedAt),
+		Deadline:           formatTime(e.Deadline),
+		Closed:             e.IsClosed(),
+		Answers:            e.AnswerScheme(),
+	}
 }
 
-// NewExperimentsResponse returns a Response with a list of Experiments
-func NewExperimentsResponse(experiments []*model.Experiment, progresses []float32) *Response {
+// NewExperimentsResponse returns a Response with a page of Experiments and the
+// total number of existing Experiments
+func NewExperimentsResponse(experiments []*model.Experiment, progresses []float32, total int) *Response {
 	result := make([]experimentResponse, len(experiments))
 	for i, e := range experiments {
-		result[i] = experimentResponse{
-			ID:          e.ID,
-			Name:        e.Name,
-			Description: e.Description,
-			Progress:    progresses[i],
-		}
+		result[i] = newExperimentResponse(e, progresses[i])
+	}
+
+	return newPaginatedResponse(result, total)
+}
+
+// NewExperimentsProgressResponse returns a Response with the progress of a
+// User in several Experiments, by their IDs
+func NewExperimentsProgressResponse(progresses map[int]float32) *Response {
+	return newResponse(progresses)
+}
+
+// experimentTags returns the tags of the Experiment, never nil so they are
+// always serialized as an array
+func experimentTags(e *model.Experiment) []string {
+	if e.Tags == nil {
+		return []string{}
+	}
+
+	return e.Tags
+}
+
+type leaderboardEntryResponse struct {
+	UserID         int    `json:"userId"`
+	Login          string `json:"login"`
+	CompletedCount int    `json:"completedCount"`
+}
+
+// NewLeaderboardResponse returns a Response with the number of answered
+// Assignments of each User
+func NewLeaderboardResponse(entries []*model.LeaderboardEntry) *Response {
+	result := make([]leaderboardEntryResponse, len(entries))
+	for i, e := range entries {
+		result[i] = leaderboardEntryResponse{e.UserID, e.Login, e.Completed}
+	}
+
+	return newResponse(result)
+}
+
+type userProgressResponse struct {
+	UserID    int     `json:"userId"`
+	Login     string  `json:"login"`
+	Completed int     `json:"completed"`
+	Total     int     `json:"total"`
+	Progress  float32 `json:"progress"`
+}
+
+// NewUsersProgressResponse returns a Response with the progress of each User
+func NewUsersProgressResponse(ps []*model.UserProgress) *Response {
+	result := make([]userProgressResponse, len(ps))
+	for i, p := range ps {
+		result[i] = userProgressResponse{p.UserID, p.Login, p.Completed, p.Total, p.Progress()}
 	}
 
 	return newResponse(result)
@@ -106,41 +220,447 @@ type assignmentResponse struct {
 	ExperimentID int     `json:"experimentId"`
 	Answer       *string `json:"answer"`
 	Duration     int     `json:"duration"`
+	CreatedAt    *string `json:"createdAt"`
+	UpdatedAt    *string `json:"updatedAt"`
+	Outlier      bool    `json:"outlier"`
+	Confidence   *int    `json:"confidence"`
+	Comment      *string `json:"comment"`
+	Flagged      bool    `json:"flagged"`
+}
+
+// NewAssignmentResponse returns a Response for the passed Assignment
+func NewAssignmentResponse(a *model.Assignment) *Response {
+	return newResponse(newAssignmentResponse(a))
+}
+
+func newAssignmentResponse(a *model.Assignment) assignmentResponse {
+	var answer *string
+
+	if a.Answer.Valid {
+		answer = &a.Answer.String
+	}
+
+	return assignmentResponse{a.ID, a.UserID, a.PairID,
+		a.ExperimentID, answer, a.Duration,
+		formatTime(a.CreatedAt), formatTime(a.UpdatedAt), a.Outlier, a.Confidence, a.Comment, a.Flagged}
+}
+
+type apiKeyResponse struct {
+	ID           int     `json:"id"`
+	UserID       int     `json:"userId"`
+	ExperimentID int     `json:"experimentId"`
+	Name         string  `json:"name"`
+	CreatedAt    *string `json:"createdAt"`
+	RevokedAt    *string `json:"revokedAt"`
+	Key          string  `json:"key,omitempty"`
+}
+
+func newAPIKeyResponse(k *model.APIKey, key string) apiKeyResponse {
+	return apiKeyResponse{k.ID, k.UserID, k.ExperimentID, k.Name,
+		formatTime(k.CreatedAt), formatTime(k.RevokedAt), key}
+}
+
+// NewAPIKeyResponse returns a Response for the passed APIKey. The key itself
+// is only passed when the APIKey is created, as it can not be retrieved later
+func NewAPIKeyResponse(k *model.APIKey, key string) *Response {
+	return newResponse(newAPIKeyResponse(k, key))
 }
 
-// NewAssignmentsResponse returns a Response for the passed Assignment
+// NewAPIKeysResponse returns a Response for the passed APIKeys, without their keys
+func NewAPIKeysResponse(ks []*model.APIKey) *Response {
+	keys := make([]apiKeyResponse, len(ks))
+	for i, k := range ks {
+		keys[i] = newAPIKeyResponse(k, "")
+	}
+
+	return newResponse(keys)
+}
+
+type commentMatchResponse struct {
+	assignmentResponse
+	Snippet string `json:"snippet"`
+}
+
+// NewCommentMatchesResponse returns a Response for the passed Assignments
+// found by their comment, with the snippet of each comment
+func NewCommentMatchesResponse(as []*model.Assignment, snippets []string) *Response {
+	matches := make([]commentMatchResponse, len(as))
+	for i, a := range as {
+		matches[i] = commentMatchResponse{newAssignmentResponse(a), snippets[i]}
+	}
+
+	return newResponse(matches)
+}
+
+// NewAssignmentsResponse returns a Response for the passed Assignments
 func NewAssignmentsResponse(as []*model.Assignment) *Response {
 	assignments := make([]assignmentResponse, len(as))
 	for i, a := range as {
-		var answer *string
+		assignments[i] = newAssignmentResponse(a)
+	}
 
-		if a.Answer.Valid {
-			answer = &a.Answer.String
-		}
+	return newResponse(assignments)
+}
 
-		assignments[i] = assignmentResponse{a.ID, a.UserID, a.PairID,
-			a.ExperimentID, answer, a.Duration}
+// formatTime returns the RFC3339 representation of the given time, or nil
+func formatTime(t *time.Time) *string {
+	if t == nil {
+		return nil
 	}
 
-	return newResponse(assignments)
+	str := t.UTC().Format(time.RFC3339)
+	return &str
+}
+
+// AnnotationRecord is the exported representation of an Annotation. UserID
+// is nil in the anonymized exports
+type AnnotationRecord struct {
+	AssignmentID int     `json:"assignmentId"`
+	ExperimentID int     `json:"experimentId"`
+	PairID       int     `json:"pairId"`
+	UserID       *int    `json:"userId,omitempty"`
+	Login        string  `json:"login"`
+	LeftPath     string  `json:"leftPath"`
+	RightPath    string  `json:"rightPath"`
+	Answer       *string `json:"answer"`
+	Duration     int     `json:"duration"`
+	Comment      *string `json:"comment"`
 }
 
-// ExpAnnotationResponse stores the data needed by NewExpAnnotationsResponse
+// NewAnnotationRecord returns the AnnotationRecord for the passed Annotation
+func NewAnnotationRecord(a *model.Annotation) AnnotationRecord {
+	var answer *string
+	if a.Answer.Valid {
+		answer = &a.Answer.String
+	}
+
+	return AnnotationRecord{
+		AssignmentID: a.ID,
+		ExperimentID: a.ExperimentID,
+		PairID:       a.PairID,
+		UserID:       &a.UserID,
+		Login:        a.Login,
+		LeftPath:     a.LeftPath,
+		RightPath:    a.RightPath,
+		Answer:       answer,
+		Duration:     a.Duration,
+		Comment:      a.Comment,
+	}
+}
+
+// ExpAnnotationResponse stores the data needed by NewExpAnnotationsResponse.
+// Answers counts every answer, including the ones of custom answer schemes,
+// while Yes, Maybe, No and Skip only count the model.DefaultAnswers
 type ExpAnnotationResponse struct {
-	Yes        int `json:"yes"`
-	Maybe      int `json:"maybe"`
-	No         int `json:"no"`
-	Skip       int `json:"skip"`
-	Unanswered int `json:"unanswered"`
-	Total      int `json:"total"`
+	Yes        int            `json:"yes"`
+	Maybe      int            `json:"maybe"`
+	No         int            `json:"no"`
+	Skip       int            `json:"skip"`
+	Answers    map[string]int `json:"answers,omitempty"`
+	Unanswered int            `json:"unanswered"`
+	Total      int            `json:"total"`
+}
+
+// NewExpAnnotationResponse returns an ExpAnnotationResponse with no
+// Assignments counted yet for every answer of the given Experiment answer
+// scheme, so the answers nobody gave are also included
+func NewExpAnnotationResponse(e *model.Experiment) ExpAnnotationResponse {
+	answers := make(map[string]int)
+	for _, answer := range e.AnswerScheme() {
+		answers[answer] = 0
+	}
+
+	return ExpAnnotationResponse{Answers: answers}
+}
+
+// AnnotationEvent is sent to the experiment event subscribers every time
+// one of its assignments is answered
+type AnnotationEvent struct {
+	PairID int    `json:"pairId"`
+	Answer string `json:"answer"`
+}
+
+// MaybeAs tells how the "maybe" answers are counted by NewExpAnnotationsResponse
+type MaybeAs string
+
+const (
+	// MaybeSeparate keeps the "maybe" answers in their own bucket
+	MaybeSeparate MaybeAs = "separate"
+	// MaybePositive counts the "maybe" answers as "yes"
+	MaybePositive MaybeAs = "positive"
+	// MaybeNegative counts the "maybe" answers as "no"
+	MaybeNegative MaybeAs = "negative"
+)
+
+// IsValid returns true if m is one of the known MaybeAs values
+func (m MaybeAs) IsValid() bool {
+	switch m {
+	case MaybeSeparate, MaybePositive, MaybeNegative:
+		return true
+	}
+
+	return false
 }
 
 // NewExpAnnotationsResponse returns a Response for the Experiment Annotation
-// results
-func NewExpAnnotationsResponse(data ExpAnnotationResponse) *Response {
+// results. The "maybe" answers are moved to the "yes" or "no" bucket, or kept
+// apart, as set by maybeAs
+func NewExpAnnotationsResponse(data ExpAnnotationResponse, maybeAs MaybeAs) *Response {
+	switch maybeAs {
+	case MaybePositive:
+		data.Yes += data.Maybe
+		data.Maybe = 0
+		data.moveAnswers("maybe", "yes")
+	case MaybeNegative:
+		data.No += data.Maybe
+		data.Maybe = 0
+		data.moveAnswers("maybe", "no")
+	}
+
+	return newResponse(data)
+}
+
+// moveAnswers counts the from answers as to answers, if there is any
+func (r *ExpAnnotationResponse) moveAnswers(from, to string) {
+	n, ok := r.Answers[from]
+	if !ok {
+		return
+	}
+
+	delete(r.Answers, from)
+	r.Answers[to] += n
+}
+
+// Add counts n more Assignments with the given answer
+func (r *ExpAnnotationResponse) Add(answer string, n int) {
+	switch answer {
+	case "yes":
+		r.Yes += n
+	case "maybe":
+		r.Maybe += n
+	case "no":
+		r.No += n
+	case "skip":
+		r.Skip += n
+	case "":
+		r.Unanswered += n
+	}
+
+	if answer != "" {
+		if r.Answers == nil {
+			r.Answers = make(map[string]int)
+		}
+
+		r.Answers[answer] += n
+	}
+
+	r.Total += n
+}
+
+// TimelineBucketResponse stores the annotations made in the time bucket
+// that starts at Start
+type TimelineBucketResponse struct {
+	Start time.Time `json:"start"`
+	ExpAnnotationResponse
+}
+
+// NewTimelineResponse returns a Response for the Experiment annotations
+// timeline
+func NewTimelineResponse(buckets []*TimelineBucketResponse) *Response {
+	return newResponse(buckets)
+}
+
+// UsersAgreementResponse stores the agreement between two users on the
+// FilePairs answered by both of them
+type UsersAgreementResponse struct {
+	UserA int     `json:"userA"`
+	UserB int     `json:"userB"`
+	Pairs int     `json:"pairs"`
+	Kappa float64 `json:"kappa"`
+}
+
+type agreementResponse struct {
+	Users        []UsersAgreementResponse `json:"users"`
+	AverageKappa float64                  `json:"averageKappa"`
+}
+
+// NewAgreementResponse returns a Response with the agreement of every two
+// users and its average
+func NewAgreementResponse(users []UsersAgreementResponse, averageKappa float64) *Response {
+	return newResponse(agreementResponse{users, averageKappa})
+}
+
+type pairConsensusResponse struct {
+	PairID  int            `json:"pairId"`
+	Answers map[string]int `json:"answers"`
+	Votes   int            `json:"votes"`
+	// Majority is nil when there is a tie
+	Majority *string `json:"majority"`
+	Tie      bool    `json:"tie"`
+}
+
+// NewPairConsensusResponse returns a Response with the answers given to every
+// FilePair, and their majority answer
+func NewPairConsensusResponse(consensus []*model.PairConsensus) *Response {
+	result := make([]pairConsensusResponse, len(consensus))
+	for i, c := range consensus {
+		majority, tie := c.Majority()
+		result[i] = pairConsensusResponse{
+			PairID:  c.PairID,
+			Answers: c.Answers,
+			Votes:   c.Votes,
+			Tie:     tie,
+		}
+
+		if !tie {
+			result[i].Majority = &majority
+		}
+	}
+
+	return newResponse(result)
+}
+
+type pairSkipRateResponse struct {
+	PairID  int     `json:"pairId"`
+	Skips   int     `json:"skips"`
+	Answers int     `json:"answers"`
+	Rate    float64 `json:"rate"`
+}
+
+// NewPairSkipRatesResponse returns a Response with how many times every
+// FilePair was skipped, and the fraction of its answers that were skipped
+func NewPairSkipRatesResponse(rates []*model.PairSkipRate) *Response {
+	result := make([]pairSkipRateResponse, len(rates))
+	for i, s := range rates {
+		result[i] = pairSkipRateResponse{
+			PairID:  s.PairID,
+			Skips:   s.Skips,
+			Answers: s.Answers,
+			Rate:    s.Rate(),
+		}
+	}
+
+	return newResponse(result)
+}
+
+type confusionMatrixResponse struct {
+	UserID int `json:"userId"`
+	Pairs  int `json:"pairs"`
+	// Matrix holds the user answer counts by majority answer
+	Matrix map[string]map[string]int `json:"matrix"`
+}
+
+// NewConfusionMatrixResponse returns a Response with the confusion matrix of
+// the answers of a User against the majority answers of the compared FilePairs
+func NewConfusionMatrixResponse(userID, pairs int, matrix map[string]map[string]int) *Response {
+	return newResponse(confusionMatrixResponse{UserID: userID, Pairs: pairs, Matrix: matrix})
+}
+
+type fleissKappaResponse struct {
+	Kappa         float64 `json:"kappa"`
+	Pairs         int     `json:"pairs"`
+	ExcludedPairs int     `json:"excludedPairs"`
+}
+
+// NewFleissKappaResponse returns a Response with the Fleiss' kappa of an
+// Experiment, the number of FilePairs used to compute it, and the number of
+// FilePairs excluded for not having enough answers
+func NewFleissKappaResponse(kappa float64, pairs, excludedPairs int) *Response {
+	return newResponse(fleissKappaResponse{kappa, pairs, excludedPairs})
+}
+
+// ExpStatsResponse stores the data needed by NewExpStatsResponse. Durations
+// are in milliseconds
+type ExpStatsResponse struct {
+	MedianDuration float64 `json:"medianDuration"`
+	MeanDuration   float64 `json:"meanDuration"`
+	Durations      int     `json:"durations"`
+	FilePairs      int     `json:"filePairs"`
+	TotalLOC       int     `json:"totalLoc"`
+	MeanScore      float64 `json:"meanScore"`
+	Annotators     int     `json:"annotators"`
+	// MeanConfidence is nil if no answer has a confidence
+	MeanConfidence *float64 `json:"meanConfidence"`
+}
+
+// NewExpStatsResponse returns a Response for the Experiment stats
+func NewExpStatsResponse(data ExpStatsResponse) *Response {
 	return newResponse(data)
 }
 
+type qualityScoreResponse struct {
+	UserID    int `json:"userId"`
+	Evaluated int `json:"evaluated"`
+	Correct   int `json:"correct"`
+	// Accuracy is nil if no answer was evaluated
+	Accuracy *float64 `json:"accuracy"`
+}
+
+// NewQualityScoreResponse returns a Response for the QualityScore of a User
+func NewQualityScoreResponse(userID int, score model.QualityScore) *Response {
+	return newResponse(qualityScoreResponse{
+		UserID:    userID,
+		Evaluated: score.Evaluated,
+		Correct:   score.Correct,
+		Accuracy:  score.Accuracy(),
+	})
+}
+
+type sessionResponse struct {
+	SessionID string `json:"sessionId"`
+	Answers   int    `json:"answers"`
+	// Duration is the sum of the answers durations, in milliseconds
+	Duration int `json:"duration"`
+}
+
+type userSessionsResponse struct {
+	UserID       int               `json:"userId"`
+	SessionCount int               `json:"sessionCount"`
+	Sessions     []sessionResponse `json:"sessions"`
+}
+
+// NewUserSessionsResponse returns a Response for the annotation Sessions of a User
+func NewUserSessionsResponse(userID int, sessions []*model.Session) *Response {
+	result := make([]sessionResponse, len(sessions))
+	for i, s := range sessions {
+		result[i] = sessionResponse{SessionID: s.ID, Answers: s.Answers, Duration: s.Duration}
+	}
+
+	return newResponse(userSessionsResponse{
+		UserID:       userID,
+		SessionCount: len(sessions),
+		Sessions:     result,
+	})
+}
+
+type answerChangeResponse struct {
+	Answer    string `json:"answer"`
+	Duration  int    `json:"duration"`
+	ChangedAt string `json:"changedAt"`
+	UserID    int    `json:"userId"`
+}
+
+type answerHistoryResponse struct {
+	AssignmentID int                    `json:"assignmentId"`
+	Changes      []answerChangeResponse `json:"changes"`
+}
+
+// NewAnswerHistoryResponse returns a Response for the answers given to an
+// Assignment, in order
+func NewAnswerHistoryResponse(assignmentID int, history []*model.AnswerChange) *Response {
+	changes := make([]answerChangeResponse, len(history))
+	for i, c := range history {
+		changes[i] = answerChangeResponse{
+			Answer:    c.Answer,
+			Duration:  c.Duration,
+			ChangedAt: *formatTime(&c.ChangedAt),
+			UserID:    c.UserID,
+		}
+	}
+
+	return newResponse(answerHistoryResponse{AssignmentID: assignmentID, Changes: changes})
+}
+
 type filePairResponse struct {
 	ID          int     `json:"id"`
 	Diff        string  `json:"diff"`
@@ -149,28 +669,136 @@ type filePairResponse struct {
 	RightBlobID string  `json:"rightBlobId"`
 	LeftLOC     int     `json:"leftLoc"`
 	RightLOC    int     `json:"rightLoc"`
+	LeftLang    string  `json:"leftLang"`
+	RightLang   string  `json:"rightLang"`
+	// the changes of the diff, counted in words for the word diffs
+	AddedLines   int `json:"addedLines"`
+	RemovedLines int `json:"removedLines"`
+	ChangedLines int `json:"changedLines"`
+	// Truncated is true if the files are too big to be compared whole
+	Truncated bool `json:"truncated"`
+	// only set for three-way pairs
+	*baseFileResponse
+}
+
+// baseFileResponse holds the base file of a three-way pair, and the diffs of
+// both sides against it
+type baseFileResponse struct {
+	BaseBlobID    string `json:"baseBlobId"`
+	BaseLOC       int    `json:"baseLoc"`
+	BaseLang      string `json:"baseLang"`
+	LeftBaseDiff  string `json:"leftBaseDiff"`
+	RightBaseDiff string `json:"rightBaseDiff"`
+}
+
+// FilePairDetails stores the data needed by NewFilePairResponse and
+// NewFilePairsDetailsResponse for each FilePair. The base fields are only
+// used if the FilePair has a Base
+type FilePairDetails struct {
+	FilePair  *model.FilePair
+	Diff      string
+	LeftLOC   int
+	RightLOC  int
+	LeftLang  string
+	RightLang string
+	// AddedLines, RemovedLines and ChangedLines count the changes of Diff
+	AddedLines   int
+	RemovedLines int
+	ChangedLines int
+	// Truncated is true if any diff only compares the beginning of the files
+	Truncated bool
+
+	LeftBaseDiff  string
+	RightBaseDiff string
+	BaseLOC       int
+	BaseLang      string
+}
+
+// NewFilePairResponse returns a Response for the FilePair of the given details.
+// The base file fields are included only for three-way pairs
+func NewFilePairResponse(d FilePairDetails) *Response {
+	return newResponse(newFilePairResponse(d))
+}
+
+// NewFilePairsDetailsResponse returns a Response with the details of several
+// FilePairs, in the given order
+func NewFilePairsDetailsResponse(details []FilePairDetails) *Response {
+	result := make([]filePairResponse, len(details))
+	for i, d := range details {
+		result[i] = newFilePairResponse(d)
+	}
+
+	return newResponse(result)
 }
 
-// NewFilePairResponse returns a Response for the given FilePair
-func NewFilePairResponse(fp *model.FilePair, diff string, leftLOC, rightLOC int) *Response {
-	return newResponse(filePairResponse{
-		fp.ID, diff, fp.Score, fp.Left.BlobID, fp.Right.BlobID, leftLOC, rightLOC})
+func newFilePairResponse(d FilePairDetails) filePairResponse {
+	fp := d.FilePair
+	res := filePairResponse{
+		ID:          fp.ID,
+		Diff:        d.Diff,
+		Score:       fp.Score,
+		LeftBlobID:  fp.Left.BlobID,
+		RightBlobID: fp.Right.BlobID,
+		LeftLOC:     d.LeftLOC,
+		RightLOC:    d.RightLOC,
+		LeftLang:    d.LeftLang,
+		RightLang:   d.RightLang,
+
+		AddedLines:   d.AddedLines,
+		RemovedLines: d.RemovedLines,
+		ChangedLines: d.ChangedLines,
+		Truncated:    d.Truncated,
+	}
+
+	if fp.Base != nil {
+		res.baseFileResponse = &baseFileResponse{
+			BaseBlobID:    fp.Base.BlobID,
+			BaseLOC:       d.BaseLOC,
+			BaseLang:      d.BaseLang,
+			LeftBaseDiff:  d.LeftBaseDiff,
+			RightBaseDiff: d.RightBaseDiff,
+		}
+	}
+
+	return res
+}
+
+type blobResponse struct {
+	BlobID    string `json:"blobId"`
+	Path      string `json:"path"`
+	Content   string `json:"content"`
+	Truncated bool   `json:"truncated"`
+	Lang      string `json:"lang"`
+	LOC       int    `json:"loc"`
+}
+
+// NewBlobResponse returns a Response for the given blob File, using content
+// instead of its own Content that could be truncated
+func NewBlobResponse(f *model.File, content string, truncated bool, lang string, loc int) *Response {
+	return newResponse(blobResponse{f.BlobID, f.Path, content, truncated, lang, loc})
 }
 
 type listFilePairResponse struct {
-	ID        int    `json:"id"`
-	LeftPath  string `json:"leftPath"`
-	RightPath string `json:"rightPath"`
+	ID              int    `json:"id"`
+	LeftPath        string `json:"leftPath"`
+	RightPath       string `json:"rightPath"`
+	AnnotationCount *int   `json:"annotationCount,omitempty"`
 }
 
-// NewListFilePairsResponse returns a Response for the given FilePairs
-func NewListFilePairsResponse(fps []*model.FilePair) *Response {
+// NewListFilePairsResponse returns a Response with a page of FilePairs and the
+// total number of FilePairs in the experiment. If annotationCounts is not nil,
+// every FilePair includes its number of annotations, by FilePair ID
+func NewListFilePairsResponse(fps []*model.FilePair, total int, annotationCounts map[int]int) *Response {
 	result := make([]listFilePairResponse, len(fps))
 	for i, fp := range fps {
-		result[i] = listFilePairResponse{fp.ID, fp.Left.Path, fp.Right.Path}
+		result[i] = listFilePairResponse{ID: fp.ID, LeftPath: fp.Left.Path, RightPath: fp.Right.Path}
+		if annotationCounts != nil {
+			count := annotationCounts[fp.ID]
+			result[i].AnnotationCount = &count
+		}
 	}
 
-	return newResponse(result)
+	return newPaginatedResponse(result, total)
 }
 
 type userResponse struct {
@@ -187,6 +815,17 @@ func NewUserResponse(u *model.User) *Response {
 		userResponse{u.ID, u.Login, u.Username, u.AvatarURL, u.Role.String()})
 }
 
+// NewUsersResponse returns a Response with a page of Users and the total
+// number of Users matching the filters
+func NewUsersResponse(users []*model.User, total int) *Response {
+	result := make([]userResponse, len(users))
+	for i, u := range users {
+		result[i] = userResponse{u.ID, u.Login, u.Username, u.AvatarURL, u.Role.String()}
+	}
+
+	return newPaginatedResponse(result, total)
+}
+
 type featureResponse struct {
 	Name   string  `json:"name"`
 	Weight float64 `json:"weight"`
@@ -196,10 +835,19 @@ type featuresResponse struct {
 	Object1 []featureResponse `json:"featuresA"`
 	Object2 []featureResponse `json:"featuresB"`
 	Pair    featureResponse   `json:"score"`
+	// Recomputed is the score calculated from the current feature weights
+	Recomputed *featureResponse `json:"recomputedScore,omitempty"`
 }
 
 // NewFeaturesResponse returns a Response for the passed Features and score
 func NewFeaturesResponse(fsA []*model.Feature, fsB []*model.Feature, s *model.Feature) *Response {
+	return NewRecomputedFeaturesResponse(fsA, fsB, s, nil)
+}
+
+// NewRecomputedFeaturesResponse returns a Response for the passed Features,
+// the stored score and the score recomputed from the Features weights. If
+// recomputed is nil it is omitted
+func NewRecomputedFeaturesResponse(fsA []*model.Feature, fsB []*model.Feature, s, recomputed *model.Feature) *Response {
 	featuresA := make([]featureResponse, len(fsA))
 	for i, f := range fsA {
 		featuresA[i] = featureResponse(*f)
@@ -210,39 +858,75 @@ func NewFeaturesResponse(fsA []*model.Feature, fsB []*model.Feature, s *model.Fe
 		featuresB[i] = featureResponse(*f)
 	}
 
-	return newResponse(featuresResponse{
+	resp := featuresResponse{
 		Object1: featuresA,
 		Object2: featuresB,
 		Pair:    featureResponse(*s),
-	})
+	}
+	if recomputed != nil {
+		r := featureResponse(*recomputed)
+		resp.Recomputed = &r
+	}
+
+	return newResponse(resp)
 }
 
 type countResponse struct {
-	Count int `json:"count"`
+	Count   int  `json:"count"`
+	Overlap *int `json:"overlap,omitempty"`
 }
 
 // NewCountResponse returns a Response for the total of a count
 func NewCountResponse(c int) *Response {
-	return newResponse(countResponse{c})
+	return newResponse(countResponse{Count: c})
+}
+
+// NewOverlapCountResponse returns a Response for the number of created
+// Assignments, and the number of users each file pair was assigned to
+func NewOverlapCountResponse(c int, overlap int) *Response {
+	return newResponse(countResponse{Count: c, Overlap: &overlap})
+}
+
+type remainingCountResponse struct {
+	Remaining int `json:"remaining"`
+	Total     int `json:"total"`
+}
+
+// NewRemainingCountResponse returns a Response with the number of unanswered
+// Assignments of a User, and the total of them
+func NewRemainingCountResponse(remaining, total int) *Response {
+	return newResponse(remainingCountResponse{Remaining: remaining, Total: total})
 }
 
 type versionResponse struct {
-	Version string `json:"version"`
+	Version   string `json:"version"`
+	GitCommit string `json:"gitCommit"`
+	BuildTime string `json:"buildTime"`
+	GoVersion string `json:"goVersion"`
 }
 
 // NewVersionResponse returns a Response with current version of the server
-func NewVersionResponse(version string) *Response {
-	return newResponse(versionResponse{version})
+// and the metadata of its build
+func NewVersionResponse(version, gitCommit, buildTime, goVersion string) *Response {
+	return newResponse(versionResponse{version, gitCommit, buildTime, goVersion})
+}
+
+// UploadFailure describes why a row of an upload failed
+type UploadFailure struct {
+	Row    int    `json:"row"`
+	Reason string `json:"reason"`
 }
 
 type filePairsUploadResponse struct {
-	Success  int64 `json:"success"`
-	Failures int64 `json:"failures"`
+	Success        int64           `json:"success"`
+	Failures       int64           `json:"failures"`
+	FailureDetails []UploadFailure `json:"failureDetails,omitempty"`
 }
 
-// NewFilePairsUploadResponse returns a Response with results of upload
-func NewFilePairsUploadResponse(success, failures int64) *Response {
-	return newResponse(filePairsUploadResponse{success, failures})
+// NewFilePairsUploadResponse returns a Response with results of upload, and
+// optionally the details of its failures
+func NewFilePairsUploadResponse(success, failures int64, details ...UploadFailure) *Response {
+	return newResponse(filePairsUploadResponse{success, failures, details})
 }
 
 type tokenResponse struct {
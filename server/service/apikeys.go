@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/src-d/code-annotation/server/repository"
+)
+
+// APIKeyPrefix starts every API key, so they can be told apart from the JWT
+// tokens sent in the same Authorization header
+const APIKeyPrefix = "cat_"
+
+// NewAPIKey returns a new random API key, and the hash of it to be stored
+func NewAPIKey() (key string, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("can't generate API key: %s", err)
+	}
+
+	key = APIKeyPrefix + hex.EncodeToString(b)
+	return key, HashAPIKey(key), nil
+}
+
+// HashAPIKey returns the hash stored for the given API key. The keys are long
+// random strings, so a fast hash is enough to protect them
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// APIKeyAuth authenticates the requests made with an API key
+type APIKeyAuth struct {
+	repo *repository.APIKeys
+}
+
+// NewAPIKeyAuth creates a new APIKeyAuth service
+func NewAPIKeyAuth(repo *repository.APIKeys) *APIKeyAuth {
+	return &APIKeyAuth{repo: repo}
+}
+
+// Middleware returns a middleware that authenticates the requests with an API
+// key in the Authorization bearer header as the user of the key, and passes
+// the rest to the fallback middleware, usually JWT.Middleware. The API keys
+// are only allowed in the routes of their experiment, and they can not be used
+// to manage the API keys themselves
+func (a *APIKeyAuth) Middleware(fallback func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fallbackHandler := fallback(next)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, _ := stripBearerPrefixFromTokenString(r.Header.Get("Authorization"))
+			if !strings.HasPrefix(key, APIKeyPrefix) {
+				fallbackHandler.ServeHTTP(w, r)
+				return
+			}
+
+			apiKey, err := a.repo.GetActiveByHash(r.Context(), HashAPIKey(key))
+			if err != nil || apiKey == nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			if !inAPIKeyScope(r.URL.Path, apiKey.ExperimentID) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			ctx := SetUserID(r.Context(), apiKey.UserID)
+			r = r.WithContext(context.WithValue(ctx, apiKeyAuthKey, true))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type apiKeyAuthContext int
+
+const apiKeyAuthKey apiKeyAuthContext = 1
+
+// IsAPIKeyAuth returns true if the request of the Context was authenticated
+// by APIKeyAuth
+func IsAPIKeyAuth(ctx context.Context) bool {
+	auth, _ := ctx.Value(apiKeyAuthKey).(bool)
+	return auth
+}
+
+// inAPIKeyScope returns true if the path is one of the API routes of the
+// given experiment, other than the ones of its API keys
+func inAPIKeyScope(path string, experimentID int) bool {
+	prefix := fmt.Sprintf("/api/experiments/%d", experimentID)
+	if path != prefix && !strings.HasPrefix(path, prefix+"/") {
+		return false
+	}
+
+	rest := strings.TrimPrefix(path, prefix)
+	return rest != "/api-keys" && !strings.HasPrefix(rest, "/api-keys/")
+}
@@ -0,0 +1,9 @@
+package service
+
+// CORSConfig defines enviroment variables for the CORS headers. Every field
+// is a comma separated list; an origin "*" allows any origin
+type CORSConfig struct {
+	AllowedOrigins []string `envconfig:"ALLOWED_ORIGINS" default:"*"`
+	AllowedMethods []string `envconfig:"ALLOWED_METHODS" default:"GET,POST,PUT,PATCH,DELETE,OPTIONS"`
+	AllowedHeaders []string `envconfig:"ALLOWED_HEADERS" default:"Location,Authorization,Content-Type,X-Session-Id,Idempotency-Key"`
+}
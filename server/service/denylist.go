@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// TokenDenylist holds the IDs of the revoked tokens until they can not be
+// used anymore
+type TokenDenylist interface {
+	// Add denies the token with the given ID until the given time
+	Add(ctx context.Context, id string, until time.Time) error
+	// Contains returns true if the token with the given ID is denied
+	Contains(ctx context.Context, id string) (bool, error)
+}
+
+// DenylistError is returned by the JWT service when its TokenDenylist fails,
+// as opposed to the errors caused by the token sent
+type DenylistError struct {
+	Err error
+}
+
+func (e *DenylistError) Error() string {
+	return fmt.Sprintf("can't check the revoked tokens: %s", e.Err)
+}
+
+// MemoryTokenDenylist is an in-memory TokenDenylist. The revocations are lost
+// when the server restarts, and they are not shared between several servers,
+// so it is only meant for tests and single processes; the server uses
+// repository.RevokedTokens. It is safe for concurrent use
+type MemoryTokenDenylist struct {
+	mu      sync.Mutex
+	entries map[string]time.Time
+}
+
+// NewMemoryTokenDenylist returns an empty MemoryTokenDenylist
+func NewMemoryTokenDenylist() *MemoryTokenDenylist {
+	return &MemoryTokenDenylist{entries: make(map[string]time.Time)}
+}
+
+// Add implements the TokenDenylist interface. The entries that are already
+// expired are removed, so the list only grows with the tokens that could
+// still be used
+func (d *MemoryTokenDenylist) Add(ctx context.Context, id string, until time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	for entryID, entryUntil := range d.entries {
+		if !entryUntil.After(now) {
+			delete(d.entries, entryID)
+		}
+	}
+
+	if until.After(now) {
+		d.entries[id] = until
+	}
+
+	return nil
+}
+
+// Contains implements the TokenDenylist interface
+func (d *MemoryTokenDenylist) Contains(ctx context.Context, id string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	until, ok := d.entries[id]
+	return ok && until.After(time.Now()), nil
+}
+
+// Len returns the number of denied tokens, including the expired ones that
+// were not removed yet
+func (d *MemoryTokenDenylist) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return len(d.entries)
+}
@@ -0,0 +1,40 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/src-d/code-annotation/server/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryTokenDenylist(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := context.Background()
+	d := service.NewMemoryTokenDenylist()
+	assert.Nil(d.Add(ctx, "a", time.Now().Add(time.Hour)))
+	assert.Nil(d.Add(ctx, "b", time.Now().Add(50*time.Millisecond)))
+	assert.Nil(d.Add(ctx, "c", time.Now().Add(-time.Minute)))
+
+	contains := func(id string) bool {
+		ok, err := d.Contains(ctx, id)
+		assert.Nil(err)
+		return ok
+	}
+
+	assert.True(contains("a"))
+	assert.True(contains("b"))
+	assert.False(contains("c"))
+	assert.False(contains("d"))
+	assert.Equal(2, d.Len())
+
+	time.Sleep(100 * time.Millisecond)
+	assert.False(contains("b"))
+
+	// the expired entries are removed when a new one is added
+	assert.Nil(d.Add(ctx, "d", time.Now().Add(time.Hour)))
+	assert.True(contains("d"))
+	assert.Equal(2, d.Len())
+}
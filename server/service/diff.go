@@ -1,26 +1,98 @@
 package service
 
 import (
+	"fmt"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/pmezard/go-difflib/difflib"
 )
 
 // Diff service generates diff for files
 type Diff struct {
-	context int
+	context     int
+	maxBlobSize int
 }
 
-// NewDiff creates Diff service
-func NewDiff() *Diff {
-	return &Diff{context: 6} // keep it hard coded for now
+// NewDiff creates Diff service. The files bigger than maxBlobSize bytes are
+// truncated before comparing them, see Truncated. 0 disables the limit
+func NewDiff(maxBlobSize int) *Diff {
+	return &Diff{context: 6, maxBlobSize: maxBlobSize} // keep the context hard coded for now
+}
+
+// Truncated returns true if any of the given contents is bigger than the max
+// blob size, so their diff only compares the beginning of both files
+func (d *Diff) Truncated(contentA, contentB string) bool {
+	return d.maxBlobSize > 0 && (len(contentA) > d.maxBlobSize || len(contentB) > d.maxBlobSize)
+}
+
+// truncate returns the contents cut to the max blob size. The last line is
+// dropped if it does not fit whole, unless it is the only one
+func (d *Diff) truncate(contentA, contentB string) (string, string) {
+	if d.maxBlobSize == 0 {
+		return contentA, contentB
+	}
+
+	return d.truncateLines(contentA), d.truncateLines(contentB)
+}
+
+func (d *Diff) truncateLines(content string) string {
+	truncated, ok := TruncateContent(content, d.maxBlobSize)
+	if !ok {
+		return content
+	}
+
+	if i := strings.LastIndexByte(truncated, '\n'); i >= 0 {
+		return truncated[:i+1]
+	}
+
+	return truncated
+}
+
+// TruncateContent returns the content cut to the given size, without
+// splitting any UTF-8 character, and true if it was truncated
+func TruncateContent(content string, size int) (string, bool) {
+	if len(content) <= size {
+		return content, false
+	}
+
+	for size > 0 && !utf8.RuneStart(content[size]) {
+		size--
+	}
+
+	return content[:size], true
 }
 
 // DiffPreprocessorFunc type is function signature to preprocess diffs
 type DiffPreprocessorFunc func(string) string
 
-// Generate return unified diff string for 2 files
+// DiffMode is the granularity used to generate a diff
+type DiffMode string
+
+const (
+	// LineDiff compares the files line by line
+	LineDiff DiffMode = "line"
+	// WordDiff compares the files word by word
+	WordDiff DiffMode = "word"
+)
+
+// ParseDiffMode returns the DiffMode for the given string, using LineDiff
+// if it is empty
+func ParseDiffMode(s string) (DiffMode, error) {
+	switch DiffMode(s) {
+	case "", LineDiff:
+		return LineDiff, nil
+	case WordDiff:
+		return WordDiff, nil
+	default:
+		return "", fmt.Errorf("unknown diff mode %q", s)
+	}
+}
+
+// Generate return unified diff string for 2 files, cut to the max blob size
 func (d *Diff) Generate(nameA, nameB, contentA, contentB string, preprocessors ...DiffPreprocessorFunc) (string, error) {
+	contentA, contentB = d.truncate(contentA, contentB)
+
 	for _, p := range preprocessors {
 		contentA = p(contentA)
 		contentB = p(contentB)
@@ -29,6 +101,29 @@ func (d *Diff) Generate(nameA, nameB, contentA, contentB string, preprocessors .
 	return d.generate(nameA, nameB, contentA, contentB)
 }
 
+// GenerateWords returns a unified diff string for 2 files where every line
+// of the diff holds a single word, so the changes are shown word by word.
+// The preprocessors are applied to every word. The files are cut to the max
+// blob size. The binary files, that can not be split in words, get the same
+// message used by git instead
+func (d *Diff) GenerateWords(nameA, nameB, contentA, contentB string, preprocessors ...DiffPreprocessorFunc) (string, error) {
+	if isBinary(contentA) || isBinary(contentB) {
+		return binaryDiff(nameA, nameB), nil
+	}
+
+	contentA, contentB = d.truncate(contentA, contentB)
+
+	diff := difflib.UnifiedDiff{
+		A:        splitWords(contentA, preprocessors),
+		B:        splitWords(contentB, preprocessors),
+		FromFile: nameA,
+		ToFile:   nameB,
+		Context:  d.context,
+	}
+
+	return difflib.GetUnifiedDiffString(diff)
+}
+
 func (d *Diff) generate(nameA, nameB, contentA, contentB string) (string, error) {
 	diff := difflib.UnifiedDiff{
 		A:        difflib.SplitLines(contentA),
@@ -41,6 +136,56 @@ func (d *Diff) generate(nameA, nameB, contentA, contentB string) (string, error)
 	return difflib.GetUnifiedDiffString(diff)
 }
 
+// DiffStats holds how many lines, or words for WordDiff, were added and
+// removed in a diff. Changed is how many of the removed ones were replaced by
+// added ones, so they are also counted in Added and Removed
+type DiffStats struct {
+	Added   int
+	Removed int
+	Changed int
+}
+
+// CountDiffStats returns the DiffStats of a unified diff generated by Diff.
+// The diffs of binary or identical files have no changes
+func CountDiffStats(diff string) DiffStats {
+	var stats DiffStats
+	var added, removed int
+	inHunk := false
+
+	// every block of removed and added lines replaces as many lines as the
+	// smallest side has
+	endBlock := func() {
+		if added < removed {
+			stats.Changed += added
+		} else {
+			stats.Changed += removed
+		}
+
+		added, removed = 0, 0
+	}
+
+	for _, line := range strings.Split(diff, "\n") {
+		switch {
+		case strings.HasPrefix(line, "@@"):
+			endBlock()
+			inHunk = true
+		case !inHunk:
+			// the file names before the first hunk
+		case strings.HasPrefix(line, "+"):
+			stats.Added++
+			added++
+		case strings.HasPrefix(line, "-"):
+			stats.Removed++
+			removed++
+		default:
+			endBlock()
+		}
+	}
+
+	endBlock()
+	return stats
+}
+
 // ReplaceInvisible preprocessor function that replace invisible character with visible onces
 func ReplaceInvisible(content string) string {
 	content = strings.Replace(content, " ", "·", -1)
@@ -48,3 +193,28 @@ func ReplaceInvisible(content string) string {
 	content = strings.Replace(content, "\r", "^M", -1)
 	return strings.Replace(content, "\n", "↵\n", -1)
 }
+
+// splitWords returns the words of the content, each one ending with a
+// new line as expected by difflib
+func splitWords(content string, preprocessors []DiffPreprocessorFunc) []string {
+	words := strings.Fields(content)
+	for i, w := range words {
+		for _, p := range preprocessors {
+			w = p(w)
+		}
+
+		words[i] = w + "\n"
+	}
+
+	return words
+}
+
+// isBinary returns true if the content can not be split in words
+func isBinary(content string) bool {
+	return strings.IndexByte(content, 0) >= 0 || !utf8.ValidString(content)
+}
+
+// binaryDiff returns a diff for binary files, in the same format used by git
+func binaryDiff(nameA, nameB string) string {
+	return fmt.Sprintf("Binary files %s and %s differ\n", nameA, nameB)
+}
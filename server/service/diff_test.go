@@ -2,6 +2,7 @@ package service_test
 
 import (
 	"io/ioutil"
+	"strings"
 	"testing"
 
 	"github.com/src-d/code-annotation/server/service"
@@ -14,7 +15,7 @@ type DiffSuite struct {
 
 func (suite *DiffSuite) TestDiff() {
 	assert := suite.Assert()
-	diff := service.NewDiff()
+	diff := service.NewDiff(0)
 
 	a, err := readFile("./testdata/a.txt")
 	assert.NoError(err)
@@ -53,6 +54,106 @@ func (suite *DiffSuite) TestDiffReplaceInvisible() {
 	)
 }
 
+func (suite *DiffSuite) TestDiffWords() {
+	assert := suite.Assert()
+	diff := service.NewDiff(0)
+
+	wordsDiff, err := diff.GenerateWords("a.txt", "b.txt",
+		"hello old world\n", "hello  new\nworld\n")
+
+	assert.NoError(err)
+	assert.Equal("--- a.txt\n"+
+		"+++ b.txt\n"+
+		"@@ -1,3 +1,3 @@\n"+
+		" hello\n"+
+		"-old\n"+
+		"+new\n"+
+		" world\n", wordsDiff)
+}
+
+func (suite *DiffSuite) TestDiffWordsBinary() {
+	assert := suite.Assert()
+	diff := service.NewDiff(0)
+
+	for _, content := range []string{"bin\x00ary", "latin1 \xe9t\xe9"} {
+		// the line diffs are generated as for any other file
+		lineDiff, err := diff.Generate("a.bin", "b.bin", content, "text")
+		assert.NoError(err)
+		assert.True(strings.HasPrefix(lineDiff, "--- a.bin\n+++ b.bin\n@@ "))
+
+		lineDiff, err = diff.Generate("a.bin", "b.bin", content, content)
+		assert.NoError(err)
+		assert.Empty(lineDiff)
+
+		wordsDiff, err := diff.GenerateWords("a.bin", "b.bin", "text", content)
+		assert.NoError(err)
+		assert.Equal("Binary files a.bin and b.bin differ\n", wordsDiff)
+	}
+}
+
+func (suite *DiffSuite) TestCountDiffStats() {
+	assert := suite.Assert()
+	diff := service.NewDiff(0)
+
+	lineDiff, err := diff.Generate("a.txt", "b.txt",
+		"same\nold\n--flag\nsame\ngone\n", "same\nnew\nother\nsame\n")
+	assert.NoError(err)
+	assert.Equal(service.DiffStats{Added: 2, Removed: 3, Changed: 2}, service.CountDiffStats(lineDiff))
+
+	wordsDiff, err := diff.GenerateWords("a.txt", "b.txt",
+		"hello old world\n", "hello  new\nworld\n")
+	assert.NoError(err)
+	assert.Equal(service.DiffStats{Added: 1, Removed: 1, Changed: 1}, service.CountDiffStats(wordsDiff))
+
+	identical, err := diff.Generate("a", "b", "same\n", "same\n")
+	assert.NoError(err)
+	assert.Equal(service.DiffStats{}, service.CountDiffStats(identical))
+
+	binary, err := diff.GenerateWords("a", "b", "bin\x00ary", "text")
+	assert.NoError(err)
+	assert.Equal(service.DiffStats{}, service.CountDiffStats(binary))
+}
+
+func (suite *DiffSuite) TestDiffMaxBlobSize() {
+	assert := suite.Assert()
+	diff := service.NewDiff(10)
+
+	assert.False(diff.Truncated("0123456789", "short"))
+	assert.True(diff.Truncated("0123456789\nmore", "short"))
+
+	lineDiff, err := diff.Generate("a.txt", "b.txt", "same\nold\nhidden\n", "same\nnew\nother\n")
+	assert.NoError(err)
+	assert.Equal("--- a.txt\n"+
+		"+++ b.txt\n"+
+		"@@ -1,3 +1,3 @@\n"+
+		" same\n"+
+		"-old\n"+
+		"+new\n"+
+		" \n", lineDiff)
+
+	// no UTF-8 character is split
+	content, truncated := service.TruncateContent("añb", 2)
+	assert.True(truncated)
+	assert.Equal("a", content)
+}
+
+func (suite *DiffSuite) TestParseDiffMode() {
+	assert := suite.Assert()
+
+	for s, expected := range map[string]service.DiffMode{
+		"":     service.LineDiff,
+		"line": service.LineDiff,
+		"word": service.WordDiff,
+	} {
+		mode, err := service.ParseDiffMode(s)
+		assert.NoError(err)
+		assert.Equal(expected, mode)
+	}
+
+	_, err := service.ParseDiffMode("char")
+	assert.Error(err)
+}
+
 func readFile(filename string) (string, error) {
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
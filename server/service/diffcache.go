@@ -0,0 +1,126 @@
+package service
+
+import (
+	"container/list"
+	"sync"
+)
+
+// DiffCacheKey identifies a diff in the DiffCache: the file pair it compares
+// and the options used to generate it. Base is empty for the diff between the
+// left and right files, or the side compared with the base file of three-way
+// pairs
+type DiffCacheKey struct {
+	PairID        int
+	Base          string
+	Mode          DiffMode
+	ShowInvisible bool
+}
+
+type diffCacheEntry struct {
+	key         DiffCacheKey
+	leftBlobID  string
+	rightBlobID string
+	diff        string
+}
+
+// DiffCache is an in-memory LRU cache of the generated diffs. The least
+// recently used diffs are evicted once their total size goes over the max
+// size. Every diff is stored with the blob IDs of the files it compares; if
+// they change the diff is not returned anymore. It is safe for concurrent use
+type DiffCache struct {
+	maxSize int
+
+	mu      sync.Mutex
+	size    int
+	entries *list.List
+	byKey   map[DiffCacheKey]*list.Element
+	hits    uint64
+	misses  uint64
+}
+
+// NewDiffCache returns an empty DiffCache that holds up to maxSize bytes of
+// diffs. If maxSize is 0 no diff is stored
+func NewDiffCache(maxSize int) *DiffCache {
+	return &DiffCache{
+		maxSize: maxSize,
+		entries: list.New(),
+		byKey:   make(map[DiffCacheKey]*list.Element),
+	}
+}
+
+// Get returns the diff stored for the given key and blob IDs, and true. If
+// there is none it returns false
+func (c *DiffCache) Get(key DiffCacheKey, leftBlobID, rightBlobID string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.byKey[key]
+	if !ok {
+		c.misses++
+		return "", false
+	}
+
+	entry := el.Value.(*diffCacheEntry)
+	if entry.leftBlobID != leftBlobID || entry.rightBlobID != rightBlobID {
+		c.remove(el)
+		c.misses++
+		return "", false
+	}
+
+	c.entries.MoveToFront(el)
+	c.hits++
+	return entry.diff, true
+}
+
+// Add stores the diff for the given key and blob IDs, evicting the least
+// recently used diffs if needed. Diffs bigger than the max size are not stored
+func (c *DiffCache) Add(key DiffCacheKey, leftBlobID, rightBlobID, diff string) {
+	if len(diff) > c.maxSize {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.byKey[key]; ok {
+		c.remove(el)
+	}
+
+	entry := &diffCacheEntry{key, leftBlobID, rightBlobID, diff}
+	c.byKey[key] = c.entries.PushFront(entry)
+	c.size += len(diff)
+
+	for c.size > c.maxSize {
+		c.remove(c.entries.Back())
+	}
+}
+
+func (c *DiffCache) remove(el *list.Element) {
+	entry := c.entries.Remove(el).(*diffCacheEntry)
+	delete(c.byKey, entry.key)
+	c.size -= len(entry.diff)
+}
+
+// Len returns the number of stored diffs
+func (c *DiffCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.entries.Len()
+}
+
+// Hits returns the number of calls to Get that found a diff
+func (c *DiffCache) Hits() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.hits
+}
+
+// Misses returns the number of calls to Get that did not find a diff
+func (c *DiffCache) Misses() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.misses
+}
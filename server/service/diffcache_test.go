@@ -0,0 +1,63 @@
+package service_test
+
+import (
+	"testing"
+
+	"github.com/src-d/code-annotation/server/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffCache(t *testing.T) {
+	assert := assert.New(t)
+
+	c := service.NewDiffCache(10)
+	first := service.DiffCacheKey{PairID: 1, Mode: service.LineDiff}
+	second := service.DiffCacheKey{PairID: 2, Mode: service.LineDiff}
+	third := service.DiffCacheKey{PairID: 3, Mode: service.LineDiff}
+
+	_, ok := c.Get(first, "a", "b")
+	assert.False(ok)
+
+	c.Add(first, "a", "b", "1234")
+	c.Add(second, "c", "d", "5678")
+
+	d, ok := c.Get(first, "a", "b")
+	assert.True(ok)
+	assert.Equal("1234", d)
+
+	// the options are part of the key
+	_, ok = c.Get(service.DiffCacheKey{PairID: 1, Mode: service.WordDiff}, "a", "b")
+	assert.False(ok)
+
+	// the second diff is the least recently used one
+	c.Add(third, "e", "f", "9012")
+	assert.Equal(2, c.Len())
+	_, ok = c.Get(second, "c", "d")
+	assert.False(ok)
+	_, ok = c.Get(third, "e", "f")
+	assert.True(ok)
+
+	// a diff of other blobs is removed
+	_, ok = c.Get(first, "a", "changed")
+	assert.False(ok)
+	assert.Equal(1, c.Len())
+
+	// too big to be stored
+	c.Add(first, "a", "b", "12345678901")
+	assert.Equal(1, c.Len())
+
+	assert.Equal(uint64(2), c.Hits())
+	assert.Equal(uint64(4), c.Misses())
+}
+
+func TestDiffCacheDisabled(t *testing.T) {
+	assert := assert.New(t)
+
+	c := service.NewDiffCache(0)
+	key := service.DiffCacheKey{PairID: 1, Mode: service.LineDiff}
+	c.Add(key, "a", "b", "diff")
+
+	_, ok := c.Get(key, "a", "b")
+	assert.False(ok)
+	assert.Equal(0, c.Len())
+}
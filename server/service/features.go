@@ -0,0 +1,53 @@
+package service
+
+import "github.com/src-d/code-annotation/server/model"
+
+// FeaturesScore returns the similarity of two blobs from the weights of their
+// features, as the weighted Jaccard index: the sum of the smallest weight of
+// every feature in both blobs, divided by the sum of the largest ones. A
+// feature missing in a blob has weight 0. It returns 0 if there are no
+// features, or their weights add up to 0. Negative weights are taken as 0
+func FeaturesScore(featuresA, featuresB []*model.Feature) float64 {
+	weightsA := featureWeights(featuresA)
+	weightsB := featureWeights(featuresB)
+
+	names := make(map[string]bool, len(weightsA)+len(weightsB))
+	for name := range weightsA {
+		names[name] = true
+	}
+	for name := range weightsB {
+		names[name] = true
+	}
+
+	var min, max float64
+	for name := range names {
+		a, b := weightsA[name], weightsB[name]
+		if a > b {
+			a, b = b, a
+		}
+
+		min += a
+		max += b
+	}
+
+	if max == 0 {
+		return 0
+	}
+
+	return min / max
+}
+
+// featureWeights returns the weight of every feature by name, with the
+// negative weights set to 0
+func featureWeights(features []*model.Feature) map[string]float64 {
+	weights := make(map[string]float64, len(features))
+	for _, f := range features {
+		if f.Weight > 0 {
+			weights[f.Name] = f.Weight
+		} else {
+			weights[f.Name] = 0
+		}
+	}
+
+	return weights
+}
@@ -0,0 +1,23 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/src-d/code-annotation/server/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeaturesScore(t *testing.T) {
+	assert := assert.New(t)
+
+	a := []*model.Feature{{Name: "identifiers", Weight: 1}, {Name: "literals", Weight: 0.5}}
+	b := []*model.Feature{{Name: "identifiers", Weight: 0.5}, {Name: "imports", Weight: 0.5}}
+
+	// min: 0.5 + 0 + 0 = 0.5, max: 1 + 0.5 + 0.5 = 2
+	assert.InDelta(0.25, FeaturesScore(a, b), 1e-9)
+	assert.InDelta(1, FeaturesScore(a, a), 1e-9)
+	assert.Equal(0.0, FeaturesScore(nil, nil))
+
+	negative := []*model.Feature{{Name: "literals", Weight: -3}}
+	assert.Equal(0.0, FeaturesScore(negative, negative))
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrTooManySubscribers is returned by Hub.Subscribe when the max number of
+// subscribers is reached
+var ErrTooManySubscribers = errors.New("too many subscribers")
+
+// ErrHubClosed is returned by Hub.Subscribe once the hub is closed
+var ErrHubClosed = errors.New("hub closed")
+
+// Hub is an in-process pub/sub that delivers the messages published for every
+// experiment to its subscribers
+type Hub struct {
+	maxSubscribers int
+	buffer         int
+	latestOnly     bool
+
+	mu     sync.Mutex
+	closed bool
+	total  int
+	nextID int
+	subs   map[int]map[int]chan interface{}
+}
+
+// NewProgressHub returns a Hub that accepts up to maxSubscribers concurrent
+// subscribers among all the experiments. Only the latest message is kept for
+// the subscribers that are not reading fast enough, as every progress update
+// replaces the previous one
+func NewProgressHub(maxSubscribers int) *Hub {
+	return &Hub{
+		maxSubscribers: maxSubscribers,
+		buffer:         1,
+		latestOnly:     true,
+		subs:           make(map[int]map[int]chan interface{}),
+	}
+}
+
+// NewEventHub returns a Hub that accepts up to maxSubscribers concurrent
+// subscribers among all the experiments. Up to buffer messages are kept for
+// the subscribers that are not reading fast enough; the next ones are dropped
+func NewEventHub(maxSubscribers, buffer int) *Hub {
+	return &Hub{
+		maxSubscribers: maxSubscribers,
+		buffer:         buffer,
+		subs:           make(map[int]map[int]chan interface{}),
+	}
+}
+
+// Subscribe returns a channel that receives the messages published for the
+// given experiment, and a function to unsubscribe that must be called once
+// the channel is not read anymore. The channel is closed when the hub is
+func (h *Hub) Subscribe(experimentID int) (<-chan interface{}, func(), error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil, nil, ErrHubClosed
+	}
+
+	if h.total >= h.maxSubscribers {
+		return nil, nil, ErrTooManySubscribers
+	}
+
+	if h.subs[experimentID] == nil {
+		h.subs[experimentID] = make(map[int]chan interface{})
+	}
+
+	h.nextID++
+	id := h.nextID
+	ch := make(chan interface{}, h.buffer)
+	h.subs[experimentID][id] = ch
+	h.total++
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+
+			// the subscriber is already gone if the hub was closed
+			if _, ok := h.subs[experimentID][id]; !ok {
+				return
+			}
+
+			delete(h.subs[experimentID], id)
+			if len(h.subs[experimentID]) == 0 {
+				delete(h.subs, experimentID)
+			}
+			h.total--
+		})
+	}
+
+	return ch, unsubscribe, nil
+}
+
+// HasSubscribers returns true if anyone is subscribed to the given experiment
+func (h *Hub) HasSubscribers(experimentID int) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return len(h.subs[experimentID]) > 0
+}
+
+// Subscribers returns the number of subscribers among all the experiments
+func (h *Hub) Subscribers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.total
+}
+
+// Publish sends the message to all the subscribers of the given experiment.
+// It never blocks
+func (h *Hub) Publish(experimentID int, msg interface{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, ch := range h.subs[experimentID] {
+		if h.latestOnly {
+			select {
+			case <-ch:
+			default:
+			}
+		}
+
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+}
+
+// Close closes the channels of all the subscribers, so they know no more
+// messages will be published, and rejects the new subscriptions
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return
+	}
+
+	h.closed = true
+	for _, subs := range h.subs {
+		for _, ch := range subs {
+			close(ch)
+		}
+	}
+
+	h.subs = make(map[int]map[int]chan interface{})
+	h.total = 0
+}
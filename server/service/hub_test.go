@@ -0,0 +1,83 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgressHub(t *testing.T) {
+	assert := assert.New(t)
+
+	hub := NewProgressHub(2)
+
+	updates, unsubscribe, err := hub.Subscribe(1)
+	assert.Nil(err)
+	assert.True(hub.HasSubscribers(1))
+	assert.False(hub.HasSubscribers(2))
+
+	other, unsubscribeOther, err := hub.Subscribe(2)
+	assert.Nil(err)
+	assert.Equal(2, hub.Subscribers())
+
+	_, _, err = hub.Subscribe(1)
+	assert.Equal(ErrTooManySubscribers, err)
+
+	// only the latest update is kept for slow subscribers
+	hub.Publish(1, "first")
+	hub.Publish(1, "second")
+	assert.Equal("second", <-updates)
+	assert.Len(other, 0)
+
+	unsubscribe()
+	unsubscribe()
+	assert.False(hub.HasSubscribers(1))
+
+	_, _, err = hub.Subscribe(1)
+	assert.Nil(err)
+
+	unsubscribeOther()
+	assert.False(hub.HasSubscribers(2))
+}
+
+func TestEventHub(t *testing.T) {
+	assert := assert.New(t)
+
+	hub := NewEventHub(1, 2)
+
+	events, unsubscribe, err := hub.Subscribe(1)
+	assert.Nil(err)
+	defer unsubscribe()
+
+	// the messages above the buffer size are dropped
+	hub.Publish(1, "first")
+	hub.Publish(1, "second")
+	hub.Publish(1, "third")
+	assert.Equal("first", <-events)
+	assert.Equal("second", <-events)
+	assert.Len(events, 0)
+}
+
+func TestHubClose(t *testing.T) {
+	assert := assert.New(t)
+
+	hub := NewEventHub(2, 1)
+
+	events, unsubscribe, err := hub.Subscribe(1)
+	assert.Nil(err)
+
+	hub.Close()
+	hub.Close()
+	_, ok := <-events
+	assert.False(ok)
+	assert.Equal(0, hub.Subscribers())
+
+	// unsubscribing after the hub is closed is a no-op
+	unsubscribe()
+	assert.Equal(0, hub.Subscribers())
+
+	hub.Publish(1, "after close")
+
+	_, _, err = hub.Subscribe(1)
+	assert.Equal(ErrHubClosed, err)
+}
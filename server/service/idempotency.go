@@ -0,0 +1,127 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// IdempotencyState is the state of a request key in an IdempotencyStore
+type IdempotencyState int
+
+const (
+	// IdempotencyNew means the key was not seen before, and it is now reserved
+	// for the request being processed
+	IdempotencyNew IdempotencyState = iota
+	// IdempotencyInProgress means another request with the same key is still
+	// being processed
+	IdempotencyInProgress
+	// IdempotencyDone means a request with the same key was already processed,
+	// and its result is returned
+	IdempotencyDone
+)
+
+// IdempotencyStore keeps the results of the processed requests by their
+// idempotency key for a while, so the repeated requests can get the original
+// result instead of being processed again. It is kept in memory, so the keys
+// are lost when the server restarts, and they are not shared between several
+// servers. It is safe for concurrent use
+type IdempotencyStore struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*idempotencyEntry
+	// pruneAt is the number of keys that triggers the removal of the
+	// expired ones
+	pruneAt int
+}
+
+// minIdempotencyPrune is the number of keys kept by IdempotencyStore before
+// removing the expired ones for the first time
+const minIdempotencyPrune = 10000
+
+type idempotencyEntry struct {
+	result  interface{}
+	done    bool
+	expires time.Time
+}
+
+// NewIdempotencyStore returns an empty IdempotencyStore that keeps every key
+// for the given time
+func NewIdempotencyStore(ttl time.Duration) *IdempotencyStore {
+	return &IdempotencyStore{
+		ttl:     ttl,
+		entries: make(map[string]*idempotencyEntry),
+		pruneAt: minIdempotencyPrune,
+	}
+}
+
+// Begin returns the state of the given key. If it is IdempotencyNew, the key
+// is reserved until Finish or Release are called, or it expires. If it is
+// IdempotencyDone, the result passed to Finish is also returned. The expired
+// keys are taken as new, and they are removed once the store holds too many
+func (s *IdempotencyStore) Begin(key string) (IdempotencyState, interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	e, ok := s.entries[key]
+	switch {
+	case !ok || !e.expires.After(now):
+		if !ok && len(s.entries) >= s.pruneAt {
+			s.prune(now)
+		}
+
+		s.entries[key] = &idempotencyEntry{expires: now.Add(s.ttl)}
+		return IdempotencyNew, nil
+	case !e.done:
+		return IdempotencyInProgress, nil
+	default:
+		return IdempotencyDone, e.result
+	}
+}
+
+// Finish keeps the result of the request with the given key, that will be
+// returned by Begin until the key expires
+func (s *IdempotencyStore) Finish(key string, result interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[key] = &idempotencyEntry{
+		result:  result,
+		done:    true,
+		expires: time.Now().Add(s.ttl),
+	}
+}
+
+// Release forgets the given key, so a new request with it is processed again
+func (s *IdempotencyStore) Release(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+}
+
+// Len returns the number of keys, including the expired ones that were not
+// removed yet
+func (s *IdempotencyStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.entries)
+}
+
+// prune removes the expired keys. The next prune waits until the store holds
+// twice the keys left, so the cost of pruning is spread over the new keys
+func (s *IdempotencyStore) prune(now time.Time) {
+	for k, e := range s.entries {
+		if !e.expires.After(now) {
+			delete(s.entries, k)
+		}
+	}
+
+	s.pruneAt = 2 * len(s.entries)
+	if s.pruneAt < minIdempotencyPrune {
+		s.pruneAt = minIdempotencyPrune
+	}
+}
@@ -0,0 +1,55 @@
+package service_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/src-d/code-annotation/server/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotencyStore(t *testing.T) {
+	assert := assert.New(t)
+
+	s := service.NewIdempotencyStore(50 * time.Millisecond)
+
+	state, result := s.Begin("a")
+	assert.Equal(service.IdempotencyNew, state)
+	assert.Nil(result)
+
+	state, _ = s.Begin("a")
+	assert.Equal(service.IdempotencyInProgress, state)
+
+	s.Finish("a", "result")
+	state, result = s.Begin("a")
+	assert.Equal(service.IdempotencyDone, state)
+	assert.Equal("result", result)
+
+	// the released keys are processed again
+	s.Begin("b")
+	s.Release("b")
+	state, _ = s.Begin("b")
+	assert.Equal(service.IdempotencyNew, state)
+
+	time.Sleep(100 * time.Millisecond)
+	state, _ = s.Begin("a")
+	assert.Equal(service.IdempotencyNew, state)
+}
+
+func TestIdempotencyStorePrune(t *testing.T) {
+	assert := assert.New(t)
+
+	s := service.NewIdempotencyStore(50 * time.Millisecond)
+	for i := 0; i < 10000; i++ {
+		s.Begin(strconv.Itoa(i))
+	}
+
+	// the expired keys are kept until there are too many
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(10000, s.Len())
+
+	state, _ := s.Begin("new")
+	assert.Equal(service.IdempotencyNew, state)
+	assert.Equal(1, s.Len())
+}
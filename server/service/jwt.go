@@ -2,9 +2,14 @@ package service
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/src-d/code-annotation/server/model"
 
@@ -31,19 +36,170 @@ var extractor = &request.PostExtractionFilter{
 
 // JWTConfig defines enviroment variables for JWT
 type JWTConfig struct {
-	SigningKey string `envconfig:"SIGNING_KEY" required:"true"`
+	SigningMethod   string        `envconfig:"SIGNING_METHOD" default:"HS256"`
+	SigningKey      string        `envconfig:"SIGNING_KEY"`
+	PrivateKeyFile  string        `envconfig:"PRIVATE_KEY_FILE"`
+	PublicKeyFile   string        `envconfig:"PUBLIC_KEY_FILE"`
+	TTL             time.Duration `envconfig:"TTL" default:"24h"`
+	RefreshWindow   time.Duration `envconfig:"REFRESH_WINDOW" default:"1h"`
+	RefreshGrace    time.Duration `envconfig:"REFRESH_GRACE" default:"1h"`
+	Issuer          string        `envconfig:"ISSUER"`
+	Audience        string        `envconfig:"AUDIENCE"`
+	SkipClaimsCheck bool          `envconfig:"SKIP_CLAIMS_CHECK"`
 }
 
 // JWT service abstracts JWT implementation
 type JWT struct {
-	signingKey []byte
+	method        jwt.SigningMethod
+	signKey       interface{}
+	verifyKey     interface{}
+	ttl           time.Duration
+	refreshWindow time.Duration
+	refreshGrace  time.Duration
+	denylist      TokenDenylist
+
+	issuer          string
+	audience        string
+	skipClaimsCheck bool
+}
+
+// NewJWT return new JWT service signing with HS256. The issued tokens expire
+// after ttl. They can only be refreshed during the refreshWindow before their
+// expiration, and while expired during refreshGrace
+func NewJWT(signingKey string, ttl, refreshWindow, refreshGrace time.Duration) *JWT {
+	return &JWT{
+		method:        jwt.SigningMethodHS256,
+		signKey:       []byte(signingKey),
+		verifyKey:     []byte(signingKey),
+		ttl:           ttl,
+		refreshWindow: refreshWindow,
+		refreshGrace:  refreshGrace,
+		denylist:      NewMemoryTokenDenylist(),
+	}
 }
 
-// NewJWT return new JWT service
-func NewJWT(signingKey string) *JWT {
-	return &JWT{signingKey: []byte(signingKey)}
+// SetDenylist sets the TokenDenylist used to revoke the tokens. By default
+// they are kept in a MemoryTokenDenylist
+func (j *JWT) SetDenylist(denylist TokenDenylist) {
+	j.denylist = denylist
+}
+
+// NewJWTFromConfig returns a new JWT service using the signing method of the
+// config. HS256 needs a SigningKey, while RS256 and ES256 need a PEM encoded
+// PrivateKeyFile. If no PublicKeyFile is set, the public key is taken from
+// the private one
+func NewJWTFromConfig(conf JWTConfig) (*JWT, error) {
+	j, err := newJWTFromConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	j.issuer = conf.Issuer
+	j.audience = conf.Audience
+	j.skipClaimsCheck = conf.SkipClaimsCheck
+	return j, nil
 }
 
+func newJWTFromConfig(conf JWTConfig) (*JWT, error) {
+	switch conf.SigningMethod {
+	case jwt.SigningMethodHS256.Alg():
+		if conf.SigningKey == "" {
+			return nil, fmt.Errorf("a signing key is required for %s", conf.SigningMethod)
+		}
+
+		return NewJWT(conf.SigningKey, conf.TTL, conf.RefreshWindow, conf.RefreshGrace), nil
+	case jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg():
+	default:
+		return nil, fmt.Errorf("unsupported JWT signing method %q", conf.SigningMethod)
+	}
+
+	if conf.PrivateKeyFile == "" {
+		return nil, fmt.Errorf("a private key file is required for %s", conf.SigningMethod)
+	}
+
+	privatePEM, err := ioutil.ReadFile(conf.PrivateKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("can't read the private key file: %s", err)
+	}
+
+	var publicPEM []byte
+	if conf.PublicKeyFile != "" {
+		publicPEM, err = ioutil.ReadFile(conf.PublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't read the public key file: %s", err)
+		}
+	}
+
+	j := &JWT{
+		ttl:           conf.TTL,
+		refreshWindow: conf.RefreshWindow,
+		refreshGrace:  conf.RefreshGrace,
+		denylist:      NewMemoryTokenDenylist(),
+	}
+
+	if conf.SigningMethod == jwt.SigningMethodRS256.Alg() {
+		j.method = jwt.SigningMethodRS256
+		err = j.parseRSAKeys(privatePEM, publicPEM)
+	} else {
+		j.method = jwt.SigningMethodES256
+		err = j.parseECKeys(privatePEM, publicPEM)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid key for %s: %s", conf.SigningMethod, err)
+	}
+
+	return j, nil
+}
+
+func (j *JWT) parseRSAKeys(privatePEM, publicPEM []byte) error {
+	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(privatePEM)
+	if err != nil {
+		return err
+	}
+
+	j.signKey = privateKey
+	j.verifyKey = &privateKey.PublicKey
+	if publicPEM != nil {
+		j.verifyKey, err = jwt.ParseRSAPublicKeyFromPEM(publicPEM)
+	}
+
+	return err
+}
+
+func (j *JWT) parseECKeys(privatePEM, publicPEM []byte) error {
+	privateKey, err := jwt.ParseECPrivateKeyFromPEM(privatePEM)
+	if err != nil {
+		return err
+	}
+
+	j.signKey = privateKey
+	j.verifyKey = &privateKey.PublicKey
+	if publicPEM != nil {
+		j.verifyKey, err = jwt.ParseECPublicKeyFromPEM(publicPEM)
+	}
+
+	return err
+}
+
+// ErrRefreshExpired is returned when a token expired before the refresh grace window
+var ErrRefreshExpired = errors.New("the token expired too long ago to be refreshed")
+
+// ErrRefreshTooEarly is returned when a token is refreshed before the refresh
+// window before its expiration
+var ErrRefreshTooEarly = errors.New("the token can not be refreshed yet")
+
+// ErrTokenRevoked is returned when a revoked token is refreshed
+var ErrTokenRevoked = errors.New("the token was revoked")
+
+// ErrTokenNotRevocable is returned when a token without ID or expiration is
+// revoked, as the ones issued before the tokens had them
+var ErrTokenNotRevocable = errors.New("the token has no ID or expiration, it can not be revoked; log in again to get a new one")
+
+// ErrUnexpectedClaims is returned when the iss or aud claims of a token are
+// not the configured ones
+var ErrUnexpectedClaims = errors.New("the token has an unexpected issuer or audience")
+
 type userIDContext int
 
 const userIDKey userIDContext = 1
@@ -55,23 +211,162 @@ type jwtClaim struct {
 
 // MakeToken generates token string for a user
 func (j *JWT) MakeToken(user *model.User) (string, error) {
-	claims := &jwtClaim{ID: user.ID}
-	t := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	ss, err := t.SignedString(j.signingKey)
+	return j.makeToken(user.ID)
+}
+
+func (j *JWT) makeToken(userID int) (string, error) {
+	id, err := newTokenID()
+	if err != nil {
+		return "", fmt.Errorf("can't generate jwt token ID: %s", err)
+	}
+
+	now := time.Now()
+	claims := &jwtClaim{
+		ID: userID,
+		StandardClaims: jwt.StandardClaims{
+			Id:        id,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(j.ttl).Unix(),
+			Issuer:    j.issuer,
+			Audience:  j.audience,
+		},
+	}
+
+	t := jwt.NewWithClaims(j.method, claims)
+	ss, err := t.SignedString(j.signKey)
 	if err != nil {
 		return "", fmt.Errorf("can't sign jwt token: %s", err)
 	}
 	return ss, nil
 }
 
-// Middleware return http.Handler which validates token and set user id in context
+// RefreshToken generates a new token string for the user of the token sent in
+// the request. The sent token must have a valid signature, it must expire
+// within the refresh window, and it must not be expired for longer than the
+// refresh grace window. The tokens without expiration are taken as expired
+func (j *JWT) RefreshToken(r *http.Request) (string, error) {
+	tokStr, err := extractor.ExtractToken(r)
+	if err != nil {
+		return "", err
+	}
+
+	var claims jwtClaim
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	if _, err := parser.ParseWithClaims(tokStr, &claims, j.keyFunc); err != nil {
+		return "", err
+	}
+
+	if !j.validClaims(&claims) {
+		return "", ErrUnexpectedClaims
+	}
+
+	if claims.ExpiresAt == 0 ||
+		time.Unix(claims.ExpiresAt, 0).Add(j.refreshGrace).Before(time.Now()) {
+		return "", ErrRefreshExpired
+	}
+
+	if time.Now().Before(time.Unix(claims.ExpiresAt, 0).Add(-j.refreshWindow)) {
+		return "", ErrRefreshTooEarly
+	}
+
+	revoked, err := j.isRevoked(r.Context(), &claims)
+	if err != nil {
+		return "", &DenylistError{err}
+	}
+
+	if revoked {
+		return "", ErrTokenRevoked
+	}
+
+	return j.makeToken(claims.ID)
+}
+
+// RevokeToken denies the token sent in the request, that must be valid, so it
+// can not be used nor refreshed anymore, by any server sharing the denylist.
+// It is kept in the denylist until the refresh grace window after its
+// expiration is over
+func (j *JWT) RevokeToken(r *http.Request) error {
+	var claims jwtClaim
+	if _, err := request.ParseFromRequestWithClaims(r, extractor, &claims, j.keyFunc); err != nil {
+		return err
+	}
+
+	if !j.validClaims(&claims) {
+		return ErrUnexpectedClaims
+	}
+
+	if claims.Id == "" || claims.ExpiresAt == 0 {
+		return ErrTokenNotRevocable
+	}
+
+	until := time.Unix(claims.ExpiresAt, 0)
+	if err := j.denylist.Add(r.Context(), claims.Id, until.Add(j.refreshGrace)); err != nil {
+		return &DenylistError{err}
+	}
+
+	return nil
+}
+
+// validClaims returns true if the iss and aud claims of the token are the
+// configured ones. Only the configured values are checked
+func (j *JWT) validClaims(claims *jwtClaim) bool {
+	if j.skipClaimsCheck {
+		return true
+	}
+
+	if j.issuer != "" && !claims.VerifyIssuer(j.issuer, true) {
+		return false
+	}
+
+	return j.audience == "" || claims.VerifyAudience(j.audience, true)
+}
+
+func (j *JWT) isRevoked(ctx context.Context, claims *jwtClaim) (bool, error) {
+	if claims.Id == "" {
+		return false, nil
+	}
+
+	return j.denylist.Contains(ctx, claims.Id)
+}
+
+// newTokenID returns a random ID for the jti claim of a token
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// keyFunc returns the key to verify the token, rejecting the tokens signed
+// with a different method than the configured one
+func (j *JWT) keyFunc(token *jwt.Token) (interface{}, error) {
+	if token.Method.Alg() != j.method.Alg() {
+		return nil, fmt.Errorf("unexpected JWT signing method %q", token.Method.Alg())
+	}
+
+	return j.verifyKey, nil
+}
+
+// Middleware return http.Handler which validates token and set user id in context.
+// The tokens without expiration, issued before they had one, are rejected
 func (j *JWT) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var claims jwtClaim
-		_, err := request.ParseFromRequestWithClaims(r, extractor, &claims, func(token *jwt.Token) (interface{}, error) {
-			return j.signingKey, nil
-		})
+		_, err := request.ParseFromRequestWithClaims(r, extractor, &claims, j.keyFunc)
+		if err != nil || claims.ExpiresAt == 0 || !j.validClaims(&claims) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		revoked, err := j.isRevoked(r.Context(), &claims)
 		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if revoked {
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
@@ -0,0 +1,234 @@
+package service_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"github.com/src-d/code-annotation/server/model"
+	"github.com/src-d/code-annotation/server/service"
+	"github.com/stretchr/testify/suite"
+)
+
+type JWTSuite struct {
+	suite.Suite
+}
+
+func tokenRequest(token string) *http.Request {
+	req, _ := http.NewRequest("POST", "/api/auth/refresh", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func (suite *JWTSuite) TestRefreshToken() {
+	assert := suite.Assert()
+	jwt := service.NewJWT("key", time.Hour, time.Hour, time.Hour)
+
+	token, err := jwt.MakeToken(&model.User{ID: 1})
+	assert.NoError(err)
+
+	refreshed, err := jwt.RefreshToken(tokenRequest(token))
+	assert.NoError(err)
+	assert.NotEmpty(refreshed)
+
+	_, err = jwt.RefreshToken(tokenRequest(token + "x"))
+	assert.Error(err)
+
+	_, err = service.NewJWT("other key", time.Hour, time.Hour, time.Hour).RefreshToken(tokenRequest(token))
+	assert.Error(err)
+}
+
+func (suite *JWTSuite) TestRefreshExpiredToken() {
+	assert := suite.Assert()
+
+	// tokens expired half an hour ago
+	expiredJWT := service.NewJWT("key", -30*time.Minute, 0, 0)
+	token, err := expiredJWT.MakeToken(&model.User{ID: 1})
+	assert.NoError(err)
+
+	_, err = service.NewJWT("key", time.Hour, time.Hour, time.Hour).RefreshToken(tokenRequest(token))
+	assert.NoError(err)
+
+	_, err = service.NewJWT("key", time.Hour, time.Hour, 10*time.Minute).RefreshToken(tokenRequest(token))
+	assert.Equal(service.ErrRefreshExpired, err)
+}
+
+func (suite *JWTSuite) TestTokenWithoutExpiration() {
+	assert := suite.Assert()
+	jwt := service.NewJWT("key", time.Hour, time.Hour, time.Hour)
+
+	// tokens issued before they had an expiration and an ID
+	token, err := jwtgo.NewWithClaims(jwtgo.SigningMethodHS256, jwtgo.MapClaims{"ID": 1}).SignedString([]byte("key"))
+	assert.NoError(err)
+
+	w := httptest.NewRecorder()
+	jwt.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})).ServeHTTP(w, tokenRequest(token))
+	assert.Equal(http.StatusUnauthorized, w.Code)
+
+	_, err = jwt.RefreshToken(tokenRequest(token))
+	assert.Equal(service.ErrRefreshExpired, err)
+	assert.Equal(service.ErrTokenNotRevocable, jwt.RevokeToken(tokenRequest(token)))
+}
+
+func (suite *JWTSuite) TestRefreshTokenTooEarly() {
+	assert := suite.Assert()
+
+	// tokens that expire in two hours
+	token, err := service.NewJWT("key", 2*time.Hour, time.Hour, time.Hour).MakeToken(&model.User{ID: 1})
+	assert.NoError(err)
+
+	_, err = service.NewJWT("key", time.Hour, time.Hour, time.Hour).RefreshToken(tokenRequest(token))
+	assert.Equal(service.ErrRefreshTooEarly, err)
+
+	_, err = service.NewJWT("key", time.Hour, 3*time.Hour, time.Hour).RefreshToken(tokenRequest(token))
+	assert.NoError(err)
+}
+
+func (suite *JWTSuite) TestRevokeToken() {
+	assert := suite.Assert()
+	jwt := service.NewJWT("key", time.Hour, time.Hour, time.Hour)
+
+	revoked, err := jwt.MakeToken(&model.User{ID: 1})
+	assert.NoError(err)
+	other, err := jwt.MakeToken(&model.User{ID: 1})
+	assert.NoError(err)
+
+	status := func(token string) int {
+		w := httptest.NewRecorder()
+		jwt.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})).ServeHTTP(w, tokenRequest(token))
+		return w.Code
+	}
+
+	assert.Equal(http.StatusOK, status(revoked))
+	assert.NoError(jwt.RevokeToken(tokenRequest(revoked)))
+
+	assert.Equal(http.StatusUnauthorized, status(revoked))
+	_, err = jwt.RefreshToken(tokenRequest(revoked))
+	assert.Equal(service.ErrTokenRevoked, err)
+
+	// the other tokens of the user are still valid
+	assert.Equal(http.StatusOK, status(other))
+
+	assert.Error(jwt.RevokeToken(tokenRequest(revoked + "x")))
+}
+
+func (suite *JWTSuite) TestIssuerAndAudience() {
+	assert := suite.Assert()
+
+	newJWT := func(issuer, audience string, skip bool) *service.JWT {
+		j, err := service.NewJWTFromConfig(service.JWTConfig{
+			SigningMethod: "HS256", SigningKey: "key", TTL: time.Hour, RefreshWindow: time.Hour, RefreshGrace: time.Hour,
+			Issuer: issuer, Audience: audience, SkipClaimsCheck: skip})
+		assert.NoError(err)
+		return j
+	}
+
+	status := func(j *service.JWT, token string) int {
+		w := httptest.NewRecorder()
+		j.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})).ServeHTTP(w, tokenRequest(token))
+		return w.Code
+	}
+
+	sso := newJWT("sso", "annotation", false)
+	token, err := sso.MakeToken(&model.User{ID: 1})
+	assert.NoError(err)
+	legacy, err := newJWT("", "", false).MakeToken(&model.User{ID: 1})
+	assert.NoError(err)
+
+	assert.Equal(http.StatusOK, status(sso, token))
+	_, err = sso.RefreshToken(tokenRequest(token))
+	assert.NoError(err)
+
+	assert.Equal(http.StatusUnauthorized, status(newJWT("other", "annotation", false), token))
+	assert.Equal(http.StatusUnauthorized, status(newJWT("sso", "other", false), token))
+	assert.Equal(http.StatusUnauthorized, status(sso, legacy))
+	_, err = sso.RefreshToken(tokenRequest(legacy))
+	assert.Equal(service.ErrUnexpectedClaims, err)
+	assert.Equal(service.ErrUnexpectedClaims, sso.RevokeToken(tokenRequest(legacy)))
+
+	// tokens without the claims are still valid when the check is skipped
+	skipped := newJWT("sso", "annotation", true)
+	assert.Equal(http.StatusOK, status(skipped, legacy))
+	_, err = skipped.RefreshToken(tokenRequest(legacy))
+	assert.NoError(err)
+}
+
+func (suite *JWTSuite) TestNewJWTFromConfig() {
+	assert := suite.Assert()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(err)
+	rsaFile := suite.writePEM("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+	defer os.Remove(rsaFile)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(err)
+	ecBytes, err := x509.MarshalECPrivateKey(ecKey)
+	assert.NoError(err)
+	ecFile := suite.writePEM("EC PRIVATE KEY", ecBytes)
+	defer os.Remove(ecFile)
+
+	hmacJWT, err := service.NewJWTFromConfig(service.JWTConfig{
+		SigningMethod: "HS256", SigningKey: "key", TTL: time.Hour, RefreshWindow: time.Hour})
+	assert.NoError(err)
+	rsaJWT, err := service.NewJWTFromConfig(service.JWTConfig{
+		SigningMethod: "RS256", PrivateKeyFile: rsaFile, TTL: time.Hour, RefreshWindow: time.Hour})
+	assert.NoError(err)
+	ecJWT, err := service.NewJWTFromConfig(service.JWTConfig{
+		SigningMethod: "ES256", PrivateKeyFile: ecFile, TTL: time.Hour, RefreshWindow: time.Hour})
+	assert.NoError(err)
+
+	for _, j := range []*service.JWT{hmacJWT, rsaJWT, ecJWT} {
+		token, err := j.MakeToken(&model.User{ID: 1})
+		assert.NoError(err)
+
+		for _, other := range []*service.JWT{hmacJWT, rsaJWT, ecJWT} {
+			_, err = other.RefreshToken(tokenRequest(token))
+			if other == j {
+				assert.NoError(err)
+			} else {
+				assert.Error(err)
+			}
+		}
+	}
+
+	for _, conf := range []service.JWTConfig{
+		{SigningMethod: "HS256"},
+		{SigningMethod: "RS256"},
+		{SigningMethod: "ES256", PrivateKeyFile: "missing.pem"},
+		{SigningMethod: "ES256", PrivateKeyFile: rsaFile},
+		{SigningMethod: "none", SigningKey: "key"},
+	} {
+		_, err := service.NewJWTFromConfig(conf)
+		assert.Error(err, conf.SigningMethod)
+	}
+}
+
+func (suite *JWTSuite) writePEM(blockType string, bytes []byte) string {
+	f, err := ioutil.TempFile("", "jwt_key")
+	suite.Require().NoError(err)
+	defer f.Close()
+
+	suite.Require().NoError(pem.Encode(f, &pem.Block{Type: blockType, Bytes: bytes}))
+	return f.Name()
+}
+
+func TestJWT(t *testing.T) {
+	suite.Run(t, new(JWTSuite))
+}
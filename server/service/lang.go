@@ -0,0 +1,129 @@
+package service
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// DefaultLanguage is returned by DetectLanguage when the language can not be
+// detected
+const DefaultLanguage = "text"
+
+var languagesByFilename = map[string]string{
+	"dockerfile":     "dockerfile",
+	"makefile":       "makefile",
+	"gnumakefile":    "makefile",
+	"cmakelists.txt": "cmake",
+	"gemfile":        "ruby",
+	"rakefile":       "ruby",
+	"vagrantfile":    "ruby",
+	"jenkinsfile":    "groovy",
+	".bashrc":        "shell",
+	".zshrc":         "shell",
+	".profile":       "shell",
+}
+
+var languagesByExtension = map[string]string{
+	".c":      "c",
+	".h":      "c",
+	".cc":     "cpp",
+	".cpp":    "cpp",
+	".cxx":    "cpp",
+	".hpp":    "cpp",
+	".cs":     "csharp",
+	".clj":    "clojure",
+	".coffee": "coffeescript",
+	".css":    "css",
+	".dart":   "dart",
+	".erl":    "erlang",
+	".ex":     "elixir",
+	".exs":    "elixir",
+	".go":     "go",
+	".groovy": "groovy",
+	".hs":     "haskell",
+	".html":   "html",
+	".htm":    "html",
+	".java":   "java",
+	".js":     "javascript",
+	".jsx":    "javascript",
+	".json":   "json",
+	".kt":     "kotlin",
+	".lua":    "lua",
+	".m":      "objectivec",
+	".md":     "markdown",
+	".php":    "php",
+	".pl":     "perl",
+	".py":     "python",
+	".r":      "r",
+	".rb":     "ruby",
+	".rs":     "rust",
+	".scala":  "scala",
+	".scss":   "scss",
+	".sh":     "shell",
+	".bash":   "shell",
+	".sql":    "sql",
+	".swift":  "swift",
+	".ts":     "typescript",
+	".tsx":    "typescript",
+	".xml":    "xml",
+	".yaml":   "yaml",
+	".yml":    "yaml",
+}
+
+var languagesByInterpreter = map[string]string{
+	"bash":    "shell",
+	"sh":      "shell",
+	"zsh":     "shell",
+	"node":    "javascript",
+	"perl":    "perl",
+	"php":     "php",
+	"python":  "python",
+	"python2": "python",
+	"python3": "python",
+	"ruby":    "ruby",
+}
+
+// DetectLanguage returns the language of a file using its path and, if it is
+// not enough, the shebang of its content. It returns DefaultLanguage if the
+// detection is inconclusive
+func DetectLanguage(path, content string) string {
+	name := strings.ToLower(filepath.Base(path))
+	if lang, ok := languagesByFilename[name]; ok {
+		return lang
+	}
+
+	if lang, ok := languagesByExtension[filepath.Ext(name)]; ok {
+		return lang
+	}
+
+	if lang, ok := languagesByInterpreter[interpreter(content)]; ok {
+		return lang
+	}
+
+	return DefaultLanguage
+}
+
+// interpreter returns the name of the interpreter in the shebang of the
+// content, or "" if there is none
+func interpreter(content string) string {
+	if !strings.HasPrefix(content, "#!") {
+		return ""
+	}
+
+	line := content[2:]
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return ""
+	}
+
+	name := filepath.Base(fields[0])
+	if name == "env" && len(fields) > 1 {
+		name = fields[1]
+	}
+
+	return name
+}
@@ -0,0 +1,31 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	assert := assert.New(t)
+
+	cases := []struct {
+		path     string
+		content  string
+		expected string
+	}{
+		{"main.go", "package main", "go"},
+		{"src/App.JSX", "", "javascript"},
+		{"build/Dockerfile", "FROM alpine", "dockerfile"},
+		{"Makefile", "all:", "makefile"},
+		{"bin/run", "#!/bin/bash\necho 1", "shell"},
+		{"bin/tool", "#!/usr/bin/env python3\nprint(1)", "python"},
+		{"bin/tool", "#!/usr/bin/env", DefaultLanguage},
+		{"LICENSE", "MIT License", DefaultLanguage},
+		{"data.unknown", "", DefaultLanguage},
+	}
+
+	for _, c := range cases {
+		assert.Equal(c.expected, DetectLanguage(c.path, c.content), c.path)
+	}
+}
@@ -1,20 +1,53 @@
 package service
 
 import (
+	"fmt"
+
 	"github.com/sirupsen/logrus"
 )
 
-// NewLogger returns a logrus Logger
-func NewLogger(env string) *logrus.Logger {
+// Log formats accepted by NewLogger
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+)
+
+// LoggerConfig defines enviroment variables for the logger. When a field is
+// empty, the default for the environment is used
+type LoggerConfig struct {
+	Format string `envconfig:"FORMAT"`
+	Level  string `envconfig:"LEVEL"`
+}
+
+// NewLogger returns a logrus Logger. The dev environment logs text messages
+// from the debug level, the rest JSON messages from the info level, unless
+// the config says otherwise
+func NewLogger(env string, conf LoggerConfig) (*logrus.Logger, error) {
 	logger := logrus.New()
 
+	format, level := LogFormatJSON, logrus.InfoLevel
 	if env == "dev" {
+		format, level = LogFormatText, logrus.DebugLevel
+	}
+
+	if conf.Format != "" {
+		format = conf.Format
+	}
+
+	if conf.Level != "" {
+		var err error
+		if level, err = logrus.ParseLevel(conf.Level); err != nil {
+			return nil, err
+		}
+	}
+
+	switch format {
+	case LogFormatText:
 		logger.Formatter = &logrus.TextFormatter{
 			FullTimestamp:   true,
 			TimestampFormat: "2006-01-02 15:04:05",
 		}
-		logger.SetLevel(logrus.DebugLevel)
-	} else {
+	case LogFormatJSON:
 		logger.Formatter = &logrus.JSONFormatter{
 			FieldMap: logrus.FieldMap{
 				logrus.FieldKeyTime:  "time",
@@ -22,8 +55,11 @@ func NewLogger(env string) *logrus.Logger {
 				logrus.FieldKeyMsg:   "message",
 			},
 		}
-		logger.SetLevel(logrus.WarnLevel)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
 	}
 
-	return logger
+	logger.SetLevel(level)
+
+	return logger, nil
 }
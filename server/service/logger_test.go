@@ -0,0 +1,20 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewLoggerFormat(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := NewLogger("dev", LoggerConfig{Format: "json", Level: "warn"})
+	assert.Nil(err)
+
+	_, err = NewLogger("production", LoggerConfig{Format: "xml"})
+	assert.Error(err)
+
+	_, err = NewLogger("production", LoggerConfig{Level: "loud"})
+	assert.Error(err)
+}
@@ -0,0 +1,204 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricsContentType is the content type of the metrics written by
+// Metrics.Write, the Prometheus text exposition format
+const MetricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// durationBuckets are the upper bounds, in seconds, of the duration
+// histograms. They are the Prometheus client defaults
+var durationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics collects the application metrics and writes them in the
+// Prometheus text format. It is safe for concurrent use
+type Metrics struct {
+	requests *histogramVec
+	queries  *histogramVec
+
+	mu     sync.Mutex
+	values []valueFunc
+}
+
+// NewMetrics returns an empty Metrics
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests: newHistogramVec(
+			"cat_http_request_duration_seconds",
+			"Duration of the HTTP requests, by route pattern, method and status code.",
+			"route", "method", "status"),
+		queries: newHistogramVec(
+			"cat_db_query_duration_seconds",
+			"Duration of the DB queries until their results are available, by operation.",
+			"operation"),
+	}
+}
+
+// ObserveRequest records the duration of a request to the given route
+// pattern. The histogram count is the number of requests
+func (m *Metrics) ObserveRequest(route, method string, status int, d time.Duration) {
+	m.requests.observe(d.Seconds(), route, method, strconv.Itoa(status))
+}
+
+// ObserveQuery records the duration of a DB query of the given operation,
+// such as select or insert
+func (m *Metrics) ObserveQuery(operation string, d time.Duration) {
+	m.queries.observe(d.Seconds(), operation)
+}
+
+// AddGauge adds a gauge whose value is read from fn every time the metrics
+// are written
+func (m *Metrics) AddGauge(name, help string, fn func() float64) {
+	m.addValue(valueFunc{name, help, "gauge", fn})
+}
+
+// AddCounter adds a counter whose value is read from fn every time the
+// metrics are written. The value returned by fn must never decrease
+func (m *Metrics) AddCounter(name, help string, fn func() float64) {
+	m.addValue(valueFunc{name, help, "counter", fn})
+}
+
+func (m *Metrics) addValue(v valueFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.values = append(m.values, v)
+}
+
+// Write writes all the metrics to w
+func (m *Metrics) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	m.requests.write(bw)
+	m.queries.write(bw)
+
+	m.mu.Lock()
+	values := m.values
+	m.mu.Unlock()
+
+	for _, v := range values {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n%s %s\n",
+			v.name, v.help, v.name, v.kind, v.name, formatFloat(v.fn()))
+	}
+
+	return bw.Flush()
+}
+
+// valueFunc is a gauge or counter metric
+type valueFunc struct {
+	name string
+	help string
+	kind string
+	fn   func() float64
+}
+
+type histogram struct {
+	labels []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// histogramVec is a group of histograms with the same name and buckets,
+// one for each combination of label values
+type histogramVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+func newHistogramVec(name, help string, labels ...string) *histogramVec {
+	return &histogramVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		series: make(map[string]*histogram),
+	}
+}
+
+func (v *histogramVec) observe(value float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	h, ok := v.series[key]
+	if !ok {
+		h = &histogram{labels: labelValues, counts: make([]uint64, len(durationBuckets))}
+		v.series[key] = h
+	}
+
+	for i, le := range durationBuckets {
+		if value <= le {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// write writes the histograms sorted by their label values, so the output is
+// stable between calls
+func (v *histogramVec) write(w io.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", v.name, v.help, v.name)
+
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		h := v.series[key]
+		labels := v.formatLabels(h.labels)
+
+		for i, le := range durationBuckets {
+			fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", v.name, labels, formatFloat(le), h.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", v.name, labels, h.count)
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", v.name, strings.TrimSuffix(labels, ","), formatFloat(h.sum))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", v.name, strings.TrimSuffix(labels, ","), h.count)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels returns the label pairs of the histogram, each one followed
+// by a comma
+func (v *histogramVec) formatLabels(values []string) string {
+	var b strings.Builder
+	for i, name := range v.labels {
+		fmt.Fprintf(&b, "%s=\"%s\",", name, labelEscaper.Replace(values[i]))
+	}
+
+	return b.String()
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsWrite(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewMetrics()
+	m.ObserveRequest("/api/experiments/{experimentId}", "GET", 200, 20*time.Millisecond)
+	m.ObserveRequest("/api/experiments/{experimentId}", "GET", 200, 2*time.Second)
+	m.ObserveRequest("/api/\"quoted\"", "POST", 404, time.Millisecond)
+	m.ObserveQuery("select", 3*time.Millisecond)
+	m.AddGauge("cat_test_gauge", "A test gauge.", func() float64 { return 3 })
+	m.AddCounter("cat_test_total", "A test counter.", func() float64 { return 7 })
+
+	var buf bytes.Buffer
+	assert.Nil(m.Write(&buf))
+	out := buf.String()
+
+	assert.Contains(out, "# TYPE cat_http_request_duration_seconds histogram\n")
+	assert.Contains(out, `cat_http_request_duration_seconds_bucket{route="/api/experiments/{experimentId}",method="GET",status="200",le="0.025"} 1`+"\n")
+	assert.Contains(out, `cat_http_request_duration_seconds_bucket{route="/api/experiments/{experimentId}",method="GET",status="200",le="2.5"} 2`+"\n")
+	assert.Contains(out, `cat_http_request_duration_seconds_bucket{route="/api/experiments/{experimentId}",method="GET",status="200",le="+Inf"} 2`+"\n")
+	assert.Contains(out, `cat_http_request_duration_seconds_sum{route="/api/experiments/{experimentId}",method="GET",status="200"} 2.02`+"\n")
+	assert.Contains(out, `cat_http_request_duration_seconds_count{route="/api/experiments/{experimentId}",method="GET",status="200"} 2`+"\n")
+	assert.Contains(out, `cat_http_request_duration_seconds_count{route="/api/\"quoted\"",method="POST",status="404"} 1`+"\n")
+	assert.Contains(out, `cat_db_query_duration_seconds_count{operation="select"} 1`+"\n")
+	assert.Contains(out, "# TYPE cat_test_gauge gauge\ncat_test_gauge 3\n")
+	assert.Contains(out, "# TYPE cat_test_total counter\ncat_test_total 7\n")
+
+	var again bytes.Buffer
+	assert.Nil(m.Write(&again))
+	assert.Equal(out, again.String())
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimitConfig defines enviroment variables for the rate limit
+type RateLimitConfig struct {
+	PerMinute int `envconfig:"PER_MINUTE" default:"30"`
+	Burst     int `envconfig:"BURST" default:"10"`
+}
+
+// RateLimitStore keeps track of the requests made by every client, and
+// decides if a new one is allowed
+type RateLimitStore interface {
+	// Allow returns true if the client with the given key can make a new
+	// request, and counts it
+	Allow(key string) (bool, error)
+}
+
+// maxMemoryBuckets is the number of clients tracked by MemoryRateLimitStore
+// before forgetting the ones that did not make any recent request
+const maxMemoryBuckets = 10000
+
+// MemoryRateLimitStore is an in-memory RateLimitStore implementing a token
+// bucket for every client
+type MemoryRateLimitStore struct {
+	perMinute float64
+	burst     float64
+	now       func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewMemoryRateLimitStore returns a new MemoryRateLimitStore allowing
+// perMinute requests per minute from every client, with bursts of up to
+// burst requests
+func NewMemoryRateLimitStore(perMinute, burst int) *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		perMinute: float64(perMinute),
+		burst:     float64(burst),
+		now:       time.Now,
+		buckets:   make(map[string]*tokenBucket),
+	}
+}
+
+// Allow implements the RateLimitStore interface
+func (s *MemoryRateLimitStore) Allow(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+
+	b, ok := s.buckets[key]
+	if !ok {
+		if len(s.buckets) >= maxMemoryBuckets {
+			s.prune(now)
+		}
+
+		b = &tokenBucket{tokens: s.burst, last: now}
+		s.buckets[key] = b
+	}
+
+	s.refill(b, now)
+	if b.tokens < 1 {
+		return false, nil
+	}
+
+	b.tokens--
+	return true, nil
+}
+
+func (s *MemoryRateLimitStore) refill(b *tokenBucket, now time.Time) {
+	b.tokens += now.Sub(b.last).Minutes() * s.perMinute
+	if b.tokens > s.burst {
+		b.tokens = s.burst
+	}
+
+	b.last = now
+}
+
+// prune forgets the buckets that are full again, as they behave like new ones
+func (s *MemoryRateLimitStore) prune(now time.Time) {
+	for key, b := range s.buckets {
+		s.refill(b, now)
+		if b.tokens >= s.burst {
+			delete(s.buckets, key)
+		}
+	}
+}
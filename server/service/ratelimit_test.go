@@ -0,0 +1,40 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	store := NewMemoryRateLimitStore(6, 2)
+	store.now = func() time.Time { return now }
+
+	allow := func(key string) bool {
+		allowed, err := store.Allow(key)
+		assert.NoError(err)
+		return allowed
+	}
+
+	assert.True(allow("a"))
+	assert.True(allow("a"))
+	assert.False(allow("a"))
+	assert.True(allow("b"))
+
+	// 6 per minute refills a token every 10 seconds
+	now = now.Add(5 * time.Second)
+	assert.False(allow("a"))
+	now = now.Add(5 * time.Second)
+	assert.True(allow("a"))
+	assert.False(allow("a"))
+
+	// the burst caps the refilled tokens
+	now = now.Add(time.Hour)
+	assert.True(allow("a"))
+	assert.True(allow("a"))
+	assert.False(allow("a"))
+}
@@ -0,0 +1,47 @@
+package service
+
+import "math"
+
+// ScoreTargetBandwidth is how fast the weight of a FilePair decreases as its
+// score moves away from the target score, see ScoreWeight. The FilePair
+// scores are similarities between 0 and 1
+const ScoreTargetBandwidth = 0.1
+
+// ScoreWeight returns the weight, between 0 and 1, of a FilePair with the
+// given score when the pairs close to the target score are preferred. It
+// decays exponentially with the distance to the target
+func ScoreWeight(score, target float64) float64 {
+	return math.Exp(-math.Abs(score-target) / ScoreTargetBandwidth)
+}
+
+// PickByScore returns the index of one of the given scores, chosen with a
+// probability proportional to its ScoreWeight for the target score. r must
+// be a uniform random value in [0, 1). It returns -1 if there are no scores
+func PickByScore(scores []float64, target float64, r float64) int {
+	if len(scores) == 0 {
+		return -1
+	}
+
+	weights := make([]float64, len(scores))
+	var total float64
+	for i, score := range scores {
+		weights[i] = ScoreWeight(score, target)
+		total += weights[i]
+	}
+
+	// all the weights underflow if every score is too far from the target
+	if total == 0 {
+		return int(r * float64(len(scores)))
+	}
+
+	threshold := r * total
+	for i, w := range weights {
+		if threshold < w {
+			return i
+		}
+
+		threshold -= w
+	}
+
+	return len(scores) - 1
+}
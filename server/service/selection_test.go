@@ -0,0 +1,42 @@
+package service_test
+
+import (
+	"testing"
+
+	"github.com/src-d/code-annotation/server/service"
+	"github.com/stretchr/testify/suite"
+)
+
+type SelectionSuite struct {
+	suite.Suite
+}
+
+func (suite *SelectionSuite) TestScoreWeight() {
+	assert := suite.Assert()
+
+	assert.Equal(1.0, service.ScoreWeight(0.5, 0.5))
+	assert.True(service.ScoreWeight(0.6, 0.5) > service.ScoreWeight(0.9, 0.5))
+	assert.InDelta(service.ScoreWeight(0.4, 0.5), service.ScoreWeight(0.6, 0.5), 1e-9)
+}
+
+func (suite *SelectionSuite) TestPickByScore() {
+	assert := suite.Assert()
+
+	assert.Equal(-1, service.PickByScore(nil, 0.5, 0))
+
+	// the weights are about 1, 0.37 and 0.00005
+	scores := []float64{0.5, 0.6, 1.5}
+	assert.Equal(0, service.PickByScore(scores, 0.5, 0))
+	assert.Equal(0, service.PickByScore(scores, 0.5, 0.7))
+	assert.Equal(1, service.PickByScore(scores, 0.5, 0.8))
+	assert.Equal(1, service.PickByScore(scores, 0.5, 0.9999))
+
+	// every pair is picked the same when all the weights underflow
+	far := []float64{0, 0.1}
+	assert.Equal(0, service.PickByScore(far, 1000, 0.4))
+	assert.Equal(1, service.PickByScore(far, 1000, 0.6))
+}
+
+func TestSelection(t *testing.T) {
+	suite.Run(t, new(SelectionSuite))
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+// Snippet returns the part of text around the first occurrence of term,
+// ignoring the case, with at most radius characters at each side of it. If
+// term is not found, the snippet is the start of text. The snippet is HTML
+// escaped, with every occurrence of term wrapped in <mark> tags, and an
+// ellipsis where text was cut
+func Snippet(text, term string, radius int) string {
+	runes := []rune(text)
+	lower := lowerRunes(runes)
+	needle := lowerRunes([]rune(term))
+
+	start, end := 0, len(runes)
+	if first := indexRunes(lower, needle); first >= 0 {
+		start = first - radius
+		end = first + len(needle) + radius
+	} else {
+		end = 2 * radius
+	}
+
+	if start < 0 {
+		start = 0
+	}
+	if end > len(runes) {
+		end = len(runes)
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+
+	for i := start; i < end; {
+		if len(needle) > 0 && i+len(needle) <= end && equalRunes(lower[i:i+len(needle)], needle) {
+			b.WriteString("<mark>")
+			b.WriteString(html.EscapeString(string(runes[i : i+len(needle)])))
+			b.WriteString("</mark>")
+			i += len(needle)
+			continue
+		}
+
+		b.WriteString(html.EscapeString(string(runes[i])))
+		i++
+	}
+
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+
+	return b.String()
+}
+
+// lowerRunes returns the runes in lower case. Unlike strings.ToLower, the
+// number of runes is kept, so the positions match the original ones
+func lowerRunes(runes []rune) []rune {
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	return lower
+}
+
+// indexRunes returns the position of the first occurrence of needle in runes,
+// or -1 if there is none
+func indexRunes(runes, needle []rune) int {
+	if len(needle) == 0 {
+		return -1
+	}
+
+	for i := 0; i+len(needle) <= len(runes); i++ {
+		if equalRunes(runes[i:i+len(needle)], needle) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func equalRunes(a, b []rune) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
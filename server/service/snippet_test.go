@@ -0,0 +1,38 @@
+package service_test
+
+import (
+	"testing"
+
+	"github.com/src-d/code-annotation/server/service"
+	"github.com/stretchr/testify/suite"
+)
+
+type SnippetSuite struct {
+	suite.Suite
+}
+
+func (suite *SnippetSuite) TestSnippet() {
+	assert := suite.Assert()
+
+	cases := []struct {
+		text     string
+		term     string
+		radius   int
+		expected string
+	}{
+		{"same logic", "LOGIC", 10, "same <mark>logic</mark>"},
+		{"a long comment about the loop", "about", 5, "…ment <mark>about</mark> the …"},
+		{"loop in a loop", "loop", 20, "<mark>loop</mark> in a <mark>loop</mark>"},
+		{"ÑANDÚ ñandú", "ñandú", 1, "<mark>ÑANDÚ</mark> …"},
+		{"<b>bold</b> & more", "bold", 4, "&lt;b&gt;<mark>bold</mark>&lt;/b&gt;…"},
+		{"nothing matches here", "loop", 4, "nothing …"},
+	}
+
+	for _, c := range cases {
+		assert.Equal(c.expected, service.Snippet(c.text, c.term, c.radius), c.text)
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	suite.Run(t, new(SnippetSuite))
+}
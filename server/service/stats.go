@@ -0,0 +1,122 @@
+package service
+
+import "sort"
+
+// Mean returns the arithmetic mean of the given values, or 0 if there are none
+func Mean(values []int) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	var sum float64
+	for _, v := range values {
+		sum += float64(v)
+	}
+
+	return sum / float64(len(values))
+}
+
+// Median returns the median of the given values, or 0 if there are none. For
+// an even number of values it is the mean of the two middle ones
+func Median(values []int) float64 {
+	n := len(values)
+	if n == 0 {
+		return 0
+	}
+
+	sorted := make([]int, n)
+	copy(sorted, values)
+	sort.Ints(sorted)
+
+	if n%2 == 1 {
+		return float64(sorted[n/2])
+	}
+
+	return float64(sorted[n/2-1]+sorted[n/2]) / 2
+}
+
+// CohenKappa returns the Cohen's kappa coefficient of the agreement between
+// two raters. Every rating holds the categories, the answers, given by each
+// rater to the same item. If both raters always used the same category the
+// agreement by chance is total, and 1 is returned
+func CohenKappa(ratings [][2]string) float64 {
+	n := float64(len(ratings))
+	if n == 0 {
+		return 0
+	}
+
+	var agreements float64
+	countsA := make(map[string]float64)
+	countsB := make(map[string]float64)
+	for _, r := range ratings {
+		if r[0] == r[1] {
+			agreements++
+		}
+
+		countsA[r[0]]++
+		countsB[r[1]]++
+	}
+
+	observed := agreements / n
+
+	var chance float64
+	for category, count := range countsA {
+		chance += (count / n) * (countsB[category] / n)
+	}
+
+	if chance == 1 {
+		return 1
+	}
+
+	return (observed - chance) / (1 - chance)
+}
+
+// FleissKappa returns the Fleiss' kappa coefficient of the agreement between
+// any number of raters. Every item holds the categories, the answers, given
+// by its raters, and it can be rated by a different number of raters. The
+// items with less than two ratings are skipped. If all the ratings are of the
+// same category the agreement by chance is total, and 1 is returned
+func FleissKappa(items [][]string) float64 {
+	var rated, total float64
+	var agreement float64
+	totals := make(map[string]float64)
+
+	for _, ratings := range items {
+		n := float64(len(ratings))
+		if n < 2 {
+			continue
+		}
+
+		counts := make(map[string]float64)
+		for _, category := range ratings {
+			counts[category]++
+			totals[category]++
+		}
+
+		var agreeingPairs float64
+		for _, count := range counts {
+			agreeingPairs += count * (count - 1)
+		}
+
+		agreement += agreeingPairs / (n * (n - 1))
+		rated++
+		total += n
+	}
+
+	if rated == 0 {
+		return 0
+	}
+
+	observed := agreement / rated
+
+	var chance float64
+	for _, count := range totals {
+		chance += (count / total) * (count / total)
+	}
+
+	if chance == 1 {
+		return 1
+	}
+
+	return (observed - chance) / (1 - chance)
+}
@@ -0,0 +1,99 @@
+package service_test
+
+import (
+	"testing"
+
+	"github.com/src-d/code-annotation/server/service"
+	"github.com/stretchr/testify/suite"
+)
+
+type StatsSuite struct {
+	suite.Suite
+}
+
+func (suite *StatsSuite) TestMean() {
+	assert := suite.Assert()
+
+	assert.Equal(0.0, service.Mean(nil))
+	assert.Equal(2.0, service.Mean([]int{2}))
+	assert.Equal(2.5, service.Mean([]int{1, 2, 3, 4}))
+}
+
+func (suite *StatsSuite) TestMedian() {
+	assert := suite.Assert()
+
+	assert.Equal(0.0, service.Median(nil))
+	assert.Equal(3.0, service.Median([]int{5, 3, 1}))
+	assert.Equal(2.5, service.Median([]int{4, 1, 3, 2}))
+	assert.Equal(1500.0, service.Median([]int{1000, 2000}))
+
+	values := []int{3, 1, 2}
+	service.Median(values)
+	assert.Equal([]int{3, 1, 2}, values, "the passed values must not be sorted")
+}
+
+func (suite *StatsSuite) TestCohenKappa() {
+	assert := suite.Assert()
+
+	// example from https://en.wikipedia.org/wiki/Cohen%27s_kappa
+	var ratings [][2]string
+	for r, n := range map[[2]string]int{
+		{"yes", "yes"}: 20,
+		{"yes", "no"}:  5,
+		{"no", "yes"}:  10,
+		{"no", "no"}:   15,
+	} {
+		for i := 0; i < n; i++ {
+			ratings = append(ratings, r)
+		}
+	}
+
+	assert.InDelta(0.4, service.CohenKappa(ratings), 1e-9)
+
+	assert.Equal(0.0, service.CohenKappa(nil))
+	assert.Equal(1.0, service.CohenKappa([][2]string{{"yes", "yes"}, {"yes", "yes"}}))
+	assert.Equal(1.0, service.CohenKappa([][2]string{{"yes", "yes"}, {"skip", "skip"}}))
+	assert.InDelta(-1.0, service.CohenKappa([][2]string{{"yes", "no"}, {"no", "yes"}}), 1e-9)
+}
+
+func (suite *StatsSuite) TestFleissKappa() {
+	assert := suite.Assert()
+
+	// example from https://en.wikipedia.org/wiki/Fleiss%27_kappa with 14 raters
+	categories := []string{"1", "2", "3", "4", "5"}
+	table := [][]int{
+		{0, 0, 0, 0, 14},
+		{0, 2, 6, 4, 2},
+		{0, 0, 3, 5, 6},
+		{0, 3, 9, 2, 0},
+		{2, 2, 8, 1, 1},
+		{7, 7, 0, 0, 0},
+		{3, 2, 6, 3, 0},
+		{2, 5, 3, 2, 2},
+		{6, 5, 2, 1, 0},
+		{0, 2, 2, 3, 7},
+	}
+
+	items := make([][]string, len(table))
+	for i, row := range table {
+		for j, n := range row {
+			for k := 0; k < n; k++ {
+				items[i] = append(items[i], categories[j])
+			}
+		}
+	}
+
+	assert.InDelta(0.20993, service.FleissKappa(items), 1e-5)
+
+	// items with a single rating are skipped
+	assert.InDelta(0.20993, service.FleissKappa(append(items, []string{"1"})), 1e-5)
+
+	assert.Equal(0.0, service.FleissKappa(nil))
+	assert.Equal(0.0, service.FleissKappa([][]string{{"yes"}}))
+	assert.Equal(1.0, service.FleissKappa([][]string{{"yes", "yes", "yes"}}))
+	assert.Equal(1.0, service.FleissKappa([][]string{{"yes", "yes"}, {"no", "no", "no"}}))
+}
+
+func TestStats(t *testing.T) {
+	suite.Run(t, new(StatsSuite))
+}
@@ -91,6 +91,12 @@ function me() {
   return apiCall(`/api/me`);
 }
 
+function logout() {
+  return apiCall(`/api/logout`, {
+    method: 'POST',
+  });
+}
+
 function getExperiments() {
   return apiCall(`/api/experiments`);
 }
@@ -102,10 +108,10 @@ function createExperiment(name, description) {
   });
 }
 
-function updateExperiment(experimentId, name, description) {
+function updateExperiment(experimentId, name, description, version) {
   return apiCall(`/api/experiments/${experimentId}`, {
     method: 'PUT',
-    body: { name, description },
+    body: { name, description, version },
   });
 }
 
@@ -128,6 +134,16 @@ function getAssignments(experimentId) {
   return apiCall(`/api/experiments/${experimentId}/assignments`);
 }
 
+function getAssignment(experimentId, assignmentId) {
+  return apiCall(
+    `/api/experiments/${experimentId}/assignments/${assignmentId}`
+  );
+}
+
+function getRemainingCount(experimentId) {
+  return apiCall(`/api/experiments/${experimentId}/assignments/remaining`);
+}
+
 function getFilePair(experimentId, pairId, showInvisible = false) {
   let queryStr = '';
   if (showInvisible) {
@@ -138,6 +154,13 @@ function getFilePair(experimentId, pairId, showInvisible = false) {
   );
 }
 
+function getFilePairsBatch(experimentId, ids) {
+  return apiCall(`/api/experiments/${experimentId}/file-pairs/batch`, {
+    method: 'POST',
+    body: { ids },
+  });
+}
+
 function putAnswer(experimentId, assignmentId, answer) {
   return apiCall(
     `/api/experiments/${experimentId}/assignments/${assignmentId}`,
@@ -181,13 +204,17 @@ function exportDownload(filename) {
 export default {
   auth,
   me,
+  logout,
   getExperiments,
   createExperiment,
   updateExperiment,
   getExperiment,
   uploadFilePairs,
   getAssignments,
+  getAssignment,
+  getRemainingCount,
   getFilePair,
+  getFilePairsBatch,
   putAnswer,
   exportList,
   exportCreate,
@@ -66,7 +66,8 @@ class ExperimentEditModal extends Component {
     return this.props.experimentUpdate(
       this.props.editModalExperiment.id,
       this.state.nameVal,
-      this.state.descriptionVal
+      this.state.descriptionVal,
+      this.props.editModalExperiment.version
     );
   }
 
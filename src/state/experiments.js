@@ -160,10 +160,10 @@ export const create = (name, description) => dispatch => {
     });
 };
 
-export const update = (id, name, description) => dispatch => {
+export const update = (id, name, description, version) => dispatch => {
   dispatch({ type: UPDATE });
   return api
-    .updateExperiment(id, name, description)
+    .updateExperiment(id, name, description, version)
     .then(() => {
       dispatch({ type: UPDATE_SUCCESS });
       dispatch(load());
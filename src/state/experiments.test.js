@@ -274,7 +274,7 @@ describe('experiments/actions', () => {
 
       fetch.mockResponseOnce(JSON.stringify({ data: mockRes }));
 
-      store.dispatch(update(3, 'new_name', 'new_desc')).then(() => {
+      store.dispatch(update(3, 'new_name', 'new_desc', 0)).then(() => {
         expect(store.getActions()).toEqual([
           {
             type: UPDATE,
@@ -295,7 +295,7 @@ describe('experiments/actions', () => {
       const errText = 'some error';
       fetch.mockReject(errText);
 
-      store.dispatch(update(3, 'new_name', 'new_desc')).then(() => {
+      store.dispatch(update(3, 'new_name', 'new_desc', 0)).then(() => {
         expect(store.getActions()).toEqual([
           {
             type: UPDATE,
@@ -56,6 +56,10 @@ const reducer = (state = initialState, action) => {
 };
 
 export const logOut = () => dispatch => {
+  if (TokenService.exists()) {
+    // the token is revoked in the background, it is removed anyway
+    api.logout().catch(() => {});
+  }
   TokenService.remove();
   dispatch({ type: LOG_OUT });
   return dispatch(push('/'));